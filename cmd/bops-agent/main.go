@@ -1,38 +1,153 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"bops/internal/agent"
+	"bops/internal/config"
+	"bops/runner/engine"
+	"bops/runner/logging"
+	"bops/runner/modules"
+	"bops/runner/scriptstore"
+	"go.uber.org/zap"
 )
 
 func main() {
-	fs := flag.NewFlagSet("bops-agent", flag.ExitOnError)
-	id := fs.String("id", "agent-local", "agent id")
-	interval := fs.Duration("heartbeat", 10*time.Second, "heartbeat interval")
-	if err := fs.Parse(os.Args[1:]); err != nil {
+	if err := run(os.Args[1:]); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("bops-agent", flag.ContinueOnError)
+	configPath := fs.String("config", "", "config file path")
+	id := fs.String("id", "", "agent id (default agent_server.id or hostname)")
+	addr := fs.String("addr", "", "listen address (default agent_listen)")
+	token := fs.String("token", "", "auth token (default agent_server.token)")
+	capabilities := fs.String("capabilities", "", "comma separated actions the agent runs (default agent_server.capabilities)")
+	maxTasks := fs.Int("max-tasks", -1, "max tasks running at once, 0 for unlimited (default agent_server.max_tasks)")
+	stateFile := fs.String("state-file", "", "task state file (default agent_server.task_state_path)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	resolvedPath := config.ResolvePath(*configPath)
+	cfg, err := config.Load(resolvedPath)
+	if err != nil {
+		return err
+	}
+	_, _ = logging.Init(logging.Config{LogLevel: cfg.LogLevel, LogFormat: cfg.LogFormat})
+
+	opts := cfg.AgentServer
+	if *id != "" {
+		opts.ID = *id
+	}
+	if *addr != "" {
+		cfg.AgentListen = *addr
+	}
+	if *token != "" {
+		opts.Token = *token
+	}
+	if *capabilities != "" {
+		opts.Capabilities = strings.Split(*capabilities, ",")
+	}
+	if *maxTasks >= 0 {
+		opts.MaxTasks = *maxTasks
+	}
+	if *stateFile != "" {
+		opts.TaskStatePath = *stateFile
+	}
+	if strings.TrimSpace(opts.ID) == "" {
+		opts.ID, _ = os.Hostname()
+	}
+	if strings.TrimSpace(opts.TaskStatePath) == "" {
+		opts.TaskStatePath = filepath.Join(cfg.DataDir, "agent_tasks.json")
+	}
 
-	ag := agent.New(*id, []string{"cmd.run", "shell.run", "script.shell", "script.python", "env.set", "template.render", "wait.event"})
+	reg := engine.DefaultRegistry(scriptstore.New(filepath.Join(cfg.DataDir, "scripts")))
+	actions, err := agentCapabilities(reg, opts.Capabilities)
+	if err != nil {
+		return err
+	}
+	ag := agent.New(opts.ID, actions)
 	ag.Start()
-	printInfo(ag.Info())
+	srv, err := agent.NewServer(ag, reg, agent.Options{
+		Token:    opts.Token,
+		MaxTasks: opts.MaxTasks,
+		Store:    agent.NewTaskStore(opts.TaskStatePath, opts.TaskHistory),
+	})
+	if err != nil {
+		return err
+	}
 
-	ticker := time.NewTicker(*interval)
-	defer ticker.Stop()
+	httpServer := &http.Server{
+		Addr:              cfg.AgentListen,
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		logging.L().Info("agent shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
 
-	for range ticker.C {
-		ag.Heartbeat()
-		printInfo(ag.Info())
+	logging.L().Info("agent listening",
+		zap.String("id", opts.ID),
+		zap.String("addr", cfg.AgentListen),
+		zap.Strings("capabilities", actions),
+		zap.Int("max_tasks", opts.MaxTasks),
+		zap.String("task_state_path", opts.TaskStatePath),
+		zap.Bool("token_required", strings.TrimSpace(opts.Token) != ""),
+		zap.String("config_path", resolvedPath),
+	)
+	err = httpServer.ListenAndServe()
+	srv.Close()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
+	return err
 }
 
-func printInfo(info agent.Info) {
-	enc := json.NewEncoder(os.Stdout)
-	_ = enc.Encode(info)
+// agentCapabilities returns the actions the agent advertises: the
+// configured ones, which must be registered, or every registered action an
+// agent can run on its own.
+func agentCapabilities(reg *modules.Registry, configured []string) ([]string, error) {
+	var actions []string
+	for _, action := range configured {
+		action = strings.TrimSpace(action)
+		if action == "" {
+			continue
+		}
+		if _, ok := reg.Get(action); !ok {
+			return nil, fmt.Errorf("unknown capability %q", action)
+		}
+		actions = append(actions, action)
+	}
+	if len(actions) > 0 {
+		return actions, nil
+	}
+	for _, action := range reg.Actions() {
+		// workflow.call runs sub-workflows through the engine that
+		// dispatched it, which an agent does not have.
+		if action == "workflow.call" {
+			continue
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
 }
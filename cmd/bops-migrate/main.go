@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"path/filepath"
 
 	"bops/internal/stepsstore"
+	"bops/runner/state"
 )
 
 func main() {
 	dataDir := flag.String("data", "data", "data directory")
+	statePath := flag.String("state", "", "state.json to import runs from (default <data>/state.json)")
+	runStorePath := flag.String("run-store", "", "SQLite run store to import runs into; empty skips the import")
 	flag.Parse()
 
 	store := stepsstore.New(filepath.Join(*dataDir, "workflows"))
@@ -19,4 +24,49 @@ func main() {
 		return
 	}
 	fmt.Printf("migration done, workflows=%d\n", len(items))
+
+	if *runStorePath == "" {
+		return
+	}
+	if *statePath == "" {
+		*statePath = filepath.Join(*dataDir, "state.json")
+	}
+	imported, skipped, err := importRuns(*statePath, *runStorePath)
+	if err != nil {
+		fmt.Printf("run import failed: %v\n", err)
+		return
+	}
+	fmt.Printf("run import done, imported=%d skipped=%d\n", imported, skipped)
+}
+
+// importRuns copies every run of the JSON state file into the SQLite store.
+// Runs already present are skipped, so the import can be repeated.
+func importRuns(statePath, runStorePath string) (int, int, error) {
+	data, err := state.NewFileStore(statePath).Load()
+	if err != nil {
+		return 0, 0, err
+	}
+	target, err := state.NewSQLiteStore(runStorePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer target.Close()
+
+	imported, skipped := 0, 0
+	for _, run := range data.Runs {
+		if run.Status == "stopped" {
+			// The server records runs stopped by a user as "stopped".
+			run.Status = state.RunStatusCanceled
+		}
+		err := target.CreateRun(context.Background(), run)
+		if errors.Is(err, state.ErrRunExists) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return imported, skipped, fmt.Errorf("import run %s: %w", run.RunID, err)
+		}
+		imported++
+	}
+	return imported, skipped, nil
 }
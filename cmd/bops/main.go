@@ -3,18 +3,22 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"bops/internal/config"
 	"bops/runner/engine"
 	"bops/runner/logging"
 	"bops/runner/modules"
+	"bops/runner/planner"
 	"bops/internal/report"
 	"bops/runner/scriptstore"
 	"bops/internal/server"
+	"bops/internal/stepsstore"
 	"bops/runner/state"
 	"bops/runner/workflow"
 	"go.uber.org/zap"
@@ -32,6 +36,9 @@ func main() {
 	switch os.Args[1] {
 	case "plan":
 		if err := runPlan(os.Args[2:]); err != nil {
+			if errors.Is(err, errChangesPending) {
+				os.Exit(2)
+			}
 			fatal(err)
 		}
 	case "apply":
@@ -56,9 +63,18 @@ func main() {
 	}
 }
 
+// errChangesPending makes `bops plan --detailed-exitcode` exit with 2.
+var errChangesPending = errors.New("changes pending")
+
 func runPlan(args []string) error {
 	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
 	file := fs.String("f", "", "workflow file")
+	asDiff := fs.Bool("diff", false, "print a readable diff instead of JSON")
+	noColor := fs.Bool("no-color", false, "disable colors in the -diff output")
+	detailed := fs.Bool("detailed-exitcode", false, "exit 0 when nothing changes, 2 when changes are pending, 1 on error")
+	out := fs.String("out", "", "save the signed plan to this file for apply -plan")
+	params := paramFlags{}
+	fs.Var(params, "param", "set a workflow param as name=value (repeatable)")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -67,18 +83,42 @@ func runPlan(args []string) error {
 	}
 
 	logging.L().Debug("plan start", zap.String("file", *file))
-	wf, err := loadWorkflow(*file)
+	wf, err := loadWorkflow(*file, params)
 	if err != nil {
 		return err
 	}
 
-	eng := engine.New(defaultRegistry())
+	eng := newEngine()
+	defer eng.Close()
 	plan, err := eng.Plan(context.Background(), wf)
 	if err != nil {
 		return err
 	}
 
-	return printJSON(plan)
+	if *out != "" {
+		if err := savePlan(*out, plan, wf, *file); err != nil {
+			return err
+		}
+	}
+
+	if *asDiff {
+		err = planner.Render(os.Stdout, plan, !*noColor && colorTerminal(os.Stdout))
+	} else {
+		err = printJSON(plan)
+	}
+	if err == nil && *detailed && plan.HasChanges() {
+		return errChangesPending
+	}
+	return err
+}
+
+// colorTerminal reports whether f is a terminal and NO_COLOR is unset.
+func colorTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
 func runApply(args []string) error {
@@ -86,25 +126,79 @@ func runApply(args []string) error {
 	file := fs.String("f", "", "workflow file")
 	verbose := fs.Bool("verbose", false, "print step output")
 	verboseShort := fs.Bool("v", false, "print step output (shorthand)")
+	resume := fs.String("resume", "", "resume a failed or interrupted run by id")
+	planFile := fs.String("plan", "", "apply exactly the steps of a plan saved by plan -out")
+	params := paramFlags{}
+	fs.Var(params, "param", "set a workflow param as name=value (repeatable)")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	if *planFile != "" && *resume != "" {
+		return fmt.Errorf("-plan and -resume cannot be combined")
+	}
+
+	cfg, err := config.Load("")
+	if err != nil {
+		return err
+	}
+
+	var saved planner.SavedPlan
+	if *planFile != "" {
+		key, err := planner.PlanKey(dataDir())
+		if err != nil {
+			return err
+		}
+		if saved, err = planner.LoadSavedPlan(*planFile, key); err != nil {
+			return err
+		}
+		if *file == "" {
+			*file = saved.WorkflowFile
+		}
+	}
 	if *file == "" {
 		return fmt.Errorf("workflow file is required")
 	}
 
-	logging.L().Debug("apply start", zap.String("file", *file))
-	wf, err := loadWorkflow(*file)
+	logging.L().Debug("apply start", zap.String("file", *file), zap.String("resume", *resume), zap.String("plan", *planFile))
+	wf, err := loadWorkflow(*file, params)
 	if err != nil {
 		return err
 	}
+	if *planFile != "" {
+		if err := saved.Verify(wf); err != nil {
+			return fmt.Errorf("%w; run bops plan again", err)
+		}
+		if !saved.Plan.HasChanges() {
+			fmt.Println("saved plan has no changes; nothing to apply")
+			return nil
+		}
+		wf = saved.Workflow(wf)
+	}
 
-	eng := engine.New(defaultRegistry())
+	runStore, closeStore, err := openRunStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	eng := newEngine()
+	defer eng.Close()
+	eng.RunStore = runStore
 	if *verbose || *verboseShort {
 		eng.Verbose = true
 		eng.Out = os.Stdout
 	}
-	return eng.Apply(context.Background(), wf)
+
+	var run state.RunState
+	if *resume != "" {
+		run, err = eng.Resume(context.Background(), wf, *resume, engine.RunOptions{})
+	} else {
+		run, err = eng.ApplyWithRun(context.Background(), wf, engine.RunOptions{})
+	}
+	if run.RunID != "" {
+		fmt.Printf("run_id=%s attempt=%d status=%s\n", run.RunID, run.Attempt, run.Status)
+	}
+	return err
 }
 
 func runTest(args []string) error {
@@ -118,12 +212,13 @@ func runTest(args []string) error {
 	}
 
 	logging.L().Debug("test plan start", zap.String("file", *file))
-	wf, err := loadWorkflow(*file)
+	wf, err := loadWorkflow(*file, nil)
 	if err != nil {
 		return err
 	}
 
-	eng := engine.New(defaultRegistry())
+	eng := newEngine()
+	defer eng.Close()
 	plan, err := eng.Plan(context.Background(), wf)
 	if err != nil {
 		return err
@@ -144,6 +239,22 @@ func runStatus(args []string) error {
 
 	_, _ = logging.Init(logging.Config{LogLevel: cfg.LogLevel, LogFormat: cfg.LogFormat})
 	logging.L().Debug("status requested")
+	if cfg.RunStorePath != "" {
+		runStore, closeStore, err := openRunStore(cfg)
+		if err != nil {
+			return err
+		}
+		defer closeStore()
+		runs, err := runStore.ListRuns(context.Background(), state.ListFilter{Limit: 1})
+		if err != nil {
+			return err
+		}
+		if len(runs) == 0 {
+			fmt.Println("no runs")
+			return nil
+		}
+		return printJSON(report.Summarize(runs[0]))
+	}
 	store := state.NewFileStore(cfg.StatePath)
 	data, err := store.Load()
 	if err != nil {
@@ -184,25 +295,119 @@ func runServe(args []string) error {
 	return srv.ListenAndServe()
 }
 
-func loadWorkflow(path string) (workflow.Workflow, error) {
+// openRunStore opens the SQLite run store when run_store_path is set and
+// falls back to the JSON state file otherwise.
+func openRunStore(cfg config.Config) (state.RunStateStore, func(), error) {
+	if cfg.RunStorePath == "" {
+		return state.NewFileStore(cfg.StatePath), func() {}, nil
+	}
+	store, err := state.NewSQLiteStore(cfg.RunStorePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, func() { _ = store.Close() }, nil
+}
+
+func loadWorkflow(path string, params paramFlags) (workflow.Workflow, error) {
+	wf, err := expandWorkflow(path)
+	if err != nil {
+		return workflow.Workflow{}, err
+	}
+	if err := wf.ResolveParams(params); err != nil {
+		return workflow.Workflow{}, err
+	}
+	return wf, nil
+}
+
+// expandWorkflow loads a workflow file with its includes expanded. Files
+// are included relative to the including file; other names come from the
+// workflows stored in the data dir.
+func expandWorkflow(path string) (workflow.Workflow, error) {
 	wf, err := workflow.LoadFile(path)
 	if err != nil {
 		return workflow.Workflow{}, err
 	}
+	includer := storedWorkflows().Includer()
+	includer.Dir = filepath.Dir(path)
+	includer.NoFiles = false
+	wf, err = includer.Expand(wf, path)
+	if err != nil {
+		return workflow.Workflow{}, err
+	}
+	if err := wf.Validate(); err != nil {
+		return workflow.Workflow{}, err
+	}
+	return wf, nil
+}
+
+// loadCalledWorkflow loads the workflow of a workflow.call step, a file or a
+// workflow stored in the data dir. The step resolves its params.
+func loadCalledWorkflow(ref string) (workflow.Workflow, error) {
+	if workflow.IsFileRef(ref) {
+		return expandWorkflow(ref)
+	}
+	wf, err := storedWorkflows().LoadWorkflow(ref)
+	if err != nil {
+		return workflow.Workflow{}, err
+	}
 	if err := wf.Validate(); err != nil {
 		return workflow.Workflow{}, err
 	}
 	return wf, nil
 }
 
+func storedWorkflows() *stepsstore.Store {
+	return stepsstore.New(filepath.Join(dataDir(), "workflows"))
+}
+
+// paramFlags collects repeated -param name=value flags.
+type paramFlags map[string]any
+
+func (p paramFlags) String() string {
+	return fmt.Sprint(map[string]any(p))
+}
+
+func (p paramFlags) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("param must be name=value, got %q", value)
+	}
+	p[name] = val
+	return nil
+}
+
+func newEngine() *engine.Engine {
+	eng := engine.New(defaultRegistry())
+	eng.Workflows = loadCalledWorkflow
+	return eng
+}
+
 func defaultRegistry() *modules.Registry {
+	scriptStore := scriptstore.New(filepath.Join(dataDir(), "scripts"))
+	return engine.DefaultRegistry(scriptStore)
+}
+
+func dataDir() string {
 	cfg, err := config.Load("")
-	dataDir := config.DefaultConfig().DataDir
 	if err == nil && cfg.DataDir != "" {
-		dataDir = cfg.DataDir
+		return cfg.DataDir
 	}
-	scriptStore := scriptstore.New(filepath.Join(dataDir, "scripts"))
-	return engine.DefaultRegistry(scriptStore)
+	return config.DefaultConfig().DataDir
+}
+
+// savePlan writes plan, bound to the workflow file and its inputs, signed
+// with the plan key from the data dir.
+func savePlan(path string, plan planner.Plan, wf workflow.Workflow, workflowFile string) error {
+	key, err := planner.PlanKey(dataDir())
+	if err != nil {
+		return err
+	}
+	saved, err := planner.NewSavedPlan(plan, wf, workflowFile)
+	if err != nil {
+		return err
+	}
+	return saved.Save(path, key)
 }
 
 func printJSON(value any) error {
@@ -213,6 +418,9 @@ func printJSON(value any) error {
 
 func usage() {
 	fmt.Fprintln(os.Stderr, "usage: bops <plan|apply|test|status|serve> -f <workflow.yaml>")
+	fmt.Fprintln(os.Stderr, "       bops plan -f <workflow.yaml> [-param name=value]... [-diff [-no-color]] [--detailed-exitcode] [-out plan.json]")
+	fmt.Fprintln(os.Stderr, "       bops apply -f <workflow.yaml> [-param name=value]...")
+	fmt.Fprintln(os.Stderr, "       bops apply -plan plan.json [-f <workflow.yaml>] [-param name=value]...")
 }
 
 func fatal(err error) {
@@ -1,15 +1,20 @@
 package agent
 
-import "time"
+import (
+	"strings"
+	"sync"
+	"time"
+)
 
 type Info struct {
-	ID           string    `json:"id"`
-	StartedAt    time.Time `json:"started_at"`
+	ID            string    `json:"id"`
+	StartedAt     time.Time `json:"started_at"`
 	LastHeartbeat time.Time `json:"last_heartbeat"`
-	Capabilities []string  `json:"capabilities"`
+	Capabilities  []string  `json:"capabilities"`
 }
 
 type Agent struct {
+	mu           sync.Mutex
 	id           string
 	capabilities []string
 	startedAt    time.Time
@@ -24,20 +29,37 @@ func New(id string, capabilities []string) *Agent {
 }
 
 func (a *Agent) Start() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	now := time.Now().UTC()
 	a.startedAt = now
 	a.lastBeat = now
 }
 
 func (a *Agent) Heartbeat() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.lastBeat = time.Now().UTC()
 }
 
+// Supports reports whether action is one of the advertised capabilities.
+func (a *Agent) Supports(action string) bool {
+	action = strings.TrimSpace(action)
+	for _, capability := range a.capabilities {
+		if capability == action {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *Agent) Info() Info {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	return Info{
-		ID:           a.id,
-		StartedAt:    a.startedAt,
+		ID:            a.id,
+		StartedAt:     a.startedAt,
 		LastHeartbeat: a.lastBeat,
-		Capabilities: append([]string{}, a.capabilities...),
+		Capabilities:  append([]string{}, a.capabilities...),
 	}
 }
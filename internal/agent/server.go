@@ -0,0 +1,538 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bops/runner/logging"
+	"bops/runner/modules"
+	"bops/runner/scheduler"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultAsyncAfter is how long /run waits for a task before answering
+	// "running" and leaving the dispatcher to poll /status.
+	DefaultAsyncAfter = 4 * time.Second
+	// DefaultOutputLimit caps the stdout and stderr kept per running task;
+	// a task can raise it with the max_output_bytes arg.
+	DefaultOutputLimit = 64 * 1024
+	// BusyRetryAfter is the Retry-After a busy agent sends with its 429.
+	BusyRetryAfter = 2 * time.Second
+)
+
+// Options configures a Server.
+type Options struct {
+	// Token is required as a bearer token or X-Runner-Token header when set.
+	Token string
+	// MaxTasks caps the tasks running at once; /run answers 429 beyond it.
+	// 0 is unlimited.
+	MaxTasks int
+	// Store persists tasks across restarts; nil keeps them in memory. Its
+	// Limit also bounds the finished tasks kept in memory.
+	Store *TaskStore
+	// AsyncAfter defaults to DefaultAsyncAfter.
+	AsyncAfter time.Duration
+}
+
+// Server runs the tasks AgentDispatcher sends to the agent over HTTP.
+type Server struct {
+	agent    *Agent
+	registry *modules.Registry
+	opts     Options
+	mux      *http.ServeMux
+
+	mu      sync.Mutex
+	tasks   map[string]*task
+	running int
+	wg      sync.WaitGroup
+}
+
+type task struct {
+	record TaskRecord
+	// active is set while the goroutine running the task has not returned,
+	// which a canceled task outlives.
+	active bool
+	cancel context.CancelFunc
+	stdout *outputBuffer
+	stderr *outputBuffer
+}
+
+type runRequest struct {
+	Task scheduler.Task `json:"task"`
+}
+
+type runResponse struct {
+	Result scheduler.Result `json:"result"`
+	RunID  string           `json:"run_id,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+type statusRequest struct {
+	TaskID string `json:"task_id"`
+}
+
+// NewServer returns a server running tasks for ag with the modules of reg.
+// Tasks the store still holds as running were cut off by a restart and are
+// marked failed.
+func NewServer(ag *Agent, reg *modules.Registry, opts Options) (*Server, error) {
+	if ag == nil {
+		return nil, fmt.Errorf("agent is nil")
+	}
+	if reg == nil {
+		return nil, fmt.Errorf("registry is nil")
+	}
+	if opts.AsyncAfter <= 0 {
+		opts.AsyncAfter = DefaultAsyncAfter
+	}
+	s := &Server{
+		agent:    ag,
+		registry: reg,
+		opts:     opts,
+		mux:      http.NewServeMux(),
+		tasks:    map[string]*task{},
+	}
+
+	records, err := opts.Store.Load()
+	if err != nil {
+		return nil, err
+	}
+	interrupted := 0
+	now := time.Now().UTC()
+	for _, record := range records {
+		if !record.Done {
+			record.Done = true
+			record.FinishedAt = now
+			record.Result = scheduler.Result{
+				TaskID: record.Task.ID,
+				Status: "failed",
+				Error:  "agent restarted while the task was running",
+			}
+			interrupted++
+		}
+		s.tasks[record.Task.ID] = &task{record: record}
+	}
+	if interrupted > 0 {
+		logging.L().Warn("agent tasks interrupted by restart", zap.Int("count", interrupted))
+		if err := opts.Store.Save(s.records()); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mux.HandleFunc("/run", s.handleRun)
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.HandleFunc("/cancel", s.handleCancel)
+	s.mux.HandleFunc("/heartbeat", s.handleHeartbeat)
+	s.mux.HandleFunc("/info", s.handleInfo)
+	s.mux.HandleFunc("/health", s.handleHealth)
+	return s, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Close cancels the running tasks and waits for them to finish.
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, t := range s.tasks {
+		if !t.record.Done && t.cancel != nil {
+			t.cancel()
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.checkAuth(w, r) {
+		return
+	}
+	var req runRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, runResponse{Error: err.Error()})
+		return
+	}
+	req.Task.ID = strings.TrimSpace(req.Task.ID)
+	if req.Task.ID == "" {
+		req.Task.ID = fmt.Sprintf("task-%d", time.Now().UTC().UnixNano())
+	}
+	if strings.TrimSpace(req.Task.RunID) == "" {
+		req.Task.RunID = req.Task.ID
+	}
+
+	action := req.Task.Step.Action
+	if !s.agent.Supports(action) {
+		writeJSON(w, http.StatusBadRequest, runResponse{RunID: req.Task.RunID, Error: fmt.Sprintf("action %q is not supported by this agent", action)})
+		return
+	}
+	module, ok := s.registry.Get(action)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, runResponse{RunID: req.Task.RunID, Error: fmt.Sprintf("action %q is not registered", action)})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t, existing, err := s.start(req.Task, cancel)
+	if err != nil || existing != nil {
+		cancel()
+	}
+	if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(BusyRetryAfter/time.Second)))
+		writeJSON(w, http.StatusTooManyRequests, runResponse{RunID: req.Task.RunID, Error: err.Error()})
+		return
+	}
+	if existing != nil {
+		writeJSON(w, http.StatusOK, runResponse{Result: existing.Result, RunID: existing.Task.RunID, Error: existing.Result.Error})
+		return
+	}
+	logging.L().Info("agent run start",
+		zap.String("task_id", req.Task.ID),
+		zap.String("run_id", req.Task.RunID),
+		zap.String("step", req.Task.Step.Name),
+		zap.String("action", action),
+		zap.String("host", req.Task.Host.Name),
+	)
+
+	done := make(chan scheduler.Result, 1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		done <- s.execute(ctx, module, t)
+	}()
+
+	select {
+	case result := <-done:
+		logging.L().Info("agent run finish",
+			zap.String("task_id", req.Task.ID),
+			zap.String("run_id", req.Task.RunID),
+			zap.String("status", result.Status),
+		)
+		writeJSON(w, http.StatusOK, runResponse{Result: result, RunID: req.Task.RunID, Error: result.Error})
+	case <-time.After(s.opts.AsyncAfter):
+		logging.L().Info("agent run switched to async",
+			zap.String("task_id", req.Task.ID),
+			zap.Duration("threshold", s.opts.AsyncAfter),
+		)
+		writeJSON(w, http.StatusOK, runResponse{Result: scheduler.Result{TaskID: req.Task.ID, Status: "running"}, RunID: req.Task.RunID})
+	}
+}
+
+// start registers a new task and takes a slot for it. A task ID seen
+// before returns its record instead, so a retried dispatch does not run
+// the task twice.
+func (s *Server) start(st scheduler.Task, cancel context.CancelFunc) (*task, *TaskRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tasks[st.ID]; ok {
+		record := s.snapshot(t)
+		return nil, &record, nil
+	}
+	if s.opts.MaxTasks > 0 && s.running >= s.opts.MaxTasks {
+		return nil, nil, fmt.Errorf("agent busy: %d tasks running", s.running)
+	}
+	limit := outputLimit(st.Step.Args)
+	t := &task{
+		record: TaskRecord{
+			Task:      st,
+			Result:    scheduler.Result{TaskID: st.ID, Status: "running"},
+			StartedAt: time.Now().UTC(),
+		},
+		active: true,
+		cancel: cancel,
+		stdout: newOutputBuffer(limit),
+		stderr: newOutputBuffer(limit),
+	}
+	s.tasks[st.ID] = t
+	s.running++
+	s.wg.Add(1)
+	s.persistLocked()
+	return t, nil, nil
+}
+
+func (s *Server) execute(ctx context.Context, module modules.Module, t *task) scheduler.Result {
+	st := t.record.Task
+	req := modules.Request{
+		Step:   st.Step,
+		Host:   st.Host,
+		Vars:   st.Vars,
+		Stdout: t.stdout,
+		Stderr: t.stderr,
+	}
+	var res modules.Result
+	var err error
+	if st.Rollback {
+		req.ApplyOutput = st.ApplyOutput
+		res, err = module.Rollback(ctx, req)
+	} else {
+		res, err = module.Apply(ctx, req)
+	}
+
+	result := scheduler.Result{TaskID: st.ID, Status: "success", Output: res.Output}
+	switch {
+	case ctx.Err() != nil && err != nil:
+		result.Status = "canceled"
+		result.Error = "task canceled"
+	case st.Rollback:
+		result, _ = scheduler.RollbackResult(st.ID, res, err)
+	case err != nil:
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	t.active = false
+	if t.record.Done {
+		// Canceled by request; keep the canceled result.
+		result = t.record.Result
+	} else {
+		t.record.Result = result
+		t.record.Done = true
+		t.record.FinishedAt = time.Now().UTC()
+	}
+	s.persistLocked()
+	return result
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := s.taskID(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	t, found := s.tasks[taskID]
+	var record TaskRecord
+	if found {
+		record = s.snapshot(t)
+	}
+	s.mu.Unlock()
+	if !found {
+		writeJSON(w, http.StatusNotFound, runResponse{Error: "task not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, runResponse{Result: record.Result, RunID: record.Task.RunID, Error: record.Result.Error})
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := s.taskID(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	t, found := s.tasks[taskID]
+	if !found || t.record.Done {
+		s.mu.Unlock()
+		writeJSON(w, http.StatusNotFound, runResponse{Error: "task not found or already done"})
+		return
+	}
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.record.Result = scheduler.Result{TaskID: taskID, Status: "canceled", Error: "task canceled"}
+	t.record.Done = true
+	t.record.FinishedAt = time.Now().UTC()
+	runID := t.record.Task.RunID
+	s.persistLocked()
+	s.mu.Unlock()
+
+	logging.L().Info("agent task canceled", zap.String("task_id", taskID), zap.String("run_id", runID))
+	writeJSON(w, http.StatusOK, runResponse{Result: scheduler.Result{TaskID: taskID, Status: "canceled"}, RunID: runID})
+}
+
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.checkAuth(w, r) {
+		return
+	}
+	s.agent.Heartbeat()
+	info := s.agent.Info()
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":        "ok",
+		"last_beat":     info.LastHeartbeat.Format(time.RFC3339),
+		"timestamp":     info.LastHeartbeat.Unix(),
+		"capabilities":  info.Capabilities,
+		"running_tasks": running,
+	})
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.checkAuth(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.agent.Info())
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	info := s.agent.Info()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":    "ok",
+		"timestamp": time.Now().UTC().Unix(),
+		"last_beat": info.LastHeartbeat.Format(time.RFC3339),
+	})
+}
+
+// taskID reads the task_id of a /status or /cancel request from the query
+// or, for POST, the JSON body.
+func (s *Server) taskID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return "", false
+	}
+	if !s.checkAuth(w, r) {
+		return "", false
+	}
+	taskID := strings.TrimSpace(r.URL.Query().Get("task_id"))
+	if taskID == "" && r.Method == http.MethodPost {
+		var req statusRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, runResponse{Error: err.Error()})
+			return "", false
+		}
+		taskID = strings.TrimSpace(req.TaskID)
+	}
+	if taskID == "" {
+		writeJSON(w, http.StatusBadRequest, runResponse{Error: "task_id is required"})
+		return "", false
+	}
+	return taskID, true
+}
+
+func (s *Server) checkAuth(w http.ResponseWriter, r *http.Request) bool {
+	token := strings.TrimSpace(s.opts.Token)
+	if token == "" {
+		return true
+	}
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	if strings.HasPrefix(strings.ToLower(auth), "bearer ") {
+		auth = strings.TrimSpace(auth[len("bearer "):])
+	}
+	if auth == token || strings.TrimSpace(r.Header.Get("X-Runner-Token")) == token {
+		return true
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	_, _ = w.Write([]byte("unauthorized"))
+	return false
+}
+
+// snapshot returns the record of t with the output captured so far while
+// it runs. Callers hold s.mu.
+func (s *Server) snapshot(t *task) TaskRecord {
+	record := t.record
+	if !record.Done && (t.stdout != nil || t.stderr != nil) {
+		record.Result.Output = map[string]any{
+			"stdout": t.stdout.String(),
+			"stderr": t.stderr.String(),
+		}
+	}
+	return record
+}
+
+func (s *Server) records() []TaskRecord {
+	records := make([]TaskRecord, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		records = append(records, t.record)
+	}
+	return records
+}
+
+// persistLocked forgets the oldest finished tasks beyond the history limit
+// and saves the rest. A canceled task is kept until its goroutine returns,
+// so that a retried /run of it does not start a second copy. Callers hold
+// s.mu.
+func (s *Server) persistLocked() {
+	limit := 0
+	if s.opts.Store != nil {
+		limit = s.opts.Store.Limit
+	}
+	records := pruneTasks(s.records(), limit)
+	if len(records) < len(s.tasks) {
+		kept := make(map[string]struct{}, len(records))
+		for _, record := range records {
+			kept[record.Task.ID] = struct{}{}
+		}
+		for id, t := range s.tasks {
+			if _, ok := kept[id]; !ok && !t.active {
+				delete(s.tasks, id)
+			}
+		}
+	}
+	if s.opts.Store == nil {
+		return
+	}
+	if err := s.opts.Store.Save(records); err != nil {
+		logging.L().Warn("agent task state save failed", zap.String("path", s.opts.Store.Path), zap.Error(err))
+	}
+}
+
+func outputLimit(args map[string]any) int {
+	limit := DefaultOutputLimit
+	switch v := args["max_output_bytes"].(type) {
+	case int:
+		limit = v
+	case int64:
+		limit = int(v)
+	case float64:
+		limit = int(v)
+	case string:
+		var out int
+		_, _ = fmt.Sscanf(strings.TrimSpace(v), "%d", &out)
+		if out > 0 {
+			limit = out
+		}
+	}
+	return limit
+}
+
+type outputBuffer struct {
+	mu      sync.Mutex
+	maxSize int
+	data    []byte
+}
+
+func newOutputBuffer(maxSize int) *outputBuffer {
+	return &outputBuffer{maxSize: maxSize}
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	if b.maxSize > 0 && len(b.data) > b.maxSize {
+		b.data = b.data[len(b.data)-b.maxSize:]
+	}
+	return len(p), nil
+}
+
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
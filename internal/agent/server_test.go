@@ -0,0 +1,368 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bops/runner/modules"
+	"bops/runner/scheduler"
+	"bops/runner/workflow"
+)
+
+type testModule struct {
+	release chan struct{}
+}
+
+func (m testModule) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	return modules.Result{}, nil
+}
+
+func (m testModule) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	if m.release != nil {
+		select {
+		case <-m.release:
+		case <-ctx.Done():
+			return modules.Result{}, ctx.Err()
+		}
+	}
+	return modules.Result{Output: map[string]any{"host": req.Host.Name}}, nil
+}
+
+func (m testModule) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	return modules.Result{}, nil
+}
+
+func newTestServer(t *testing.T, reg *modules.Registry, capabilities []string, opts Options) (*Server, *httptest.Server) {
+	t.Helper()
+	ag := New("agent-test", capabilities)
+	ag.Start()
+	srv, err := NewServer(ag, reg, opts)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+	return srv, ts
+}
+
+func TestServerRunsDispatchedTasks(t *testing.T) {
+	reg := modules.NewRegistry()
+	_ = reg.Register("test.echo", testModule{})
+	_ = reg.Register("test.other", testModule{})
+	_, ts := newTestServer(t, reg, []string{"test.echo"}, Options{Token: "secret"})
+
+	dispatcher := scheduler.NewAgentDispatcherWithToken(ts.URL, "secret")
+	dispatcher.Heartbeat = true
+	result, err := dispatcher.Dispatch(context.Background(), scheduler.Task{
+		ID:   "task-1",
+		Step: workflow.Step{Name: "echo", Action: "test.echo"},
+		Host: workflow.HostSpec{Name: "web1"},
+	})
+	if err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	if result.Status != "success" || result.Output["host"] != "web1" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	_, err = dispatcher.Dispatch(context.Background(), scheduler.Task{
+		ID:   "task-2",
+		Step: workflow.Step{Name: "other", Action: "test.other"},
+	})
+	if err == nil || !strings.Contains(err.Error(), `action \"test.other\" is not supported`) {
+		t.Fatalf("expected capability error, got %v", err)
+	}
+
+	resp, err := http.Get(ts.URL + "/info")
+	if err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected info to require the token, got %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/info", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	defer resp.Body.Close()
+	var info Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("decode info: %v", err)
+	}
+	if info.ID != "agent-test" || len(info.Capabilities) != 1 || info.Capabilities[0] != "test.echo" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
+
+func TestServerLimitsRunningTasks(t *testing.T) {
+	release := make(chan struct{})
+	reg := modules.NewRegistry()
+	_ = reg.Register("test.wait", testModule{release: release})
+	_, ts := newTestServer(t, reg, []string{"test.wait"}, Options{MaxTasks: 1, AsyncAfter: 10 * time.Millisecond})
+
+	post := func(path string, body any) (int, runResponse) {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var decoded runResponse
+		_ = json.NewDecoder(resp.Body).Decode(&decoded)
+		return resp.StatusCode, decoded
+	}
+	task := func(id string) runRequest {
+		return runRequest{Task: scheduler.Task{ID: id, Step: workflow.Step{Name: "wait", Action: "test.wait"}}}
+	}
+
+	if code, resp := post("/run", task("task-1")); code != http.StatusOK || resp.Result.Status != "running" {
+		t.Fatalf("expected first task to run, got %d %+v", code, resp)
+	}
+	if code, resp := post("/run", task("task-2")); code != http.StatusTooManyRequests || !strings.Contains(resp.Error, "agent busy") {
+		t.Fatalf("expected second task to be rejected, got %d %+v", code, resp)
+	}
+	data, _ := json.Marshal(task("task-2"))
+	busy, err := http.Post(ts.URL+"/run", "application/json", strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("post /run: %v", err)
+	}
+	busy.Body.Close()
+	if got := busy.Header.Get("Retry-After"); got != "2" {
+		t.Fatalf("expected Retry-After 2 on a busy agent, got %q", got)
+	}
+	if code, resp := post("/run", task("task-1")); code != http.StatusOK || resp.Result.Status != "running" {
+		t.Fatalf("expected a repeated task id to report the running task, got %d %+v", code, resp)
+	}
+
+	if code, _ := post("/cancel", statusRequest{TaskID: "task-1"}); code != http.StatusOK {
+		t.Fatalf("expected cancel to succeed, got %d", code)
+	}
+	if _, resp := post("/status", statusRequest{TaskID: "task-1"}); resp.Result.Status != "canceled" {
+		t.Fatalf("expected canceled status, got %+v", resp)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		code, resp := post("/run", task("task-3"))
+		if code == http.StatusOK {
+			close(release)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the canceled task to free its slot, got %d %+v", code, resp)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDispatcherWaitsForBusyAgent(t *testing.T) {
+	release, hold := make(chan struct{}), make(chan struct{})
+	defer close(hold)
+	reg := modules.NewRegistry()
+	_ = reg.Register("test.wait", testModule{release: release})
+	_ = reg.Register("test.hold", testModule{release: hold})
+	_ = reg.Register("test.echo", testModule{})
+	_, ts := newTestServer(t, reg, []string{"test.wait", "test.hold", "test.echo"}, Options{MaxTasks: 1, AsyncAfter: 10 * time.Millisecond})
+
+	dispatcher := scheduler.NewAgentDispatcher(ts.URL)
+	dispatcher.RetryDelay = 10 * time.Millisecond
+	dispatcher.PollInterval = 10 * time.Millisecond
+	first := make(chan error, 1)
+	go func() {
+		_, err := dispatcher.Dispatch(context.Background(), scheduler.Task{ID: "task-1", Step: workflow.Step{Name: "wait", Action: "test.wait"}})
+		first <- err
+	}()
+	waitForStatus(t, ts.URL, "task-1", "running")
+
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	result, err := dispatcher.Dispatch(context.Background(), scheduler.Task{ID: "task-2", Step: workflow.Step{Name: "echo", Action: "test.echo"}, Host: workflow.HostSpec{Name: "web1"}})
+	if err != nil || result.Status != "success" {
+		t.Fatalf("expected the second task to run once the agent had a free slot, got %+v %v", result, err)
+	}
+	if err := <-first; err != nil {
+		t.Fatalf("first task: %v", err)
+	}
+
+	go func() {
+		_, _ = dispatcher.Dispatch(context.Background(), scheduler.Task{ID: "task-3", Step: workflow.Step{Name: "hold", Action: "test.hold"}})
+	}()
+	waitForStatus(t, ts.URL, "task-3", "running")
+	impatient := scheduler.NewAgentDispatcher(ts.URL)
+	impatient.BusyTimeout = time.Nanosecond
+	if _, err := impatient.Dispatch(context.Background(), scheduler.Task{ID: "task-4", Step: workflow.Step{Name: "echo", Action: "test.echo"}}); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("expected a busy agent to fail the dispatch after BusyTimeout, got %v", err)
+	}
+}
+
+func TestDispatcherDoesNotRetryRejectedTasks(t *testing.T) {
+	reg := modules.NewRegistry()
+	_ = reg.Register("test.echo", testModule{})
+	_, ts := newTestServer(t, reg, []string{"test.echo"}, Options{})
+	var runs atomic.Int32
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/run" {
+			runs.Add(1)
+		}
+		proxy, _ := http.NewRequestWithContext(r.Context(), r.Method, ts.URL+r.URL.RequestURI(), r.Body)
+		proxy.Header = r.Header
+		resp, err := http.DefaultClient.Do(proxy)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	defer counting.Close()
+
+	dispatcher := scheduler.NewAgentDispatcher(counting.URL)
+	dispatcher.RetryMax = 3
+	dispatcher.RetryDelay = 10 * time.Millisecond
+	_, err := dispatcher.Dispatch(context.Background(), scheduler.Task{ID: "task-1", Step: workflow.Step{Name: "pkg", Action: "pkg.install"}})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("expected the unsupported action to be rejected, got %v", err)
+	}
+	if got := runs.Load(); got != 1 {
+		t.Fatalf("expected a rejected task to be sent once, got %d", got)
+	}
+}
+
+func TestServerKeepsCanceledTaskUntilItReturns(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	reg := modules.NewRegistry()
+	_ = reg.Register("test.wait", testModule{release: release})
+	_ = reg.Register("test.echo", testModule{})
+	store := NewTaskStore(filepath.Join(t.TempDir(), "agent_tasks.json"), 1)
+	srv, ts := newTestServer(t, reg, []string{"test.wait", "test.echo"}, Options{Store: store, AsyncAfter: 10 * time.Millisecond})
+
+	dispatcher := scheduler.NewAgentDispatcher(ts.URL)
+	dispatcher.PollInterval = 10 * time.Millisecond
+	go func() {
+		_, _ = dispatcher.Dispatch(context.Background(), scheduler.Task{ID: "task-1", Step: workflow.Step{Name: "wait", Action: "test.wait"}})
+	}()
+	waitForStatus(t, ts.URL, "task-1", "running")
+
+	// The module ignores cancellation until released.
+	srv.mu.Lock()
+	srv.tasks["task-1"].cancel = func() {}
+	srv.mu.Unlock()
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/cancel?task_id=task-1", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	resp.Body.Close()
+
+	for _, id := range []string{"task-2", "task-3"} {
+		if _, err := dispatcher.Dispatch(context.Background(), scheduler.Task{ID: id, Step: workflow.Step{Name: "echo", Action: "test.echo"}}); err != nil {
+			t.Fatalf("dispatch %s: %v", id, err)
+		}
+	}
+	retry := scheduler.NewAgentDispatcher(ts.URL)
+	retry.AsyncTimeout = time.Second
+	result, err := retry.Dispatch(context.Background(), scheduler.Task{ID: "task-1", Step: workflow.Step{Name: "wait", Action: "test.wait"}})
+	if err == nil || result.Status != "failed" || !strings.Contains(err.Error(), "task canceled") {
+		t.Fatalf("expected a retried run to report the canceled task, got %+v %v", result, err)
+	}
+}
+
+func waitForStatus(t *testing.T, baseURL, taskID, status string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err := http.Get(baseURL + "/status?task_id=" + taskID)
+		if err == nil {
+			var decoded runResponse
+			_ = json.NewDecoder(resp.Body).Decode(&decoded)
+			resp.Body.Close()
+			if decoded.Result.Status == status {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %s did not reach status %s", taskID, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerMarksInterruptedTasks(t *testing.T) {
+	store := NewTaskStore(filepath.Join(t.TempDir(), "agent_tasks.json"), 0)
+	now := time.Now().UTC()
+	if err := store.Save([]TaskRecord{
+		{
+			Task:       scheduler.Task{ID: "task-done", RunID: "run-1"},
+			Result:     scheduler.Result{TaskID: "task-done", Status: "success"},
+			Done:       true,
+			StartedAt:  now.Add(-time.Minute),
+			FinishedAt: now.Add(-time.Minute),
+		},
+		{
+			Task:      scheduler.Task{ID: "task-running", RunID: "run-1"},
+			Result:    scheduler.Result{TaskID: "task-running", Status: "running"},
+			StartedAt: now,
+		},
+	}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	_, ts := newTestServer(t, modules.NewRegistry(), nil, Options{Store: store})
+
+	status := func(id string) scheduler.Result {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/status?task_id=" + id)
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		defer resp.Body.Close()
+		var decoded runResponse
+		_ = json.NewDecoder(resp.Body).Decode(&decoded)
+		return decoded.Result
+	}
+	if got := status("task-done"); got.Status != "success" {
+		t.Fatalf("expected finished task to keep its result, got %+v", got)
+	}
+	if got := status("task-running"); got.Status != "failed" || !strings.Contains(got.Error, "restarted") {
+		t.Fatalf("expected running task to be marked failed, got %+v", got)
+	}
+
+	records, err := store.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	for _, record := range records {
+		if !record.Done {
+			t.Fatalf("expected interrupted task to be saved as done, got %+v", record)
+		}
+	}
+}
+
+func TestTaskStoreKeepsNewestFinishedTasks(t *testing.T) {
+	now := time.Now().UTC()
+	records := []TaskRecord{
+		{Task: scheduler.Task{ID: "old"}, Done: true, StartedAt: now.Add(-3 * time.Minute)},
+		{Task: scheduler.Task{ID: "running"}, StartedAt: now.Add(-4 * time.Minute)},
+		{Task: scheduler.Task{ID: "new"}, Done: true, StartedAt: now.Add(-time.Minute)},
+	}
+	got := pruneTasks(records, 1)
+	if len(got) != 2 || got[0].Task.ID != "running" || got[1].Task.ID != "new" {
+		t.Fatalf("unexpected pruned tasks %+v", got)
+	}
+}
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bops/runner/scheduler"
+)
+
+// DefaultTaskHistory is the number of finished tasks kept when
+// TaskStore.Limit is not set.
+const DefaultTaskHistory = 500
+
+// TaskRecord is the state of one task run by the agent.
+type TaskRecord struct {
+	Task       scheduler.Task   `json:"task"`
+	Result     scheduler.Result `json:"result"`
+	Done       bool             `json:"done"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+}
+
+// TaskStore keeps the tasks of the agent in a JSON file, so their results
+// can still be fetched after a restart. The file holds task vars and is
+// only readable by its owner. An empty Path keeps nothing.
+type TaskStore struct {
+	Path  string
+	Limit int
+	mu    sync.Mutex
+}
+
+func NewTaskStore(path string, limit int) *TaskStore {
+	return &TaskStore{Path: path, Limit: limit}
+}
+
+// Load returns the stored tasks, oldest first.
+func (s *TaskStore) Load() ([]TaskRecord, error) {
+	if s == nil || strings.TrimSpace(s.Path) == "" {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []TaskRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parse agent tasks %s: %w", s.Path, err)
+	}
+	return records, nil
+}
+
+// Save replaces the stored tasks with records. Unfinished tasks are always
+// kept; finished ones beyond the limit are dropped, oldest first.
+func (s *TaskStore) Save(records []TaskRecord) error {
+	if s == nil || strings.TrimSpace(s.Path) == "" {
+		return nil
+	}
+	records = pruneTasks(records, s.Limit)
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+// pruneTasks returns records oldest first without the oldest finished
+// tasks beyond limit.
+func pruneTasks(records []TaskRecord, limit int) []TaskRecord {
+	sorted := append([]TaskRecord(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].StartedAt.Before(sorted[j].StartedAt)
+	})
+	if limit <= 0 {
+		limit = DefaultTaskHistory
+	}
+	done := 0
+	for _, record := range sorted {
+		if record.Done {
+			done++
+		}
+	}
+	out := make([]TaskRecord, 0, len(sorted))
+	for _, record := range sorted {
+		if record.Done && done > limit {
+			done--
+			continue
+		}
+		out = append(out, record)
+	}
+	return out
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Config defines process-level settings loaded from a JSON file.
@@ -14,8 +15,14 @@ type Config struct {
 	LogFormat          string        `json:"log_format"`
 	DataDir            string        `json:"data_dir"`
 	StatePath          string        `json:"state_path"`
+	RunStorePath       string        `json:"run_store_path,omitempty"`
+	RunRetention       RunRetention  `json:"run_retention"`
+	DriftScan          DriftScan     `json:"drift_scan"`
+	Scheduler          Scheduler     `json:"scheduler"`
+	RunQueue           RunQueue      `json:"run_queue"`
 	ServerListen       string        `json:"server_listen"`
 	AgentListen        string        `json:"agent_listen"`
+	AgentServer        AgentServer   `json:"agent_server"`
 	StaticDir          string        `json:"static_dir"`
 	CORSOrigins        []string      `json:"cors_origins"`
 	AIProvider         string        `json:"ai_provider"`
@@ -31,6 +38,72 @@ type Config struct {
 	ToolConflictPolicy string        `json:"tool_conflict_policy"`
 }
 
+// RunRetention bounds the run history kept in the state file. Durations use
+// Go syntax such as "720h"; zero values disable the matching limit.
+type RunRetention struct {
+	// MaxRunsPerWorkflow keeps only the newest finished runs of each workflow.
+	MaxRunsPerWorkflow int `json:"max_runs_per_workflow,omitempty"`
+	// MaxAge drops finished runs older than this.
+	MaxAge string `json:"max_age,omitempty"`
+	// FailedMaxAge keeps failed, canceled and interrupted runs until this age
+	// instead of MaxAge, and exempts them from MaxRunsPerWorkflow.
+	FailedMaxAge string `json:"failed_max_age,omitempty"`
+	// CompactInterval is how often the policy is enforced.
+	CompactInterval string `json:"compact_interval,omitempty"`
+	// OutputSpillBytes moves host output fields larger than this into
+	// per-run log files; 0 keeps every output inline.
+	OutputSpillBytes int `json:"output_spill_bytes,omitempty"`
+}
+
+// DriftScan schedules drift checks of stored workflows. Scans only run
+// Check, never Apply.
+type DriftScan struct {
+	// Interval between scans, such as "30m"; empty disables scheduled scans.
+	Interval string `json:"interval,omitempty"`
+	// Workflows lists the stored workflows to scan; "*" scans all of them.
+	Workflows []string `json:"workflows,omitempty"`
+	// History is the number of scans kept per workflow.
+	History int `json:"history,omitempty"`
+}
+
+// Scheduler configures cron schedules of stored workflows.
+type Scheduler struct {
+	// CatchUp is the default for schedules without catch_up: "skip" drops
+	// runs missed while the server was down, "once" starts one of them.
+	CatchUp string `json:"catch_up,omitempty"`
+	// CatchUpWindow skips missed runs older than this even when catching
+	// up, such as "6h"; empty means no limit.
+	CatchUpWindow string `json:"catch_up_window,omitempty"`
+}
+
+// RunQueue limits how many runs the server applies at once. Runs beyond the
+// limits, or whose hosts are in use by another run, wait in a queue.
+type RunQueue struct {
+	// MaxConcurrent caps running runs across all workflows; 0 is unlimited.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// MaxPerWorkflow caps running runs of one workflow; 0 is unlimited.
+	MaxPerWorkflow int `json:"max_per_workflow,omitempty"`
+}
+
+// AgentServer configures the bops-agent daemon, which listens on
+// AgentListen and runs the tasks AgentDispatcher sends it.
+type AgentServer struct {
+	// ID identifies the agent in /info; empty uses the hostname.
+	ID string `json:"id,omitempty"`
+	// Token is required from dispatchers when set.
+	Token string `json:"token,omitempty"`
+	// Capabilities lists the actions the agent runs; empty allows every
+	// built-in action except workflow.call.
+	Capabilities []string `json:"capabilities,omitempty"`
+	// MaxTasks caps the tasks running at once; 0 is unlimited.
+	MaxTasks int `json:"max_tasks,omitempty"`
+	// TaskStatePath keeps task results across restarts; empty uses
+	// <data_dir>/agent_tasks.json.
+	TaskStatePath string `json:"task_state_path,omitempty"`
+	// TaskHistory is the number of finished tasks kept.
+	TaskHistory int `json:"task_history,omitempty"`
+}
+
 type AgentConfig struct {
 	Name   string   `json:"name"`
 	Role   string   `json:"role,omitempty"`
@@ -60,6 +133,16 @@ func DefaultConfig() Config {
 		DefaultAgent:       "",
 		DefaultAgents:      nil,
 		ToolConflictPolicy: "error",
+		RunRetention: RunRetention{
+			CompactInterval:  "1h",
+			OutputSpillBytes: 64 * 1024,
+		},
+		Scheduler: Scheduler{
+			CatchUp: "skip",
+		},
+		RunQueue: RunQueue{
+			MaxPerWorkflow: 1,
+		},
 	}
 }
 
@@ -120,6 +203,9 @@ func ApplyEnvOverrides(cfg *Config) error {
 		}
 		cfg.Agents = agents
 	}
+	if raw := os.Getenv("BOPS_AGENT_TOKEN"); raw != "" {
+		cfg.AgentServer.Token = strings.TrimSpace(raw)
+	}
 	if raw := os.Getenv("BOPS_AI_PLANNER_MODEL"); raw != "" {
 		cfg.AIPlannerModel = strings.TrimSpace(raw)
 	}
@@ -132,7 +218,8 @@ func ApplyEnvOverrides(cfg *Config) error {
 	return nil
 }
 
-// Validate checks optional Claude skill and agent configuration.
+// Validate checks optional Claude skill, agent, run retention, drift scan,
+// scheduler, run queue and agent server configuration.
 func (cfg *Config) Validate() error {
 	if cfg == nil {
 		return nil
@@ -151,6 +238,46 @@ func (cfg *Config) Validate() error {
 			return fmt.Errorf("agent %s has no skills", name)
 		}
 	}
+	for field, raw := range map[string]string{
+		"run_retention.max_age":          cfg.RunRetention.MaxAge,
+		"run_retention.failed_max_age":   cfg.RunRetention.FailedMaxAge,
+		"run_retention.compact_interval": cfg.RunRetention.CompactInterval,
+		"drift_scan.interval":            cfg.DriftScan.Interval,
+		"scheduler.catch_up_window":      cfg.Scheduler.CatchUpWindow,
+	} {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(raw); err != nil || d < 0 {
+			return fmt.Errorf("invalid %s: %s", field, raw)
+		}
+	}
+	if cfg.RunRetention.MaxRunsPerWorkflow < 0 {
+		return fmt.Errorf("invalid run_retention.max_runs_per_workflow: %d", cfg.RunRetention.MaxRunsPerWorkflow)
+	}
+	if cfg.RunRetention.OutputSpillBytes < 0 {
+		return fmt.Errorf("invalid run_retention.output_spill_bytes: %d", cfg.RunRetention.OutputSpillBytes)
+	}
+	if cfg.DriftScan.History < 0 {
+		return fmt.Errorf("invalid drift_scan.history: %d", cfg.DriftScan.History)
+	}
+	if cfg.RunQueue.MaxConcurrent < 0 {
+		return fmt.Errorf("invalid run_queue.max_concurrent: %d", cfg.RunQueue.MaxConcurrent)
+	}
+	if cfg.RunQueue.MaxPerWorkflow < 0 {
+		return fmt.Errorf("invalid run_queue.max_per_workflow: %d", cfg.RunQueue.MaxPerWorkflow)
+	}
+	if cfg.AgentServer.MaxTasks < 0 {
+		return fmt.Errorf("invalid agent_server.max_tasks: %d", cfg.AgentServer.MaxTasks)
+	}
+	if cfg.AgentServer.TaskHistory < 0 {
+		return fmt.Errorf("invalid agent_server.task_history: %d", cfg.AgentServer.TaskHistory)
+	}
+	switch cfg.Scheduler.CatchUp {
+	case "", "skip", "once":
+	default:
+		return fmt.Errorf("invalid scheduler.catch_up: %s", cfg.Scheduler.CatchUp)
+	}
 	if cfg.ToolConflictPolicy != "" {
 		switch cfg.ToolConflictPolicy {
 		case "error", "overwrite", "keep", "prefix":
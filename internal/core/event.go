@@ -14,6 +14,14 @@ const (
 	EventStepFailed    EventType = "step_failed"
 	EventPlanGenerated EventType = "plan_generated"
 	EventAgentOutput   EventType = "agent_output"
+	EventPhaseStart    EventType = "phase_start"
+	EventPhaseEnd      EventType = "phase_end"
+	EventSignal        EventType = "signal"
+	EventWaitStart     EventType = "wait_start"
+	EventWaitEnd       EventType = "wait_end"
+	EventDriftDetected EventType = "drift_detected"
+	EventDriftResolved EventType = "drift_resolved"
+	EventRunQueued     EventType = "run_queued"
 )
 
 const (
@@ -0,0 +1,271 @@
+package drift
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bops/internal/core"
+	"bops/internal/eventbus"
+	"bops/runner/logging"
+	"bops/runner/planner"
+	"bops/runner/state"
+	"bops/runner/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	StatusInSync  = "in_sync"
+	StatusDrifted = "drifted"
+	StatusFailed  = "failed"
+)
+
+// Scan is one drift check of a workflow: the state of every step/host the
+// workflow manages and which of them no longer match it.
+type Scan struct {
+	ID         string                         `json:"id"`
+	Workflow   string                         `json:"workflow"`
+	Status     string                         `json:"status"`
+	Error      string                         `json:"error,omitempty"`
+	StartedAt  time.Time                      `json:"started_at"`
+	FinishedAt time.Time                      `json:"finished_at"`
+	Drifted    []string                       `json:"drifted,omitempty"`
+	Hosts      []string                       `json:"hosts,omitempty"`
+	Resources  map[string]state.ResourceState `json:"resources,omitempty"`
+}
+
+// Planner computes a plan without changing hosts; *engine.Engine implements
+// it.
+type Planner interface {
+	Plan(ctx context.Context, wf workflow.Workflow) (planner.Plan, error)
+}
+
+// Scanner periodically plans stored workflows and records whether their
+// hosts still match them.
+type Scanner struct {
+	Planner Planner
+	// Load returns the workflow to scan, ready to plan.
+	Load func(name string) (workflow.Workflow, error)
+	// Workflows returns the names of the workflows scanned by ScanAll.
+	Workflows func() ([]string, error)
+	Store     *Store
+	Bus       *eventbus.Bus
+}
+
+// Scan checks one workflow, records the result and publishes a drift event
+// when the set of drifted resources changed since the previous scan.
+func (s *Scanner) Scan(ctx context.Context, name string) (Scan, error) {
+	scan := Scan{
+		ID:        fmt.Sprintf("drift-%d", time.Now().UTC().UnixNano()),
+		Workflow:  name,
+		StartedAt: time.Now().UTC(),
+	}
+	previous, hasPrevious, err := s.Store.Latest(name)
+	if err != nil {
+		return Scan{}, err
+	}
+
+	plan, err := s.plan(ctx, name)
+	scan.FinishedAt = time.Now().UTC()
+	if err != nil {
+		scan.Status = StatusFailed
+		scan.Error = err.Error()
+		if storeErr := s.Store.Append(scan); storeErr != nil {
+			return scan, storeErr
+		}
+		return scan, err
+	}
+	scan.Resources = Resources(plan, scan.FinishedAt)
+	hosts := map[string]struct{}{}
+	for _, step := range plan.Steps {
+		// Operations always report a change, so they would show every host
+		// as drifted. A called workflow is scanned on its own.
+		if planner.IsOperation(step.Action) {
+			continue
+		}
+		for _, change := range step.Changes {
+			scan.Drifted = append(scan.Drifted, change.ResourceID)
+			hosts[strings.TrimPrefix(change.ResourceID, step.Name+":")] = struct{}{}
+		}
+	}
+	sort.Strings(scan.Drifted)
+	scan.Drifted = dedupe(scan.Drifted)
+	for hostName := range hosts {
+		scan.Hosts = append(scan.Hosts, hostName)
+	}
+	sort.Strings(scan.Hosts)
+	scan.Status = StatusInSync
+	if len(scan.Drifted) > 0 {
+		scan.Status = StatusDrifted
+	}
+	if err := s.Store.Append(scan); err != nil {
+		return scan, err
+	}
+
+	var wasDrifted []string
+	if hasPrevious && previous.Status == StatusDrifted {
+		wasDrifted = previous.Drifted
+	}
+	s.publish(scan, wasDrifted, hasPrevious && previous.Status != StatusFailed)
+	return scan, nil
+}
+
+func (s *Scanner) plan(ctx context.Context, name string) (planner.Plan, error) {
+	wf, err := s.Load(name)
+	if err != nil {
+		return planner.Plan{}, err
+	}
+	return s.Planner.Plan(ctx, wf)
+}
+
+func (s *Scanner) publish(scan Scan, wasDrifted []string, known bool) {
+	if s.Bus == nil {
+		return
+	}
+	switch {
+	case scan.Status == StatusDrifted && !equalStrings(scan.Drifted, wasDrifted):
+		s.Bus.Publish(core.Event{
+			ID:         scan.ID,
+			Type:       core.EventDriftDetected,
+			Level:      core.EventWarn,
+			Time:       scan.FinishedAt,
+			WorkflowID: scan.Workflow,
+			Message:    fmt.Sprintf("%d resource(s) on %d host(s) no longer match workflow %s", len(scan.Drifted), len(scan.Hosts), scan.Workflow),
+			Data: map[string]any{
+				"scan_id":   scan.ID,
+				"resources": scan.Drifted,
+				"hosts":     scan.Hosts,
+			},
+		})
+	case scan.Status == StatusInSync && known && len(wasDrifted) > 0:
+		s.Bus.Publish(core.Event{
+			ID:         scan.ID,
+			Type:       core.EventDriftResolved,
+			Level:      core.EventInfo,
+			Time:       scan.FinishedAt,
+			WorkflowID: scan.Workflow,
+			Message:    fmt.Sprintf("hosts match workflow %s again", scan.Workflow),
+			Data: map[string]any{
+				"scan_id":   scan.ID,
+				"resources": wasDrifted,
+			},
+		})
+	}
+}
+
+// ScanAll scans every workflow returned by Workflows. Failures are logged
+// and recorded in the workflow's history.
+func (s *Scanner) ScanAll(ctx context.Context) {
+	names, err := s.Workflows()
+	if err != nil {
+		logging.L().Warn("drift scan list workflows failed", zap.Error(err))
+		return
+	}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		scan, err := s.Scan(ctx, name)
+		if err != nil {
+			logging.L().Warn("drift scan failed", zap.String("workflow", name), zap.Error(err))
+			continue
+		}
+		logging.L().Debug("drift scan done",
+			zap.String("workflow", name),
+			zap.String("status", scan.Status),
+			zap.Int("drifted", len(scan.Drifted)),
+		)
+	}
+}
+
+// Start scans now and then every interval until ctx is done.
+func (s *Scanner) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.ScanAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Resources turns a plan into one ResourceState per step and host, keyed
+// by "step:host". Hosts without changes get an empty diff. Fields reported
+// without a current value identify the resource and are recorded as in
+// sync.
+func Resources(plan planner.Plan, now time.Time) map[string]state.ResourceState {
+	resources := map[string]state.ResourceState{}
+	for _, step := range plan.Steps {
+		if planner.IsOperation(step.Action) {
+			continue
+		}
+		for _, target := range step.Targets {
+			id := step.Name + ":" + target
+			resources[id] = state.ResourceState{
+				ID:        id,
+				Type:      step.Action,
+				Desired:   map[string]any{},
+				Current:   map[string]any{},
+				Diff:      map[string]any{},
+				UpdatedAt: now,
+			}
+		}
+		for _, change := range step.Changes {
+			resource, ok := resources[change.ResourceID]
+			if !ok {
+				resource = state.ResourceState{
+					ID:        change.ResourceID,
+					Type:      step.Action,
+					Desired:   map[string]any{},
+					Current:   map[string]any{},
+					Diff:      map[string]any{},
+					UpdatedAt: now,
+				}
+			}
+			for key, entry := range change.Diff {
+				resource.Desired[key] = entry.Desired
+				if entry.Current == nil && entry.Text == "" {
+					resource.Current[key] = entry.Desired
+					continue
+				}
+				resource.Current[key] = entry.Current
+				resource.Diff[key] = entry
+			}
+			resources[change.ResourceID] = resource
+		}
+	}
+	return resources
+}
+
+func dedupe(sorted []string) []string {
+	out := sorted[:0]
+	for i, value := range sorted {
+		if i > 0 && value == sorted[i-1] {
+			continue
+		}
+		out = append(out, value)
+	}
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package drift
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"bops/internal/core"
+	"bops/internal/eventbus"
+	"bops/runner/planner"
+	"bops/runner/workflow"
+)
+
+type fakePlanner struct {
+	plans []planner.Plan
+	err   error
+	calls int
+}
+
+func (f *fakePlanner) Plan(ctx context.Context, wf workflow.Workflow) (planner.Plan, error) {
+	if f.err != nil {
+		return planner.Plan{}, f.err
+	}
+	plan := f.plans[min(f.calls, len(f.plans)-1)]
+	f.calls++
+	return plan, nil
+}
+
+func driftPlan(changed ...string) planner.Plan {
+	render := planner.StepPlan{Name: "render", Action: "template.render", Targets: []string{"web1", "web2"}}
+	for _, hostName := range changed {
+		render.Changes = append(render.Changes, planner.ResourceChange{
+			ResourceID: "render:" + hostName,
+			Diff: map[string]planner.DiffEntry{
+				"dest":    {Desired: "/etc/app.conf"},
+				"content": {Current: "aaa", Desired: "bbb", Text: "-a\n+b\n"},
+			},
+		})
+	}
+	return planner.Plan{
+		WorkflowName: "deploy",
+		Steps: []planner.StepPlan{
+			{Name: "migrate", Action: "cmd.run", Targets: []string{"web1"}, Changes: []planner.ResourceChange{
+				{ResourceID: "migrate:web1", Diff: map[string]planner.DiffEntry{"cmd": {Desired: "true"}}},
+			}},
+			render,
+		},
+	}
+}
+
+func newTestScanner(t *testing.T, fake *fakePlanner, bus *eventbus.Bus) *Scanner {
+	t.Helper()
+	return &Scanner{
+		Planner: fake,
+		Load: func(name string) (workflow.Workflow, error) {
+			return workflow.Workflow{Name: name}, nil
+		},
+		Workflows: func() ([]string, error) { return []string{"deploy"}, nil },
+		Store:     NewStore(filepath.Join(t.TempDir(), "drift"), 3),
+		Bus:       bus,
+	}
+}
+
+func TestScanRecordsResourcesAndPublishesTransitions(t *testing.T) {
+	bus := eventbus.New()
+	sub := bus.Subscribe(8)
+	defer sub.Cancel()
+	fake := &fakePlanner{plans: []planner.Plan{driftPlan("web2"), driftPlan("web2"), driftPlan()}}
+	scanner := newTestScanner(t, fake, bus)
+
+	scan, err := scanner.Scan(context.Background(), "deploy")
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if scan.Status != StatusDrifted || !reflect.DeepEqual(scan.Drifted, []string{"render:web2"}) || !reflect.DeepEqual(scan.Hosts, []string{"web2"}) {
+		t.Fatalf("unexpected scan: %+v", scan)
+	}
+	if _, ok := scan.Resources["migrate:web1"]; ok {
+		t.Fatalf("expected cmd.run to be ignored, got %v", scan.Resources)
+	}
+	if report := planner.DetectDrift(scan.Resources["render:web1"]); report.HasDrift {
+		t.Fatalf("expected web1 in sync, got %+v", report)
+	}
+	report := planner.DetectDrift(scan.Resources["render:web2"])
+	if !report.HasDrift || len(report.Diff) != 1 || report.Diff["content"].Current != "aaa" {
+		t.Fatalf("expected content drift on web2, got %+v", report)
+	}
+	expectEvent(t, sub.C, core.EventDriftDetected)
+
+	// The same drift again is not announced twice.
+	if _, err := scanner.Scan(context.Background(), "deploy"); err != nil {
+		t.Fatalf("second scan: %v", err)
+	}
+	if scan, err = scanner.Scan(context.Background(), "deploy"); err != nil || scan.Status != StatusInSync {
+		t.Fatalf("expected in sync scan, got %+v %v", scan, err)
+	}
+	expectEvent(t, sub.C, core.EventDriftResolved)
+
+	history, err := scanner.Store.History("deploy")
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if len(history) != 3 || history[0].Status != StatusInSync || history[2].Status != StatusDrifted {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+}
+
+func TestScanRecordsFailuresAndTrimsHistory(t *testing.T) {
+	fake := &fakePlanner{plans: []planner.Plan{driftPlan()}}
+	scanner := newTestScanner(t, fake, nil)
+	for i := 0; i < 4; i++ {
+		scanner.ScanAll(context.Background())
+	}
+	fake.err = fmt.Errorf("host unreachable")
+	scan, err := scanner.Scan(context.Background(), "deploy")
+	if err == nil || scan.Status != StatusFailed || scan.Error != "host unreachable" {
+		t.Fatalf("expected failed scan, got %+v %v", scan, err)
+	}
+
+	history, err := scanner.Store.History("deploy")
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if len(history) != 3 || history[0].Status != StatusFailed {
+		t.Fatalf("expected 3 scans with the failure first, got %+v", history)
+	}
+	if _, err := scanner.Store.History("../etc"); err == nil {
+		t.Fatalf("expected invalid workflow name to be rejected")
+	}
+}
+
+func expectEvent(t *testing.T, events <-chan core.Event, want core.EventType) {
+	t.Helper()
+	select {
+	case event := <-events:
+		if event.Type != want || event.WorkflowID != "deploy" {
+			t.Fatalf("expected %s for deploy, got %+v", want, event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected %s event", want)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected extra event %+v", event)
+	default:
+	}
+}
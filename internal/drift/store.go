@@ -0,0 +1,124 @@
+package drift
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultHistory is the number of scans kept per workflow when Store.Limit
+// is not set.
+const DefaultHistory = 50
+
+// Store keeps the scan history of each workflow in <Dir>/<workflow>.json,
+// oldest first.
+type Store struct {
+	Dir   string
+	Limit int
+	mu    sync.Mutex
+}
+
+func NewStore(dir string, limit int) *Store {
+	return &Store{Dir: dir, Limit: limit}
+}
+
+// History returns the scans of workflow, newest first.
+func (s *Store) History(workflow string) ([]Scan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scans, err := s.load(workflow)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Scan, len(scans))
+	for i, scan := range scans {
+		out[len(scans)-1-i] = scan
+	}
+	return out, nil
+}
+
+// Latest returns the newest scan of workflow, if any.
+func (s *Store) Latest(workflow string) (Scan, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scans, err := s.load(workflow)
+	if err != nil || len(scans) == 0 {
+		return Scan{}, false, err
+	}
+	return scans[len(scans)-1], true, nil
+}
+
+// Append records scan and drops the oldest scans beyond the limit.
+func (s *Store) Append(scan Scan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scans, err := s.load(scan.Workflow)
+	if err != nil {
+		return err
+	}
+	scans = append(scans, scan)
+	limit := s.Limit
+	if limit <= 0 {
+		limit = DefaultHistory
+	}
+	if len(scans) > limit {
+		scans = scans[len(scans)-limit:]
+	}
+
+	path, err := s.path(scan.Workflow)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(scans, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *Store) load(workflow string) ([]Scan, error) {
+	path, err := s.path(workflow)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var scans []Scan
+	if err := json.Unmarshal(data, &scans); err != nil {
+		return nil, fmt.Errorf("parse drift history %s: %w", path, err)
+	}
+	return scans, nil
+}
+
+func (s *Store) path(workflow string) (string, error) {
+	if strings.TrimSpace(s.Dir) == "" {
+		return "", fmt.Errorf("drift store dir is empty")
+	}
+	name := strings.TrimSpace(workflow)
+	if name == "" {
+		return "", fmt.Errorf("workflow name is empty")
+	}
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			continue
+		}
+		return "", fmt.Errorf("invalid workflow name %q", workflow)
+	}
+	return filepath.Join(s.Dir, name+".json"), nil
+}
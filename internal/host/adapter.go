@@ -3,13 +3,18 @@ package host
 import (
 	"bytes"
 	"context"
+	"io"
 	"os"
 	"os/exec"
 )
 
 type RunOptions struct {
-	Dir string
-	Env []string
+	Dir   string
+	Env   []string
+	Stdin io.Reader
+	// Stdout and Stderr receive a copy of the command output while it runs.
+	Stdout io.Writer
+	Stderr io.Writer
 }
 
 type RunResult struct {
@@ -23,6 +28,7 @@ type Adapter interface {
 	ReadFile(path string) ([]byte, error)
 	WriteFile(path string, data []byte, perm os.FileMode) error
 	MkdirAll(path string, perm os.FileMode) error
+	Remove(path string) error
 	LookPath(file string) (string, error)
 }
 
@@ -40,10 +46,13 @@ func (a *LocalAdapter) Run(ctx context.Context, cmd string, args []string, opts
 	if len(opts.Env) > 0 {
 		command.Env = append(os.Environ(), opts.Env...)
 	}
+	if opts.Stdin != nil {
+		command.Stdin = opts.Stdin
+	}
 
 	var stdout, stderr bytes.Buffer
-	command.Stdout = &stdout
-	command.Stderr = &stderr
+	command.Stdout = teeWriter(&stdout, opts.Stdout)
+	command.Stderr = teeWriter(&stderr, opts.Stderr)
 
 	err := command.Run()
 	exitCode := 0
@@ -72,6 +81,17 @@ func (a *LocalAdapter) MkdirAll(path string, perm os.FileMode) error {
 	return os.MkdirAll(path, perm)
 }
 
+func (a *LocalAdapter) Remove(path string) error {
+	return os.Remove(path)
+}
+
 func (a *LocalAdapter) LookPath(file string) (string, error) {
 	return exec.LookPath(file)
 }
+
+func teeWriter(buf *bytes.Buffer, extra io.Writer) io.Writer {
+	if extra == nil {
+		return buf
+	}
+	return io.MultiWriter(buf, extra)
+}
@@ -0,0 +1,82 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"bops/runner/workflow"
+)
+
+// Pool hands out adapters per host, keeping one SSH connection per distinct
+// user/address/key and reusing it across steps and runs.
+type Pool struct {
+	mu      sync.Mutex
+	local   *LocalAdapter
+	clients map[string]*SSHAdapter
+	dial    func(ctx context.Context, cfg SSHConfig) (*SSHAdapter, error)
+}
+
+func NewPool() *Pool {
+	return &Pool{
+		local:   NewLocalAdapter(),
+		clients: map[string]*SSHAdapter{},
+		dial:    DialSSH,
+	}
+}
+
+// Adapter returns the adapter for the host: a pooled SSH connection when the
+// host is configured for ssh, otherwise the local adapter.
+func (p *Pool) Adapter(ctx context.Context, spec workflow.HostSpec) (Adapter, error) {
+	if !UsesSSH(spec) {
+		return p.local, nil
+	}
+	cfg, err := SSHConfigFromHost(spec)
+	if err != nil {
+		return nil, err
+	}
+	key := cfg.key()
+
+	p.mu.Lock()
+	if existing, ok := p.clients[key]; ok {
+		if existing.Alive() {
+			p.mu.Unlock()
+			return existing, nil
+		}
+		delete(p.clients, key)
+	}
+	p.mu.Unlock()
+
+	adapter, err := p.dial(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if existing, ok := p.clients[key]; ok && existing.Alive() {
+		// Another goroutine connected first; keep a single connection.
+		_ = adapter.Close()
+		return existing, nil
+	}
+	p.clients[key] = adapter
+	return adapter, nil
+}
+
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	clients := p.clients
+	p.clients = map[string]*SSHAdapter{}
+	p.mu.Unlock()
+
+	var errs []error
+	for _, client := range clients {
+		if !client.Alive() {
+			continue
+		}
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,425 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"bops/runner/workflow"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSSHPort    = 22
+	defaultSSHTimeout = 10 * time.Second
+)
+
+// SSHConfig describes how to reach a single host over SSH.
+type SSHConfig struct {
+	Address        string
+	Port           int
+	User           string
+	KeyPath        string
+	Password       string
+	KnownHostsPath string
+	// InsecureIgnoreHostKey disables host key verification. Only meant for
+	// throwaway environments and tests.
+	InsecureIgnoreHostKey bool
+	Timeout               time.Duration
+}
+
+// UsesSSH reports whether the host should be reached over SSH instead of
+// running on the local machine. An explicit connection var wins; otherwise
+// any ssh_* connection var opts the host in.
+func UsesSSH(spec workflow.HostSpec) bool {
+	if conn, ok := readVarString(spec.Vars, "connection"); ok {
+		return strings.EqualFold(conn, "ssh")
+	}
+	for _, key := range []string{"ssh_user", "ssh_port", "ssh_key_path", "ssh_password"} {
+		if _, ok := spec.Vars[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SSHConfigFromHost builds the connection settings from the resolved host
+// address and its ssh_* vars.
+func SSHConfigFromHost(spec workflow.HostSpec) (SSHConfig, error) {
+	cfg := SSHConfig{
+		Address: strings.TrimSpace(spec.Address),
+		Timeout: defaultSSHTimeout,
+	}
+	if cfg.Address == "" {
+		cfg.Address = strings.TrimSpace(spec.Name)
+	}
+	if cfg.Address == "" {
+		return SSHConfig{}, fmt.Errorf("host address is required for ssh")
+	}
+	if user, ok := readVarString(spec.Vars, "ssh_user"); ok {
+		cfg.User = user
+	}
+	if raw, ok := readVarString(spec.Vars, "ssh_port"); ok {
+		port, err := strconv.Atoi(raw)
+		if err != nil || port <= 0 || port > 65535 {
+			return SSHConfig{}, fmt.Errorf("host %s: invalid ssh_port %q", spec.Name, raw)
+		}
+		cfg.Port = port
+	}
+	if keyPath, ok := readVarString(spec.Vars, "ssh_key_path"); ok {
+		cfg.KeyPath = expandHome(keyPath)
+	}
+	if password, ok := readVarString(spec.Vars, "ssh_password"); ok {
+		cfg.Password = password
+	}
+	if knownHosts, ok := readVarString(spec.Vars, "ssh_known_hosts"); ok {
+		cfg.KnownHostsPath = expandHome(knownHosts)
+	}
+	if raw, ok := readVarString(spec.Vars, "ssh_host_key_check"); ok {
+		check, err := strconv.ParseBool(raw)
+		if err != nil {
+			return SSHConfig{}, fmt.Errorf("host %s: invalid ssh_host_key_check %q", spec.Name, raw)
+		}
+		cfg.InsecureIgnoreHostKey = !check
+	}
+	if raw, ok := readVarString(spec.Vars, "ssh_timeout"); ok {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil {
+			return SSHConfig{}, fmt.Errorf("host %s: invalid ssh_timeout %q", spec.Name, raw)
+		}
+		cfg.Timeout = timeout
+	}
+	if cfg.User == "" {
+		cfg.User = os.Getenv("USER")
+	}
+	if cfg.User == "" {
+		return SSHConfig{}, fmt.Errorf("host %s: ssh_user is required", spec.Name)
+	}
+	return cfg, nil
+}
+
+// Target returns the host:port the client dials.
+func (c SSHConfig) Target() string {
+	if host, port, err := net.SplitHostPort(c.Address); err == nil {
+		if c.Port > 0 {
+			port = strconv.Itoa(c.Port)
+		}
+		return net.JoinHostPort(host, port)
+	}
+	port := c.Port
+	if port <= 0 {
+		port = defaultSSHPort
+	}
+	return net.JoinHostPort(c.Address, strconv.Itoa(port))
+}
+
+func (c SSHConfig) key() string {
+	return fmt.Sprintf("%s@%s|%s", c.User, c.Target(), c.KeyPath)
+}
+
+func (c SSHConfig) clientConfig() (*ssh.ClientConfig, error) {
+	auth, err := c.authMethods()
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := c.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         c.Timeout,
+	}, nil
+}
+
+func (c SSHConfig) authMethods() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	keyPaths := []string{c.KeyPath}
+	if c.KeyPath == "" {
+		keyPaths = defaultKeyPaths()
+	}
+	var signers []ssh.Signer
+	for _, path := range keyPaths {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if c.KeyPath == "" && os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("read ssh key %s: %w", path, err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse ssh key %s: %w", path, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if c.Password != "" {
+		methods = append(methods, ssh.Password(c.Password))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no ssh credentials for %s: set ssh_key_path or ssh_password", c.Target())
+	}
+	return methods, nil
+}
+
+func (c SSHConfig) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if c.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	path := c.KnownHostsPath
+	if path == "" {
+		path = expandHome("~/.ssh/known_hosts")
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("load known_hosts %s: %w", path, err)
+	}
+	return callback, nil
+}
+
+// SSHAdapter runs commands and file operations on a remote host through a
+// single multiplexed SSH connection.
+type SSHAdapter struct {
+	cfg    SSHConfig
+	client *ssh.Client
+	closed atomic.Bool
+}
+
+var _ Adapter = (*SSHAdapter)(nil)
+
+func DialSSH(ctx context.Context, cfg SSHConfig) (*SSHAdapter, error) {
+	clientCfg, err := cfg.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	target := cfg.Target()
+	dialer := net.Dialer{Timeout: cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, fmt.Errorf("ssh dial %s: %w", target, err)
+	}
+	if cfg.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(cfg.Timeout))
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, target, clientCfg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("ssh handshake %s: %w", target, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	adapter := &SSHAdapter{cfg: cfg, client: ssh.NewClient(sshConn, chans, reqs)}
+	go func() {
+		_ = adapter.client.Wait()
+		adapter.closed.Store(true)
+	}()
+	return adapter, nil
+}
+
+// Alive reports whether the underlying connection is still usable.
+func (a *SSHAdapter) Alive() bool {
+	return a != nil && !a.closed.Load()
+}
+
+func (a *SSHAdapter) Close() error {
+	if a == nil || a.client == nil {
+		return nil
+	}
+	a.closed.Store(true)
+	return a.client.Close()
+}
+
+func (a *SSHAdapter) Run(ctx context.Context, cmd string, args []string, opts RunOptions) (RunResult, error) {
+	session, err := a.client.NewSession()
+	if err != nil {
+		return RunResult{}, fmt.Errorf("ssh session %s: %w", a.cfg.Target(), err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = teeWriter(&stdout, opts.Stdout)
+	session.Stderr = teeWriter(&stderr, opts.Stderr)
+	if opts.Stdin != nil {
+		session.Stdin = opts.Stdin
+	}
+
+	if err := session.Start(remoteCommand(cmd, args, opts)); err != nil {
+		return RunResult{}, fmt.Errorf("ssh start %s: %w", a.cfg.Target(), err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+		<-done
+		err = ctx.Err()
+	}
+
+	exitCode := 0
+	if err != nil {
+		var exitErr *ssh.ExitError
+		var missingErr *ssh.ExitMissingError
+		switch {
+		case errors.As(err, &exitErr):
+			exitCode = exitErr.ExitStatus()
+		case errors.As(err, &missingErr):
+			exitCode = -1
+		}
+	}
+
+	return RunResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitCode,
+	}, err
+}
+
+func (a *SSHAdapter) ReadFile(path string) ([]byte, error) {
+	ctx := context.Background()
+	res, err := a.Run(ctx, "cat", []string{"--", path}, RunOptions{})
+	if err == nil {
+		return []byte(res.Stdout), nil
+	}
+	if res.ExitCode != 0 {
+		if probe, probeErr := a.Run(ctx, "test", []string{"-e", path}, RunOptions{}); probeErr != nil && probe.ExitCode == 1 {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+	}
+	return nil, remoteError("read", path, res, err)
+}
+
+func (a *SSHAdapter) WriteFile(path string, data []byte, perm os.FileMode) error {
+	script := `cat > "$1" && chmod "$2" "$1"`
+	res, err := a.Run(context.Background(), "/bin/sh", []string{"-c", script, "sh", path, fmt.Sprintf("%o", perm.Perm())}, RunOptions{
+		Stdin: bytes.NewReader(data),
+	})
+	if err != nil {
+		return remoteError("write", path, res, err)
+	}
+	return nil
+}
+
+func (a *SSHAdapter) MkdirAll(path string, perm os.FileMode) error {
+	res, err := a.Run(context.Background(), "mkdir", []string{"-p", "-m", fmt.Sprintf("%o", perm.Perm()), "--", path}, RunOptions{})
+	if err != nil {
+		return remoteError("mkdir", path, res, err)
+	}
+	return nil
+}
+
+func (a *SSHAdapter) Remove(path string) error {
+	ctx := context.Background()
+	res, err := a.Run(ctx, "rm", []string{"--", path}, RunOptions{})
+	if err == nil {
+		return nil
+	}
+	if res.ExitCode != 0 {
+		if probe, probeErr := a.Run(ctx, "test", []string{"-e", path}, RunOptions{}); probeErr != nil && probe.ExitCode == 1 {
+			return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+		}
+	}
+	return remoteError("remove", path, res, err)
+}
+
+func (a *SSHAdapter) LookPath(file string) (string, error) {
+	res, err := a.Run(context.Background(), "/bin/sh", []string{"-c", `command -v "$1"`, "sh", file}, RunOptions{})
+	found := strings.TrimSpace(res.Stdout)
+	if err != nil || found == "" {
+		return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+	}
+	return found, nil
+}
+
+// remoteCommand renders the argv into a single command line for the remote
+// login shell, applying Dir and Env the way exec.Cmd would locally.
+func remoteCommand(cmd string, args []string, opts RunOptions) string {
+	parts := make([]string, 0, len(args)+len(opts.Env)+2)
+	if len(opts.Env) > 0 {
+		parts = append(parts, "env")
+		for _, kv := range opts.Env {
+			parts = append(parts, shellQuote(kv))
+		}
+	}
+	parts = append(parts, shellQuote(cmd))
+	for _, arg := range args {
+		parts = append(parts, shellQuote(arg))
+	}
+	line := strings.Join(parts, " ")
+	if opts.Dir != "" {
+		line = "cd " + shellQuote(opts.Dir) + " && " + line
+	}
+	return line
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func remoteError(op, path string, res RunResult, err error) error {
+	if detail := strings.TrimSpace(res.Stderr); detail != "" {
+		return fmt.Errorf("%s %s: %s: %w", op, path, detail, err)
+	}
+	return fmt.Errorf("%s %s: %w", op, path, err)
+}
+
+func readVarString(vars map[string]any, key string) (string, bool) {
+	if vars == nil {
+		return "", false
+	}
+	raw, ok := vars[key]
+	if !ok || raw == nil {
+		return "", false
+	}
+	value := strings.TrimSpace(fmt.Sprint(raw))
+	if value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+func defaultKeyPaths() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	return []string{
+		filepath.Join(home, ".ssh", "id_ed25519"),
+		filepath.Join(home, ".ssh", "id_ecdsa"),
+		filepath.Join(home, ".ssh", "id_rsa"),
+	}
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
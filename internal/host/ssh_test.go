@@ -0,0 +1,342 @@
+package host
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"bops/runner/workflow"
+	"golang.org/x/crypto/ssh"
+)
+
+type testSSHServer struct {
+	addr    string
+	keyPath string
+	conns   atomic.Int32
+}
+
+// startTestSSHServer runs an in-process SSH server that executes "exec"
+// requests with the local /bin/sh, which is enough to exercise the adapter.
+func startTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	if _, err := exec.LookPath("/bin/sh"); err != nil {
+		t.Skip("/bin/sh not available")
+	}
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("host signer: %v", err)
+	}
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatalf("client public key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatalf("marshal client key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("write client key: %v", err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized")
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &testSSHServer{addr: listener.Addr().String(), keyPath: keyPath}
+
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		_ = listener.Close()
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.conns.Add(1)
+			go server.serveConn(conn, cfg)
+		}
+	}()
+	return server
+}
+
+func (s *testSSHServer) serveConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			_ = newChan.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(channel, requests)
+	}
+}
+
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		if len(req.Payload) < 4 {
+			_ = req.Reply(false, nil)
+			return
+		}
+		size := binary.BigEndian.Uint32(req.Payload[:4])
+		command := string(req.Payload[4 : 4+size])
+		_ = req.Reply(true, nil)
+
+		cmd := exec.Command("/bin/sh", "-c", command)
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		stdin, _ := cmd.StdinPipe()
+		go func() {
+			_, _ = io.Copy(stdin, channel)
+			_ = stdin.Close()
+		}()
+		status := uint32(0)
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				status = uint32(exitErr.ExitCode())
+			} else {
+				status = 127
+			}
+		}
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, status)
+		_, _ = channel.SendRequest("exit-status", false, payload)
+		return
+	}
+}
+
+func (s *testSSHServer) hostSpec() workflow.HostSpec {
+	host, port, _ := net.SplitHostPort(s.addr)
+	return workflow.HostSpec{
+		Name:    "web1",
+		Address: host,
+		Vars: map[string]any{
+			"ssh_user":           "deploy",
+			"ssh_port":           port,
+			"ssh_key_path":       s.keyPath,
+			"ssh_host_key_check": false,
+		},
+	}
+}
+
+func TestSSHAdapterRun(t *testing.T) {
+	server := startTestSSHServer(t)
+	pool := NewPool()
+	defer pool.Close()
+
+	adapter, err := pool.Adapter(context.Background(), server.hostSpec())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if _, ok := adapter.(*SSHAdapter); !ok {
+		t.Fatalf("expected ssh adapter, got %T", adapter)
+	}
+
+	dir := t.TempDir()
+	var streamed strings.Builder
+	res, err := adapter.Run(context.Background(), "/bin/sh", []string{"-s", "--", "it's"}, RunOptions{
+		Dir:    dir,
+		Env:    []string{"GREETING=hello world"},
+		Stdin:  strings.NewReader(`echo "$GREETING $1"; pwd; echo oops >&2`),
+		Stdout: &streamed,
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := "hello world it's\n" + dir + "\n"
+	if res.Stdout != want {
+		t.Fatalf("unexpected stdout %q, want %q", res.Stdout, want)
+	}
+	if streamed.String() != want {
+		t.Fatalf("expected stdout to be streamed, got %q", streamed.String())
+	}
+	if strings.TrimSpace(res.Stderr) != "oops" {
+		t.Fatalf("unexpected stderr %q", res.Stderr)
+	}
+
+	res, err = adapter.Run(context.Background(), "/bin/sh", []string{"-c", "exit 3"}, RunOptions{})
+	if err == nil {
+		t.Fatalf("expected exit error")
+	}
+	if res.ExitCode != 3 {
+		t.Fatalf("expected exit code 3, got %d", res.ExitCode)
+	}
+
+	if _, err := adapter.LookPath("sh"); err != nil {
+		t.Fatalf("lookpath sh: %v", err)
+	}
+	if _, err := adapter.LookPath("definitely-not-a-command"); !errors.Is(err, exec.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestSSHAdapterFiles(t *testing.T) {
+	server := startTestSSHServer(t)
+	pool := NewPool()
+	defer pool.Close()
+
+	adapter, err := pool.Adapter(context.Background(), server.hostSpec())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "etc", "app")
+	if err := adapter.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	path := filepath.Join(dir, "app.conf")
+	if _, err := adapter.ReadFile(path); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+	if err := adapter.WriteFile(path, []byte("port=80\n"), 0o640); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	data, err := adapter.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "port=80\n" {
+		t.Fatalf("unexpected content %q", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Fatalf("expected mode 0640, got %o", info.Mode().Perm())
+	}
+
+	if err := adapter.Remove(path); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := adapter.Remove(path); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+}
+
+func TestPoolReusesConnectionPerHost(t *testing.T) {
+	server := startTestSSHServer(t)
+	pool := NewPool()
+	defer pool.Close()
+
+	spec := server.hostSpec()
+	first, err := pool.Adapter(context.Background(), spec)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	second, err := pool.Adapter(context.Background(), spec)
+	if err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	if first != second {
+		t.Fatalf("expected pooled adapter to be reused")
+	}
+	if got := server.conns.Load(); got != 1 {
+		t.Fatalf("expected 1 connection, got %d", got)
+	}
+
+	_ = first.(*SSHAdapter).Close()
+	third, err := pool.Adapter(context.Background(), spec)
+	if err != nil {
+		t.Fatalf("redial: %v", err)
+	}
+	if third == first {
+		t.Fatalf("expected closed connection to be replaced")
+	}
+
+	local, err := pool.Adapter(context.Background(), workflow.HostSpec{Name: "local", Address: "local"})
+	if err != nil {
+		t.Fatalf("local adapter: %v", err)
+	}
+	if _, ok := local.(*LocalAdapter); !ok {
+		t.Fatalf("expected local adapter for host without ssh vars, got %T", local)
+	}
+}
+
+func TestSSHConfigFromHost(t *testing.T) {
+	cfg, err := SSHConfigFromHost(workflow.HostSpec{
+		Name:    "web2",
+		Address: "10.0.0.12",
+		Vars: map[string]any{
+			"ssh_user": "ops",
+			"ssh_port": 2222,
+		},
+	})
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if cfg.Target() != "10.0.0.12:2222" {
+		t.Fatalf("unexpected target %q", cfg.Target())
+	}
+	if cfg.User != "ops" {
+		t.Fatalf("unexpected user %q", cfg.User)
+	}
+
+	cfg, err = SSHConfigFromHost(workflow.HostSpec{Name: "web3", Address: "web3.internal:2200", Vars: map[string]any{"ssh_user": "ops"}})
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if cfg.Target() != "web3.internal:2200" {
+		t.Fatalf("unexpected target %q", cfg.Target())
+	}
+
+	if _, err := SSHConfigFromHost(workflow.HostSpec{Name: "web4", Vars: map[string]any{"ssh_port": "nope"}}); err == nil {
+		t.Fatalf("expected invalid port error")
+	}
+	if UsesSSH(workflow.HostSpec{Vars: map[string]any{"ssh_user": "ops", "connection": "local"}}) {
+		t.Fatalf("expected connection=local to win over ssh vars")
+	}
+	if !UsesSSH(workflow.HostSpec{Vars: map[string]any{"connection": "ssh"}}) {
+		t.Fatalf("expected connection=ssh to select ssh")
+	}
+}
@@ -6,22 +6,30 @@ import (
 )
 
 type Summary struct {
-	RunID        string    `json:"run_id"`
-	WorkflowName string    `json:"workflow_name"`
-	Status       string    `json:"status"`
-	FailedStep   string    `json:"failed_step,omitempty"`
-	FailedHost   string    `json:"failed_host,omitempty"`
-	StartedAt    time.Time `json:"started_at"`
-	FinishedAt   time.Time `json:"finished_at"`
-	Steps        int       `json:"steps"`
-	FailedSteps  int       `json:"failed_steps"`
+	RunID        string `json:"run_id"`
+	WorkflowName string `json:"workflow_name"`
+	ScheduleID   string `json:"schedule_id,omitempty"`
+	ParentRunID  string `json:"parent_run_id,omitempty"`
+	Status       string `json:"status"`
+	// QueuePosition is the 1-based position of a queued run.
+	QueuePosition int       `json:"queue_position,omitempty"`
+	FailedStep    string    `json:"failed_step,omitempty"`
+	FailedHost    string    `json:"failed_host,omitempty"`
+	QueuedAt      time.Time `json:"queued_at,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	Steps         int       `json:"steps"`
+	FailedSteps   int       `json:"failed_steps"`
 }
 
 func Summarize(run state.RunState) Summary {
 	summary := Summary{
 		RunID:        run.RunID,
 		WorkflowName: run.WorkflowName,
+		ScheduleID:   run.ScheduleID,
+		ParentRunID:  run.ParentRunID,
 		Status:       "success",
+		QueuedAt:     run.QueuedAt,
 		StartedAt:    run.StartedAt,
 		FinishedAt:   run.FinishedAt,
 		Steps:        len(run.Steps),
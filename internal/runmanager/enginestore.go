@@ -0,0 +1,45 @@
+package runmanager
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"bops/runner/state"
+)
+
+// EngineStore wraps the run store of the engine that applies the manager's
+// runs. The engine is given the manager's run ID, and the manager's Recorder
+// already records that run, so the engine's own copy of it is dropped rather
+// than stored twice or written over the manager's record. Runs created by the
+// engine alone go to store.
+func EngineStore(store state.RunStateStore) state.RunStateStore {
+	return &engineStore{RunStateStore: store, adopted: map[string]struct{}{}}
+}
+
+type engineStore struct {
+	state.RunStateStore
+	mu      sync.Mutex
+	adopted map[string]struct{}
+}
+
+func (s *engineStore) CreateRun(ctx context.Context, run state.RunState) error {
+	err := s.RunStateStore.CreateRun(ctx, run)
+	if !errors.Is(err, state.ErrRunExists) {
+		return err
+	}
+	s.mu.Lock()
+	s.adopted[run.RunID] = struct{}{}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *engineStore) UpdateRun(ctx context.Context, run state.RunState) error {
+	s.mu.Lock()
+	_, ok := s.adopted[run.RunID]
+	s.mu.Unlock()
+	if ok {
+		return nil
+	}
+	return s.RunStateStore.UpdateRun(ctx, run)
+}
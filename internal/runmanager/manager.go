@@ -3,6 +3,7 @@ package runmanager
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,15 +16,35 @@ import (
 )
 
 type Manager struct {
-	store  state.Store
-	bus    *eventbus.Bus
-	mu     sync.Mutex
-	active map[string]*RunContext
+	store      state.Store
+	bus        *eventbus.Bus
+	mu         sync.Mutex
+	active     map[string]*RunContext
+	retention  RetentionPolicy
+	logDir     string
+	spillBytes int
+	limits     QueueOptions
+	queue      []*queuedRun
+	leases     map[string]lease
+}
+
+// Options configures run history retention and output spilling.
+type Options struct {
+	Retention RetentionPolicy
+	// LogDir holds one directory of spilled output files per run.
+	LogDir string
+	// SpillBytes moves host outputs larger than this into LogDir; 0 keeps
+	// every output inline.
+	SpillBytes int
+	// Queue limits the runs started through Enqueue.
+	Queue QueueOptions
 }
 
 type RunContext struct {
 	ID     string
 	Cancel context.CancelFunc
+	// ScheduleID is set for runs started by a schedule.
+	ScheduleID string
 }
 
 func New(store state.Store) *Manager {
@@ -31,23 +52,52 @@ func New(store state.Store) *Manager {
 }
 
 func NewWithBus(store state.Store, bus *eventbus.Bus) *Manager {
+	return NewWithOptions(store, bus, Options{})
+}
+
+func NewWithOptions(store state.Store, bus *eventbus.Bus, opts Options) *Manager {
 	return &Manager{
-		store:  store,
-		bus:    bus,
-		active: make(map[string]*RunContext),
+		store:      store,
+		bus:        bus,
+		active:     make(map[string]*RunContext),
+		retention:  opts.Retention,
+		logDir:     opts.LogDir,
+		spillBytes: opts.SpillBytes,
+		limits:     opts.Queue,
+		leases:     make(map[string]lease),
 	}
 }
 
 func (m *Manager) StartRun(ctx context.Context, wf workflow.Workflow) (string, context.Context, error) {
+	return m.StartScheduledRun(ctx, wf, "")
+}
+
+// StartScheduledRun starts a run and records the schedule that started it.
+func (m *Manager) StartScheduledRun(ctx context.Context, wf workflow.Workflow, scheduleID string) (string, context.Context, error) {
+	return m.startRun(ctx, wf, scheduleID, "")
+}
+
+// StartChildRun starts the run of a workflow called by a workflow.call step
+// of parentRunID. Child runs skip the queue: the parent already holds the
+// hosts they run on. Stopping the parent cancels ctx and so the child.
+func (m *Manager) StartChildRun(ctx context.Context, wf workflow.Workflow, parentRunID string) (string, context.Context, error) {
+	return m.startRun(ctx, wf, "", parentRunID)
+}
+
+func (m *Manager) startRun(ctx context.Context, wf workflow.Workflow, scheduleID, parentRunID string) (string, context.Context, error) {
 	runID := fmt.Sprintf("run-%d", time.Now().UTC().UnixNano())
 	runCtx, cancel := context.WithCancel(ctx)
-	logging.L().Debug("run start", zap.String("run_id", runID), zap.String("workflow", wf.Name))
+	logging.L().Debug("run start", zap.String("run_id", runID), zap.String("workflow", wf.Name), zap.String("schedule", scheduleID), zap.String("parent_run_id", parentRunID))
 
 	run := state.RunState{
 		RunID:           runID,
 		WorkflowName:    wf.Name,
 		WorkflowVersion: wf.Version,
+		ScheduleID:      scheduleID,
+		ParentRunID:     parentRunID,
+		Params:          wf.ParamValues(),
 		Status:          "running",
+		Attempt:         1,
 		StartedAt:       time.Now().UTC(),
 		Steps:           []state.StepState{},
 		Resources:       map[string]state.ResourceState{},
@@ -59,17 +109,44 @@ func (m *Manager) StartRun(ctx context.Context, wf workflow.Workflow) (string, c
 		return "", nil, err
 	}
 
-	m.publish(runID, wf.Name, core.EventWorkflowStart, core.EventInfo, map[string]any{
-		"status": "running",
-	})
+	data := map[string]any{"status": "running"}
+	if scheduleID != "" {
+		data["schedule_id"] = scheduleID
+	}
+	if parentRunID != "" {
+		data["parent_run_id"] = parentRunID
+	}
+	m.publish(runID, wf.Name, core.EventWorkflowStart, core.EventInfo, data)
 
 	m.mu.Lock()
-	m.active[runID] = &RunContext{ID: runID, Cancel: cancel}
+	m.active[runID] = &RunContext{ID: runID, Cancel: cancel, ScheduleID: scheduleID}
 	m.mu.Unlock()
 
 	return runID, runCtx, nil
 }
 
+// ResumeRun starts the next attempt of a failed, stopped or interrupted run
+// under the same run ID. It returns the run as the previous attempt left it.
+func (m *Manager) ResumeRun(ctx context.Context, runID string) (state.RunState, context.Context, error) {
+	prev, attempt, err := m.beginAttempt(runID, state.RunStatusRunning)
+	if err != nil {
+		logging.L().Debug("run resume failed", zap.String("run_id", runID), zap.Error(err))
+		return state.RunState{}, nil, err
+	}
+	logging.L().Debug("run resume", zap.String("run_id", runID), zap.Int("attempt", attempt))
+
+	runCtx, cancel := context.WithCancel(ctx)
+	m.mu.Lock()
+	m.active[runID] = &RunContext{ID: runID, Cancel: cancel}
+	m.mu.Unlock()
+
+	m.publish(runID, prev.WorkflowName, core.EventWorkflowStart, core.EventInfo, map[string]any{
+		"status":  "running",
+		"attempt": attempt,
+	})
+	return prev, runCtx, nil
+}
+
 func (m *Manager) FinishRun(runID string, runErr error) error {
 	logging.L().Debug("run finish", zap.String("run_id", runID), zap.Error(runErr))
 	m.mu.Lock()
@@ -114,8 +191,37 @@ func (m *Manager) CancelRun(runID string) bool {
 	return true
 }
 
+// ActiveScheduleRuns returns the IDs of the active and queued runs started
+// by the schedule, oldest first.
+func (m *Manager) ActiveScheduleRuns(scheduleID string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var runIDs []string
+	for runID, ctx := range m.active {
+		if ctx.ScheduleID == scheduleID {
+			runIDs = append(runIDs, runID)
+		}
+	}
+	for _, q := range m.queue {
+		if q.scheduleID == scheduleID {
+			runIDs = append(runIDs, q.runID)
+		}
+	}
+	sort.Strings(runIDs)
+	return runIDs
+}
+
 func (m *Manager) StopRun(runID string) error {
-	logging.L().Debug("run stop", zap.String("run_id", runID))
+	return m.StopRunReason(runID, "stopped by user")
+}
+
+// StopRunReason stops an active run and records reason as its message. A
+// queued run is removed from the queue and marked canceled instead.
+func (m *Manager) StopRunReason(runID, reason string) error {
+	logging.L().Debug("run stop", zap.String("run_id", runID), zap.String("reason", reason))
+	if canceled, err := m.cancelQueued(runID, reason); canceled {
+		return err
+	}
 	m.mu.Lock()
 	if ctx, ok := m.active[runID]; ok {
 		delete(m.active, runID)
@@ -126,12 +232,12 @@ func (m *Manager) StopRun(runID string) error {
 	err := m.updateRun(runID, func(run *state.RunState) {
 		run.FinishedAt = time.Now().UTC()
 		run.Status = "stopped"
-		run.Message = "stopped by user"
+		run.Message = reason
 	})
 
 	m.publish(runID, "", core.EventWorkflowEnd, core.EventWarn, map[string]any{
 		"status":  "stopped",
-		"message": "stopped by user",
+		"message": reason,
 	})
 
 	return err
@@ -203,6 +309,52 @@ func (m *Manager) updateRun(runID string, apply func(*state.RunState)) error {
 	return fmt.Errorf("run %s not found", runID)
 }
 
+// beginAttempt moves a finished run to its next attempt with the given
+// status, running for ResumeRun and queued for EnqueueResume.
+func (m *Manager) beginAttempt(runID, status string) (state.RunState, int, error) {
+	if m.store == nil {
+		return state.RunState{}, 0, fmt.Errorf("state store is nil")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := m.store.Load()
+	if err != nil {
+		return state.RunState{}, 0, err
+	}
+
+	for i := range data.Runs {
+		if data.Runs[i].RunID != runID {
+			continue
+		}
+		if _, ok := m.active[runID]; ok {
+			return state.RunState{}, 0, fmt.Errorf("run %s is still active", runID)
+		}
+		if m.queuedLocked(runID) {
+			return state.RunState{}, 0, fmt.Errorf("run %s is already queued", runID)
+		}
+		check := data.Runs[i]
+		if check.Status == "stopped" {
+			check.Status = state.RunStatusCanceled
+		}
+		if err := state.ValidateRunResume(check); err != nil {
+			return state.RunState{}, 0, err
+		}
+		prev := state.CloneRunState(data.Runs[i])
+		now := time.Now().UTC()
+		data.Runs[i].BeginAttempt(now)
+		if status == state.RunStatusQueued {
+			data.Runs[i].Status = status
+			data.Runs[i].QueuedAt = now
+		}
+		if err := m.store.Save(data); err != nil {
+			return state.RunState{}, 0, err
+		}
+		return prev, data.Runs[i].Attempt, nil
+	}
+
+	return state.RunState{}, 0, fmt.Errorf("run %s not found", runID)
+}
+
 func (m *Manager) publish(runID, workflowName string, eventType core.EventType, level core.EventLevel, data map[string]any) {
 	if m.bus == nil {
 		return
@@ -0,0 +1,318 @@
+package runmanager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"bops/internal/core"
+	"bops/runner/logging"
+	"bops/runner/state"
+	"bops/runner/workflow"
+	"go.uber.org/zap"
+)
+
+// QueueOptions bounds how many queued runs execute at once. A run waits
+// while a limit is reached or while another run holds one of its hosts.
+type QueueOptions struct {
+	// MaxConcurrent caps running runs across all workflows; 0 is unlimited.
+	MaxConcurrent int
+	// MaxPerWorkflow caps running runs of one workflow; 0 is unlimited.
+	MaxPerWorkflow int
+}
+
+// Exec applies a run once it leaves the queue; its error finishes the run.
+type Exec func(ctx context.Context, runID string) error
+
+type queuedRun struct {
+	runID      string
+	workflow   string
+	scheduleID string
+	hosts      []string
+	exec       Exec
+}
+
+// lease is held from the moment a queued run starts until its Exec returns,
+// even when the run is stopped earlier, so hosts are never shared.
+type lease struct {
+	workflow string
+	hosts    []string
+}
+
+// Enqueue records a queued run of wf and starts exec once the concurrency
+// limits and host locks allow it.
+func (m *Manager) Enqueue(wf workflow.Workflow, scheduleID string, exec Exec) (string, error) {
+	now := time.Now().UTC()
+	runID := fmt.Sprintf("run-%d", now.UnixNano())
+	run := state.RunState{
+		RunID:           runID,
+		WorkflowName:    wf.Name,
+		WorkflowVersion: wf.Version,
+		ScheduleID:      scheduleID,
+		Params:          wf.ParamValues(),
+		Status:          state.RunStatusQueued,
+		Attempt:         1,
+		QueuedAt:        now,
+		Steps:           []state.StepState{},
+		Resources:       map[string]state.ResourceState{},
+	}
+	if err := m.appendRun(run); err != nil {
+		return "", err
+	}
+	m.push(&queuedRun{runID: runID, workflow: wf.Name, scheduleID: scheduleID, hosts: lockHosts(wf), exec: exec})
+	return runID, nil
+}
+
+// EnqueueResume queues the next attempt of a failed, stopped or interrupted
+// run. exec receives the run as the previous attempt left it.
+func (m *Manager) EnqueueResume(runID string, wf workflow.Workflow, exec func(ctx context.Context, prev state.RunState) error) error {
+	prev, attempt, err := m.beginAttempt(runID, state.RunStatusQueued)
+	if err != nil {
+		logging.L().Debug("run resume failed", zap.String("run_id", runID), zap.Error(err))
+		return err
+	}
+	logging.L().Debug("run resume queued", zap.String("run_id", runID), zap.Int("attempt", attempt))
+	m.push(&queuedRun{
+		runID:      runID,
+		workflow:   prev.WorkflowName,
+		scheduleID: prev.ScheduleID,
+		hosts:      lockHosts(wf),
+		exec: func(ctx context.Context, _ string) error {
+			return exec(ctx, prev)
+		},
+	})
+	return nil
+}
+
+func (m *Manager) push(q *queuedRun) {
+	m.mu.Lock()
+	m.queue = append(m.queue, q)
+	position := len(m.queue)
+	m.mu.Unlock()
+	m.publish(q.runID, q.workflow, core.EventRunQueued, core.EventInfo, map[string]any{
+		"status":         state.RunStatusQueued,
+		"queue_position": position,
+	})
+	m.dispatch()
+}
+
+// dispatch starts queued runs in FIFO order. A run that has to wait also
+// blocks later runs of its workflow and hosts, so they cannot overtake it.
+func (m *Manager) dispatch() {
+	m.mu.Lock()
+	running := len(m.leases)
+	perWorkflow := map[string]int{}
+	locked := map[string]struct{}{}
+	for _, l := range m.leases {
+		perWorkflow[l.workflow]++
+		for _, h := range l.hosts {
+			locked[h] = struct{}{}
+		}
+	}
+	blockedWorkflows := map[string]struct{}{}
+
+	var ready []*queuedRun
+	contexts := map[string]context.Context{}
+	waiting := m.queue[:0]
+	for _, q := range m.queue {
+		if !m.canStart(q, running, perWorkflow, locked, blockedWorkflows) {
+			waiting = append(waiting, q)
+			blockedWorkflows[q.workflow] = struct{}{}
+			for _, h := range q.hosts {
+				locked[h] = struct{}{}
+			}
+			continue
+		}
+		running++
+		perWorkflow[q.workflow]++
+		for _, h := range q.hosts {
+			locked[h] = struct{}{}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		m.active[q.runID] = &RunContext{ID: q.runID, Cancel: cancel, ScheduleID: q.scheduleID}
+		m.leases[q.runID] = lease{workflow: q.workflow, hosts: q.hosts}
+		contexts[q.runID] = ctx
+		ready = append(ready, q)
+	}
+	for i := len(waiting); i < len(m.queue); i++ {
+		m.queue[i] = nil
+	}
+	m.queue = waiting
+	m.mu.Unlock()
+
+	for _, q := range ready {
+		m.begin(q, contexts[q.runID])
+	}
+}
+
+func (m *Manager) canStart(q *queuedRun, running int, perWorkflow map[string]int, locked, blockedWorkflows map[string]struct{}) bool {
+	if m.limits.MaxConcurrent > 0 && running >= m.limits.MaxConcurrent {
+		return false
+	}
+	if m.limits.MaxPerWorkflow > 0 && perWorkflow[q.workflow] >= m.limits.MaxPerWorkflow {
+		return false
+	}
+	if _, ok := blockedWorkflows[q.workflow]; ok {
+		return false
+	}
+	for _, h := range q.hosts {
+		if _, ok := locked[h]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Manager) begin(q *queuedRun, ctx context.Context) {
+	now := time.Now().UTC()
+	attempt := 1
+	err := m.updateRun(q.runID, func(run *state.RunState) {
+		run.Status = state.RunStatusRunning
+		if run.StartedAt.IsZero() {
+			run.StartedAt = now
+		}
+		run.UpdatedAt = now
+		attempt = run.Attempt
+	})
+	if err != nil {
+		logging.L().Warn("run start failed", zap.String("run_id", q.runID), zap.Error(err))
+	}
+	logging.L().Debug("run start", zap.String("run_id", q.runID), zap.String("workflow", q.workflow))
+	data := map[string]any{"status": state.RunStatusRunning, "attempt": attempt}
+	if q.scheduleID != "" {
+		data["schedule_id"] = q.scheduleID
+	}
+	m.publish(q.runID, q.workflow, core.EventWorkflowStart, core.EventInfo, data)
+
+	go func() {
+		runErr := q.exec(ctx, q.runID)
+		m.mu.Lock()
+		stopped := m.active[q.runID] == nil
+		m.mu.Unlock()
+		if !stopped {
+			_ = m.FinishRun(q.runID, runErr)
+		}
+		m.mu.Lock()
+		delete(m.leases, q.runID)
+		m.mu.Unlock()
+		m.dispatch()
+	}()
+}
+
+// cancelQueued removes a queued run and marks it canceled. It reports false
+// when the run is not queued.
+func (m *Manager) cancelQueued(runID, reason string) (bool, error) {
+	m.mu.Lock()
+	found := false
+	for i, q := range m.queue {
+		if q.runID == runID {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			found = true
+			break
+		}
+	}
+	m.mu.Unlock()
+	if !found {
+		return false, nil
+	}
+
+	err := m.updateRun(runID, func(run *state.RunState) {
+		run.Status = state.RunStatusCanceled
+		run.Message = reason
+		run.FinishedAt = time.Now().UTC()
+	})
+	m.publish(runID, "", core.EventWorkflowEnd, core.EventWarn, map[string]any{
+		"status":  state.RunStatusCanceled,
+		"message": reason,
+	})
+	// Runs waiting behind the canceled one may be able to start now.
+	m.dispatch()
+	return true, err
+}
+
+func (m *Manager) queuedLocked(runID string) bool {
+	for _, q := range m.queue {
+		if q.runID == runID {
+			return true
+		}
+	}
+	return false
+}
+
+// QueuePosition returns the 1-based position of a queued run, or 0 when the
+// run is not waiting.
+func (m *Manager) QueuePosition(runID string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, q := range m.queue {
+		if q.runID == runID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// InterruptQueued marks runs left queued by a previous process as
+// interrupted; their queue entries did not survive the restart.
+func (m *Manager) InterruptQueued(reason string) (int, error) {
+	if m.store == nil {
+		return 0, fmt.Errorf("state store is nil")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := m.store.Load()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	updated := 0
+	for i := range data.Runs {
+		if !strings.EqualFold(data.Runs[i].Status, state.RunStatusQueued) {
+			continue
+		}
+		data.Runs[i].Status = state.RunStatusInterrupted
+		data.Runs[i].InterruptedReason = reason
+		data.Runs[i].Message = reason
+		data.Runs[i].FinishedAt = now
+		updated++
+	}
+	if updated == 0 {
+		return 0, nil
+	}
+	return updated, m.store.Save(data)
+}
+
+// lockHosts returns the hosts a run of wf may touch, by address and SSH
+// port, so two inventories naming the same machine differently still
+// serialize while hosts sharing an address behind different ports (say
+// containers forwarded from one gateway) do not. A host without an address
+// is reached by its name, so it is locked under its name. Port 22 is the
+// default and is left out of the key.
+func lockHosts(wf workflow.Workflow) []string {
+	seen := map[string]struct{}{}
+	for _, h := range wf.Inventory.ResolveHosts() {
+		seen[lockKey(h)] = struct{}{}
+	}
+	hosts := make([]string, 0, len(seen))
+	for h := range seen {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func lockKey(h workflow.HostSpec) string {
+	key := strings.TrimSpace(h.Address)
+	if key == "" {
+		key = h.Name
+	}
+	if raw, ok := h.Vars["ssh_port"]; ok && raw != nil {
+		if port := strings.TrimSpace(fmt.Sprint(raw)); port != "" && port != "22" {
+			return net.JoinHostPort(key, port)
+		}
+	}
+	return key
+}
@@ -0,0 +1,185 @@
+package runmanager
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"bops/runner/state"
+	"bops/runner/workflow"
+)
+
+type queueFixture struct {
+	manager *Manager
+	started chan string
+	release map[string]chan error
+}
+
+func newQueueFixture(t *testing.T, opts QueueOptions) *queueFixture {
+	t.Helper()
+	store := state.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	return &queueFixture{
+		manager: NewWithOptions(store, nil, Options{Queue: opts}),
+		started: make(chan string, 16),
+		release: map[string]chan error{},
+	}
+}
+
+// enqueue queues a run of wf whose Exec blocks until its release channel
+// receives or the run is stopped.
+func (f *queueFixture) enqueue(t *testing.T, wf workflow.Workflow) string {
+	t.Helper()
+	done := make(chan error, 1)
+	runID, err := f.manager.Enqueue(wf, "", func(ctx context.Context, runID string) error {
+		f.started <- runID
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	f.release[runID] = done
+	// Run IDs come from the clock; keep them distinct.
+	time.Sleep(time.Millisecond)
+	return runID
+}
+
+func (f *queueFixture) expectStart(t *testing.T, runID string) {
+	t.Helper()
+	select {
+	case got := <-f.started:
+		if got != runID {
+			t.Fatalf("expected %s to start, got %s", runID, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s did not start", runID)
+	}
+}
+
+func (f *queueFixture) expectIdle(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-f.started:
+		t.Fatalf("unexpected start of %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (f *queueFixture) status(t *testing.T, runID string) string {
+	t.Helper()
+	run, ok, err := f.manager.GetRun(runID)
+	if err != nil || !ok {
+		t.Fatalf("get run %s: %v", runID, err)
+	}
+	return run.Status
+}
+
+func queueWorkflow(name string, hosts ...string) workflow.Workflow {
+	wf := workflow.Workflow{Name: name, Inventory: workflow.Inventory{Hosts: map[string]workflow.Host{}}}
+	for _, h := range hosts {
+		wf.Inventory.Hosts[h] = workflow.Host{Address: h}
+	}
+	return wf
+}
+
+func TestQueueSerializesOverlappingHosts(t *testing.T) {
+	f := newQueueFixture(t, QueueOptions{})
+	first := f.enqueue(t, queueWorkflow("deploy", "web1", "web2"))
+	f.expectStart(t, first)
+
+	// backup shares web2 with the running deploy and has to wait; db only
+	// touches db1 and starts at once even though it was queued later.
+	backup := f.enqueue(t, queueWorkflow("backup", "web2"))
+	db := f.enqueue(t, queueWorkflow("db", "db1"))
+	f.expectStart(t, db)
+	f.expectIdle(t)
+	if got := f.status(t, backup); got != state.RunStatusQueued {
+		t.Fatalf("expected backup to be queued, got %s", got)
+	}
+	if got := f.manager.QueuePosition(backup); got != 1 {
+		t.Fatalf("expected backup at position 1, got %d", got)
+	}
+
+	f.release[first] <- nil
+	f.expectStart(t, backup)
+	if got := f.manager.QueuePosition(backup); got != 0 {
+		t.Fatalf("expected backup to leave the queue, got position %d", got)
+	}
+}
+
+func TestQueueLimitsAndCancel(t *testing.T) {
+	f := newQueueFixture(t, QueueOptions{MaxConcurrent: 2, MaxPerWorkflow: 1})
+	a1 := f.enqueue(t, queueWorkflow("a", "h1"))
+	a2 := f.enqueue(t, queueWorkflow("a", "h2"))
+	b1 := f.enqueue(t, queueWorkflow("b", "h3"))
+	c1 := f.enqueue(t, queueWorkflow("c", "h4"))
+	f.expectStart(t, a1)
+	f.expectStart(t, b1)
+	f.expectIdle(t)
+	if got := [2]int{f.manager.QueuePosition(a2), f.manager.QueuePosition(c1)}; got != [2]int{1, 2} {
+		t.Fatalf("unexpected queue positions %v", got)
+	}
+
+	if err := f.manager.StopRun(a2); err != nil {
+		t.Fatalf("cancel queued run: %v", err)
+	}
+	if got := f.status(t, a2); got != state.RunStatusCanceled {
+		t.Fatalf("expected canceled queued run, got %s", got)
+	}
+	f.expectIdle(t)
+
+	f.release[b1] <- nil
+	f.expectStart(t, c1)
+	if err := f.manager.StopRun(a1); err != nil {
+		t.Fatalf("stop running run: %v", err)
+	}
+	// The next run of a starts once the stopped run's Exec has returned, and
+	// the stopped run keeps its status.
+	a3 := f.enqueue(t, queueWorkflow("a", "h1"))
+	f.expectStart(t, a3)
+	if got := f.status(t, a1); got != "stopped" {
+		t.Fatalf("expected a1 to stay stopped, got %s", got)
+	}
+	if got := f.status(t, b1); got != state.RunStatusSuccess {
+		t.Fatalf("expected b1 to succeed, got %s", got)
+	}
+}
+
+func TestInterruptQueued(t *testing.T) {
+	store := state.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	if err := store.Save(state.StateFile{Runs: []state.RunState{
+		{RunID: "run-1", WorkflowName: "deploy", Status: state.RunStatusQueued},
+		{RunID: "run-2", WorkflowName: "deploy", Status: state.RunStatusSuccess},
+	}}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	m := NewWithOptions(store, nil, Options{})
+	n, err := m.InterruptQueued("server restarted")
+	if err != nil || n != 1 {
+		t.Fatalf("expected one interrupted run, got %d %v", n, err)
+	}
+	run, _, _ := m.GetRun("run-1")
+	if run.Status != state.RunStatusInterrupted || run.InterruptedReason != "server restarted" {
+		t.Fatalf("unexpected run: %+v", run)
+	}
+}
+
+func TestLockHostsKeysByAddressAndPort(t *testing.T) {
+	wf := workflow.Workflow{Inventory: workflow.Inventory{Hosts: map[string]workflow.Host{
+		"app1":   {Address: "10.0.0.5", Vars: map[string]any{"ssh_port": 2201}},
+		"app2":   {Address: "10.0.0.5", Vars: map[string]any{"ssh_port": "2202"}},
+		"gw":     {Address: "10.0.0.5", Vars: map[string]any{"ssh_port": 22}},
+		"gw-dns": {Address: "10.0.0.5"},
+		"local":  {},
+	}}}
+	want := []string{"10.0.0.5", "10.0.0.5:2201", "10.0.0.5:2202", "local"}
+	if got := lockHosts(wf); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -27,6 +27,10 @@ func (r *Recorder) StepStart(step workflow.Step, targets []workflow.HostSpec) {
 			stepState.StartedAt = now
 		}
 		stepState.Status = "running"
+		if step.Parent != "" {
+			stepState.Parent = step.Parent
+			stepState.Section = step.Section
+		}
 
 		if stepState.Hosts == nil {
 			stepState.Hosts = make(map[string]state.HostResult, len(targets))
@@ -43,13 +47,18 @@ func (r *Recorder) StepStart(step workflow.Step, targets []workflow.HostSpec) {
 		}
 	})
 
+	data := map[string]any{"targets": targets}
+	if step.Parent != "" {
+		data["parent"] = step.Parent
+		data["section"] = step.Section
+	}
 	r.publish(core.Event{
 		Type:  core.EventStepStart,
 		Level: core.EventInfo,
 		Time:  now,
 		RunID: r.runID,
 		Step:  step.Name,
-		Data:  map[string]any{"targets": targets},
+		Data:  data,
 	})
 }
 
@@ -79,6 +88,7 @@ func (r *Recorder) StepFinish(step workflow.Step, status string) {
 
 func (r *Recorder) HostResult(step workflow.Step, host workflow.HostSpec, result scheduler.Result) {
 	now := time.Now().UTC()
+	output, files := r.manager.spillOutput(r.runID, step.Name, host.Name, result.Output)
 	_ = r.manager.updateRun(r.runID, func(run *state.RunState) {
 		stepState := ensureStep(run, step.Name)
 		if stepState.Hosts == nil {
@@ -90,8 +100,10 @@ func (r *Recorder) HostResult(step workflow.Step, host workflow.HostSpec, result
 		}
 		entry.Host = host.Name
 		entry.Status = result.Status
-		entry.Output = result.Output
+		entry.Output = output
+		entry.OutputFiles = files
 		entry.Message = result.Error
+		entry.Batch = result.Batch
 		entry.FinishedAt = now
 		stepState.Hosts[host.Name] = entry
 	})
@@ -107,21 +119,64 @@ func (r *Recorder) HostResult(step workflow.Step, host workflow.HostSpec, result
 			"status": result.Status,
 			"output": result.Output,
 			"error":  result.Error,
+			"batch":  result.Batch,
 		},
 	})
 }
 
-func ensureStep(run *state.RunState, name string) *state.StepState {
-	for i := range run.Steps {
-		if run.Steps[i].Name == name {
-			return &run.Steps[i]
+func (r *Recorder) StepVars(step workflow.Step, vars, allowed map[string]any) {
+	_ = r.manager.updateRun(r.runID, func(run *state.RunState) {
+		stepState := ensureStep(run, step.Name)
+		stepState.Vars = vars
+		stepState.AllowedVars = allowed
+	})
+}
+
+func (r *Recorder) HostFacts(host string, facts map[string]any) {
+	_ = r.manager.updateRun(r.runID, func(run *state.RunState) {
+		if run.Facts == nil {
+			run.Facts = map[string]map[string]any{}
 		}
+		run.Facts[host] = facts
+	})
+}
+
+func (r *Recorder) PhaseStart(phase string) {
+	now := time.Now().UTC()
+	_ = r.manager.updateRun(r.runID, func(run *state.RunState) {
+		run.BeginPhase(phase, now)
+	})
+
+	r.publish(core.Event{
+		Type:  core.EventPhaseStart,
+		Level: core.EventInfo,
+		Time:  now,
+		RunID: r.runID,
+		Data:  map[string]any{"phase": phase},
+	})
+}
+
+func (r *Recorder) PhaseFinish(phase, status string) {
+	now := time.Now().UTC()
+	_ = r.manager.updateRun(r.runID, func(run *state.RunState) {
+		run.FinishPhase(phase, status, "", now)
+	})
+
+	level := core.EventInfo
+	if status == "failed" {
+		level = core.EventError
 	}
-	run.Steps = append(run.Steps, state.StepState{
-		Name:  name,
-		Hosts: map[string]state.HostResult{},
+	r.publish(core.Event{
+		Type:  core.EventPhaseEnd,
+		Level: level,
+		Time:  now,
+		RunID: r.runID,
+		Data:  map[string]any{"phase": phase, "status": status},
 	})
-	return &run.Steps[len(run.Steps)-1]
+}
+
+func ensureStep(run *state.RunState, name string) *state.StepState {
+	return run.EnsureStep(name)
 }
 
 func (r *Recorder) publish(event core.Event) {
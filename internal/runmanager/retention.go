@@ -0,0 +1,173 @@
+package runmanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bops/runner/logging"
+	"bops/runner/state"
+	"go.uber.org/zap"
+)
+
+// RetentionPolicy decides which finished runs are dropped from the state
+// file. Queued and running runs are always kept.
+type RetentionPolicy struct {
+	// MaxRunsPerWorkflow keeps the newest N finished runs per workflow.
+	MaxRunsPerWorkflow int
+	// MaxAge drops runs that finished longer ago than this.
+	MaxAge time.Duration
+	// FailedMaxAge, when set, replaces MaxAge for failed, canceled, stopped
+	// and interrupted runs and exempts them from MaxRunsPerWorkflow, so
+	// failures stay around longer for troubleshooting.
+	FailedMaxAge time.Duration
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxRunsPerWorkflow > 0 || p.MaxAge > 0 || p.FailedMaxAge > 0
+}
+
+// Expired returns the IDs of the runs the policy drops at now.
+func (p RetentionPolicy) Expired(runs []state.RunState, now time.Time) map[string]struct{} {
+	expired := map[string]struct{}{}
+	if !p.enabled() {
+		return expired
+	}
+
+	ordered := make([]state.RunState, 0, len(runs))
+	for _, run := range runs {
+		if isActiveStatus(run.Status) {
+			continue
+		}
+		ordered = append(ordered, run)
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return runTime(ordered[i]).After(runTime(ordered[j]))
+	})
+
+	kept := map[string]int{}
+	for _, run := range ordered {
+		age := now.Sub(runTime(run))
+		if isFailedStatus(run.Status) && p.FailedMaxAge > 0 {
+			if age > p.FailedMaxAge {
+				expired[run.RunID] = struct{}{}
+			}
+			continue
+		}
+		if p.MaxAge > 0 && age > p.MaxAge {
+			expired[run.RunID] = struct{}{}
+			continue
+		}
+		if p.MaxRunsPerWorkflow > 0 {
+			if kept[run.WorkflowName] >= p.MaxRunsPerWorkflow {
+				expired[run.RunID] = struct{}{}
+				continue
+			}
+			kept[run.WorkflowName]++
+		}
+	}
+	return expired
+}
+
+// Compact applies the retention policy to the state file and removes the
+// output logs of dropped runs. It returns the number of runs dropped.
+func (m *Manager) Compact(now time.Time) (int, error) {
+	if m.store == nil || !m.retention.enabled() {
+		return 0, nil
+	}
+	m.mu.Lock()
+	data, err := m.store.Load()
+	if err != nil {
+		m.mu.Unlock()
+		return 0, err
+	}
+	expired := m.retention.Expired(data.Runs, now)
+	if len(expired) == 0 {
+		m.mu.Unlock()
+		return 0, nil
+	}
+	kept := make([]state.RunState, 0, len(data.Runs)-len(expired))
+	for _, run := range data.Runs {
+		if _, ok := expired[run.RunID]; ok {
+			continue
+		}
+		kept = append(kept, run)
+	}
+	data.Runs = kept
+	err = m.store.Save(data)
+	m.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	for runID := range expired {
+		if dir := m.runLogDir(runID); dir != "" {
+			if err := os.RemoveAll(dir); err != nil {
+				logging.L().Warn("remove run logs failed", zap.String("run_id", runID), zap.Error(err))
+			}
+		}
+	}
+	logging.L().Info("run history compacted", zap.Int("removed", len(expired)), zap.Int("kept", len(kept)))
+	return len(expired), nil
+}
+
+// StartCompactor compacts the run history now and then every interval until
+// ctx is done.
+func (m *Manager) StartCompactor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 || !m.retention.enabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := m.Compact(time.Now().UTC()); err != nil {
+				logging.L().Warn("run history compaction failed", zap.Error(err))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func runTime(run state.RunState) time.Time {
+	switch {
+	case !run.FinishedAt.IsZero():
+		return run.FinishedAt
+	case !run.UpdatedAt.IsZero():
+		return run.UpdatedAt
+	default:
+		return run.StartedAt
+	}
+}
+
+func isActiveStatus(status string) bool {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case state.RunStatusQueued, state.RunStatusRunning:
+		return true
+	default:
+		return false
+	}
+}
+
+func isFailedStatus(status string) bool {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case state.RunStatusFailed, state.RunStatusCanceled, state.RunStatusInterrupted, "stopped":
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Manager) runLogDir(runID string) string {
+	if m.logDir == "" || runID == "" || filepath.Base(runID) != runID {
+		return ""
+	}
+	return filepath.Join(m.logDir, runID)
+}
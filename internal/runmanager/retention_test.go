@@ -0,0 +1,168 @@
+package runmanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"bops/runner/scheduler"
+	"bops/runner/state"
+	"bops/runner/workflow"
+)
+
+func TestRetentionPolicyExpired(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	runs := []state.RunState{
+		{RunID: "run-1", WorkflowName: "deploy", Status: "success", FinishedAt: now.Add(-1 * time.Hour)},
+		{RunID: "run-2", WorkflowName: "deploy", Status: "success", FinishedAt: now.Add(-2 * time.Hour)},
+		{RunID: "run-3", WorkflowName: "deploy", Status: "success", FinishedAt: now.Add(-3 * time.Hour)},
+		{RunID: "run-4", WorkflowName: "deploy", Status: "failed", FinishedAt: now.Add(-4 * time.Hour)},
+		{RunID: "run-5", WorkflowName: "deploy", Status: "stopped", FinishedAt: now.Add(-30 * time.Hour)},
+		{RunID: "run-6", WorkflowName: "backup", Status: "success", FinishedAt: now.Add(-25 * time.Hour)},
+		{RunID: "run-7", WorkflowName: "backup", Status: "running", StartedAt: now.Add(-48 * time.Hour)},
+	}
+	policy := RetentionPolicy{MaxRunsPerWorkflow: 2, MaxAge: 24 * time.Hour, FailedMaxAge: 24 * time.Hour * 2}
+
+	expired := policy.Expired(runs, now)
+	for _, id := range []string{"run-3", "run-6"} {
+		if _, ok := expired[id]; !ok {
+			t.Fatalf("expected %s to expire, got %v", id, expired)
+		}
+	}
+	if len(expired) != 2 {
+		t.Fatalf("expected 2 expired runs, got %v", expired)
+	}
+
+	policy.FailedMaxAge = 0
+	expired = policy.Expired(runs, now)
+	if _, ok := expired["run-5"]; !ok {
+		t.Fatalf("expected stopped run to fall back to max_age, got %v", expired)
+	}
+	if _, ok := expired["run-4"]; !ok {
+		t.Fatalf("expected failed run to count against max runs, got %v", expired)
+	}
+}
+
+func TestRecorderSpillsLargeOutputAndCompactRemovesLogs(t *testing.T) {
+	dir := t.TempDir()
+	logDir := filepath.Join(dir, "run_logs")
+	manager := NewWithOptions(state.NewFileStore(filepath.Join(dir, "state.json")), nil, Options{
+		Retention:  RetentionPolicy{MaxRunsPerWorkflow: 1},
+		LogDir:     logDir,
+		SpillBytes: 16,
+	})
+
+	wf := workflow.Workflow{Name: "demo"}
+	oldID, _, err := manager.StartRun(context.Background(), wf)
+	if err != nil {
+		t.Fatalf("start run: %v", err)
+	}
+	stdout := strings.Repeat("x", 2048)
+	manager.Recorder(oldID).HostResult(workflow.Step{Name: "build"}, workflow.HostSpec{Name: "web/1"}, scheduler.Result{
+		Status: "success",
+		Output: map[string]any{"stdout": stdout, "stderr": "ok", "code": 0},
+	})
+	if err := manager.FinishRun(oldID, nil); err != nil {
+		t.Fatalf("finish run: %v", err)
+	}
+
+	run, _, err := manager.GetRun(oldID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	host := run.Steps[0].Hosts["web/1"]
+	path := host.OutputFiles["stdout"]
+	if path == "" || host.OutputFiles["stderr"] != "" {
+		t.Fatalf("expected only stdout to spill, got %v", host.OutputFiles)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != stdout {
+		t.Fatalf("expected full stdout in %s, got %d bytes (%v)", path, len(data), err)
+	}
+	if inline, _ := host.Output["stdout"].(string); len(inline) >= len(stdout) || !strings.HasSuffix(inline, "...(truncated)") {
+		t.Fatalf("expected truncated inline stdout, got %d bytes", len(inline))
+	}
+	if host.Output["stderr"] != "ok" {
+		t.Fatalf("expected small output inline, got %v", host.Output["stderr"])
+	}
+
+	manager.Recorder(oldID).HostResult(workflow.Step{Name: "fetch"}, workflow.HostSpec{Name: "web/1"}, scheduler.Result{
+		Status: "success",
+		Output: map[string]any{"result": map[string]any{"body": []any{stdout}}, "code": 0},
+	})
+	run, _, err = manager.GetRun(oldID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	nested := run.Steps[1].Hosts["web/1"]
+	path = nested.OutputFiles["result"]
+	if path == "" || !strings.HasSuffix(path, ".json") || nested.OutputFiles["code"] != "" {
+		t.Fatalf("expected the nested result to spill as JSON, got %v", nested.OutputFiles)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), stdout) {
+		t.Fatalf("expected the nested value in %s, got %d bytes (%v)", path, len(data), err)
+	}
+	if inline, _ := nested.Output["result"].(string); !strings.HasSuffix(inline, "...(truncated)") {
+		t.Fatalf("expected a truncated preview of the nested result, got %v", nested.Output["result"])
+	}
+
+	time.Sleep(time.Millisecond)
+	newID, _, err := manager.StartRun(context.Background(), wf)
+	if err != nil {
+		t.Fatalf("start second run: %v", err)
+	}
+	if err := manager.FinishRun(newID, nil); err != nil {
+		t.Fatalf("finish second run: %v", err)
+	}
+
+	removed, err := manager.Compact(time.Now().UTC())
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected 1 run removed, got %d", removed)
+	}
+	if _, ok, _ := manager.GetRun(oldID); ok {
+		t.Fatalf("expected old run to be compacted")
+	}
+	if _, err := os.Stat(filepath.Join(logDir, oldID)); !os.IsNotExist(err) {
+		t.Fatalf("expected old run logs removed, got %v", err)
+	}
+}
+
+func TestSpillKeepsRunesAndSeparatesFiles(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewWithOptions(state.NewFileStore(filepath.Join(dir, "state.json")), nil, Options{
+		LogDir:     filepath.Join(dir, "run_logs"),
+		SpillBytes: 16,
+	})
+
+	// 1023 ASCII bytes put the three-byte rune across the preview limit.
+	text := strings.Repeat("x", spillPreviewBytes-1) + strings.Repeat("部署", 8)
+	inline, files := manager.spillOutput("run-1", "build", "web", map[string]any{"stdout": text})
+	preview := strings.TrimSuffix(inline["stdout"].(string), "...(truncated)")
+	if !utf8.ValidString(preview) || preview != strings.Repeat("x", spillPreviewBytes-1) {
+		t.Fatalf("expected the preview cut before the split rune, got %q", preview[len(preview)-4:])
+	}
+
+	// Two loop items of one step/host and two names that sanitize alike.
+	paths := map[string]string{"item 0": files["stdout"]}
+	_, files = manager.spillOutput("run-1", "build", "web", map[string]any{"stdout": text + "second"})
+	paths["item 1"] = files["stdout"]
+	_, files = manager.spillOutput("run-1", "build/x", "web", map[string]any{"stdout": text})
+	paths["build/x"] = files["stdout"]
+	_, files = manager.spillOutput("run-1", "build_x", "web", map[string]any{"stdout": text})
+	paths["build_x"] = files["stdout"]
+	seen := map[string]string{}
+	for name, path := range paths {
+		if other, ok := seen[path]; ok || path == "" {
+			t.Fatalf("expected %s and %s to spill to different files, got %q", name, other, path)
+		}
+		seen[path] = name
+	}
+}
@@ -0,0 +1,115 @@
+package runmanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"unicode/utf8"
+
+	"bops/runner/logging"
+	"go.uber.org/zap"
+)
+
+// spillPreviewBytes is how much of a spilled output stays inline in the run
+// state.
+const spillPreviewBytes = 1024
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// spillOutput writes output values larger than the spill threshold to
+// <logDir>/<runID>/ and returns the output with those values replaced by a
+// short preview, plus the files written keyed by output name. Strings are
+// measured and written as they are; other values, such as the nested
+// results of a module, as JSON.
+func (m *Manager) spillOutput(runID, step, host string, output map[string]any) (map[string]any, map[string]string) {
+	if m.spillBytes <= 0 || len(output) == 0 {
+		return output, nil
+	}
+	dir := m.runLogDir(runID)
+	if dir == "" {
+		return output, nil
+	}
+
+	var inline map[string]any
+	var files map[string]string
+	for key, value := range output {
+		data, ext := spillData(value)
+		if len(data) <= m.spillBytes {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			logging.L().Warn("create run log dir failed", zap.String("run_id", runID), zap.Error(err))
+			return output, nil
+		}
+		path := filepath.Join(dir, spillFileName(step, host, key, ext, data))
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			logging.L().Warn("spill output failed", zap.String("run_id", runID), zap.String("path", path), zap.Error(err))
+			continue
+		}
+		if inline == nil {
+			inline = make(map[string]any, len(output))
+			for k, v := range output {
+				inline[k] = v
+			}
+			files = map[string]string{}
+		}
+		inline[key] = string(spillPreview(data)) + "...(truncated)"
+		files[key] = path
+	}
+	if inline == nil {
+		return output, nil
+	}
+	return inline, files
+}
+
+// spillFileName names the file an output value spills to. Each loop item
+// reports the same step, host and key, and sanitizing can map different
+// names to one, so a hash of the names and the content keeps the files
+// apart.
+func spillFileName(step, host, key, ext string, data []byte) string {
+	sum := sha256.New()
+	for _, part := range []string{step, host, key} {
+		sum.Write([]byte(part))
+		sum.Write([]byte{0})
+	}
+	sum.Write(data)
+	return fmt.Sprintf("%s_%s_%s_%s.%s", safeFileName(step), safeFileName(host), safeFileName(key), hex.EncodeToString(sum.Sum(nil))[:12], ext)
+}
+
+// spillPreview returns the start of data kept inline, cut on a rune
+// boundary.
+func spillPreview(data []byte) []byte {
+	if len(data) <= spillPreviewBytes {
+		return data
+	}
+	end := spillPreviewBytes
+	for end > 0 && !utf8.RuneStart(data[end]) {
+		end--
+	}
+	return data[:end]
+}
+
+// spillData returns what spillOutput measures and writes for value, and
+// the file extension to write it with.
+func spillData(value any) ([]byte, string) {
+	if text, ok := value.(string); ok {
+		return []byte(text), "log"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, "json"
+	}
+	return data, "json"
+}
+
+func safeFileName(name string) string {
+	name = unsafeFileChars.ReplaceAllString(name, "_")
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
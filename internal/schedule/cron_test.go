@@ -0,0 +1,48 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+	base := time.Date(2024, 3, 15, 10, 7, 30, 0, time.UTC) // a Friday
+	cases := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", base, time.Date(2024, 3, 15, 10, 15, 0, 0, time.UTC)},
+		{"0 2 * * *", base, time.Date(2024, 3, 16, 2, 0, 0, 0, time.UTC)},
+		{"30 9 * * mon-fri", base, time.Date(2024, 3, 18, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", base, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", base, time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", base, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week, as in Vixie cron.
+		{"0 0 20 * 0", base, time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", base, time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 8 * * *", base.In(shanghai), time.Date(2024, 3, 16, 8, 0, 0, 0, shanghai)},
+		{"0 0 30 2 *", base, time.Time{}},
+	}
+	for _, tc := range cases {
+		c, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.expr, err)
+		}
+		if got := c.Next(tc.from); !got.Equal(tc.want) {
+			t.Errorf("%q next after %s = %s, want %s", tc.expr, tc.from, got, tc.want)
+		}
+	}
+}
+
+func TestParseCronRejectsInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "0 0 * foo *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("expected %q to be rejected", expr)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"bops/runner/logging"
+	"go.uber.org/zap"
+)
+
+const (
+	StatusStarted = "started"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// DefaultTick is how often schedules are checked when Scheduler.Tick is not
+// set.
+const DefaultTick = 15 * time.Second
+
+// Runs is the part of the run manager the scheduler uses to apply the
+// concurrency policy.
+type Runs interface {
+	// ActiveScheduleRuns returns the IDs of the active runs started by the
+	// schedule.
+	ActiveScheduleRuns(scheduleID string) []string
+	StopRunReason(runID, reason string) error
+}
+
+// Launcher starts a run of the schedule's workflow and returns its run ID.
+type Launcher func(ctx context.Context, item Schedule) (string, error)
+
+// Scheduler starts runs for due schedules.
+type Scheduler struct {
+	Store  *Store
+	Runs   Runs
+	Launch Launcher
+	// CatchUp is used for schedules without catch_up; empty means skip.
+	CatchUp string
+	// CatchUpWindow skips missed runs older than this even when catching
+	// up; 0 means no limit.
+	CatchUpWindow time.Duration
+	Tick          time.Duration
+	// Now returns the current time; tests replace it.
+	Now func() time.Time
+}
+
+func (s *Scheduler) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s *Scheduler) tick() time.Duration {
+	if s.Tick > 0 {
+		return s.Tick
+	}
+	return DefaultTick
+}
+
+// RunDue starts the runs of every schedule whose next run time has passed.
+func (s *Scheduler) RunDue(ctx context.Context) {
+	items, err := s.Store.List()
+	if err != nil {
+		logging.L().Warn("schedule list failed", zap.Error(err))
+		return
+	}
+	now := s.now()
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return
+		}
+		if item.Paused {
+			continue
+		}
+		if item.NextRunAt.IsZero() {
+			s.record(item.ID, now, "", "", "")
+			continue
+		}
+		if item.NextRunAt.After(now) {
+			continue
+		}
+		s.fire(ctx, item, now)
+	}
+}
+
+func (s *Scheduler) fire(ctx context.Context, item Schedule, now time.Time) {
+	// A run is missed when its time passed by more than a tick, which only
+	// happens while the server is down.
+	if now.Sub(item.NextRunAt) > 2*s.tick() {
+		missed := item.NextRunAt
+		for next := item.NextAfter(missed); !next.IsZero() && !next.After(now); next = item.NextAfter(next) {
+			missed = next
+		}
+		policy := item.CatchUp
+		if policy == "" {
+			policy = s.CatchUp
+		}
+		switch {
+		case policy != CatchUpOnce:
+			s.record(item.ID, now, "", StatusSkipped, fmt.Sprintf("missed run at %s skipped", missed.Format(time.RFC3339)))
+			return
+		case s.CatchUpWindow > 0 && now.Sub(missed) > s.CatchUpWindow:
+			s.record(item.ID, now, "", StatusSkipped, fmt.Sprintf("missed run at %s is older than the catch-up window", missed.Format(time.RFC3339)))
+			return
+		}
+		logging.L().Info("schedule catching up missed run", zap.String("schedule", item.ID), zap.Time("missed", missed))
+	}
+
+	active := s.Runs.ActiveScheduleRuns(item.ID)
+	switch {
+	case len(active) > 0 && item.Concurrency == ConcurrencyForbid:
+		s.record(item.ID, now, "", StatusSkipped, fmt.Sprintf("previous run %s is still active", strings.Join(active, ", ")))
+		return
+	case len(active) > 0 && item.Concurrency == ConcurrencyReplace:
+		for _, runID := range active {
+			if err := s.Runs.StopRunReason(runID, "replaced by schedule "+item.ID); err != nil {
+				logging.L().Warn("schedule stop previous run failed", zap.String("schedule", item.ID), zap.String("run_id", runID), zap.Error(err))
+			}
+		}
+	}
+
+	runID, err := s.Launch(ctx, item)
+	if err != nil {
+		logging.L().Warn("schedule start run failed", zap.String("schedule", item.ID), zap.Error(err))
+		s.record(item.ID, now, "", StatusFailed, err.Error())
+		return
+	}
+	logging.L().Info("schedule started run", zap.String("schedule", item.ID), zap.String("run_id", runID))
+	s.record(item.ID, now, runID, StatusStarted, "")
+}
+
+// record stores the outcome of a due schedule and its next run time. An
+// empty status only advances the next run time.
+func (s *Scheduler) record(id string, now time.Time, runID, status, message string) {
+	_, err := s.Store.Update(id, func(item *Schedule) {
+		item.NextRunAt = item.NextAfter(now)
+		if status == "" {
+			return
+		}
+		item.LastRunAt = now
+		item.LastStatus = status
+		item.LastMessage = message
+		if runID != "" {
+			item.LastRunID = runID
+		}
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logging.L().Warn("schedule update failed", zap.String("schedule", id), zap.Error(err))
+	}
+}
+
+// Start checks schedules every tick until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.tick())
+		defer ticker.Stop()
+		for {
+			s.RunDue(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,171 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeRuns struct {
+	active  map[string][]string
+	stopped []string
+}
+
+func (f *fakeRuns) ActiveScheduleRuns(scheduleID string) []string {
+	return f.active[scheduleID]
+}
+
+func (f *fakeRuns) StopRunReason(runID, reason string) error {
+	f.stopped = append(f.stopped, runID)
+	return nil
+}
+
+type schedulerFixture struct {
+	scheduler *Scheduler
+	runs      *fakeRuns
+	launched  []string
+	now       time.Time
+}
+
+func newSchedulerFixture(t *testing.T) *schedulerFixture {
+	t.Helper()
+	f := &schedulerFixture{
+		runs: &fakeRuns{active: map[string][]string{}},
+		now:  time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC),
+	}
+	f.scheduler = &Scheduler{
+		Store: NewStore(filepath.Join(t.TempDir(), "schedules")),
+		Runs:  f.runs,
+		Launch: func(ctx context.Context, item Schedule) (string, error) {
+			runID := fmt.Sprintf("run-%d", len(f.launched)+1)
+			f.launched = append(f.launched, item.ID+"/"+runID)
+			return runID, nil
+		},
+		Tick: 15 * time.Second,
+		Now:  func() time.Time { return f.now },
+	}
+	return f
+}
+
+func (f *schedulerFixture) save(t *testing.T, item Schedule) Schedule {
+	t.Helper()
+	if err := item.Normalize(); err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if item.NextRunAt.IsZero() {
+		item.NextRunAt = item.NextAfter(f.now)
+	}
+	saved, err := f.scheduler.Store.Save(item)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	return saved
+}
+
+func (f *schedulerFixture) get(t *testing.T, id string) Schedule {
+	t.Helper()
+	item, err := f.scheduler.Store.Get(id)
+	if err != nil {
+		t.Fatalf("get %s: %v", id, err)
+	}
+	return item
+}
+
+func TestSchedulerStartsDueRunsAndAppliesConcurrency(t *testing.T) {
+	f := newSchedulerFixture(t)
+	allow := f.save(t, Schedule{ID: "allow", Workflow: "deploy", Cron: "*/5 * * * *"})
+	forbid := f.save(t, Schedule{ID: "forbid", Workflow: "deploy", Cron: "*/5 * * * *", Concurrency: "forbid"})
+	replace := f.save(t, Schedule{ID: "replace", Workflow: "deploy", Cron: "*/5 * * * *", Concurrency: "replace"})
+	f.save(t, Schedule{ID: "paused", Workflow: "deploy", Cron: "*/5 * * * *", Paused: true})
+
+	f.scheduler.RunDue(context.Background())
+	if len(f.launched) != 0 {
+		t.Fatalf("expected nothing due yet, got %v", f.launched)
+	}
+
+	f.runs.active = map[string][]string{"allow": {"run-a"}, "forbid": {"run-f"}, "replace": {"run-r"}}
+	f.now = f.now.Add(5*time.Minute + time.Second)
+	f.scheduler.RunDue(context.Background())
+	if !reflect.DeepEqual(f.launched, []string{"allow/run-1", "replace/run-2"}) {
+		t.Fatalf("unexpected launches: %v", f.launched)
+	}
+	if !reflect.DeepEqual(f.runs.stopped, []string{"run-r"}) {
+		t.Fatalf("expected replace to stop the previous run, got %v", f.runs.stopped)
+	}
+
+	next := time.Date(2024, 3, 15, 10, 10, 0, 0, time.UTC)
+	got := f.get(t, allow.ID)
+	if got.LastRunID != "run-1" || got.LastStatus != StatusStarted || !got.NextRunAt.Equal(next) {
+		t.Fatalf("unexpected allow schedule: %+v", got)
+	}
+	got = f.get(t, forbid.ID)
+	if got.LastStatus != StatusSkipped || !strings.Contains(got.LastMessage, "run-f") || !got.NextRunAt.Equal(next) {
+		t.Fatalf("unexpected forbid schedule: %+v", got)
+	}
+	if got := f.get(t, replace.ID); got.LastRunID != "run-2" {
+		t.Fatalf("unexpected replace schedule: %+v", got)
+	}
+	if got := f.get(t, "paused"); got.LastStatus != "" {
+		t.Fatalf("expected paused schedule to stay idle, got %+v", got)
+	}
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	f := newSchedulerFixture(t)
+	f.scheduler.CatchUp = CatchUpSkip
+	f.scheduler.CatchUpWindow = 3 * time.Hour
+	// All three were due at 10:00 and the server comes back at 12:30.
+	due := f.now
+	f.save(t, Schedule{ID: "skip", Workflow: "deploy", Cron: "0 * * * *", NextRunAt: due})
+	f.save(t, Schedule{ID: "once", Workflow: "deploy", Cron: "0 * * * *", CatchUp: "once", NextRunAt: due})
+	f.save(t, Schedule{ID: "daily", Workflow: "deploy", Cron: "0 10 * * *", CatchUp: "once", NextRunAt: due.Add(-24 * time.Hour)})
+
+	f.now = due.Add(150 * time.Minute)
+	f.scheduler.RunDue(context.Background())
+	if !reflect.DeepEqual(f.launched, []string{"daily/run-1", "once/run-2"}) {
+		t.Fatalf("unexpected launches: %v", f.launched)
+	}
+	if got := f.get(t, "skip"); got.LastStatus != StatusSkipped || !strings.Contains(got.LastMessage, "12:00:00Z") {
+		t.Fatalf("expected the latest missed run to be skipped, got %+v", got)
+	}
+	if got := f.get(t, "once"); !got.NextRunAt.Equal(time.Date(2024, 3, 15, 13, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected next run: %+v", got)
+	}
+
+	// A missed run older than the window is skipped even with catch_up once.
+	f.save(t, Schedule{ID: "stale", Workflow: "deploy", Cron: "0 9 * * *", CatchUp: "once", NextRunAt: due.Add(-time.Hour)})
+	f.scheduler.RunDue(context.Background())
+	if got := f.get(t, "stale"); got.LastStatus != StatusSkipped || !strings.Contains(got.LastMessage, "window") {
+		t.Fatalf("expected stale run to be skipped, got %+v", got)
+	}
+}
+
+func TestScheduleNormalize(t *testing.T) {
+	item := Schedule{Workflow: " deploy ", Cron: "@daily", Timezone: "Asia/Shanghai"}
+	if err := item.Normalize(); err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if item.Workflow != "deploy" || item.Concurrency != ConcurrencyAllow {
+		t.Fatalf("unexpected defaults: %+v", item)
+	}
+	from := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	if next := item.NextAfter(from); !next.Equal(time.Date(2024, 3, 15, 16, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected midnight in Shanghai, got %s", next)
+	}
+
+	for _, bad := range []Schedule{
+		{Cron: "@daily"},
+		{Workflow: "deploy", Cron: "bad"},
+		{Workflow: "deploy", Cron: "@daily", Timezone: "Mars/Olympus"},
+		{Workflow: "deploy", Cron: "@daily", Concurrency: "queue"},
+		{Workflow: "deploy", Cron: "@daily", CatchUp: "all"},
+	} {
+		if err := bad.Normalize(); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
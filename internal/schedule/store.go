@@ -0,0 +1,267 @@
+package schedule
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ConcurrencyAllow   = "allow"
+	ConcurrencyForbid  = "forbid"
+	ConcurrencyReplace = "replace"
+
+	CatchUpSkip = "skip"
+	CatchUpOnce = "once"
+)
+
+// ErrNotFound is returned for unknown schedule IDs.
+var ErrNotFound = errors.New("schedule not found")
+
+// Schedule starts runs of a stored workflow on a cron expression.
+type Schedule struct {
+	ID       string `json:"id" yaml:"id"`
+	Workflow string `json:"workflow" yaml:"workflow"`
+	Cron     string `json:"cron" yaml:"cron"`
+	// Timezone is an IANA name such as "Asia/Shanghai"; empty means UTC.
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	// Vars override the workflow vars for scheduled runs.
+	Vars          map[string]any `json:"vars,omitempty" yaml:"vars,omitempty"`
+	ValidationEnv string         `json:"validation_env,omitempty" yaml:"validation_env,omitempty"`
+	// Concurrency decides what happens when the previous run of the
+	// schedule is still active: allow, forbid (skip) or replace (stop it).
+	Concurrency string `json:"concurrency" yaml:"concurrency"`
+	// CatchUp decides what happens to runs missed while the server was
+	// down: skip or once. Empty uses the server default.
+	CatchUp string `json:"catch_up,omitempty" yaml:"catch_up,omitempty"`
+	Paused  bool   `json:"paused,omitempty" yaml:"paused,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" yaml:"updated_at"`
+	// NextRunAt is kept on disk so runs missed while the server is down
+	// are noticed on start. The Last* fields describe the last time the
+	// schedule came due, whether or not it started a run.
+	NextRunAt   time.Time `json:"next_run_at,omitempty" yaml:"next_run_at,omitempty"`
+	LastRunAt   time.Time `json:"last_run_at,omitempty" yaml:"last_run_at,omitempty"`
+	LastRunID   string    `json:"last_run_id,omitempty" yaml:"last_run_id,omitempty"`
+	LastStatus  string    `json:"last_status,omitempty" yaml:"last_status,omitempty"`
+	LastMessage string    `json:"last_message,omitempty" yaml:"last_message,omitempty"`
+}
+
+// Normalize fills defaults and checks the schedule.
+func (s *Schedule) Normalize() error {
+	s.Workflow = strings.TrimSpace(s.Workflow)
+	s.Cron = strings.TrimSpace(s.Cron)
+	s.Timezone = strings.TrimSpace(s.Timezone)
+	s.Concurrency = strings.ToLower(strings.TrimSpace(s.Concurrency))
+	s.CatchUp = strings.ToLower(strings.TrimSpace(s.CatchUp))
+	if s.Workflow == "" {
+		return fmt.Errorf("schedule workflow is required")
+	}
+	if _, err := ParseCron(s.Cron); err != nil {
+		return err
+	}
+	if _, err := s.Location(); err != nil {
+		return err
+	}
+	switch s.Concurrency {
+	case "":
+		s.Concurrency = ConcurrencyAllow
+	case ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace:
+	default:
+		return fmt.Errorf("schedule concurrency must be allow, forbid or replace")
+	}
+	switch s.CatchUp {
+	case "", CatchUpSkip, CatchUpOnce:
+	default:
+		return fmt.Errorf("schedule catch_up must be skip or once")
+	}
+	return nil
+}
+
+// Location returns the schedule's time zone.
+func (s Schedule) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule timezone %q", s.Timezone)
+	}
+	return loc, nil
+}
+
+// NextAfter returns the next fire time after t, or the zero time when the
+// expression is invalid or never matches.
+func (s Schedule) NextAfter(t time.Time) time.Time {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := s.Location()
+	if err != nil {
+		return time.Time{}
+	}
+	next := cron.Next(t.In(loc))
+	if next.IsZero() {
+		return next
+	}
+	return next.UTC()
+}
+
+// Store keeps one YAML file per schedule.
+type Store struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// List returns all schedules ordered by ID.
+func (s *Store) List() ([]Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ensureDir(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Schedule, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".yaml" {
+			continue
+		}
+		item, err := s.read(strings.TrimSuffix(entry.Name(), ".yaml"))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+func (s *Store) Get(id string) (Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(id)
+}
+
+// Save writes item, assigning an ID to new schedules.
+func (s *Store) Save(item Schedule) (Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ensureDir(); err != nil {
+		return Schedule{}, err
+	}
+	if strings.TrimSpace(item.ID) == "" {
+		item.ID = newScheduleID()
+	}
+	path, err := s.path(item.ID)
+	if err != nil {
+		return Schedule{}, err
+	}
+	data, err := yaml.Marshal(item)
+	if err != nil {
+		return Schedule{}, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return Schedule{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return Schedule{}, err
+	}
+	return item, nil
+}
+
+// Update applies fn to the stored schedule and saves it.
+func (s *Store) Update(id string, fn func(*Schedule)) (Schedule, error) {
+	s.mu.Lock()
+	item, err := s.read(id)
+	s.mu.Unlock()
+	if err != nil {
+		return Schedule{}, err
+	}
+	fn(&item)
+	return s.Save(item)
+}
+
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) read(id string) (Schedule, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return Schedule{}, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Schedule{}, ErrNotFound
+	}
+	if err != nil {
+		return Schedule{}, err
+	}
+	var item Schedule
+	if err := yaml.Unmarshal(data, &item); err != nil {
+		return Schedule{}, fmt.Errorf("parse schedule %s: %w", id, err)
+	}
+	if item.ID == "" {
+		item.ID = id
+	}
+	return item, nil
+}
+
+func (s *Store) ensureDir() error {
+	if strings.TrimSpace(s.Dir) == "" {
+		return fmt.Errorf("store dir is empty")
+	}
+	return os.MkdirAll(s.Dir, 0o755)
+}
+
+func (s *Store) path(id string) (string, error) {
+	trimmed := strings.TrimSpace(id)
+	if trimmed == "" {
+		return "", fmt.Errorf("schedule id is empty")
+	}
+	for _, r := range trimmed {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			continue
+		}
+		return "", fmt.Errorf("invalid schedule id %q", id)
+	}
+	return filepath.Join(s.Dir, trimmed+".yaml"), nil
+}
+
+func newScheduleID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("sched-%d", time.Now().UnixNano())
+	}
+	return "sched-" + hex.EncodeToString(buf)
+}
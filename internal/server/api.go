@@ -1,8 +1,10 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,7 +16,9 @@ import (
 	"bops/internal/envstore"
 	"bops/runner/logging"
 	"bops/internal/stepsstore"
+	"bops/runner/state"
 	"bops/internal/report"
+	"bops/internal/runmanager"
 	"bops/runner/workflow"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
@@ -62,9 +66,26 @@ type envPackageResponse struct {
 	Env         map[string]string `json:"env"`
 }
 
+// runRequest is the optional body of plan, apply and resume requests.
+type runRequest struct {
+	Params map[string]any `json:"params"`
+}
+
+type paramsResponse struct {
+	Name  string           `json:"name"`
+	Items []workflow.Param `json:"items"`
+	Total int              `json:"total"`
+}
+
 type runResponse struct {
-	RunID  string `json:"run_id"`
-	Status string `json:"status"`
+	RunID         string `json:"run_id"`
+	Status        string `json:"status"`
+	QueuePosition int    `json:"queue_position,omitempty"`
+}
+
+type runEventRequest struct {
+	Event   string         `json:"event"`
+	Payload map[string]any `json:"payload"`
 }
 
 type runListResponse struct {
@@ -107,6 +128,8 @@ func (s *Server) routes() {
 	s.mux.HandleFunc("/api/agents", s.handleAgents)
 	s.mux.HandleFunc("/api/runs", s.handleRuns)
 	s.mux.HandleFunc("/api/runs/", s.handleRun)
+	s.mux.HandleFunc("/api/schedules", s.handleSchedules)
+	s.mux.HandleFunc("/api/schedules/", s.handleSchedule)
 
 	if strings.TrimSpace(s.StaticDir) != "" {
 		s.mux.Handle("/", spaHandler(s.StaticDir))
@@ -257,6 +280,14 @@ func (s *Server) handleWorkflow(w http.ResponseWriter, r *http.Request) {
 		s.handleWorkflowInventory(w, r, strings.TrimSuffix(path, "/inventory"))
 		return
 	}
+	if strings.HasSuffix(path, "/drift") {
+		s.handleWorkflowDrift(w, r, strings.TrimSuffix(path, "/drift"))
+		return
+	}
+	if strings.HasSuffix(path, "/params") {
+		s.handleWorkflowParams(w, r, strings.TrimSuffix(path, "/params"))
+		return
+	}
 
 	name := strings.Trim(path, "/")
 	if name == "" {
@@ -266,7 +297,7 @@ func (s *Server) handleWorkflow(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		wf, err := s.store.LoadWorkflow(name)
+		wf, err := s.store.LoadStored(name)
 		if err != nil {
 			writeError(w, r, http.StatusNotFound, err.Error())
 			return
@@ -301,6 +332,10 @@ func (s *Server) handleWorkflow(w http.ResponseWriter, r *http.Request) {
 			writeError(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
+		if err := s.store.CheckIncludes(name, wf); err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
 		stepsDoc, invDoc := stepsstore.SplitWorkflow(wf, name)
 		stepsRaw, err := yaml.Marshal(stepsDoc)
 		if err != nil {
@@ -326,6 +361,14 @@ func (s *Server) handleWorkflow(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func validationIssues(err error) []string {
+	var validationErr *workflow.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Issues
+	}
+	return []string{err.Error()}
+}
+
 func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request, name string) {
 	if r.Method != http.MethodPost {
 		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
@@ -353,11 +396,16 @@ func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request, name str
 		return
 	}
 	if err := wf.Validate(); err != nil {
-		if validationErr, ok := err.(*workflow.ValidationError); ok {
-			writeJSON(w, http.StatusOK, validateResponse{OK: false, Issues: validationErr.Issues})
-			return
-		}
-		writeJSON(w, http.StatusOK, validateResponse{OK: false, Issues: []string{err.Error()}})
+		writeJSON(w, http.StatusOK, validateResponse{OK: false, Issues: validationIssues(err)})
+		return
+	}
+	// Check the steps the includes expand to, and include cycles.
+	expanded, err := s.store.Includer().Expand(wf, wf.Name)
+	if err == nil {
+		err = expanded.Validate()
+	}
+	if err != nil {
+		writeJSON(w, http.StatusOK, validateResponse{OK: false, Issues: validationIssues(err)})
 		return
 	}
 
@@ -381,6 +429,15 @@ func (s *Server) handlePlan(w http.ResponseWriter, r *http.Request, name string)
 		writeError(w, r, http.StatusNotFound, err.Error())
 		return
 	}
+	params, err := readRunParams(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := wf.ResolveParams(params); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	envMap, err := s.loadEnvPackages(wf.EnvPackages)
 	if err != nil {
@@ -415,6 +472,15 @@ func (s *Server) handleApply(w http.ResponseWriter, r *http.Request, name string
 		writeError(w, r, http.StatusNotFound, err.Error())
 		return
 	}
+	params, err := readRunParams(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := wf.ResolveParams(params); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	envMap, err := s.loadEnvPackages(wf.EnvPackages)
 	if err != nil {
@@ -423,21 +489,109 @@ func (s *Server) handleApply(w http.ResponseWriter, r *http.Request, name string
 	}
 	applyEnvToWorkflow(&wf, envMap)
 
-	runID, runCtx, err := s.runs.StartRun(context.Background(), wf)
+	runID, err := s.startRun(wf, envMap, "")
 	if err != nil {
 		writeError(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	go func() {
-		recorder := s.runs.Recorder(runID)
-		ctx := engine.WithRecorder(runCtx, recorder)
+	writeJSON(w, http.StatusOK, s.runStatus(runID))
+}
+
+// runStatus reports whether a run just handed to the queue is still waiting.
+func (s *Server) runStatus(runID string) runResponse {
+	if position := s.runs.QueuePosition(runID); position > 0 {
+		return runResponse{RunID: runID, Status: state.RunStatusQueued, QueuePosition: position}
+	}
+	return runResponse{RunID: runID, Status: state.RunStatusRunning}
+}
+
+// startRun queues a new run and applies wf once the queue lets it start.
+func (s *Server) startRun(wf workflow.Workflow, envMap map[string]string, scheduleID string) (string, error) {
+	return s.runs.Enqueue(wf, scheduleID, s.applyRun(wf, envMap))
+}
+
+// applyRun returns the Exec that applies wf as a queued run.
+func (s *Server) applyRun(wf workflow.Workflow, envMap map[string]string) runmanager.Exec {
+	return func(runCtx context.Context, runID string) error {
+		ctx := engine.WithRecorder(runCtx, s.runs.Recorder(runID))
 		ctx = engine.WithEnv(ctx, envMap)
-		err := s.engine.Apply(ctx, wf)
-		_ = s.runs.FinishRun(runID, err)
-	}()
+		ctx = engine.WithChildRuns(ctx, s.runChild)
+		_, err := s.engine.ApplyWithRun(ctx, wf, engine.RunOptions{RunID: runID})
+		return err
+	}
+}
+
+// runChild runs a workflow called by workflow.call as a run of its own,
+// linked to the calling run.
+func (s *Server) runChild(ctx context.Context, parentRunID string, wf workflow.Workflow) (string, error) {
+	runID, runCtx, err := s.runs.StartChildRun(ctx, wf, parentRunID)
+	if err != nil {
+		return "", err
+	}
+	runCtx = engine.WithRecorder(runCtx, s.runs.Recorder(runID))
+	_, err = s.engine.ApplyWithRun(runCtx, wf, engine.RunOptions{RunID: runID, ParentRunID: parentRunID})
+	if finishErr := s.runs.FinishRun(runID, err); finishErr != nil {
+		logging.L().Warn("finish child run failed", zap.String("run_id", runID), zap.Error(finishErr))
+	}
+	return runID, err
+}
 
-	writeJSON(w, http.StatusOK, runResponse{RunID: runID, Status: "running"})
+// loadCalledWorkflow loads the stored workflow named by a workflow.call
+// step. The server does not read workflow files.
+func (s *Server) loadCalledWorkflow(ref string) (workflow.Workflow, error) {
+	if workflow.IsFileRef(ref) {
+		return workflow.Workflow{}, fmt.Errorf("workflow %q: the server only calls stored workflows", ref)
+	}
+	return s.store.LoadWorkflow(ref)
+}
+
+// handleWorkflowParams lists the declared params so clients can render a
+// run form. Secret defaults are not returned.
+func (s *Server) handleWorkflowParams(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name = strings.Trim(name, "/")
+	if name == "" {
+		writeError(w, r, http.StatusNotFound, "workflow name is required")
+		return
+	}
+	wf, err := s.store.LoadWorkflow(name)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	items := make([]workflow.Param, 0, len(wf.Params))
+	for _, p := range wf.Params {
+		p.Type = p.Kind()
+		if p.Type == workflow.ParamSecret {
+			p.Default = nil
+		}
+		items = append(items, p)
+	}
+	writeJSON(w, http.StatusOK, paramsResponse{Name: name, Items: items, Total: len(items)})
+}
+
+// readRunParams decodes the params of an optional runRequest body.
+func readRunParams(r *http.Request) (map[string]any, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]any{}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return params, nil
+	}
+	var req runRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid json payload")
+	}
+	for k, v := range req.Params {
+		params[k] = v
+	}
+	return params, nil
 }
 
 func (s *Server) handleWorkflowSteps(w http.ResponseWriter, r *http.Request, name string) {
@@ -469,6 +623,15 @@ func (s *Server) handleWorkflowSteps(w http.ResponseWriter, r *http.Request, nam
 			writeError(w, r, http.StatusBadRequest, "yaml is required")
 			return
 		}
+		var doc stepsstore.StepsDoc
+		if err := yaml.Unmarshal([]byte(req.YAML), &doc); err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.store.CheckIncludes(name, stepsstore.BuildWorkflow(name, doc, stepsstore.InventoryDoc{})); err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
 		if _, err := s.store.PutSteps(name, []byte(req.YAML)); err != nil {
 			writeError(w, r, http.StatusBadRequest, err.Error())
 			return
@@ -580,6 +743,14 @@ func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 		s.handleRunStop(w, r, strings.TrimSuffix(runID, "/stop"))
 		return
 	}
+	if strings.HasSuffix(runID, "/resume") {
+		s.handleRunResume(w, r, strings.TrimSuffix(runID, "/resume"))
+		return
+	}
+	if strings.HasSuffix(runID, "/events") {
+		s.handleRunEvents(w, r, strings.TrimSuffix(runID, "/events"))
+		return
+	}
 
 	if r.Method != http.MethodGet {
 		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
@@ -596,7 +767,11 @@ func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{"run": run, "steps": run.Steps})
+	resp := map[string]any{"run": run, "steps": run.Steps}
+	if position := s.runs.QueuePosition(runID); position > 0 {
+		resp["queue_position"] = position
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func (s *Server) handleRunStop(w http.ResponseWriter, r *http.Request, runID string) {
@@ -619,6 +794,155 @@ func (s *Server) handleRunStop(w http.ResponseWriter, r *http.Request, runID str
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
+func (s *Server) handleRunResume(w http.ResponseWriter, r *http.Request, runID string) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	runID = strings.Trim(runID, "/")
+	if runID == "" {
+		writeError(w, r, http.StatusNotFound, "run id is required")
+		return
+	}
+
+	run, ok, err := s.runs.GetRun(runID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "run not found")
+		return
+	}
+
+	wf, err := s.store.LoadWorkflow(run.WorkflowName)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	// Recorded params are reused; secret params have to be sent again.
+	params, err := readRunParams(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, p := range wf.Params {
+		if v, ok := run.Params[p.Name]; ok {
+			if _, set := params[p.Name]; !set {
+				params[p.Name] = v
+			}
+		}
+	}
+	if err := wf.ResolveParams(params); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	envMap, err := s.loadEnvPackages(wf.EnvPackages)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	applyEnvToWorkflow(&wf, envMap)
+
+	err = s.runs.EnqueueResume(runID, wf, func(runCtx context.Context, prev state.RunState) error {
+		ctx := engine.WithRecorder(runCtx, s.runs.Recorder(runID))
+		ctx = engine.WithEnv(ctx, envMap)
+		ctx = engine.WithChildRuns(ctx, s.runChild)
+		_, err := s.engine.ApplyWithRun(ctx, wf, engine.RunOptions{RunID: runID, Resume: &prev})
+		return err
+	})
+	if err != nil {
+		writeError(w, r, http.StatusConflict, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.runStatus(runID))
+}
+
+// handleRunEvents publishes an external signal to a running run; wait.event
+// steps waiting for that event name resume.
+func (s *Server) handleRunEvents(w http.ResponseWriter, r *http.Request, runID string) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	runID = strings.Trim(runID, "/")
+	if runID == "" {
+		writeError(w, r, http.StatusNotFound, "run id is required")
+		return
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	var req runEventRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid json payload")
+		return
+	}
+	req.Event = strings.TrimSpace(req.Event)
+	if req.Event == "" {
+		writeError(w, r, http.StatusBadRequest, "event is required")
+		return
+	}
+
+	run, ok, err := s.runs.GetRun(runID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "run not found")
+		return
+	}
+	if run.Status != "running" {
+		writeError(w, r, http.StatusConflict, fmt.Sprintf("run %s is %s", runID, run.Status))
+		return
+	}
+
+	now := time.Now().UTC()
+	s.bus.Publish(core.Event{
+		ID:         fmt.Sprintf("evt-%d", now.UnixNano()),
+		Type:       core.EventSignal,
+		Level:      core.EventInfo,
+		Time:       now,
+		WorkflowID: run.WorkflowName,
+		RunID:      runID,
+		Message:    req.Event,
+		Data:       req.Payload,
+	})
+
+	// A signal is not kept for steps that start waiting later, so tell the
+	// caller when no wait.event step received it.
+	waiting := s.signalWaiters(runID, req.Event)
+	if waiting == 0 {
+		writeJSON(w, http.StatusAccepted, map[string]any{"ok": true, "delivered": false, "waiting": 0})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "delivered": true, "waiting": waiting})
+}
+
+// signalWaiters returns how many wait.event steps of runID wait for event.
+func (s *Server) signalWaiters(runID, event string) int {
+	if s.engine == nil || s.engine.Registry == nil {
+		return 0
+	}
+	module, ok := s.engine.Registry.Get("wait.event")
+	if !ok {
+		return 0
+	}
+	waiter, ok := module.(interface{ Waiting(runID, event string) int })
+	if !ok {
+		return 0
+	}
+	return waiter.Waiting(runID, event)
+}
+
 func (s *Server) handleRunStream(w http.ResponseWriter, r *http.Request, runID string) {
 	runID = strings.Trim(runID, "/")
 	if runID == "" {
@@ -679,6 +1003,8 @@ func (s *Server) listRuns(r *http.Request, workflowName string) ([]report.Summar
 	}
 
 	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	scheduleID := strings.TrimSpace(r.URL.Query().Get("schedule_id"))
+	parentRunID := strings.TrimSpace(r.URL.Query().Get("parent_run_id"))
 	from, _ := parseTime(r.URL.Query().Get("from"))
 	to, _ := parseTime(r.URL.Query().Get("to"))
 
@@ -687,6 +1013,12 @@ func (s *Server) listRuns(r *http.Request, workflowName string) ([]report.Summar
 		if workflowName != "" && run.WorkflowName != workflowName {
 			continue
 		}
+		if scheduleID != "" && run.ScheduleID != scheduleID {
+			continue
+		}
+		if parentRunID != "" && run.ParentRunID != parentRunID {
+			continue
+		}
 
 		if !from.IsZero() && run.StartedAt.Before(from) {
 			continue
@@ -699,6 +1031,7 @@ func (s *Server) listRuns(r *http.Request, workflowName string) ([]report.Summar
 		if status != "" && summary.Status != status {
 			continue
 		}
+		summary.QueuePosition = s.runs.QueuePosition(run.RunID)
 
 		items = append(items, summary)
 	}
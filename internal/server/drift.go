@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"bops/internal/config"
+	"bops/internal/drift"
+	"bops/runner/workflow"
+)
+
+type driftResponse struct {
+	Workflow string       `json:"workflow"`
+	Latest   *drift.Scan  `json:"latest,omitempty"`
+	History  []drift.Scan `json:"history"`
+}
+
+func (s *Server) newDriftScanner(cfg config.Config) *drift.Scanner {
+	return &drift.Scanner{
+		Planner: s.engine,
+		Load: func(name string) (workflow.Workflow, error) {
+			wf, err := s.store.LoadWorkflow(name)
+			if err != nil {
+				return workflow.Workflow{}, err
+			}
+			if err := wf.ResolveParams(nil); err != nil {
+				return workflow.Workflow{}, err
+			}
+			envMap, err := s.loadEnvPackages(wf.EnvPackages)
+			if err != nil {
+				return workflow.Workflow{}, err
+			}
+			applyEnvToWorkflow(&wf, envMap)
+			return wf, nil
+		},
+		Workflows: func() ([]string, error) {
+			for _, name := range cfg.DriftScan.Workflows {
+				if strings.TrimSpace(name) != "*" {
+					continue
+				}
+				items, err := s.store.List()
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(items))
+				for _, item := range items {
+					names = append(names, item.Name)
+				}
+				return names, nil
+			}
+			return cfg.DriftScan.Workflows, nil
+		},
+		Store: drift.NewStore(filepath.Join(cfg.DataDir, "drift"), cfg.DriftScan.History),
+		Bus:   s.bus,
+	}
+}
+
+// handleWorkflowDrift returns the drift scan history of a workflow (GET,
+// newest first, ?limit=N) or scans it now (POST).
+func (s *Server) handleWorkflowDrift(w http.ResponseWriter, r *http.Request, name string) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		writeError(w, r, http.StatusNotFound, "workflow name is required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		history, err := s.drift.Store.History(name)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			limit, err := strconv.Atoi(raw)
+			if err != nil || limit < 0 {
+				writeError(w, r, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			if limit < len(history) {
+				history = history[:limit]
+			}
+		}
+		resp := driftResponse{Workflow: name, History: history}
+		if len(history) > 0 {
+			resp.Latest = &history[0]
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case http.MethodPost:
+		if _, err := s.store.LoadWorkflow(name); err != nil {
+			writeError(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+		scan, err := s.drift.Scan(r.Context(), name)
+		if err != nil && scan.ID == "" {
+			writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, scan)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateReportsIncludeCycle(t *testing.T) {
+	srv, _ := newRunTestServer(t)
+	for name, include := range map[string]string{"a": "b", "b": "a"} {
+		steps := "version: v0.1\nname: " + name + "\nsteps:\n  - name: inc\n    include: " + include + "\n"
+		if _, err := srv.store.PutSteps(name, []byte(steps)); err != nil {
+			t.Fatalf("put steps %s: %v", name, err)
+		}
+	}
+
+	body, _ := json.Marshal(validateRequest{YAML: "version: v0.1\nname: site\nsteps:\n  - name: base\n    include: a\n"})
+	rec := httptest.NewRecorder()
+	srv.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/workflows/site/validate", strings.NewReader(string(body))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("validate: %d %s", rec.Code, rec.Body.String())
+	}
+	var resp validateResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.OK || len(resp.Issues) != 1 || resp.Issues[0] != "include cycle: a -> b -> a" {
+		t.Fatalf("expected an include cycle, got %+v", resp)
+	}
+}
+
+func TestSaveRejectsIncludeCyclesAndFiles(t *testing.T) {
+	srv, _ := newRunTestServer(t)
+	if _, err := srv.store.PutSteps("a", []byte("version: v0.1\nname: a\nsteps:\n  - name: inc\n    include: b\n")); err != nil {
+		t.Fatalf("put steps a: %v", err)
+	}
+
+	put := func(path, yamlText string) (int, string) {
+		body, _ := json.Marshal(validateRequest{YAML: yamlText})
+		rec := httptest.NewRecorder()
+		srv.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, path, strings.NewReader(string(body))))
+		var resp struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp.Error
+	}
+
+	code, msg := put("/api/workflows/b/steps", "version: v0.1\nname: b\nsteps:\n  - name: inc\n    include: a\n")
+	if code != http.StatusBadRequest || !strings.Contains(msg, "include cycle: b -> a -> b") {
+		t.Fatalf("expected the indirect cycle to be rejected, got %d %s", code, msg)
+	}
+	code, msg = put("/api/workflows/c", "version: v0.1\nname: c\nsteps:\n  - name: inc\n    include: /etc/bops/secret.yaml\n")
+	if code != http.StatusBadRequest || !strings.Contains(msg, "only stored workflows can be included") {
+		t.Fatalf("expected the file include to be rejected, got %d %s", code, msg)
+	}
+	if code, msg := put("/api/workflows/b/steps", "version: v0.1\nname: b\nsteps:\n  - name: hi\n    action: cmd.run\n"); code != http.StatusOK {
+		t.Fatalf("expected b to be saved, got %d %s", code, msg)
+	}
+
+	// Files written to the store directly are still not read.
+	if _, err := srv.store.PutSteps("d", []byte("version: v0.1\nname: d\nsteps:\n  - name: inc\n    include: ../d.yaml\n")); err != nil {
+		t.Fatalf("put steps d: %v", err)
+	}
+	if _, err := srv.store.LoadWorkflow("d"); err == nil || !strings.Contains(err.Error(), "only stored workflows can be included") {
+		t.Fatalf("expected the file include to be rejected on load, got %v", err)
+	}
+}
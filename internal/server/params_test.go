@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWorkflowParamsAPI(t *testing.T) {
+	srv, _ := newRunTestServer(t)
+	steps := `version: v0.1
+name: deploy
+params:
+  - name: version
+    required: true
+  - name: token
+    type: secret
+    default: hunter2
+steps:
+  - name: hi
+    action: cmd.run
+    args:
+      cmd: echo ${version}
+`
+	if _, err := srv.store.PutSteps("deploy", []byte(steps)); err != nil {
+		t.Fatalf("put steps: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/workflows/deploy/params", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("params: %d %s", rec.Code, rec.Body.String())
+	}
+	var resp paramsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Total != 2 || resp.Items[0].Type != "string" || !resp.Items[0].Required || resp.Items[1].Default != nil {
+		t.Fatalf("unexpected params: %+v", resp)
+	}
+
+	for _, body := range []string{"", `{"params":{"version":"1.0","colour":"red"}}`, `{"params":`} {
+		rec = httptest.NewRecorder()
+		srv.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/workflows/deploy/apply", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %q, got %d %s", body, rec.Code, rec.Body.String())
+		}
+	}
+}
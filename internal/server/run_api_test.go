@@ -5,14 +5,21 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"bops/internal/aistore"
+	"bops/internal/config"
+	"bops/internal/core"
 	"bops/internal/envstore"
 	"bops/internal/eventbus"
 	"bops/internal/runmanager"
 	"bops/internal/stepsstore"
+	"bops/runner/engine"
+	"bops/runner/modules"
 	"bops/runner/scheduler"
 	"bops/runner/scriptstore"
 	"bops/runner/state"
@@ -123,3 +130,220 @@ func TestRunAPI_StatusPayload(t *testing.T) {
 		t.Fatalf("expected host stderr output, got %v", host.Output["stderr"])
 	}
 }
+
+func TestRunAPI_ResumeSkipsCompletedSteps(t *testing.T) {
+	srv, runs := newRunTestServer(t)
+	srv.engine = engine.New(defaultRegistry(srv.scriptStore, srv.bus))
+	t.Cleanup(func() { _ = srv.engine.Close() })
+
+	stepsYAML := []byte(`version: v0.1
+name: demo
+steps:
+  - name: step-1
+    action: cmd.run
+    args:
+      cmd: "echo first"
+  - name: step-2
+    action: cmd.run
+    args:
+      cmd: "echo second"
+`)
+	if _, err := srv.store.PutSteps("demo", stepsYAML); err != nil {
+		t.Fatalf("put steps: %v", err)
+	}
+	if _, err := srv.store.PutInventory("demo", []byte("inventory:\n  hosts:\n    local:\n      address: \"127.0.0.1\"\n")); err != nil {
+		t.Fatalf("put inventory: %v", err)
+	}
+	wf, err := srv.store.LoadWorkflow("demo")
+	if err != nil {
+		t.Fatalf("load workflow: %v", err)
+	}
+
+	runID, _, err := runs.StartRun(context.Background(), wf)
+	if err != nil {
+		t.Fatalf("start run: %v", err)
+	}
+	rec := runs.Recorder(runID)
+	target := workflow.HostSpec{Name: "local"}
+	rec.StepStart(wf.Steps[0], []workflow.HostSpec{target})
+	rec.HostResult(wf.Steps[0], target, scheduler.Result{Status: "success", Output: map[string]any{"stdout": "recorded"}})
+	rec.StepFinish(wf.Steps[0], "success")
+	rec.StepStart(wf.Steps[1], []workflow.HostSpec{target})
+	rec.HostResult(wf.Steps[1], target, scheduler.Result{Status: "failed", Error: "boom"})
+	rec.StepFinish(wf.Steps[1], "failed")
+	_ = runs.FinishRun(runID, context.Canceled)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/runs/"+runID+"/resume", nil)
+	recorder := httptest.NewRecorder()
+	srv.mux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var run state.RunState
+	for {
+		run, _, err = runs.GetRun(runID)
+		if err != nil {
+			t.Fatalf("get run: %v", err)
+		}
+		if run.Status != "running" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for resumed run")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if run.Status != "success" || run.Attempt != 2 {
+		t.Fatalf("expected successful attempt 2, got %q attempt %d", run.Status, run.Attempt)
+	}
+	if got := run.Steps[0].Hosts["local"].Output["stdout"]; got != "recorded" {
+		t.Fatalf("expected step-1 not to run again, got stdout %v", got)
+	}
+	if run.Steps[1].Status != "success" {
+		t.Fatalf("expected step-2 to succeed, got %q", run.Steps[1].Status)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/runs/"+runID+"/resume", nil)
+	recorder = httptest.NewRecorder()
+	srv.mux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("expected status 409 for finished run, got %d", recorder.Code)
+	}
+}
+
+func TestRunAPI_EventsPublishSignal(t *testing.T) {
+	srv, runs := newRunTestServer(t)
+	srv.engine = engine.New(defaultRegistry(srv.scriptStore, srv.bus))
+	t.Cleanup(func() { _ = srv.engine.Close() })
+
+	wf := workflow.Workflow{Version: "v0.1", Name: "demo"}
+	runID, _, err := runs.StartRun(context.Background(), wf)
+	if err != nil {
+		t.Fatalf("start run: %v", err)
+	}
+
+	sub := srv.bus.Subscribe(16)
+	defer sub.Cancel()
+
+	body := strings.NewReader(`{"event":"migrated","payload":{"version":"42"}}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/runs/"+runID+"/events", body)
+	recorder := httptest.NewRecorder()
+	srv.mux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusAccepted || !strings.Contains(recorder.Body.String(), `"delivered":false`) {
+		t.Fatalf("expected status 202 with nobody waiting, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var signal core.Event
+	for evt := range sub.C {
+		if evt.Type == core.EventSignal {
+			signal = evt
+			break
+		}
+	}
+	if signal.RunID != runID || signal.Message != "migrated" || signal.Data["version"] != "42" {
+		t.Fatalf("unexpected signal event: %+v", signal)
+	}
+
+	waitEvent, _ := srv.engine.Registry.Get("wait.event")
+	waited := make(chan error, 1)
+	go func() {
+		_, err := waitEvent.Apply(context.Background(), modules.Request{
+			RunID: runID,
+			Step:  workflow.Step{Name: "wait", Action: "wait.event", Args: map[string]any{"event": "migrated", "timeout": "5s"}},
+			Host:  workflow.HostSpec{Name: "local"},
+		})
+		waited <- err
+	}()
+	for srv.signalWaiters(runID, "migrated") == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/api/runs/"+runID+"/events", strings.NewReader(`{"event":"migrated"}`))
+	recorder = httptest.NewRecorder()
+	srv.mux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"delivered":true`) {
+		t.Fatalf("expected status 200 for a waiting step, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if err := <-waited; err != nil {
+		t.Fatalf("wait.event: %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/runs/"+runID+"/events", strings.NewReader(`{"payload":{}}`))
+	recorder = httptest.NewRecorder()
+	srv.mux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without event, got %d", recorder.Code)
+	}
+
+	_ = runs.FinishRun(runID, nil)
+	req = httptest.NewRequest(http.MethodPost, "/api/runs/"+runID+"/events", strings.NewReader(`{"event":"migrated"}`))
+	recorder = httptest.NewRecorder()
+	srv.mux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("expected status 409 for finished run, got %d", recorder.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/runs/missing/events", strings.NewReader(`{"event":"migrated"}`))
+	recorder = httptest.NewRecorder()
+	srv.mux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for unknown run, got %d", recorder.Code)
+	}
+}
+
+func TestServerRecordsRunsInRunStore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.StatePath = filepath.Join(dir, "state.json")
+	cfg.RunStorePath = filepath.Join(dir, "runs.db")
+	srv := New(cfg, filepath.Join(dir, "config.json"))
+	if _, ok := srv.runStore.(*state.SQLiteStore); !ok {
+		t.Fatalf("expected the SQLite run store, got %T", srv.runStore)
+	}
+
+	wf := workflow.Workflow{
+		Version:   "v0.1",
+		Name:      "demo",
+		Inventory: workflow.Inventory{Hosts: map[string]workflow.Host{"local": {Address: "127.0.0.1"}}},
+		Steps:     []workflow.Step{{Name: "step-1", Action: "cmd.run", Args: map[string]any{"cmd": "echo hi"}}},
+	}
+	runID, err := srv.startRun(wf, nil, "")
+	if err != nil {
+		t.Fatalf("start run: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		run, _, err := srv.runs.GetRun(runID)
+		if err != nil {
+			t.Fatalf("get run: %v", err)
+		}
+		if state.IsTerminalRunStatus(run.Status) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for run, status %q", run.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	store, err := state.NewSQLiteStore(cfg.RunStorePath)
+	if err != nil {
+		t.Fatalf("reopen run store: %v", err)
+	}
+	defer store.Close()
+	runs, err := store.ListRuns(context.Background(), state.ListFilter{})
+	if err != nil {
+		t.Fatalf("list runs: %v", err)
+	}
+	if len(runs) != 1 || runs[0].RunID != runID || runs[0].Status != state.RunStatusSuccess {
+		t.Fatalf("expected one successful run %s in the run store, got %+v", runID, runs)
+	}
+	if _, err := os.Stat(cfg.StatePath); !os.IsNotExist(err) {
+		t.Fatalf("expected no state file next to the run store, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bops/internal/config"
+	"bops/internal/schedule"
+	"bops/internal/validationrun"
+	"bops/runner/workflow"
+	"gopkg.in/yaml.v3"
+)
+
+type scheduleRequest struct {
+	Workflow      string         `json:"workflow"`
+	Cron          string         `json:"cron"`
+	Timezone      string         `json:"timezone"`
+	Vars          map[string]any `json:"vars"`
+	ValidationEnv string         `json:"validation_env"`
+	Concurrency   string         `json:"concurrency"`
+	CatchUp       string         `json:"catch_up"`
+	Paused        bool           `json:"paused"`
+}
+
+type scheduleListResponse struct {
+	Items []schedule.Schedule `json:"items"`
+	Total int                 `json:"total"`
+}
+
+func (s *Server) newScheduler(cfg config.Config) *schedule.Scheduler {
+	return &schedule.Scheduler{
+		Store:         s.schedules,
+		Runs:          s.runs,
+		Launch:        s.launchScheduledRun,
+		CatchUp:       cfg.Scheduler.CatchUp,
+		CatchUpWindow: parseConfigDuration(cfg.Scheduler.CatchUpWindow),
+	}
+}
+
+// launchScheduledRun applies the schedule's overrides to the stored workflow
+// and queues a run tagged with the schedule ID. With a validation env the
+// workflow must first apply cleanly there; the validation runs inside the
+// queued run, so a slow env never holds up the other due schedules, and a
+// failed validation fails the run before it touches any host.
+func (s *Server) launchScheduledRun(ctx context.Context, item schedule.Schedule) (string, error) {
+	wf, err := s.scheduledWorkflow(item)
+	if err != nil {
+		return "", err
+	}
+	envMap, err := s.loadEnvPackages(wf.EnvPackages)
+	if err != nil {
+		return "", err
+	}
+	applyEnvToWorkflow(&wf, envMap)
+	apply := s.applyRun(wf, envMap)
+	if item.ValidationEnv == "" {
+		return s.runs.Enqueue(wf, item.ID, apply)
+	}
+	return s.runs.Enqueue(wf, item.ID, func(runCtx context.Context, runID string) error {
+		if err := s.validateScheduledRun(runCtx, item, wf); err != nil {
+			return err
+		}
+		return apply(runCtx, runID)
+	})
+}
+
+// validateScheduledRun applies wf in the schedule's validation env, as the
+// validation run API does, and records the result in the audit log.
+func (s *Server) validateScheduledRun(ctx context.Context, item schedule.Schedule, wf workflow.Workflow) error {
+	env, _, err := s.validationStore.Get(item.ValidationEnv)
+	if err != nil {
+		return fmt.Errorf("validation env %s: %w", item.ValidationEnv, err)
+	}
+	raw, err := yaml.Marshal(wf)
+	if err != nil {
+		return err
+	}
+	result, runErr := validationrun.Runner(ctx, env, string(raw))
+	entry := validationAuditEntry{
+		Source:    "schedule",
+		Workflow:  item.Workflow,
+		Env:       env.Name,
+		EnvType:   string(env.Type),
+		Status:    result.Status,
+		Code:      result.Code,
+		YAMLHash:  hashYAML(string(raw)),
+		StepCount: len(wf.Steps),
+	}
+	if runErr != nil {
+		entry.Status = "failed"
+		entry.Error = runErr.Error()
+	}
+	s.recordValidationAudit(entry)
+	if entry.Status != "success" {
+		if entry.Error == "" {
+			entry.Error = "status " + entry.Status
+		}
+		return fmt.Errorf("validation in env %s failed: %s", env.Name, entry.Error)
+	}
+	return nil
+}
+
+// scheduledWorkflow loads the stored workflow with the schedule's vars.
+// Schedule vars named after a param set that param.
+func (s *Server) scheduledWorkflow(item schedule.Schedule) (workflow.Workflow, error) {
+	wf, err := s.store.LoadWorkflow(item.Workflow)
+	if err != nil {
+		return workflow.Workflow{}, err
+	}
+	if len(item.Vars) > 0 {
+		vars := make(map[string]any, len(wf.Vars)+len(item.Vars))
+		for k, v := range wf.Vars {
+			vars[k] = v
+		}
+		for k, v := range item.Vars {
+			vars[k] = v
+		}
+		wf.Vars = vars
+	}
+	if err := wf.ResolveParams(nil); err != nil {
+		return workflow.Workflow{}, err
+	}
+	return wf, nil
+}
+
+func (s *Server) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		items, err := s.schedules.List()
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if name := strings.TrimSpace(r.URL.Query().Get("workflow")); name != "" {
+			filtered := items[:0]
+			for _, item := range items {
+				if item.Workflow == name {
+					filtered = append(filtered, item)
+				}
+			}
+			items = filtered
+		}
+		writeJSON(w, http.StatusOK, scheduleListResponse{Items: items, Total: len(items)})
+	case http.MethodPost:
+		item, ok := s.readSchedule(w, r)
+		if !ok {
+			return
+		}
+		now := time.Now().UTC()
+		item.CreatedAt = now
+		item.UpdatedAt = now
+		item.NextRunAt = item.NextAfter(now)
+		saved, err := s.schedules.Save(item)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, saved)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/schedules/"), "/")
+	if id == "" {
+		writeError(w, r, http.StatusNotFound, "schedule id is required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		item, err := s.schedules.Get(id)
+		if err != nil {
+			writeScheduleError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, item)
+	case http.MethodPut:
+		existing, err := s.schedules.Get(id)
+		if err != nil {
+			writeScheduleError(w, r, err)
+			return
+		}
+		item, ok := s.readSchedule(w, r)
+		if !ok {
+			return
+		}
+		now := time.Now().UTC()
+		item.ID = existing.ID
+		item.CreatedAt = existing.CreatedAt
+		item.UpdatedAt = now
+		item.NextRunAt = item.NextAfter(now)
+		item.LastRunAt = existing.LastRunAt
+		item.LastRunID = existing.LastRunID
+		item.LastStatus = existing.LastStatus
+		item.LastMessage = existing.LastMessage
+		saved, err := s.schedules.Save(item)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, saved)
+	case http.MethodDelete:
+		if err := s.schedules.Delete(id); err != nil {
+			writeScheduleError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// readSchedule decodes and checks a schedule from the request body, writing
+// the error response when it is invalid.
+func (s *Server) readSchedule(w http.ResponseWriter, r *http.Request) (schedule.Schedule, bool) {
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return schedule.Schedule{}, false
+	}
+	var req scheduleRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid json payload")
+		return schedule.Schedule{}, false
+	}
+	item := schedule.Schedule{
+		Workflow:      req.Workflow,
+		Cron:          req.Cron,
+		Timezone:      req.Timezone,
+		Vars:          req.Vars,
+		ValidationEnv: strings.TrimSpace(req.ValidationEnv),
+		Concurrency:   req.Concurrency,
+		CatchUp:       req.CatchUp,
+		Paused:        req.Paused,
+	}
+	if err := item.Normalize(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return schedule.Schedule{}, false
+	}
+	if _, err := s.scheduledWorkflow(item); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return schedule.Schedule{}, false
+	}
+	if item.ValidationEnv != "" {
+		if _, _, err := s.validationStore.Get(item.ValidationEnv); err != nil {
+			writeError(w, r, http.StatusBadRequest, "validation env not found: "+item.ValidationEnv)
+			return schedule.Schedule{}, false
+		}
+	}
+	return item, true
+}
+
+func writeScheduleError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, schedule.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, err.Error())
+}
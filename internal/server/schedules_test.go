@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bops/internal/schedule"
+	"bops/internal/validationenv"
+	"bops/internal/validationrun"
+	"bops/runner/state"
+)
+
+func TestScheduleAPI(t *testing.T) {
+	srv, _ := newRunTestServer(t)
+	srv.schedules = schedule.NewStore(filepath.Join(t.TempDir(), "schedules"))
+	if _, err := srv.store.PutSteps("deploy", []byte("version: v0.1\nname: deploy\nsteps:\n  - name: hi\n    action: cmd.run\n    args:\n      cmd: echo hi\n")); err != nil {
+		t.Fatalf("put steps: %v", err)
+	}
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		srv.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/schedules", `{"workflow":"deploy","cron":"*/10 * * * *","timezone":"UTC","vars":{"tag":"nightly"},"concurrency":"forbid"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+	}
+	var created schedule.Schedule
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if created.ID == "" || created.NextRunAt.IsZero() || created.Concurrency != "forbid" || created.Vars["tag"] != "nightly" {
+		t.Fatalf("unexpected schedule: %+v", created)
+	}
+
+	for _, body := range []string{
+		`{"workflow":"deploy","cron":"bad"}`,
+		`{"workflow":"missing","cron":"@daily"}`,
+		`{"workflow":"deploy","cron":"@daily","concurrency":"queue"}`,
+	} {
+		if rec := do(http.MethodPost, "/api/schedules", body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, rec.Code)
+		}
+	}
+
+	rec = do(http.MethodPut, "/api/schedules/"+created.ID, `{"workflow":"deploy","cron":"@hourly","paused":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodGet, "/api/schedules?workflow=deploy", "")
+	var list scheduleListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if list.Total != 1 || list.Items[0].Cron != "@hourly" || !list.Items[0].Paused || !list.Items[0].CreatedAt.Equal(created.CreatedAt) {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+
+	if rec := do(http.MethodDelete, "/api/schedules/"+created.ID, ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete: %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/schedules/"+created.ID, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", rec.Code)
+	}
+}
+
+func TestScheduledRunValidatesInEnv(t *testing.T) {
+	srv, runs := newRunTestServer(t)
+	srv.validationStore = validationenv.NewStore(filepath.Join(t.TempDir(), "validation_envs"))
+	if _, err := srv.validationStore.Put("staging", validationenv.ValidationEnv{Name: "staging", Type: validationenv.EnvTypeContainer, Image: "bops:latest"}); err != nil {
+		t.Fatalf("put env: %v", err)
+	}
+	if _, err := srv.store.PutSteps("deploy", []byte("version: v0.1\nname: deploy\nsteps:\n  - name: hi\n    action: cmd.run\n    args:\n      cmd: echo ${tag}\n")); err != nil {
+		t.Fatalf("put steps: %v", err)
+	}
+
+	var validated string
+	original := validationrun.Runner
+	t.Cleanup(func() { validationrun.Runner = original })
+	validationrun.Runner = func(_ context.Context, env validationenv.ValidationEnv, yaml string) (validationrun.Result, error) {
+		validated = env.Name + "\n" + yaml
+		return validationrun.Result{Status: "failed", Code: 1}, errors.New("exit status 1")
+	}
+
+	item := schedule.Schedule{ID: "nightly", Workflow: "deploy", Vars: map[string]any{"tag": "nightly"}, ValidationEnv: "staging"}
+	runID, err := srv.launchScheduledRun(context.Background(), item)
+	if err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+	// The validation runs in the queued run, not in the scheduler tick.
+	deadline := time.Now().Add(5 * time.Second)
+	var run state.RunState
+	for {
+		run, _, err = runs.GetRun(runID)
+		if err != nil {
+			t.Fatalf("get run: %v", err)
+		}
+		if run.Status != state.RunStatusQueued && run.Status != state.RunStatusRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the scheduled run")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if run.Status != state.RunStatusFailed || !strings.Contains(run.Message, "validation in env staging failed") || run.ScheduleID != "nightly" {
+		t.Fatalf("expected the run to fail validation, got %+v", run)
+	}
+	if len(run.Steps) != 0 {
+		t.Fatalf("expected no steps after failed validation, got %+v", run.Steps)
+	}
+	if !strings.HasPrefix(validated, "staging\n") || !strings.Contains(validated, "tag: nightly") {
+		t.Fatalf("expected the scheduled workflow to be validated in staging, got %q", validated)
+	}
+
+	validationrun.Runner = func(_ context.Context, _ validationenv.ValidationEnv, _ string) (validationrun.Result, error) {
+		return validationrun.Result{Status: "success"}, nil
+	}
+	wf, err := srv.scheduledWorkflow(item)
+	if err != nil {
+		t.Fatalf("scheduled workflow: %v", err)
+	}
+	if err := srv.validateScheduledRun(context.Background(), item, wf); err != nil {
+		t.Fatalf("expected validation to pass, got %v", err)
+	}
+}
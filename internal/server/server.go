@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -13,11 +15,13 @@ import (
 	"bops/internal/aiworkflow"
 	"bops/internal/aiworkflowstore"
 	"bops/internal/config"
+	"bops/internal/drift"
 	"bops/runner/engine"
 	"bops/internal/envstore"
 	"bops/internal/eventbus"
 	"bops/runner/logging"
 	"bops/internal/runmanager"
+	"bops/internal/schedule"
 	"bops/runner/scriptstore"
 	"bops/internal/skills"
 	"bops/internal/stepsstore"
@@ -46,6 +50,12 @@ type Server struct {
 	scriptStore     *scriptstore.Store
 	engine          *engine.Engine
 	runs            *runmanager.Manager
+	runStore        runStore
+	stopCompactor   context.CancelFunc
+	drift           *drift.Scanner
+	stopDrift       context.CancelFunc
+	schedules       *schedule.Store
+	stopScheduler   context.CancelFunc
 	bus             *eventbus.Bus
 	auditLogPath    string
 	skillLoader     *skills.Loader
@@ -70,6 +80,7 @@ func New(cfg config.Config, configPath string) *Server {
 	loopPrompt := ai.LoadLoopPrompt(filepath.Join("docs", "prompt-loop.md"))
 	scriptStore := scriptstore.New(filepath.Join(cfg.DataDir, "scripts"))
 	aiWorkflowStore := aiworkflowstore.New(filepath.Join(cfg.DataDir, "ai_workflows"))
+	runs := openRunStore(cfg)
 	var aiWorkflow *aiworkflow.Pipeline
 	if aiClient != nil {
 		aiWorkflow, _ = aiworkflow.New(aiworkflow.Config{
@@ -83,6 +94,7 @@ func New(cfg config.Config, configPath string) *Server {
 		zap.String("static_dir", cfg.StaticDir),
 		zap.String("data_dir", cfg.DataDir),
 		zap.String("state_path", cfg.StatePath),
+		zap.String("run_store_path", cfg.RunStorePath),
 		zap.Bool("ai_enabled", aiClient != nil),
 		zap.String("config_path", configPath),
 	)
@@ -103,16 +115,87 @@ func New(cfg config.Config, configPath string) *Server {
 		aiWorkflowStore: aiWorkflowStore,
 		validationStore: validationenv.NewStore(filepath.Join(cfg.DataDir, "validation_envs")),
 		scriptStore:     scriptStore,
-		engine:          engine.New(defaultRegistry(scriptStore)),
-		runs:            runmanager.NewWithBus(state.NewFileStore(cfg.StatePath), bus),
+		engine:          engine.New(defaultRegistry(scriptStore, bus)),
+		runs:            runmanager.NewWithOptions(runs, bus, runManagerOptions(cfg)),
+		runStore:        runs,
 		bus:             bus,
 		auditLogPath:    filepath.Join(cfg.DataDir, "validation_audit.log"),
 	}
+	srv.engine.RunStore = runmanager.EngineStore(runs)
+	srv.engine.Workflows = srv.loadCalledWorkflow
+	if _, err := srv.engine.ReconcileRunning(context.Background(), runs, "server restarted"); err != nil {
+		logging.L().Warn("reconcile interrupted runs failed", zap.Error(err))
+	}
+	if _, err := srv.runs.InterruptQueued("server restarted before the run left the queue"); err != nil {
+		logging.L().Warn("reconcile queued runs failed", zap.Error(err))
+	}
+	compactCtx, stopCompactor := context.WithCancel(context.Background())
+	srv.stopCompactor = stopCompactor
+	srv.runs.StartCompactor(compactCtx, parseConfigDuration(cfg.RunRetention.CompactInterval))
+	srv.drift = srv.newDriftScanner(cfg)
+	driftCtx, stopDrift := context.WithCancel(context.Background())
+	srv.stopDrift = stopDrift
+	srv.drift.Start(driftCtx, parseConfigDuration(cfg.DriftScan.Interval))
+	srv.schedules = schedule.NewStore(filepath.Join(cfg.DataDir, "schedules"))
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	srv.stopScheduler = stopScheduler
+	srv.newScheduler(cfg).Start(schedulerCtx)
 	srv.initSkills(cfg)
 	srv.routes()
 	return srv
 }
 
+// runStore holds the run history of the server; the run manager works on
+// the whole history, the engine on single runs.
+type runStore interface {
+	state.Store
+	state.RunStateStore
+}
+
+// openRunStore opens the SQLite run store when run_store_path is set and
+// falls back to the JSON state file otherwise.
+func openRunStore(cfg config.Config) runStore {
+	if strings.TrimSpace(cfg.RunStorePath) == "" {
+		return state.NewFileStore(cfg.StatePath)
+	}
+	store, err := state.NewSQLiteStore(cfg.RunStorePath)
+	if err != nil {
+		logging.L().Error("open run store failed, using the state file",
+			zap.String("run_store_path", cfg.RunStorePath),
+			zap.String("state_path", cfg.StatePath),
+			zap.Error(err),
+		)
+		return state.NewFileStore(cfg.StatePath)
+	}
+	return store
+}
+
+func runManagerOptions(cfg config.Config) runmanager.Options {
+	return runmanager.Options{
+		Retention: runmanager.RetentionPolicy{
+			MaxRunsPerWorkflow: cfg.RunRetention.MaxRunsPerWorkflow,
+			MaxAge:             parseConfigDuration(cfg.RunRetention.MaxAge),
+			FailedMaxAge:       parseConfigDuration(cfg.RunRetention.FailedMaxAge),
+		},
+		LogDir:     filepath.Join(cfg.DataDir, "run_logs"),
+		SpillBytes: cfg.RunRetention.OutputSpillBytes,
+		Queue: runmanager.QueueOptions{
+			MaxConcurrent:  cfg.RunQueue.MaxConcurrent,
+			MaxPerWorkflow: cfg.RunQueue.MaxPerWorkflow,
+		},
+	}
+}
+
+// parseConfigDuration returns 0 for empty or invalid values; config
+// validation reports invalid ones at load time.
+func parseConfigDuration(raw string) time.Duration {
+	d, err := time.ParseDuration(strings.TrimSpace(raw))
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
 func (s *Server) ListenAndServe() error {
 	if s.http == nil {
 		s.http = &http.Server{
@@ -126,11 +209,37 @@ func (s *Server) ListenAndServe() error {
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.stopCompactor != nil {
+		s.stopCompactor()
+	}
+	if s.stopDrift != nil {
+		s.stopDrift()
+	}
+	if s.stopScheduler != nil {
+		s.stopScheduler()
+	}
 	if s.http == nil {
-		return nil
+		return s.closeResources()
 	}
 	logging.L().Info("http server shutting down")
-	return s.http.Shutdown(ctx)
+	err := s.http.Shutdown(ctx)
+	if closeErr := s.closeResources(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// closeResources releases the pooled host connections of the engine and the
+// run store.
+func (s *Server) closeResources() error {
+	var errs []error
+	if s.engine != nil {
+		errs = append(errs, s.engine.Close())
+	}
+	if closer, ok := s.runStore.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
 }
 
 func (s *Server) withCORS(next http.Handler) http.Handler {
@@ -23,7 +23,7 @@ func newPartsTestServer(t *testing.T) *Server {
 		envStore:    envstore.New(filepath.Join(dir, "envs")),
 		aiStore:     aistore.New(filepath.Join(dir, "ai_sessions")),
 		scriptStore: scripts,
-		engine:      engine.New(defaultRegistry(scripts)),
+		engine:      engine.New(defaultRegistry(scripts, nil)),
 		aiPrompt:    "test",
 	}
 }
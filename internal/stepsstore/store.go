@@ -1,6 +1,7 @@
 package stepsstore
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -26,8 +27,10 @@ type StepsDoc struct {
 	Description   string            `json:"description,omitempty" yaml:"description,omitempty"`
 	EnvPackages   []string          `json:"env_packages,omitempty" yaml:"env_packages,omitempty"`
 	ValidationEnv string            `json:"validation_env,omitempty" yaml:"validation_env,omitempty"`
+	Params        []workflow.Param  `json:"params,omitempty" yaml:"params,omitempty"`
 	Vars          map[string]any    `json:"vars,omitempty" yaml:"vars,omitempty"`
 	Plan          workflow.Plan     `json:"plan,omitempty" yaml:"plan,omitempty"`
+	OnFailure     string            `json:"on_failure,omitempty" yaml:"on_failure,omitempty"`
 	Steps         []workflow.Step   `json:"steps" yaml:"steps"`
 }
 
@@ -233,7 +236,37 @@ func (s *Store) PutInventory(name string, raw []byte) (InventoryDoc, error) {
 	return doc, nil
 }
 
+// LoadWorkflow returns the stored workflow with its includes expanded.
 func (s *Store) LoadWorkflow(name string) (workflow.Workflow, error) {
+	wf, err := s.LoadStored(name)
+	if err != nil {
+		return workflow.Workflow{}, err
+	}
+	return s.Includer().Expand(wf, name)
+}
+
+// Includer expands include steps against the stored workflows. Stored
+// workflows only include other stored workflows, never files.
+func (s *Store) Includer() workflow.Includer {
+	return workflow.Includer{Load: s.LoadStored, NoFiles: true}
+}
+
+// CheckIncludes expands the includes of wf as if it were stored as name
+// and returns the include issues: file includes and include cycles, also
+// through other stored workflows. Includes of workflows that are not stored
+// yet are not reported.
+func (s *Store) CheckIncludes(name string, wf workflow.Workflow) error {
+	_, err := s.Includer().Expand(wf, name)
+	var verr *workflow.ValidationError
+	if errors.As(err, &verr) {
+		return err
+	}
+	return nil
+}
+
+// LoadStored returns the stored workflow as written, without expanding its
+// includes.
+func (s *Store) LoadStored(name string) (workflow.Workflow, error) {
 	steps, _, err := s.GetSteps(name)
 	if err != nil {
 		return workflow.Workflow{}, err
@@ -256,8 +289,10 @@ func SplitWorkflow(wf workflow.Workflow, name string) (StepsDoc, InventoryDoc) {
 		Description:   wf.Description,
 		EnvPackages:   wf.EnvPackages,
 		ValidationEnv: wf.ValidationEnv,
+		Params:        wf.Params,
 		Vars:          wf.Vars,
 		Plan:          wf.Plan,
+		OnFailure:     wf.OnFailure,
 		Steps:         wf.Steps,
 	}
 	if strings.TrimSpace(stepsDoc.Name) == "" {
@@ -285,14 +320,15 @@ func BuildWorkflow(name string, steps StepsDoc, inv InventoryDoc) workflow.Workf
 		Description: strings.TrimSpace(steps.Description),
 		EnvPackages: steps.EnvPackages,
 		ValidationEnv: steps.ValidationEnv,
+		Params:      steps.Params,
 		Vars:        steps.Vars,
 		Inventory:   inv.Inventory,
-		Plan: workflow.Plan{
-			Mode:     planMode,
-			Strategy: planStrategy,
-		},
+		Plan:        steps.Plan,
+		OnFailure:   steps.OnFailure,
 		Steps: steps.Steps,
 	}
+	wf.Plan.Mode = planMode
+	wf.Plan.Strategy = planStrategy
 	return wf
 }
 
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bops/runner/modules/file"
+	"bops/runner/workflow"
+)
+
+func backupWorkflow(dest string, fail bool) workflow.Workflow {
+	wf := workflow.Workflow{
+		Version: "v0.1",
+		Name:    "conf",
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{"local": {Address: "local"}},
+		},
+		Steps: []workflow.Step{{
+			Name:   "write",
+			Action: "file.copy",
+			Args:   map[string]any{"content": "port=8080\n", "dest": dest},
+		}},
+	}
+	if fail {
+		wf.Steps = append(wf.Steps, workflow.Step{Name: "fail", Action: "cmd.run", Args: map[string]any{"cmd": "false"}})
+	}
+	return wf
+}
+
+func TestRunPrunesBackupsOnceItCannotRollBack(t *testing.T) {
+	dir := t.TempDir()
+	prev := file.BackupRoot
+	file.BackupRoot = filepath.Join(dir, "backups")
+	t.Cleanup(func() { file.BackupRoot = prev })
+	dest := filepath.Join(dir, "app.conf")
+	eng := New(DefaultRegistry(nil))
+
+	for _, tc := range []struct {
+		name     string
+		fail     bool
+		rollback bool
+		kept     bool
+	}{
+		{name: "success"},
+		{name: "failed", fail: true, kept: true},
+		{name: "rolled back", fail: true, rollback: true},
+	} {
+		if err := os.WriteFile(dest, []byte("port=80\n"), 0o644); err != nil {
+			t.Fatalf("write dest: %v", err)
+		}
+		wf := backupWorkflow(dest, tc.fail)
+		if tc.rollback {
+			wf.OnFailure = "rollback"
+		}
+		run, err := eng.ApplyWithRun(context.Background(), wf, RunOptions{})
+		if (err != nil) != tc.fail {
+			t.Fatalf("%s: unexpected apply result %v", tc.name, err)
+		}
+		_, statErr := os.Stat(file.BackupDir(run.RunID))
+		if kept := statErr == nil; kept != tc.kept {
+			t.Fatalf("%s: expected backups kept=%v, got %v", tc.name, tc.kept, statErr)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"bops/runner/modules"
+	"bops/runner/workflow"
+)
+
+// ChildRunFunc applies wf as a child run of parentRunID and returns the
+// child run ID. Callers that keep runs outside the engine run store set one
+// with WithChildRuns so workflow.call children are tracked like their other
+// runs.
+type ChildRunFunc func(ctx context.Context, parentRunID string, wf workflow.Workflow) (string, error)
+
+type childRunsKey struct{}
+
+type callStackKey struct{}
+
+func WithChildRuns(ctx context.Context, run ChildRunFunc) context.Context {
+	if run == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, childRunsKey{}, run)
+}
+
+// LoadWorkflow returns a workflow called by workflow.call through
+// Engine.Workflows.
+func (e *Engine) LoadWorkflow(ref string) (workflow.Workflow, error) {
+	if e.Workflows == nil {
+		return workflow.Workflow{}, fmt.Errorf("workflow %q: no workflow loader configured", ref)
+	}
+	return e.Workflows(ref)
+}
+
+// CheckWorkflow plans a called workflow and returns the steps that would
+// change.
+func (e *Engine) CheckWorkflow(ctx context.Context, wf workflow.Workflow) ([]string, error) {
+	if err := checkCall(ctx, wf.Name); err != nil {
+		return nil, err
+	}
+	plan, err := e.Plan(ctx, wf)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for _, step := range plan.Steps {
+		if len(step.Changes) > 0 {
+			changed = append(changed, step.Name)
+		}
+	}
+	return changed, nil
+}
+
+// RunWorkflow applies a called workflow as a run of its own, linked to the
+// calling run by ParentRunID.
+func (e *Engine) RunWorkflow(ctx context.Context, parentRunID string, wf workflow.Workflow) (string, error) {
+	if err := checkCall(ctx, wf.Name); err != nil {
+		return "", err
+	}
+	if run, ok := ctx.Value(childRunsKey{}).(ChildRunFunc); ok {
+		return run(ctx, parentRunID, wf)
+	}
+	// The child records its own run; the caller's recorder only sees the
+	// workflow.call step.
+	ctx = context.WithValue(ctx, recorderKey{}, nil)
+	run, err := e.ApplyWithRun(ctx, wf, RunOptions{ParentRunID: parentRunID})
+	return run.RunID, err
+}
+
+// withCall marks name as running for recursion checks and makes the engine
+// available to workflow.call.
+func (e *Engine) withCall(ctx context.Context, name string) context.Context {
+	stack, _ := ctx.Value(callStackKey{}).([]string)
+	stack = append(stack[:len(stack):len(stack)], name)
+	ctx = context.WithValue(ctx, callStackKey{}, stack)
+	return modules.WithWorkflowRunner(ctx, e)
+}
+
+func checkCall(ctx context.Context, name string) error {
+	stack, _ := ctx.Value(callStackKey{}).([]string)
+	for i, running := range stack {
+		if running == name {
+			cycle := append(append([]string(nil), stack[i:]...), name)
+			return fmt.Errorf("recursive workflow.call: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	return nil
+}
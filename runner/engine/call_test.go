@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"bops/runner/state"
+	"bops/runner/workflow"
+)
+
+func callWorkflows() (parent, child workflow.Workflow) {
+	parent = workflow.Workflow{
+		Version: "v0.1",
+		Name:    "parent",
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{"local": {Address: "local"}},
+		},
+		Steps: []workflow.Step{{
+			Name:   "call",
+			Action: "workflow.call",
+			Args:   map[string]any{"workflow": "child", "params": map[string]any{"msg": "hi"}},
+		}},
+	}
+	child = workflow.Workflow{
+		Version: "v0.1",
+		Name:    "child",
+		Params:  []workflow.Param{{Name: "msg", Required: true}},
+		Inventory: workflow.Inventory{
+			Groups: map[string]workflow.Group{"web": {Hosts: []string{"web1"}}},
+			Hosts:  map[string]workflow.Host{"web1": {Address: "10.0.0.1"}},
+		},
+		Steps: []workflow.Step{{
+			Name:    "say",
+			Action:  "env.set",
+			Targets: []string{"web"},
+			Args:    map[string]any{"env": map[string]any{"MSG": "${msg}"}},
+		}},
+	}
+	return parent, child
+}
+
+func TestWorkflowCallRunsChildRun(t *testing.T) {
+	parent, child := callWorkflows()
+	store := state.NewInMemoryRunStore()
+	eng := New(DefaultRegistry(nil))
+	eng.RunStore = store
+	eng.Workflows = func(ref string) (workflow.Workflow, error) {
+		return child, nil
+	}
+
+	plan, err := eng.Plan(context.Background(), parent)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if !plan.HasChanges() {
+		t.Fatalf("expected the call to report the child's changes")
+	}
+
+	run, err := eng.ApplyWithRun(context.Background(), parent, RunOptions{})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	runs, err := store.ListRuns(context.Background(), state.ListFilter{})
+	if err != nil {
+		t.Fatalf("list runs: %v", err)
+	}
+	var childRun state.RunState
+	for _, r := range runs {
+		if r.ParentRunID == run.RunID {
+			childRun = r
+		}
+	}
+	if childRun.WorkflowName != "child" || childRun.Status != state.RunStatusSuccess {
+		t.Fatalf("expected a successful child run, got %+v", childRun)
+	}
+	// The child's targets map to the calling host.
+	if len(childRun.Steps) != 1 || childRun.Steps[0].Hosts["local"].Status != state.RunStatusSuccess {
+		t.Fatalf("expected the child step to run on local, got %+v", childRun.Steps)
+	}
+	if childRun.Params["msg"] != "hi" {
+		t.Fatalf("expected the child params to be recorded, got %v", childRun.Params)
+	}
+	if len(run.Steps) != 1 || run.Steps[0].Name != "call" {
+		t.Fatalf("expected only the call step in the parent run, got %+v", run.Steps)
+	}
+}
+
+func TestWorkflowCallRejectsRecursion(t *testing.T) {
+	parent, child := callWorkflows()
+	child.Steps = append(child.Steps, workflow.Step{
+		Name:   "back",
+		Action: "workflow.call",
+		Args:   map[string]any{"workflow": "parent"},
+	})
+	eng := New(DefaultRegistry(nil))
+	eng.Workflows = func(ref string) (workflow.Workflow, error) {
+		if ref == "parent" {
+			return parent, nil
+		}
+		return child, nil
+	}
+	_, err := eng.ApplyWithRun(context.Background(), parent, RunOptions{})
+	if err == nil || !strings.Contains(err.Error(), "recursive workflow.call: parent -> child -> parent") {
+		t.Fatalf("expected a recursion error, got %v", err)
+	}
+}
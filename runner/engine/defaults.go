@@ -2,12 +2,19 @@ package engine
 
 import (
 	"bops/runner/modules"
+	"bops/runner/modules/archive"
 	"bops/runner/modules/cmd"
 	"bops/runner/modules/envset"
+	"bops/runner/modules/facts"
+	"bops/runner/modules/file"
+	"bops/runner/modules/pkg"
 	"bops/runner/modules/script"
+	"bops/runner/modules/service"
 	"bops/runner/modules/shell"
 	"bops/runner/modules/template"
+	"bops/runner/modules/user"
 	"bops/runner/modules/wait"
+	"bops/runner/modules/workflowcall"
 	"bops/runner/scriptstore"
 )
 
@@ -15,14 +22,32 @@ import (
 // Pass nil scriptStore to skip script.shell/script.python modules.
 func DefaultRegistry(scriptStore *scriptstore.Store) *modules.Registry {
 	reg := modules.NewRegistry()
+	_ = reg.Register("archive.unpack", archive.New())
+	_ = reg.Register("backup.prune", file.NewPrune())
 	_ = reg.Register("cmd.run", cmd.New())
 	_ = reg.Register("shell.run", shell.New())
 	_ = reg.Register("env.set", envset.New())
+	_ = reg.Register("facts.gather", facts.New())
+	_ = reg.Register("file.copy", file.NewCopy())
+	_ = reg.Register("file.download", file.NewDownload())
+	_ = reg.Register("file.fetch", file.NewFetch())
+	_ = reg.Register("file.line", file.NewLine())
+	_ = reg.Register("file.state", file.NewState())
+	_ = reg.Register("group.ensure", user.NewGroup())
+	_ = reg.Register("pkg.install", pkg.New())
+	_ = reg.Register("pkg.remove", pkg.New())
+	_ = reg.Register("pkg.repo", pkg.NewRepo())
 	if scriptStore != nil {
 		_ = reg.Register("script.shell", script.New("shell", scriptStore))
 		_ = reg.Register("script.python", script.New("python", scriptStore))
 	}
+	_ = reg.Register("service.ensure", service.New())
+	_ = reg.Register("service.restart", service.New())
+	_ = reg.Register("service.unit", service.NewUnit())
 	_ = reg.Register("template.render", template.New())
+	_ = reg.Register("user.ensure", user.NewUser())
 	_ = reg.Register("wait.event", wait.NewEvent())
+	_ = reg.Register("wait.for", wait.NewFor())
+	_ = reg.Register("workflow.call", workflowcall.New())
 	return reg
 }
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
@@ -9,9 +10,11 @@ import (
 	"sync"
 	"time"
 
+	"bops/internal/host"
 	"bops/runner/executor"
 	"bops/runner/logging"
 	"bops/runner/modules"
+	"bops/runner/modules/file"
 	"bops/runner/planner"
 	"bops/runner/scheduler"
 	"bops/runner/state"
@@ -22,6 +25,7 @@ import (
 type Engine struct {
 	Registry         *modules.Registry
 	Dispatcher       scheduler.Dispatcher
+	Hosts            *host.Pool
 	RunStore         state.RunStateStore
 	Notifier         state.RunStateNotifier
 	NotifyRetry      int
@@ -29,16 +33,34 @@ type Engine struct {
 	Verbose          bool
 	Out              io.Writer
 	fallbackWarnOnce sync.Once
+
+	// Workflows loads the workflows called by workflow.call steps.
+	Workflows func(ref string) (workflow.Workflow, error)
 }
 
 func New(registry *modules.Registry) *Engine {
+	hosts := host.NewPool()
 	return &Engine{
 		Registry:   registry,
-		Dispatcher: scheduler.NewLocalDispatcher(registry),
+		Dispatcher: scheduler.NewLocalDispatcherWithHosts(registry, hosts),
+		Hosts:      hosts,
 		RunStore:   state.NewInMemoryRunStore(),
 	}
 }
 
+// Close releases pooled host connections, including those of a dispatcher
+// that keeps its own pool.
+func (e *Engine) Close() error {
+	var errs []error
+	if closer, ok := e.Dispatcher.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+	if e.Hosts != nil {
+		errs = append(errs, e.Hosts.Close())
+	}
+	return errors.Join(errs...)
+}
+
 func (e *Engine) Plan(ctx context.Context, wf workflow.Workflow) (planner.Plan, error) {
 	if e.Registry == nil {
 		return planner.Plan{}, fmt.Errorf("registry is nil")
@@ -47,6 +69,7 @@ func (e *Engine) Plan(ctx context.Context, wf workflow.Workflow) (planner.Plan,
 		zap.String("workflow", wf.Name),
 		zap.Int("steps", len(wf.Steps)),
 	)
+	ctx = e.withCall(ctx, wf.Name)
 
 	hosts := wf.Inventory.ResolveHosts()
 	plan := planner.Plan{
@@ -55,30 +78,62 @@ func (e *Engine) Plan(ctx context.Context, wf workflow.Workflow) (planner.Plan,
 		CreatedAt:    time.Now().UTC(),
 	}
 
-	for _, step := range wf.Steps {
-		shouldRun, err := evalWhen(step.When, wf.Vars)
+	steps := planSteps(wf.Steps)
+	if wf.GatherFacts {
+		steps = append([]workflow.Step{{Name: workflow.GatherFactsStep, Action: "facts.gather"}}, steps...)
+	}
+	// facts gathered by facts.gather checks, so later steps can use them in
+	// when and args like they do during apply.
+	facts := map[string]map[string]any{}
+	hostVars := func(target workflow.HostSpec, stepVars map[string]any) map[string]any {
+		vars := mergeVars(target.Vars, stepVars)
+		if hostFacts, ok := facts[target.Name]; ok {
+			vars = mergeVars(vars, map[string]any{"facts": hostFacts})
+		}
+		return vars
+	}
+
+	for _, step := range steps {
+		stepVars := wf.Vars
+		if len(step.Vars) > 0 {
+			stepVars = mergeVars(wf.Vars, step.Vars)
+		}
+		targets, err := resolveTargets(step, hosts, wf.Inventory)
 		if err != nil {
-			logging.L().Debug("engine plan eval when failed",
+			if shouldRun, whenErr := evalWhen(step.When, stepVars); whenErr == nil && !shouldRun {
+				continue
+			}
+			logging.L().Debug("engine plan resolve targets failed",
 				zap.String("step", step.Name),
 				zap.Error(err),
 			)
 			return planner.Plan{}, err
 		}
-		if !shouldRun {
+		selected := make([]workflow.HostSpec, 0, len(targets))
+		for _, target := range targets {
+			shouldRun, err := evalWhen(step.When, hostVars(target, stepVars))
+			if err != nil {
+				logging.L().Debug("engine plan eval when failed",
+					zap.String("step", step.Name),
+					zap.String("host", target.Name),
+					zap.Error(err),
+				)
+				return planner.Plan{}, err
+			}
+			if shouldRun {
+				selected = append(selected, target)
+			}
+		}
+		targets = selected
+		if len(targets) == 0 {
 			continue
 		}
 
-		targets, err := resolveTargets(step, hosts, wf.Inventory)
+		loopItems, err := workflow.LoopItems(step, stepVars)
 		if err != nil {
-			logging.L().Debug("engine plan resolve targets failed",
-				zap.String("step", step.Name),
-				zap.Error(err),
-			)
 			return planner.Plan{}, err
 		}
-
-		loopItems := step.Loop
-		if len(loopItems) == 0 {
+		if loopItems == nil {
 			loopItems = []any{nil}
 		}
 
@@ -86,6 +141,8 @@ func (e *Engine) Plan(ctx context.Context, wf workflow.Workflow) (planner.Plan,
 			Name:    step.Name,
 			Action:  step.Action,
 			Targets: targetNames(targets),
+			Parent:  step.Parent,
+			Section: step.Section,
 		}
 
 		for _, item := range loopItems {
@@ -95,16 +152,27 @@ func (e *Engine) Plan(ctx context.Context, wf workflow.Workflow) (planner.Plan,
 					return planner.Plan{}, fmt.Errorf("module %q not registered", step.Action)
 				}
 
-				vars := mergeVars(target.Vars, wf.Vars)
+				vars := hostVars(target, stepVars)
 				if item != nil {
 					vars = mergeVars(vars, map[string]any{"item": item})
 				}
 
-				res, err := module.Check(ctx, modules.Request{
-					Step: step,
+				hostStep := step
+				hostStep.Args = workflow.RenderArgs(step.Args, vars)
+				req := modules.Request{
+					Step: hostStep,
 					Host: target,
 					Vars: vars,
-				})
+				}
+				if e.Hosts != nil {
+					adapter, err := e.Hosts.Adapter(ctx, target)
+					if err != nil {
+						return planner.Plan{}, err
+					}
+					req.Adapter = adapter
+				}
+
+				res, err := module.Check(ctx, req)
 				if err != nil {
 					logging.L().Debug("engine plan module check failed",
 						zap.String("step", step.Name),
@@ -113,6 +181,9 @@ func (e *Engine) Plan(ctx context.Context, wf workflow.Workflow) (planner.Plan,
 					)
 					return planner.Plan{}, err
 				}
+				if hostFacts, ok := res.Output["facts"].(map[string]any); ok && step.Action == "facts.gather" {
+					facts[target.Name] = hostFacts
+				}
 				if res.Changed {
 					stepPlan.Changes = append(stepPlan.Changes, planner.ResourceChange{
 						ResourceID: fmt.Sprintf("%s:%s", step.Name, target.Name),
@@ -132,6 +203,22 @@ func (e *Engine) Plan(ctx context.Context, wf workflow.Workflow) (planner.Plan,
 	return plan, nil
 }
 
+// planSteps flattens block steps into the steps that run when nothing
+// fails, their block and always steps. Rescue steps only run after a
+// failure and are not planned.
+func planSteps(steps []workflow.Step) []workflow.Step {
+	out := make([]workflow.Step, 0, len(steps))
+	for _, step := range steps {
+		if !step.IsBlock() {
+			out = append(out, step)
+			continue
+		}
+		out = append(out, planSteps(step.SectionSteps(workflow.SectionBlock))...)
+		out = append(out, planSteps(step.SectionSteps(workflow.SectionAlways))...)
+	}
+	return out
+}
+
 func (e *Engine) Apply(ctx context.Context, wf workflow.Workflow) error {
 	_, err := e.ApplyWithRun(ctx, wf, RunOptions{})
 	return err
@@ -142,6 +229,28 @@ func (e *Engine) ApplyWithRun(ctx context.Context, wf workflow.Workflow, opts Ru
 		zap.String("workflow", wf.Name),
 		zap.Int("steps", len(wf.Steps)),
 	)
+	store, opts := e.runOptions(opts)
+
+	tracker, err := newRunTracker(wf, RunOptions{
+		RunID:       opts.RunID,
+		Store:       store,
+		Notifier:    opts.Notifier,
+		NotifyRetry: opts.NotifyRetry,
+		NotifyDelay: opts.NotifyDelay,
+		ParentRunID: opts.ParentRunID,
+	}, store)
+	if err != nil {
+		return state.RunState{}, err
+	}
+	if err := tracker.Start(ctx); err != nil {
+		return state.RunState{}, err
+	}
+	return e.execute(ctx, wf, tracker, opts.Resume)
+}
+
+// runOptions resolves the run state store and notification settings, falling
+// back to the engine defaults.
+func (e *Engine) runOptions(opts RunOptions) (state.RunStateStore, RunOptions) {
 	store := opts.Store
 	if store == nil {
 		store = e.RunStore
@@ -154,9 +263,8 @@ func (e *Engine) ApplyWithRun(ctx context.Context, wf workflow.Workflow, opts Ru
 			logging.L().Warn("run state store is in-memory only (non-durable); inject a durable RunStateStore for production")
 		})
 	}
-	notifier := opts.Notifier
-	if notifier == nil {
-		notifier = e.Notifier
+	if opts.Notifier == nil {
+		opts.Notifier = e.Notifier
 	}
 	if opts.NotifyRetry == 0 {
 		opts.NotifyRetry = e.NotifyRetry
@@ -168,21 +276,11 @@ func (e *Engine) ApplyWithRun(ctx context.Context, wf workflow.Workflow, opts Ru
 			opts.NotifyDelay = 300 * time.Millisecond
 		}
 	}
+	return store, opts
+}
 
-	tracker, err := newRunTracker(wf, RunOptions{
-		RunID:       opts.RunID,
-		Store:       store,
-		Notifier:    notifier,
-		NotifyRetry: opts.NotifyRetry,
-		NotifyDelay: opts.NotifyDelay,
-	}, store)
-	if err != nil {
-		return state.RunState{}, err
-	}
-	if err := tracker.Start(ctx); err != nil {
-		return state.RunState{}, err
-	}
-
+func (e *Engine) execute(ctx context.Context, wf workflow.Workflow, tracker *runTracker, resume *state.RunState) (state.RunState, error) {
+	ctx = e.withCall(ctx, wf.Name)
 	baseRecorder := recorderFromContext(ctx)
 	recorder := MultiRecorder(baseRecorder, tracker)
 	env := envFromContext(ctx)
@@ -198,7 +296,11 @@ func (e *Engine) ApplyWithRun(ctx context.Context, wf workflow.Workflow, opts Ru
 		Runner:   runner,
 		Observer: recorder,
 	}
-	err = exec.Run(ctx, wf)
+	if resume != nil {
+		exec.Resume = resumeFromRun(*resume)
+		runner.restoreEnv(wf, *resume)
+	}
+	err := exec.Run(ctx, wf)
 	if err != nil {
 		status := state.RunStatusFailed
 		if ctx.Err() != nil {
@@ -261,6 +363,8 @@ type dispatchRunner struct {
 	mu         sync.Mutex
 	env        map[string]string
 	runID      string
+	// backups are the hosts where steps of the run left rollback backups.
+	backups map[string]workflow.HostSpec
 }
 
 func (r *dispatchRunner) Run(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any) (executor.RunResult, error) {
@@ -274,10 +378,7 @@ func (r *dispatchRunner) Run(ctx context.Context, step workflow.Step, host workf
 		zap.String("host", host.Name),
 	)
 	taskVars := r.injectEnv(vars)
-	taskID := fmt.Sprintf("task-%s-%s-%d", step.Name, host.Name, time.Now().UTC().UnixNano())
-	if strings.TrimSpace(r.runID) != "" {
-		taskID = fmt.Sprintf("%s-%s", r.runID, taskID)
-	}
+	taskID := r.taskID("task", step, host)
 	result, err := r.dispatcher.Dispatch(ctx, scheduler.Task{
 		ID:    taskID,
 		RunID: r.runID,
@@ -285,6 +386,7 @@ func (r *dispatchRunner) Run(ctx context.Context, step workflow.Step, host workf
 		Host:  host,
 		Vars:  taskVars,
 	})
+	result.Batch = executor.BatchFromContext(ctx)
 	if r.verbose {
 		r.printResult(step, host, result)
 	}
@@ -317,9 +419,109 @@ func (r *dispatchRunner) Run(ctx context.Context, step workflow.Step, host workf
 		zap.String("step", step.Name),
 		zap.String("host", host.Name),
 	)
+	if keepsBackup(r.runID, result.Output) {
+		r.mu.Lock()
+		if r.backups == nil {
+			r.backups = map[string]workflow.HostSpec{}
+		}
+		r.backups[host.Name] = host
+		r.mu.Unlock()
+	}
+	return executor.RunResult{Output: result.Output}, nil
+}
+
+// keepsBackup reports whether a step output records a rollback backup
+// taken in the run's backup directory on the host, as the file modules,
+// template.render and user.ensure do. Backups asked for with args.backup
+// live next to the file and are kept.
+func keepsBackup(runID string, output map[string]any) bool {
+	prev, _ := output["previous"].(map[string]any)
+	dir := file.BackupDir(runID) + "/"
+	for _, value := range prev {
+		if path, ok := value.(string); ok && strings.HasPrefix(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Finish removes the rollback backups the run left on its hosts. Failures
+// are only logged: the run itself is already done.
+func (r *dispatchRunner) Finish(ctx context.Context) {
+	r.mu.Lock()
+	hosts := make([]workflow.HostSpec, 0, len(r.backups))
+	for _, host := range r.backups {
+		hosts = append(hosts, host)
+	}
+	r.backups = nil
+	r.mu.Unlock()
+	if r.dispatcher == nil || r.runID == "" {
+		return
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	step := workflow.Step{Name: "prune-backups", Action: "backup.prune"}
+	for _, host := range hosts {
+		result, err := r.dispatcher.Dispatch(ctx, scheduler.Task{
+			ID:    r.taskID("prune", step, host),
+			RunID: r.runID,
+			Step:  step,
+			Host:  host,
+		})
+		if err == nil && result.Status != "success" {
+			err = errors.New(result.Error)
+		}
+		if err != nil {
+			logging.L().Warn("prune rollback backups failed",
+				zap.String("run_id", r.runID),
+				zap.String("host", host.Name),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (r *dispatchRunner) Rollback(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any, output map[string]any) (executor.RunResult, error) {
+	if r.dispatcher == nil {
+		return executor.RunResult{}, fmt.Errorf("dispatcher is nil")
+	}
+	logging.L().Debug("dispatch rollback",
+		zap.String("run_id", r.runID),
+		zap.String("step", step.Name),
+		zap.String("action", step.Action),
+		zap.String("host", host.Name),
+	)
+	result, err := r.dispatcher.Dispatch(ctx, scheduler.Task{
+		ID:          r.taskID("rollback", step, host),
+		RunID:       r.runID,
+		Step:        step,
+		Host:        host,
+		Vars:        r.injectEnv(vars),
+		Rollback:    true,
+		ApplyOutput: output,
+	})
+	if r.verbose {
+		r.printResult(step, host, result)
+	}
+	if r.recorder != nil {
+		r.recorder.HostResult(step, host, result)
+	}
+	if err != nil {
+		return executor.RunResult{Output: result.Output}, err
+	}
+	if result.Status != "success" && result.Status != "skipped" {
+		return executor.RunResult{Output: result.Output}, fmt.Errorf("rollback failed: %s", result.Error)
+	}
 	return executor.RunResult{Output: result.Output}, nil
 }
 
+func (r *dispatchRunner) taskID(kind string, step workflow.Step, host workflow.HostSpec) string {
+	taskID := fmt.Sprintf("%s-%s-%s-%d", kind, step.Name, host.Name, time.Now().UTC().UnixNano())
+	if strings.TrimSpace(r.runID) != "" {
+		taskID = fmt.Sprintf("%s-%s", r.runID, taskID)
+	}
+	return taskID
+}
+
 func (r *dispatchRunner) injectEnv(vars map[string]any) map[string]any {
 	if len(r.env) == 0 {
 		return vars
@@ -389,7 +591,11 @@ func (r *dispatchRunner) printResult(step workflow.Step, host workflow.HostSpec,
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	fmt.Fprintf(r.out, "step=%s host=%s status=%s\n", step.Name, host.Name, result.Status)
+	if result.Batch > 0 {
+		fmt.Fprintf(r.out, "step=%s host=%s batch=%d status=%s\n", step.Name, host.Name, result.Batch, result.Status)
+	} else {
+		fmt.Fprintf(r.out, "step=%s host=%s status=%s\n", step.Name, host.Name, result.Status)
+	}
 
 	if stdout := readOutputString(result.Output, "stdout"); stdout != "" {
 		fmt.Fprintf(r.out, "stdout:\n%s\n", stdout)
@@ -414,14 +620,52 @@ func readOutputString(output map[string]any, key string) string {
 	return raw
 }
 
+// wrapDiff turns a module Check diff into plan entries. {"before","after"}
+// values become current/desired pairs, maps of such changes (e.g. one per
+// package) are flattened to "key.name", and any other value is reported as
+// desired with no known current value.
 func wrapDiff(diff map[string]any) map[string]planner.DiffEntry {
 	out := make(map[string]planner.DiffEntry, len(diff))
 	for k, v := range diff {
+		if entry, ok := diffChange(v); ok {
+			out[k] = entry
+			continue
+		}
+		if nested, ok := v.(map[string]any); ok && len(nested) > 0 {
+			flat := make(map[string]planner.DiffEntry, len(nested))
+			for name, value := range nested {
+				entry, ok := diffChange(value)
+				if !ok {
+					break
+				}
+				flat[k+"."+name] = entry
+			}
+			if len(flat) == len(nested) {
+				for name, entry := range flat {
+					out[name] = entry
+				}
+				continue
+			}
+		}
 		out[k] = planner.DiffEntry{Current: nil, Desired: v}
 	}
 	return out
 }
 
+func diffChange(value any) (planner.DiffEntry, bool) {
+	change, ok := value.(map[string]any)
+	if !ok {
+		return planner.DiffEntry{}, false
+	}
+	before, hasBefore := change["before"]
+	after, hasAfter := change["after"]
+	if !hasBefore || !hasAfter {
+		return planner.DiffEntry{}, false
+	}
+	text, _ := change["diff"].(string)
+	return planner.DiffEntry{Current: before, Desired: after, Text: text}, true
+}
+
 func targetNames(targets []workflow.HostSpec) []string {
 	out := make([]string, 0, len(targets))
 	for _, target := range targets {
@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"errors"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -188,6 +189,149 @@ func TestReconcileRunningMarksInterrupted(t *testing.T) {
 	}
 }
 
+func TestApplyWithRunRecordsBatch(t *testing.T) {
+	eng := New(nil)
+	eng.Dispatcher = fakeDispatcher{}
+
+	wf := simpleWorkflow()
+	wf.Inventory.Hosts["remote"] = workflow.Host{Address: "remote"}
+	wf.Steps[0].Serial = 1
+
+	store := state.NewInMemoryRunStore()
+	runID := "run-apply-batch-0001"
+	if _, err := eng.ApplyWithRun(context.Background(), wf, RunOptions{RunID: runID, Store: store}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	persisted, err := store.GetRun(context.Background(), runID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	hosts := persisted.Steps[0].Hosts
+	if hosts["local"].Batch != 1 || hosts["remote"].Batch != 2 {
+		t.Fatalf("expected hosts recorded in batches 1 and 2, got %+v", hosts)
+	}
+}
+
+type stepDispatcher struct {
+	failStep string
+	tasks    []scheduler.Task
+}
+
+func (d *stepDispatcher) Dispatch(ctx context.Context, task scheduler.Task) (scheduler.Result, error) {
+	d.tasks = append(d.tasks, task)
+	if task.Rollback {
+		if task.Step.Action == "cmd.run" {
+			return scheduler.Result{TaskID: task.ID, Status: "skipped", Error: "cmd.run rollback not supported"}, nil
+		}
+		return scheduler.Result{TaskID: task.ID, Status: "success"}, nil
+	}
+	if task.Step.Name == d.failStep {
+		return scheduler.Result{TaskID: task.ID, Status: "failed", Error: "boom"}, errors.New("boom")
+	}
+	return scheduler.Result{TaskID: task.ID, Status: "success", Output: map[string]any{
+		"step": task.Step.Name,
+		"vars": map[string]any{"LAST": task.Step.Name},
+	}}, nil
+}
+
+func TestApplyWithRunRecordsRollbackPhase(t *testing.T) {
+	dispatcher := &stepDispatcher{failStep: "step-3"}
+	eng := New(nil)
+	eng.Dispatcher = dispatcher
+
+	wf := simpleWorkflow()
+	wf.OnFailure = "rollback"
+	wf.Steps = []workflow.Step{
+		{Name: "step-1", Action: "cmd.run"},
+		{Name: "step-2", Action: "template.render"},
+		{Name: "step-3", Action: "cmd.run"},
+	}
+
+	store := state.NewInMemoryRunStore()
+	runID := "run-apply-rollback-0001"
+	if _, err := eng.ApplyWithRun(context.Background(), wf, RunOptions{RunID: runID, Store: store}); err == nil {
+		t.Fatalf("expected apply to fail")
+	}
+	persisted, err := store.GetRun(context.Background(), runID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if persisted.Status != state.RunStatusFailed {
+		t.Fatalf("expected failed run, got %q", persisted.Status)
+	}
+	if len(persisted.Steps) != 3 {
+		t.Fatalf("expected 3 apply steps, got %d", len(persisted.Steps))
+	}
+	rollback := persisted.Rollback
+	if rollback == nil || persisted.Phase != state.RunPhaseRollback {
+		t.Fatalf("expected rollback phase to be recorded, got %+v", persisted)
+	}
+	if rollback.Status != state.RunStatusSuccess || rollback.FinishedAt.IsZero() {
+		t.Fatalf("expected finished successful rollback, got %+v", rollback)
+	}
+	if len(rollback.Steps) != 2 || rollback.Steps[0].Name != "step-2" || rollback.Steps[1].Name != "step-1" {
+		t.Fatalf("expected rollback of step-2 then step-1, got %+v", rollback.Steps)
+	}
+	if status := rollback.Steps[1].Hosts["local"].Status; status != "skipped" {
+		t.Fatalf("expected unsupported rollback to be skipped, got %q", status)
+	}
+
+	last := dispatcher.tasks[len(dispatcher.tasks)-1]
+	if !last.Rollback || last.ApplyOutput["step"] != "step-1" {
+		t.Fatalf("expected rollback task with apply output, got %+v", last)
+	}
+}
+
+func TestResumeContinuesFromFailedStep(t *testing.T) {
+	dispatcher := &stepDispatcher{failStep: "step-2"}
+	eng := New(nil)
+	eng.Dispatcher = dispatcher
+
+	wf := simpleWorkflow()
+	wf.Steps = []workflow.Step{
+		{Name: "step-1", Action: "cmd.run"},
+		{Name: "step-2", Action: "cmd.run"},
+	}
+
+	store := state.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	runID := "run-resume-0001"
+	if _, err := eng.ApplyWithRun(context.Background(), wf, RunOptions{RunID: runID, Store: store}); err == nil {
+		t.Fatalf("expected apply to fail")
+	}
+	persisted, err := store.GetRun(context.Background(), runID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if persisted.Attempt != 1 || persisted.Steps[0].Vars["LAST"] != "step-1" {
+		t.Fatalf("expected first attempt with step-1 vars persisted, got %+v", persisted)
+	}
+
+	dispatcher.failStep = ""
+	dispatcher.tasks = nil
+	snapshot, err := eng.Resume(context.Background(), wf, runID, RunOptions{Store: store})
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	if snapshot.RunID != runID || snapshot.Attempt != 2 || snapshot.Status != state.RunStatusSuccess {
+		t.Fatalf("expected successful attempt 2 of %s, got %s attempt %d %q", runID, snapshot.RunID, snapshot.Attempt, snapshot.Status)
+	}
+	if len(dispatcher.tasks) != 1 || dispatcher.tasks[0].Step.Name != "step-2" {
+		t.Fatalf("expected only step-2 to be dispatched, got %+v", dispatcher.tasks)
+	}
+	if got := dispatcher.tasks[0].Vars["LAST"]; got != "step-1" {
+		t.Fatalf("expected step-2 to see vars restored from step-1, got %v", got)
+	}
+
+	if _, err := eng.Resume(context.Background(), wf, runID, RunOptions{Store: store}); err == nil {
+		t.Fatalf("expected successful run not to be resumable")
+	}
+	other := wf
+	other.Name = "other"
+	if _, err := eng.Resume(context.Background(), other, runID, RunOptions{Store: store}); err == nil {
+		t.Fatalf("expected workflow mismatch to be rejected")
+	}
+}
+
 func simpleWorkflow() workflow.Workflow {
 	return workflow.Workflow{
 		Version: "v0.1",
@@ -205,3 +349,25 @@ func simpleWorkflow() workflow.Workflow {
 		},
 	}
 }
+
+type closingDispatcher struct {
+	fakeDispatcher
+	closed bool
+}
+
+func (d *closingDispatcher) Close() error {
+	d.closed = true
+	return nil
+}
+
+func TestEngineCloseClosesDispatcher(t *testing.T) {
+	eng := New(nil)
+	dispatcher := &closingDispatcher{}
+	eng.Dispatcher = dispatcher
+	if err := eng.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if !dispatcher.closed {
+		t.Fatalf("expected engine close to close the dispatcher")
+	}
+}
@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"bops/runner/planner"
+)
+
+func TestWrapDiffSplitsCurrentAndDesired(t *testing.T) {
+	got := wrapDiff(map[string]any{
+		"dest":    "/etc/app.conf",
+		"mode":    map[string]any{"before": "0644", "after": "0600"},
+		"content": map[string]any{"before": "aaa", "after": "bbb", "diff": "--- x\n+++ x\n"},
+		"packages": map[string]any{
+			"curl": map[string]any{"before": "7.81.0-1", "after": "latest"},
+			"jq":   map[string]any{"before": "", "after": "present"},
+		},
+		"missing": []string{"jq"},
+	})
+	want := map[string]planner.DiffEntry{
+		"dest":          {Desired: "/etc/app.conf"},
+		"mode":          {Current: "0644", Desired: "0600"},
+		"content":       {Current: "aaa", Desired: "bbb", Text: "--- x\n+++ x\n"},
+		"packages.curl": {Current: "7.81.0-1", Desired: "latest"},
+		"packages.jq":   {Current: "", Desired: "present"},
+		"missing":       {Desired: []string{"jq"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected diff:\n got %#v\nwant %#v", got, want)
+	}
+}
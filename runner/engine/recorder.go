@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 
+	"bops/runner/executor"
 	"bops/runner/scheduler"
 	"bops/runner/workflow"
 )
@@ -68,3 +69,35 @@ func (r *multiRecorder) HostResult(step workflow.Step, host workflow.HostSpec, r
 		recorder.HostResult(step, host, result)
 	}
 }
+
+func (r *multiRecorder) StepVars(step workflow.Step, vars, allowed map[string]any) {
+	for _, recorder := range r.recorders {
+		if observer, ok := recorder.(executor.VarsObserver); ok {
+			observer.StepVars(step, vars, allowed)
+		}
+	}
+}
+
+func (r *multiRecorder) HostFacts(host string, facts map[string]any) {
+	for _, recorder := range r.recorders {
+		if observer, ok := recorder.(executor.FactsObserver); ok {
+			observer.HostFacts(host, facts)
+		}
+	}
+}
+
+func (r *multiRecorder) PhaseStart(phase string) {
+	for _, recorder := range r.recorders {
+		if observer, ok := recorder.(executor.PhaseObserver); ok {
+			observer.PhaseStart(phase)
+		}
+	}
+}
+
+func (r *multiRecorder) PhaseFinish(phase, status string) {
+	for _, recorder := range r.recorders {
+		if observer, ok := recorder.(executor.PhaseObserver); ok {
+			observer.PhaseFinish(phase, status)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"bops/runner/executor"
+	"bops/runner/logging"
+	"bops/runner/state"
+	"bops/runner/workflow"
+	"go.uber.org/zap"
+)
+
+// Resume continues a failed, canceled or interrupted run under the same run
+// ID with the next attempt number. Steps that already succeeded are skipped
+// and their exported vars restored; a step that failed part-way only runs on
+// the hosts it has not succeeded on yet.
+func (e *Engine) Resume(ctx context.Context, wf workflow.Workflow, runID string, opts RunOptions) (state.RunState, error) {
+	logging.L().Debug("engine resume start",
+		zap.String("workflow", wf.Name),
+		zap.String("run_id", runID),
+	)
+	store, opts := e.runOptions(opts)
+
+	prev, err := store.GetRun(ctx, strings.TrimSpace(runID))
+	if err != nil {
+		return state.RunState{}, err
+	}
+	if name := strings.TrimSpace(wf.Name); prev.WorkflowName != "" && prev.WorkflowName != name {
+		return state.RunState{}, fmt.Errorf("run %s belongs to workflow %q, not %q", prev.RunID, prev.WorkflowName, name)
+	}
+
+	tracker := resumeRunTracker(prev, opts, store)
+	if err := tracker.Resume(ctx); err != nil {
+		return state.RunState{}, err
+	}
+	return e.execute(ctx, wf, tracker, &prev)
+}
+
+// resumeFromRun collects the steps and hosts that succeeded in run.
+func resumeFromRun(run state.RunState) *executor.Resume {
+	resume := &executor.Resume{
+		Steps: map[string]executor.ResumedStep{},
+		Hosts: map[string]map[string]map[string]any{},
+		Facts: run.Facts,
+	}
+	for _, step := range run.Steps {
+		if strings.EqualFold(step.Status, state.RunStatusSuccess) {
+			resume.Steps[step.Name] = executor.ResumedStep{
+				Vars:        copyMap(step.Vars),
+				AllowedVars: copyMap(step.AllowedVars),
+			}
+		}
+		// Host results are kept for finished steps too: a workflow rolled
+		// out with plan.serial runs a finished step again on later batches.
+		for name, host := range step.Hosts {
+			if !strings.EqualFold(host.Status, state.RunStatusSuccess) {
+				continue
+			}
+			if resume.Hosts[step.Name] == nil {
+				resume.Hosts[step.Name] = map[string]map[string]any{}
+			}
+			resume.Hosts[step.Name][name] = copyMap(host.Output)
+		}
+	}
+	return resume
+}
+
+// restoreEnv replays the env.set steps completed by an earlier attempt so
+// later steps see the same env.
+func (r *dispatchRunner) restoreEnv(wf workflow.Workflow, run state.RunState) {
+	steps := make(map[string]state.StepState, len(run.Steps))
+	for _, step := range run.Steps {
+		steps[step.Name] = step
+	}
+	for _, step := range workflow.FlattenSteps(wf.Steps) {
+		if step.Action != "env.set" {
+			continue
+		}
+		prev, ok := steps[step.Name]
+		if !ok || !strings.EqualFold(prev.Status, state.RunStatusSuccess) {
+			continue
+		}
+		hosts := make([]string, 0, len(prev.Hosts))
+		for name := range prev.Hosts {
+			hosts = append(hosts, name)
+		}
+		sort.Strings(hosts)
+		for _, name := range hosts {
+			r.mergeEnvFromOutput(prev.Hosts[name].Output)
+		}
+	}
+}
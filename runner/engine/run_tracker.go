@@ -20,6 +20,13 @@ type RunOptions struct {
 	Notifier    state.RunStateNotifier
 	NotifyRetry int
 	NotifyDelay time.Duration
+	// Resume is an earlier attempt whose successful steps and hosts are
+	// skipped, with their exported vars restored. Engine.Resume sets it
+	// for runs kept in the run state store; callers that track runs
+	// elsewhere pass it to ApplyWithRun.
+	Resume *state.RunState
+	// ParentRunID links the run to the run whose workflow.call started it.
+	ParentRunID string
 }
 
 type runTracker struct {
@@ -62,7 +69,10 @@ func newRunTracker(wf workflow.Workflow, opts RunOptions, fallbackStore state.Ru
 			RunID:           runID,
 			WorkflowName:    strings.TrimSpace(wf.Name),
 			WorkflowVersion: strings.TrimSpace(wf.Version),
+			ParentRunID:     strings.TrimSpace(opts.ParentRunID),
+			Params:          wf.ParamValues(),
 			Status:          state.RunStatusQueued,
+			Attempt:         1,
 			Version:         1,
 			StartedAt:       now,
 			UpdatedAt:       now,
@@ -72,6 +82,22 @@ func newRunTracker(wf workflow.Workflow, opts RunOptions, fallbackStore state.Ru
 	return tracker, nil
 }
 
+// resumeRunTracker tracks a new attempt of an existing run.
+func resumeRunTracker(prev state.RunState, opts RunOptions, store state.RunStateStore) *runTracker {
+	notifyDelay := opts.NotifyDelay
+	if notifyDelay <= 0 {
+		notifyDelay = 300 * time.Millisecond
+	}
+	return &runTracker{
+		store:       store,
+		notifier:    opts.Notifier,
+		notifyRetry: opts.NotifyRetry,
+		notifyDelay: notifyDelay,
+		run:         state.CloneRunState(prev),
+		started:     true,
+	}
+}
+
 func (t *runTracker) Start(ctx context.Context) error {
 	t.mu.Lock()
 	run := state.CloneRunState(t.run)
@@ -90,6 +116,30 @@ func (t *runTracker) Start(ctx context.Context) error {
 	return nil
 }
 
+// Resume moves the tracked run back to running under the next attempt.
+func (t *runTracker) Resume(ctx context.Context) error {
+	run, err := t.store.ResumeRun(ctx, t.RunID())
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.run = state.CloneRunState(run)
+	t.mu.Unlock()
+
+	logging.L().Info("run resumed",
+		zap.String("run_id", run.RunID),
+		zap.Int("attempt", run.Attempt),
+	)
+	t.notifyAsync(state.RunStateCallback{
+		RunID:        run.RunID,
+		WorkflowName: run.WorkflowName,
+		Status:       run.Status,
+		Timestamp:    run.UpdatedAt,
+		Version:      run.Version,
+	})
+	return nil
+}
+
 func (t *runTracker) Finish(ctx context.Context, status, message string, runErr error) error {
 	if strings.TrimSpace(status) == "" {
 		status = state.RunStatusSuccess
@@ -117,6 +167,11 @@ func (t *runTracker) StepStart(step workflow.Step, targets []workflow.HostSpec)
 	t.mu.Lock()
 	now := time.Now().UTC()
 	t.run.UpsertStepStart(step.Name, now)
+	if step.Parent != "" {
+		stepState := t.run.EnsureStep(step.Name)
+		stepState.Parent = step.Parent
+		stepState.Section = step.Section
+	}
 	t.run.UpdatedAt = now
 	t.run.Version++
 	run := state.CloneRunState(t.run)
@@ -172,6 +227,88 @@ func (t *runTracker) StepFinish(step workflow.Step, status string) {
 	})
 }
 
+func (t *runTracker) StepVars(step workflow.Step, vars, allowed map[string]any) {
+	t.mu.Lock()
+	stepState := t.run.EnsureStep(step.Name)
+	stepState.Vars = copyMap(vars)
+	stepState.AllowedVars = copyMap(allowed)
+	t.run.UpdatedAt = time.Now().UTC()
+	t.run.Version++
+	run := state.CloneRunState(t.run)
+	t.mu.Unlock()
+
+	if err := t.store.UpdateRun(context.Background(), run); err != nil {
+		logging.L().Warn("run tracker persist step vars failed",
+			zap.String("run_id", run.RunID),
+			zap.String("step", step.Name),
+			zap.Error(err),
+		)
+	}
+}
+
+func (t *runTracker) HostFacts(host string, facts map[string]any) {
+	t.mu.Lock()
+	if t.run.Facts == nil {
+		t.run.Facts = map[string]map[string]any{}
+	}
+	t.run.Facts[host] = facts
+	t.run.UpdatedAt = time.Now().UTC()
+	t.run.Version++
+	run := state.CloneRunState(t.run)
+	t.mu.Unlock()
+
+	if err := t.store.UpdateRun(context.Background(), run); err != nil {
+		logging.L().Warn("run tracker persist host facts failed",
+			zap.String("run_id", run.RunID),
+			zap.String("host", host),
+			zap.Error(err),
+		)
+	}
+}
+
+func (t *runTracker) PhaseStart(phase string) {
+	t.mu.Lock()
+	now := time.Now().UTC()
+	t.run.BeginPhase(phase, now)
+	t.run.UpdatedAt = now
+	t.run.Version++
+	run := state.CloneRunState(t.run)
+	t.mu.Unlock()
+
+	t.persistPhase(run, phase, now)
+}
+
+func (t *runTracker) PhaseFinish(phase, status string) {
+	t.mu.Lock()
+	now := time.Now().UTC()
+	t.run.FinishPhase(phase, strings.ToLower(strings.TrimSpace(status)), "", now)
+	t.run.UpdatedAt = now
+	t.run.Version++
+	run := state.CloneRunState(t.run)
+	t.mu.Unlock()
+
+	t.persistPhase(run, phase, now)
+}
+
+func (t *runTracker) persistPhase(run state.RunState, phase string, now time.Time) {
+	if err := t.store.UpdateRun(context.Background(), run); err != nil {
+		logging.L().Warn("run tracker persist phase failed",
+			zap.String("run_id", run.RunID),
+			zap.String("phase", phase),
+			zap.Error(err),
+		)
+	}
+
+	t.notifyAsync(state.RunStateCallback{
+		RunID:        run.RunID,
+		WorkflowName: run.WorkflowName,
+		Status:       run.Status,
+		Phase:        phase,
+		Timestamp:    now,
+		Version:      run.Version,
+	})
+}
+
 func (t *runTracker) HostResult(step workflow.Step, host workflow.HostSpec, result scheduler.Result) {
 	t.mu.Lock()
 	now := time.Now().UTC()
@@ -181,6 +318,7 @@ func (t *runTracker) HostResult(step workflow.Step, host workflow.HostSpec, resu
 		Message:   strings.TrimSpace(result.Error),
 		Output:    copyMap(result.Output),
 		StartedAt: now,
+		Batch:     result.Batch,
 	}
 	if strings.TrimSpace(result.Status) != state.RunStatusRunning {
 		hostResult.FinishedAt = now
@@ -150,6 +150,7 @@ func main() {
 	}
 
 	eng := engine.New(modules.NewRegistry())
+	defer eng.Close()
 	dispatcher := scheduler.NewAgentDispatcherWithToken("", *token)
 	dispatcher.Heartbeat = true
 	dispatcher.RetryMax = 2
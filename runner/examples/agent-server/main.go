@@ -164,7 +164,7 @@ func main() {
 		switch strings.ToLower(strings.TrimSpace(status)) {
 		case state.RunStatusRunning:
 			return state.RunStatusRunning
-		case state.RunStatusSuccess:
+		case state.RunStatusSuccess, "skipped":
 			return state.RunStatusSuccess
 		case state.RunStatusFailed:
 			return state.RunStatusFailed
@@ -344,16 +344,26 @@ func main() {
 		doneCh := make(chan scheduler.Result, 1)
 		go func() {
 			defer cancel()
-			res, err := module.Apply(runCtx, modules.Request{
+			moduleReq := modules.Request{
 				Step:   req.Task.Step,
 				Host:   req.Task.Host,
 				Vars:   req.Task.Vars,
 				Stdout: entry.Stdout,
 				Stderr: entry.Stderr,
-			})
+			}
+			var res modules.Result
+			var err error
+			if req.Task.Rollback {
+				moduleReq.ApplyOutput = req.Task.ApplyOutput
+				res, err = module.Rollback(runCtx, moduleReq)
+			} else {
+				res, err = module.Apply(runCtx, moduleReq)
+			}
 
 			result := scheduler.Result{TaskID: req.Task.ID, Status: "success", Output: res.Output}
-			if err != nil {
+			if req.Task.Rollback && runCtx.Err() == nil {
+				result, _ = scheduler.RollbackResult(req.Task.ID, res, err)
+			} else if err != nil {
 				if runCtx.Err() != nil {
 					result.Status = "canceled"
 					result.Error = "task canceled"
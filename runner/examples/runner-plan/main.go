@@ -31,6 +31,7 @@ func main() {
 	store := scriptstore.New("./scripts")
 	reg := engine.DefaultRegistry(store)
 	eng := engine.New(reg)
+	defer eng.Close()
 
 	plan, err := eng.Plan(context.Background(), wf)
 	if err != nil {
@@ -30,6 +30,7 @@ func main() {
 	store := scriptstore.New("./scripts")
 	reg := engine.DefaultRegistry(store)
 	eng := engine.New(reg)
+	defer eng.Close()
 
 	if err := eng.Apply(context.Background(), wf); err != nil {
 		fmt.Fprintf(os.Stderr, "apply workflow: %v\n", err)
@@ -183,6 +183,7 @@ func main() {
 			defer close(ch)
 			reg := engine.DefaultRegistry(nil)
 			eng := engine.New(reg)
+			defer eng.Close()
 			eng.RunStore = runStore
 
 			mode := strings.ToLower(strings.TrimSpace(req.Mode))
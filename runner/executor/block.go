@@ -0,0 +1,395 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"bops/runner/logging"
+	"bops/runner/workflow"
+	"go.uber.org/zap"
+)
+
+// runBlock runs a block step per host: its block steps in order, its rescue
+// steps on the hosts one of them failed on, then its always steps on every
+// host that ran the block. A host that fails leaves the remaining block
+// steps while the other hosts go on. The block fails on a host when a block
+// step fails there and there is no rescue, or when a rescue or always step
+// fails there. Rescue steps see the failure of their host as block_error and
+// block_failed_step. With serial the whole block runs batch by batch.
+func (e *Executor) runBlock(ctx context.Context, run *runContext, block workflow.Step, runtimeVars, allowedVars map[string]any) (stepResult, error) {
+	logging.L().Debug("executor block start", zap.String("step", block.Name))
+	if e.Observer != nil {
+		e.Observer.StepStart(block, nil)
+	}
+
+	hosts := e.blockHosts(ctx, run, block, runtimeVars)
+	batches := [][]workflow.HostSpec{nil}
+	var pause time.Duration
+	if block.Serial != nil {
+		var err error
+		batches, err = rolloutPolicy{serial: block.Serial}.batches(hosts)
+		if err == nil {
+			pause, err = parseTimeout(block.BatchPause)
+		}
+		if err != nil {
+			if e.Observer != nil {
+				e.Observer.StepFinish(block, "failed")
+			}
+			return stepResult{}, err
+		}
+	}
+
+	res := stepResult{vars: runtimeVars, exports: map[string]any{}}
+	allowed := allowedVars
+	var failures []blockFailure
+	for i, batch := range batches {
+		if i > 0 && pause > 0 {
+			logging.L().Debug("executor block batch pause", zap.String("step", block.Name), zap.Duration("pause", pause))
+			select {
+			case <-ctx.Done():
+				if e.Observer != nil {
+					e.Observer.StepFinish(block, "failed")
+				}
+				return stepResult{}, ctx.Err()
+			case <-time.After(pause):
+			}
+		}
+		batchCtx := ctx
+		fallback := hosts
+		if batch != nil {
+			logging.L().Debug("executor block batch start",
+				zap.String("step", block.Name),
+				zap.Int("batch", i+1),
+				zap.Int("batches", len(batches)),
+				zap.Int("hosts", len(batch)),
+			)
+			batchCtx = withBatch(ctx, i+1)
+			fallback = batch
+		}
+		failures = append(failures, e.runBlockHosts(batchCtx, run, block, batch, fallback, &res, &allowed)...)
+	}
+
+	if len(failures) > 0 {
+		err := failures[0].err
+		logging.L().Debug("executor block failed",
+			zap.String("step", block.Name),
+			zap.Strings("hosts", failedHostNames(failures)),
+			zap.Error(err),
+		)
+		if e.Observer != nil {
+			e.Observer.StepFinish(block, "failed")
+		}
+		if block.ContinueOnError {
+			return res, nil
+		}
+		if parent := blockScopeFrom(ctx); parent != nil {
+			// The enclosing block rescues the hosts this one failed on.
+			for _, failure := range failures {
+				parent.fail(failure.step, []string{failure.host}, failure.err)
+			}
+			return res, nil
+		}
+		if run.budget != nil {
+			if err := run.budget.fail(failedHostNames(failures), err); err != nil {
+				return stepResult{}, err
+			}
+			return res, nil
+		}
+		return stepResult{}, err
+	}
+	if len(res.exports) > 0 {
+		if observer, ok := e.Observer.(VarsObserver); ok {
+			observer.StepVars(block, res.exports, res.allowed)
+		}
+	}
+	if e.Observer != nil {
+		e.Observer.StepFinish(block, "success")
+	}
+	logging.L().Debug("executor block done", zap.String("step", block.Name))
+	return res, nil
+}
+
+// runBlockHosts runs the sections of block once, on the hosts in only, or
+// on every host its steps target when only is nil. fallback are the hosts a
+// section step fails on when it fails as a whole, before reaching any host.
+// It returns the hosts the block failed on, sorted by name.
+func (e *Executor) runBlockHosts(ctx context.Context, run *runContext, block workflow.Step, only, fallback []workflow.HostSpec, res *stepResult, allowed *map[string]any) []blockFailure {
+	runSection := func(section string, scope *blockScope, hosts []workflow.HostSpec) {
+		sectionCtx := withBlockScope(ctx, scope)
+		for _, step := range block.SectionSteps(section) {
+			out, err := e.runStep(sectionCtx, run, step, res.vars, *allowed)
+			if err != nil {
+				scope.fail(step.Name, scope.remaining(hosts), err)
+				return
+			}
+			res.vars = out.vars
+			if len(out.exports) > 0 {
+				res.exports = mergeVars(res.exports, out.exports)
+			}
+			if len(out.allowed) > 0 {
+				*allowed = mergeVars(*allowed, out.allowed)
+				res.allowed = mergeVars(res.allowed, out.allowed)
+			}
+		}
+	}
+
+	parent := blockScopeFrom(ctx)
+	main := newBlockScope(parent, hostNames(only))
+	runSection(workflow.SectionBlock, main, fallback)
+
+	failed := main.failures()
+	hostErrs := map[string]error{}
+	failedStep := map[string]string{}
+	for _, failure := range failed {
+		hostErrs[failure.host] = failure.err
+		failedStep[failure.host] = failure.step
+	}
+	if len(failed) > 0 && len(block.Rescue) > 0 && ctx.Err() == nil {
+		logging.L().Debug("executor block rescue",
+			zap.String("step", block.Name),
+			zap.Strings("hosts", failedHostNames(failed)),
+		)
+		rescue := newBlockScope(parent, failedHostNames(failed))
+		for _, failure := range failed {
+			rescue.setVars(failure.host, map[string]any{
+				"block_error":       failure.err.Error(),
+				"block_failed_step": failure.step,
+			})
+		}
+		runSection(workflow.SectionRescue, rescue, rescue.onlyHosts(fallback))
+		rescueErrs := map[string]error{}
+		for _, failure := range rescue.failures() {
+			rescueErrs[failure.host] = failure.err
+		}
+		for _, failure := range failed {
+			if rescueErr, ok := rescueErrs[failure.host]; ok {
+				hostErrs[failure.host] = fmt.Errorf("%w (rescue failed: %v)", failure.err, rescueErr)
+			} else {
+				delete(hostErrs, failure.host)
+			}
+		}
+	}
+
+	if len(block.Always) > 0 {
+		always := newBlockScope(parent, main.ranHosts())
+		runSection(workflow.SectionAlways, always, always.onlyHosts(fallback))
+		for _, failure := range always.failures() {
+			if err, ok := hostErrs[failure.host]; ok {
+				hostErrs[failure.host] = fmt.Errorf("%w (always failed: %v)", err, failure.err)
+				continue
+			}
+			hostErrs[failure.host] = failure.err
+			failedStep[failure.host] = failure.step
+		}
+	}
+
+	out := make([]blockFailure, 0, len(hostErrs))
+	for host, err := range hostErrs {
+		out = append(out, blockFailure{host: host, step: failedStep[host], err: err})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].host < out[j].host })
+	return out
+}
+
+// blockHosts returns the hosts the block step itself targets, for its
+// batches and for a section step that fails before reaching any host.
+func (e *Executor) blockHosts(ctx context.Context, run *runContext, block workflow.Step, runtimeVars map[string]any) []workflow.HostSpec {
+	targets, err := resolveTargets(block, run.hosts, run.wf.Inventory)
+	if err != nil {
+		return nil
+	}
+	targets = blockScopeFrom(ctx).targets(run.batchTargets(targets))
+	if selected, err := e.whenTargets(run, block.When, targets, mergeVars(runtimeVars, block.Vars)); err == nil {
+		targets = selected
+	}
+	return targets
+}
+
+// blockFailure is the failure of a block on one host.
+type blockFailure struct {
+	host string
+	step string
+	err  error
+}
+
+func failedHostNames(failures []blockFailure) []string {
+	names := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		names = append(names, failure.host)
+	}
+	return names
+}
+
+func hostNames(hosts []workflow.HostSpec) []string {
+	if hosts == nil {
+		return nil
+	}
+	names := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		names = append(names, host.Name)
+	}
+	return names
+}
+
+// blockScope limits the steps of one section of a block step to some hosts
+// and collects the hosts they ran and failed on, so that a block is rescued
+// and cleaned up per host. Steps find the scope of their section in the
+// context.
+type blockScope struct {
+	parent *blockScope
+	// only, when set, holds the hosts the section runs on.
+	only map[string]struct{}
+
+	mu sync.Mutex
+	// vars are extra vars per host: the failure a rescue step sees.
+	vars   map[string]map[string]any
+	ran    map[string]struct{}
+	failed map[string]blockFailure
+}
+
+func newBlockScope(parent *blockScope, only []string) *blockScope {
+	scope := &blockScope{
+		parent: parent,
+		vars:   map[string]map[string]any{},
+		ran:    map[string]struct{}{},
+		failed: map[string]blockFailure{},
+	}
+	if only != nil {
+		scope.only = map[string]struct{}{}
+		for _, name := range only {
+			scope.only[name] = struct{}{}
+		}
+	}
+	return scope
+}
+
+// targets keeps the targets the section runs on and have not failed in it,
+// with their extra vars. A nil scope keeps every target.
+func (s *blockScope) targets(targets []workflow.HostSpec) []workflow.HostSpec {
+	if s == nil {
+		return targets
+	}
+	targets = s.parent.targets(targets)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]workflow.HostSpec, 0, len(targets))
+	for _, target := range targets {
+		if _, ok := s.failed[target.Name]; ok {
+			continue
+		}
+		if s.only != nil {
+			if _, ok := s.only[target.Name]; !ok {
+				continue
+			}
+		}
+		if extra := s.vars[target.Name]; len(extra) > 0 {
+			target.Vars = mergeVars(target.Vars, extra)
+		}
+		out = append(out, target)
+	}
+	return out
+}
+
+// onlyHosts returns the hosts of fallback the section may still run on.
+func (s *blockScope) onlyHosts(fallback []workflow.HostSpec) []workflow.HostSpec {
+	return s.targets(fallback)
+}
+
+// remaining returns the hosts a section step that failed as a whole fails
+// on: the hosts of the section, the fallback hosts when it has none, and
+// the hosts that ran it, without those that already failed.
+func (s *blockScope) remaining(fallback []workflow.HostSpec) []string {
+	names := map[string]struct{}{}
+	for _, host := range s.targets(fallback) {
+		names[host.Name] = struct{}{}
+	}
+	s.mu.Lock()
+	for name := range s.only {
+		names[name] = struct{}{}
+	}
+	for name := range s.ran {
+		names[name] = struct{}{}
+	}
+	for name := range s.failed {
+		delete(names, name)
+	}
+	s.mu.Unlock()
+	out := make([]string, 0, len(names))
+	for name := range names {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *blockScope) setVars(host string, vars map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vars[host] = vars
+}
+
+// start records that the section ran on hosts, as did the sections
+// enclosing it.
+func (s *blockScope) start(hosts []workflow.HostSpec) {
+	if s == nil {
+		return
+	}
+	s.parent.start(hosts)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, host := range hosts {
+		s.ran[host.Name] = struct{}{}
+	}
+}
+
+// fail records that step failed on hosts with err. The hosts leave the
+// section.
+func (s *blockScope) fail(step string, hosts []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, host := range hosts {
+		if _, ok := s.failed[host]; ok {
+			continue
+		}
+		s.failed[host] = blockFailure{host: host, step: step, err: err}
+		s.ran[host] = struct{}{}
+	}
+}
+
+// failures returns the hosts the section failed on, sorted by name.
+func (s *blockScope) failures() []blockFailure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]blockFailure, 0, len(s.failed))
+	for _, failure := range s.failed {
+		out = append(out, failure)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].host < out[j].host })
+	return out
+}
+
+// ranHosts returns the hosts the section ran on, sorted by name.
+func (s *blockScope) ranHosts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]string, 0, len(s.ran))
+	for name := range s.ran {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+type blockScopeKey struct{}
+
+func withBlockScope(ctx context.Context, scope *blockScope) context.Context {
+	return context.WithValue(ctx, blockScopeKey{}, scope)
+}
+
+func blockScopeFrom(ctx context.Context) *blockScope {
+	scope, _ := ctx.Value(blockScopeKey{}).(*blockScope)
+	return scope
+}
@@ -0,0 +1,241 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bops/runner/workflow"
+)
+
+// blockRunner fails the steps in failOn, by name or as "<step>@<host>".
+type blockRunner struct {
+	mu        sync.Mutex
+	failOn    map[string]bool
+	calls     []string
+	hostCalls []string
+	vars      map[string]map[string]any
+}
+
+func (r *blockRunner) Run(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any) (RunResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, step.Name)
+	r.hostCalls = append(r.hostCalls, step.Name+"@"+host.Name)
+	if r.vars == nil {
+		r.vars = map[string]map[string]any{}
+	}
+	r.vars[step.Name] = vars
+	r.vars[step.Name+"@"+host.Name] = vars
+	if r.failOn[step.Name] || r.failOn[step.Name+"@"+host.Name] {
+		return RunResult{}, errors.New("boom on " + host.Name)
+	}
+	return RunResult{}, nil
+}
+
+// ranOn returns the hosts step ran on, sorted.
+func (r *blockRunner) ranOn(step string) []string {
+	var hosts []string
+	for _, call := range r.hostCalls {
+		if name, host, _ := strings.Cut(call, "@"); name == step {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+type sectionObserver struct {
+	started  []string
+	finished map[string]string
+}
+
+func (o *sectionObserver) StepStart(step workflow.Step, targets []workflow.HostSpec) {
+	o.started = append(o.started, step.Name+"@"+step.Parent+"/"+step.Section)
+}
+
+func (o *sectionObserver) StepFinish(step workflow.Step, status string) {
+	if o.finished == nil {
+		o.finished = map[string]string{}
+	}
+	o.finished[step.Name] = status
+}
+
+func blockWorkflow(rescue bool) workflow.Workflow {
+	block := workflow.Step{
+		Name: "rollout",
+		Vars: map[string]any{"pool": "web"},
+		Block: []workflow.Step{
+			{Name: "drain", Action: "cmd.run"},
+			{Name: "deploy", Action: "cmd.run"},
+			{Name: "check", Action: "cmd.run"},
+		},
+		Always: []workflow.Step{
+			{Name: "undrain", Action: "cmd.run"},
+		},
+	}
+	if rescue {
+		block.Rescue = []workflow.Step{{Name: "revert", Action: "cmd.run"}}
+	}
+	return workflow.Workflow{
+		Name: "demo",
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{"local": {Address: "local"}},
+		},
+		Steps: []workflow.Step{block, {Name: "after", Action: "cmd.run"}},
+	}
+}
+
+func TestBlockRescueAndAlways(t *testing.T) {
+	runner := &blockRunner{failOn: map[string]bool{"rollout/deploy": true}}
+	observer := &sectionObserver{}
+	exec := &Executor{Runner: runner, Observer: observer}
+	if err := exec.Run(context.Background(), blockWorkflow(true)); err != nil {
+		t.Fatalf("expected the rescue to recover the block, got %v", err)
+	}
+	want := []string{"rollout/drain", "rollout/deploy", "rollout/revert", "rollout/undrain", "after"}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Fatalf("unexpected calls %v", runner.calls)
+	}
+	revert := runner.vars["rollout/revert"]
+	if revert["block_failed_step"] != "rollout/deploy" || !strings.Contains(revert["block_error"].(string), "boom") || revert["pool"] != "web" {
+		t.Fatalf("expected the rescue to see the failure and block vars, got %v", revert)
+	}
+	if _, ok := runner.vars["after"]["pool"]; ok {
+		t.Fatalf("block vars must not outlive the block")
+	}
+	if _, ok := runner.vars["after"]["block_error"]; ok {
+		t.Fatalf("block_error must only be visible to rescue steps")
+	}
+	if observer.finished["rollout"] != "success" || observer.finished["rollout/deploy"] != "failed" {
+		t.Fatalf("unexpected step statuses %v", observer.finished)
+	}
+	wantStarted := []string{"rollout@/", "rollout/drain@rollout/block", "rollout/deploy@rollout/block", "rollout/revert@rollout/rescue", "rollout/undrain@rollout/always", "after@/"}
+	if !reflect.DeepEqual(observer.started, wantStarted) {
+		t.Fatalf("unexpected step starts %v", observer.started)
+	}
+}
+
+func TestBlockWithoutRescueFails(t *testing.T) {
+	runner := &blockRunner{failOn: map[string]bool{"rollout/deploy": true, "rollout/undrain": true}}
+	exec := &Executor{Runner: runner}
+	err := exec.Run(context.Background(), blockWorkflow(false))
+	if err == nil || !strings.Contains(err.Error(), "always failed") {
+		t.Fatalf("expected the block and always failures, got %v", err)
+	}
+	want := []string{"rollout/drain", "rollout/deploy", "rollout/undrain"}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Fatalf("expected always to run and later steps to stop, got %v", runner.calls)
+	}
+}
+
+func multiHostBlockWorkflow() workflow.Workflow {
+	wf := blockWorkflow(true)
+	wf.Inventory.Hosts = map[string]workflow.Host{"web1": {Address: "10.0.0.1"}, "web2": {Address: "10.0.0.2"}, "web3": {Address: "10.0.0.3"}}
+	return wf
+}
+
+func TestBlockRescuesOnlyFailedHosts(t *testing.T) {
+	runner := &blockRunner{failOn: map[string]bool{"rollout/deploy@web2": true}}
+	exec := &Executor{Runner: runner}
+	if err := exec.Run(context.Background(), multiHostBlockWorkflow()); err != nil {
+		t.Fatalf("expected the rescue to recover web2, got %v", err)
+	}
+	all := []string{"web1", "web2", "web3"}
+	for step, want := range map[string][]string{
+		"rollout/deploy":  all,
+		"rollout/check":   {"web1", "web3"},
+		"rollout/revert":  {"web2"},
+		"rollout/undrain": all,
+		"after":           all,
+	} {
+		if got := runner.ranOn(step); !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected %s on %v, got %v", step, want, got)
+		}
+	}
+	revert := runner.vars["rollout/revert@web2"]
+	if revert["block_failed_step"] != "rollout/deploy" || !strings.Contains(revert["block_error"].(string), "boom") {
+		t.Fatalf("expected the rescue to see the failure of web2, got %v", revert)
+	}
+	if _, ok := runner.vars["rollout/undrain@web1"]["block_error"]; ok {
+		t.Fatalf("block_error must only be visible to rescue steps")
+	}
+}
+
+func TestBlockFailsOnHostsRescueFailedOn(t *testing.T) {
+	runner := &blockRunner{failOn: map[string]bool{"rollout/deploy@web2": true, "rollout/deploy@web3": true, "rollout/revert@web3": true}}
+	exec := &Executor{Runner: runner}
+	err := exec.Run(context.Background(), multiHostBlockWorkflow())
+	if err == nil || !strings.Contains(err.Error(), "rescue failed") {
+		t.Fatalf("expected the block to fail on web3, got %v", err)
+	}
+	if got := runner.ranOn("rollout/revert"); !reflect.DeepEqual(got, []string{"web2", "web3"}) {
+		t.Fatalf("expected rescue on the failed hosts, got %v", got)
+	}
+	if got := runner.ranOn("rollout/undrain"); !reflect.DeepEqual(got, []string{"web1", "web2", "web3"}) {
+		t.Fatalf("expected always on every host, got %v", got)
+	}
+	if got := runner.ranOn("after"); len(got) != 0 {
+		t.Fatalf("expected the run to stop after the block, got %v", got)
+	}
+}
+
+func TestBlockRescueSeesErrorOfItsHost(t *testing.T) {
+	runner := &blockRunner{failOn: map[string]bool{"rollout/deploy@web2": true, "rollout/deploy@web3": true}}
+	exec := &Executor{Runner: runner}
+	if err := exec.Run(context.Background(), multiHostBlockWorkflow()); err != nil {
+		t.Fatalf("expected the rescue to recover web2 and web3, got %v", err)
+	}
+	for _, host := range []string{"web2", "web3"} {
+		got, _ := runner.vars["rollout/revert@"+host]["block_error"].(string)
+		if !strings.HasSuffix(got, "boom on "+host) {
+			t.Fatalf("expected the rescue on %s to see its own error, got %q", host, got)
+		}
+	}
+}
+
+func TestBlockBatchPauseCanceledFinishesStep(t *testing.T) {
+	wf := multiHostBlockWorkflow()
+	wf.Steps[0].Serial = 1
+	wf.Steps[0].BatchPause = "1h"
+	observer := &sectionObserver{}
+	exec := &Executor{Runner: &blockRunner{}, Observer: observer}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := exec.Run(ctx, wf); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the pause to be canceled, got %v", err)
+	}
+	if observer.finished["rollout"] != "failed" {
+		t.Fatalf("expected the block to finish as failed, got %v", observer.finished)
+	}
+}
+
+func TestBlockSerialRunsWholeBlockPerBatch(t *testing.T) {
+	wf := multiHostBlockWorkflow()
+	wf.Steps[0].Serial = 2
+	runner := &blockRunner{failOn: map[string]bool{"rollout/deploy@web3": true}}
+	exec := &Executor{Runner: runner}
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var order []string
+	for _, call := range runner.hostCalls {
+		if strings.HasPrefix(call, "rollout/undrain") || strings.HasPrefix(call, "rollout/drain") {
+			order = append(order, call)
+		}
+	}
+	first, second := order[:4], order[4:]
+	sort.Strings(first)
+	want := []string{"rollout/drain@web1", "rollout/drain@web2", "rollout/undrain@web1", "rollout/undrain@web2"}
+	if !reflect.DeepEqual(first, want) || !reflect.DeepEqual(second, []string{"rollout/drain@web3", "rollout/undrain@web3"}) {
+		t.Fatalf("expected the block to finish on the first batch before the second, got %v", order)
+	}
+	if got := runner.ranOn("rollout/revert"); !reflect.DeepEqual(got, []string{"web3"}) {
+		t.Fatalf("expected rescue on web3 only, got %v", got)
+	}
+}
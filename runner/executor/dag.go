@@ -0,0 +1,149 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"bops/runner/logging"
+	"bops/runner/workflow"
+	"go.uber.org/zap"
+)
+
+// runDAG runs steps as soon as their depends_on steps have finished, with at
+// most plan.max_parallel steps in flight (unbounded when zero).
+//
+// To stay deterministic regardless of completion order, a step only sees the
+// vars exported by its transitive dependencies, merged in declaration order,
+// and ready steps are started in declaration order. When a step fails no new
+// steps are started; running steps are allowed to finish and the error of the
+// first failed step in declaration order is returned.
+func (e *Executor) runDAG(ctx context.Context, run *runContext) error {
+	steps := run.wf.Steps
+	index := make(map[string]int, len(steps))
+	for i, step := range steps {
+		if _, exists := index[step.Name]; !exists {
+			index[step.Name] = i
+		}
+	}
+
+	deps := make([][]int, len(steps))
+	dependents := make([][]int, len(steps))
+	pending := make([]int, len(steps))
+	for i, step := range steps {
+		seen := map[int]struct{}{}
+		for _, name := range step.DependsOn {
+			j, ok := index[name]
+			if !ok {
+				return fmt.Errorf("step %q depends on unknown step %q", step.Name, name)
+			}
+			if _, dup := seen[j]; dup {
+				continue
+			}
+			seen[j] = struct{}{}
+			deps[i] = append(deps[i], j)
+			dependents[j] = append(dependents[j], i)
+			pending[i]++
+		}
+	}
+
+	limit := run.wf.Plan.MaxParallel
+	if limit <= 0 || limit > len(steps) {
+		limit = len(steps)
+	}
+	logging.L().Debug("executor dag start",
+		zap.String("workflow", run.wf.Name),
+		zap.Int("max_parallel", limit),
+	)
+
+	var ready []int
+	for i := range steps {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	ancestors := make([][]int, len(steps))
+	results := make([]stepResult, len(steps))
+	errs := make([]error, len(steps))
+	done := make(chan int)
+	running := 0
+	finished := 0
+	failed := false
+
+	for {
+		for !failed && running < limit && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			ancestors[i] = collectAncestors(deps[i], ancestors)
+
+			runtimeVars := mergeVars(run.wf.Vars, nil)
+			allowedVars := map[string]any{}
+			for _, a := range ancestors[i] {
+				runtimeVars = mergeExportedVars(runtimeVars, results[a].exports)
+				allowedVars = mergeVars(allowedVars, results[a].allowed)
+			}
+
+			running++
+			go func(i int) {
+				results[i], errs[i] = e.runStep(ctx, run, steps[i], runtimeVars, allowedVars)
+				done <- i
+			}(i)
+		}
+		if running == 0 {
+			break
+		}
+
+		i := <-done
+		running--
+		finished++
+		if errs[i] != nil {
+			failed = true
+			continue
+		}
+		for _, next := range dependents[i] {
+			pending[next]--
+			if pending[next] == 0 {
+				ready = insertSorted(ready, next)
+			}
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	if finished < len(steps) {
+		cycle := workflow.DependencyCycle(steps)
+		return fmt.Errorf("steps depends_on cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// collectAncestors returns the sorted transitive dependencies of a step whose
+// direct dependencies have already been resolved.
+func collectAncestors(deps []int, ancestors [][]int) []int {
+	set := map[int]struct{}{}
+	for _, dep := range deps {
+		set[dep] = struct{}{}
+		for _, a := range ancestors[dep] {
+			set[a] = struct{}{}
+		}
+	}
+	out := make([]int, 0, len(set))
+	for a := range set {
+		out = append(out, a)
+	}
+	sort.Ints(out)
+	return out
+}
+
+func insertSorted(list []int, value int) []int {
+	pos := sort.SearchInts(list, value)
+	list = append(list, 0)
+	copy(list[pos+1:], list[pos:])
+	list[pos] = value
+	return list
+}
@@ -0,0 +1,161 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bops/runner/workflow"
+)
+
+type dagRunner struct {
+	mu       sync.Mutex
+	delay    time.Duration
+	failOn   map[string]bool
+	outputs  map[string]map[string]any
+	vars     map[string]map[string]any
+	order    []string
+	inFlight int
+	peak     int
+}
+
+func (r *dagRunner) Run(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any) (RunResult, error) {
+	r.mu.Lock()
+	r.inFlight++
+	if r.inFlight > r.peak {
+		r.peak = r.inFlight
+	}
+	if r.vars == nil {
+		r.vars = map[string]map[string]any{}
+	}
+	r.vars[step.Name] = vars
+	r.mu.Unlock()
+
+	time.Sleep(r.delay)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight--
+	r.order = append(r.order, step.Name)
+	if r.failOn[step.Name] {
+		return RunResult{}, errors.New("boom " + step.Name)
+	}
+	return RunResult{Output: r.outputs[step.Name]}, nil
+}
+
+func dagWorkflow(maxParallel int, steps ...workflow.Step) workflow.Workflow {
+	return workflow.Workflow{
+		Name: "demo",
+		Plan: workflow.Plan{Strategy: "dag", MaxParallel: maxParallel},
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{
+				"local": {Address: "local"},
+			},
+		},
+		Steps: steps,
+	}
+}
+
+func TestDAGRespectsDependenciesAndMaxParallel(t *testing.T) {
+	runner := &dagRunner{delay: 20 * time.Millisecond}
+	exec := &Executor{Runner: runner}
+	wf := dagWorkflow(2,
+		workflow.Step{Name: "a", Action: "cmd.run"},
+		workflow.Step{Name: "b", Action: "cmd.run"},
+		workflow.Step{Name: "c", Action: "cmd.run"},
+		workflow.Step{Name: "d", Action: "cmd.run", DependsOn: []string{"a", "b", "c"}},
+	)
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if runner.peak != 2 {
+		t.Fatalf("expected peak parallelism 2, got %d", runner.peak)
+	}
+	if len(runner.order) != 4 || runner.order[3] != "d" {
+		t.Fatalf("expected d to run last, got %v", runner.order)
+	}
+}
+
+func TestDAGVarsOnlyFromDependencies(t *testing.T) {
+	runner := &dagRunner{
+		outputs: map[string]map[string]any{
+			"a": {"vars": map[string]any{"TOKEN": "from-a"}},
+			"b": {"vars": map[string]any{"TOKEN": "from-b", "OTHER": "b"}},
+			"c": {"vars": map[string]any{"TOKEN": "from-c"}},
+		},
+	}
+	exec := &Executor{Runner: runner}
+	wf := dagWorkflow(0,
+		workflow.Step{Name: "a", Action: "cmd.run", ExpectVars: []string{"TOKEN"}},
+		workflow.Step{Name: "b", Action: "cmd.run"},
+		workflow.Step{Name: "c", Action: "cmd.run", DependsOn: []string{"a"}},
+		workflow.Step{Name: "d", Action: "cmd.run", DependsOn: []string{"c"}, MustVars: []string{"TOKEN"}},
+	)
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := runner.vars["c"]["TOKEN"]; got != "from-a" {
+		t.Fatalf("expected c to see TOKEN from a, got %v", got)
+	}
+	if _, ok := runner.vars["c"]["OTHER"]; ok {
+		t.Fatalf("expected c not to see vars from unrelated step b")
+	}
+	if got := runner.vars["d"]["TOKEN"]; got != "from-c" {
+		t.Fatalf("expected d to see TOKEN from c, got %v", got)
+	}
+}
+
+func TestDAGFailureStopsDependents(t *testing.T) {
+	runner := &dagRunner{failOn: map[string]bool{"b": true, "c": true}}
+	exec := &Executor{Runner: runner}
+	wf := dagWorkflow(0,
+		workflow.Step{Name: "a", Action: "cmd.run"},
+		workflow.Step{Name: "b", Action: "cmd.run", DependsOn: []string{"a"}},
+		workflow.Step{Name: "c", Action: "cmd.run", DependsOn: []string{"a"}},
+		workflow.Step{Name: "d", Action: "cmd.run", DependsOn: []string{"b"}},
+	)
+
+	err := exec.Run(context.Background(), wf)
+	if err == nil || err.Error() != "boom b" {
+		t.Fatalf("expected error from first failed step b, got %v", err)
+	}
+	for _, name := range runner.order {
+		if name == "d" {
+			t.Fatalf("expected d not to run, got %v", runner.order)
+		}
+	}
+}
+
+func TestDAGContinueOnErrorUnblocksDependents(t *testing.T) {
+	runner := &dagRunner{failOn: map[string]bool{"a": true}}
+	exec := &Executor{Runner: runner}
+	wf := dagWorkflow(0,
+		workflow.Step{Name: "a", Action: "cmd.run", ContinueOnError: true},
+		workflow.Step{Name: "b", Action: "cmd.run", DependsOn: []string{"a"}},
+	)
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(runner.order) != 2 || runner.order[1] != "b" {
+		t.Fatalf("expected b to run after a, got %v", runner.order)
+	}
+}
+
+func TestDAGDetectsCycle(t *testing.T) {
+	exec := &Executor{Runner: &dagRunner{}}
+	wf := dagWorkflow(0,
+		workflow.Step{Name: "a", Action: "cmd.run", DependsOn: []string{"b"}},
+		workflow.Step{Name: "b", Action: "cmd.run", DependsOn: []string{"a"}},
+	)
+
+	err := exec.Run(context.Background(), wf)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -29,6 +30,9 @@ type Observer interface {
 type Executor struct {
 	Runner   HostRunner
 	Observer Observer
+	// Resume, when set, skips the work an earlier attempt of the run
+	// already completed.
+	Resume *Resume
 }
 
 func (e *Executor) Run(ctx context.Context, wf workflow.Workflow) error {
@@ -40,59 +44,208 @@ func (e *Executor) Run(ctx context.Context, wf workflow.Workflow) error {
 		zap.Int("steps", len(wf.Steps)),
 	)
 
-	hosts := wf.Inventory.ResolveHosts()
-	handlers := map[string]workflow.Handler{}
+	// The steps of a workflow called from a block step do not fail the
+	// hosts of that block.
+	ctx = withBlockScope(ctx, nil)
+	run := &runContext{
+		wf:       wf,
+		hosts:    wf.Inventory.ResolveHosts(),
+		handlers: map[string]workflow.Handler{},
+	}
 	for _, handler := range wf.Handlers {
-		handlers[handler.Name] = handler
+		run.handlers[handler.Name] = handler
+	}
+	if e.Resume != nil {
+		for name, facts := range e.Resume.Facts {
+			run.setFacts(name, facts)
+		}
 	}
 
-	runtimeVars := mergeVars(wf.Vars, nil)
-	allowedVars := map[string]any{}
-	for _, step := range wf.Steps {
-		shouldRun, err := evalWhen(step.When, runtimeVars)
-		if err != nil {
-			return err
+	err := e.gatherFacts(ctx, run)
+	if err == nil {
+		steps := e.runSequential
+		if wf.Plan.Strategy == "dag" {
+			steps = e.runDAG
 		}
-		if !shouldRun {
-			continue
+		err = e.runBatches(ctx, run, steps)
+	}
+	if err != nil {
+		if wf.OnFailure == "rollback" {
+			if ctx.Err() != nil {
+				logging.L().Debug("executor rollback skipped, run canceled", zap.String("workflow", wf.Name))
+			} else if rollbackErr := e.rollback(ctx, run); rollbackErr != nil {
+				return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+			} else {
+				e.finish(ctx)
+			}
 		}
+		return err
+	}
 
-		targets, err := resolveTargets(step, hosts, wf.Inventory)
+	e.finish(ctx)
+	logging.L().Debug("executor run done", zap.String("workflow", wf.Name))
+	return nil
+}
+
+func (e *Executor) runSequential(ctx context.Context, run *runContext) error {
+	runtimeVars := mergeVars(run.wf.Vars, nil)
+	allowedVars := map[string]any{}
+	for _, step := range run.wf.Steps {
+		result, err := e.runStep(ctx, run, step, runtimeVars, allowedVars)
 		if err != nil {
 			return err
 		}
+		runtimeVars = result.vars
+		allowedVars = mergeVars(allowedVars, result.allowed)
+	}
+	return nil
+}
+
+type runContext struct {
+	wf       workflow.Workflow
+	hosts    map[string]workflow.HostSpec
+	handlers map[string]workflow.Handler
+	// handlerMu serializes handlers so steps running concurrently under the
+	// dag strategy never run handlers at the same time.
+	handlerMu sync.Mutex
+
+	appliedMu sync.Mutex
+	applied   []appliedStep
+
+	// facts holds the facts gathered per host during this run.
+	factsMu sync.Mutex
+	facts   map[string]map[string]any
+
+	// batch holds the hosts of the current batch when plan.serial rolls
+	// the workflow out batch by batch; budget tracks their failures.
+	batch  map[string]struct{}
+	budget *failBudget
+}
+
+type stepResult struct {
+	// vars are the runtime vars after the step, including its exports.
+	vars    map[string]any
+	exports map[string]any
+	// allowed holds the exports listed in expect_vars, usable by must_vars.
+	allowed map[string]any
+}
+
+// runStep runs step with its own vars overlaid on the runtime vars. The
+// step vars end with the step; its exports carry on.
+func (e *Executor) runStep(ctx context.Context, run *runContext, step workflow.Step, runtimeVars, allowedVars map[string]any) (stepResult, error) {
+	if len(step.Vars) == 0 {
+		return e.runScopedStep(ctx, run, step, runtimeVars, allowedVars)
+	}
+	res, err := e.runScopedStep(ctx, run, step, mergeVars(runtimeVars, step.Vars), allowedVars)
+	if err != nil {
+		return res, err
+	}
+	res.vars = mergeExportedVars(runtimeVars, res.exports)
+	return res, nil
+}
+
+func (e *Executor) runScopedStep(ctx context.Context, run *runContext, step workflow.Step, runtimeVars, allowedVars map[string]any) (stepResult, error) {
+	// A workflow rolled out in batches resumes per host: a step that
+	// finished on earlier batches still has to run on the later ones.
+	if done, ok := e.Resume.step(step.Name); ok && run.budget == nil {
+		logging.L().Debug("executor step resumed", zap.String("step", step.Name))
+		return stepResult{
+			vars:    mergeExportedVars(runtimeVars, done.Vars),
+			exports: done.Vars,
+			allowed: done.AllowedVars,
+		}, nil
+	}
+	if step.IsBlock() {
+		return e.runBlock(ctx, run, step, runtimeVars, allowedVars)
+	}
+
+	targets, err := resolveTargets(step, run.hosts, run.wf.Inventory)
+	if err != nil {
+		// A step skipped by when does not need valid targets.
+		if shouldRun, whenErr := evalWhen(step.When, runtimeVars); whenErr == nil && !shouldRun {
+			return stepResult{vars: runtimeVars}, nil
+		}
+		return stepResult{}, err
+	}
+	targets = blockScopeFrom(ctx).targets(run.batchTargets(targets))
+	if len(targets) == 0 {
+		return stepResult{vars: runtimeVars}, nil
+	}
+	targets, err = e.whenTargets(run, step.When, targets, runtimeVars)
+	if err != nil {
+		return stepResult{}, err
+	}
+	if len(targets) == 0 {
+		return stepResult{vars: runtimeVars}, nil
+	}
+
+	logging.L().Debug("executor step start",
+		zap.String("step", step.Name),
+		zap.String("action", step.Action),
+		zap.Int("targets", len(targets)),
+	)
+	if e.Observer != nil {
+		e.Observer.StepStart(step, targets)
+	}
 
-		logging.L().Debug("executor step start",
+	if err := validateMustVars(step.MustVars, targets, allowedVars); err != nil {
+		logging.L().Debug("executor step missing required vars",
 			zap.String("step", step.Name),
-			zap.String("action", step.Action),
-			zap.Int("targets", len(targets)),
+			zap.Error(err),
 		)
 		if e.Observer != nil {
-			e.Observer.StepStart(step, targets)
+			e.Observer.StepFinish(step, "failed")
 		}
+		return stepResult{}, err
+	}
 
-		if err := validateMustVars(step.MustVars, targets, allowedVars); err != nil {
-			logging.L().Debug("executor step missing required vars",
-				zap.String("step", step.Name),
-				zap.Error(err),
-			)
+	loopItems, err := workflow.LoopItems(step, runtimeVars)
+	if err != nil {
+		if e.Observer != nil {
+			e.Observer.StepFinish(step, "failed")
+		}
+		return stepResult{}, err
+	}
+	if loopItems == nil {
+		loopItems = []any{nil}
+	}
+
+	stepFailed := false
+	stepExports := map[string]any{}
+	runTargets, resumedVars := e.Resume.remainingTargets(step, targets)
+	if len(resumedVars) > 0 {
+		stepExports = mergeVars(stepExports, resumedVars)
+		runtimeVars = mergeExportedVars(runtimeVars, resumedVars)
+	}
+	for _, item := range loopItems {
+		// Hosts that failed an earlier item in a block leave the step.
+		runTargets = blockScopeFrom(ctx).targets(runTargets)
+		stepVars, err := e.runOnTargets(ctx, run, step, runTargets, runtimeVars, item, stepRollout(step, run.wf.Plan, run.budget))
+		if errors.Is(err, errHostsFailed) {
+			stepFailed = true
+			err = nil
+		}
+		if err != nil {
+			logging.L().Debug("executor step failed", zap.String("step", step.Name), zap.Error(err))
+			if step.ContinueOnError {
+				stepFailed = true
+				break
+			}
 			if e.Observer != nil {
 				e.Observer.StepFinish(step, "failed")
 			}
-			return err
+			return stepResult{}, err
 		}
-
-		loopItems := step.Loop
-		if len(loopItems) == 0 {
-			loopItems = []any{nil}
+		if len(stepVars) > 0 {
+			stepExports = mergeVars(stepExports, stepVars)
+			runtimeVars = mergeExportedVars(runtimeVars, stepVars)
 		}
-
-		stepFailed := false
-		stepExports := map[string]any{}
-		for _, item := range loopItems {
-			stepVars, err := e.runOnTargets(ctx, step, targets, runtimeVars, item)
+		if len(step.Notify) > 0 {
+			run.handlerMu.Lock()
+			err := e.runHandlers(ctx, run, step.Notify, blockScopeFrom(ctx).targets(targets), runtimeVars, item)
+			run.handlerMu.Unlock()
 			if err != nil {
-				logging.L().Debug("executor step failed", zap.String("step", step.Name), zap.Error(err))
+				logging.L().Debug("executor handler failed", zap.String("step", step.Name), zap.Error(err))
 				if step.ContinueOnError {
 					stepFailed = true
 					break
@@ -100,113 +253,199 @@ func (e *Executor) Run(ctx context.Context, wf workflow.Workflow) error {
 				if e.Observer != nil {
 					e.Observer.StepFinish(step, "failed")
 				}
-				return err
-			}
-			if len(stepVars) > 0 {
-				stepExports = mergeVars(stepExports, stepVars)
-				runtimeVars = mergeExportedVars(runtimeVars, stepVars)
-			}
-			if len(step.Notify) > 0 {
-				if err := e.runHandlers(ctx, handlers, step.Notify, targets, runtimeVars, item); err != nil {
-					logging.L().Debug("executor handler failed", zap.String("step", step.Name), zap.Error(err))
-					if step.ContinueOnError {
-						stepFailed = true
-						break
-					}
-					if e.Observer != nil {
-						e.Observer.StepFinish(step, "failed")
-					}
-					return err
-				}
+				return stepResult{}, err
 			}
 		}
+	}
 
-		if !stepFailed && len(step.ExpectVars) > 0 {
-			if err := validateExpectedVars(step.ExpectVars, stepExports); err != nil {
-				logging.L().Debug("executor step expected vars missing",
-					zap.String("step", step.Name),
-					zap.Error(err),
-				)
-				if step.ContinueOnError {
-					stepFailed = true
-				} else {
-					if e.Observer != nil {
-						e.Observer.StepFinish(step, "failed")
-					}
-					return err
+	var allowed map[string]any
+	if !stepFailed && len(step.ExpectVars) > 0 {
+		if err := validateExpectedVars(step.ExpectVars, stepExports); err != nil {
+			logging.L().Debug("executor step expected vars missing",
+				zap.String("step", step.Name),
+				zap.Error(err),
+			)
+			if step.ContinueOnError {
+				stepFailed = true
+			} else {
+				if e.Observer != nil {
+					e.Observer.StepFinish(step, "failed")
 				}
-			} else if len(stepExports) > 0 {
-				allowedVars = mergeVars(allowedVars, selectExpectedVars(stepExports, step.ExpectVars))
+				return stepResult{}, err
 			}
+		} else if len(stepExports) > 0 {
+			allowed = selectExpectedVars(stepExports, step.ExpectVars)
 		}
+	}
 
-		if e.Observer != nil {
-			if stepFailed {
-				e.Observer.StepFinish(step, "failed")
-			} else {
-				e.Observer.StepFinish(step, "success")
-			}
+	if !stepFailed && len(stepExports) > 0 {
+		if observer, ok := e.Observer.(VarsObserver); ok {
+			observer.StepVars(step, stepExports, allowed)
 		}
-		logging.L().Debug("executor step done", zap.String("step", step.Name), zap.Bool("failed", stepFailed))
 	}
-
-	logging.L().Debug("executor run done", zap.String("workflow", wf.Name))
-	return nil
+	if e.Observer != nil {
+		if stepFailed {
+			e.Observer.StepFinish(step, "failed")
+		} else {
+			e.Observer.StepFinish(step, "success")
+		}
+	}
+	logging.L().Debug("executor step done", zap.String("step", step.Name), zap.Bool("failed", stepFailed))
+	return stepResult{vars: runtimeVars, exports: stepExports, allowed: allowed}, nil
 }
 
-func (e *Executor) runOnTargets(ctx context.Context, step workflow.Step, targets []workflow.HostSpec, baseVars map[string]any, item any) (map[string]any, error) {
+// errHostsFailed reports that a step inside a block failed on some hosts,
+// which the block step rescues. The step went on with the other hosts.
+var errHostsFailed = errors.New("hosts failed in block")
+
+func (e *Executor) runOnTargets(ctx context.Context, run *runContext, step workflow.Step, targets []workflow.HostSpec, baseVars map[string]any, item any, rollout rolloutPolicy) (map[string]any, error) {
 	timeout, err := parseTimeout(step.Timeout)
 	if err != nil {
 		return nil, err
 	}
+	batches, err := rollout.batches(targets)
+	if err != nil {
+		return nil, err
+	}
+	pause, err := parseTimeout(rollout.pause)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := appliedStep{step: step, baseVars: baseVars, item: item}
+	defer func() {
+		run.recordApplied(applied)
+	}()
 
+	merged := map[string]any{}
+	failedHosts := 0
+	blockFailed := false
+	for i, batch := range batches {
+		batchCtx := ctx
+		if rollout.serial != nil {
+			if i > 0 && pause > 0 {
+				logging.L().Debug("executor batch pause",
+					zap.String("step", step.Name),
+					zap.Duration("pause", pause),
+				)
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(pause):
+				}
+			}
+			logging.L().Debug("executor batch start",
+				zap.String("step", step.Name),
+				zap.Int("batch", i+1),
+				zap.Int("batches", len(batches)),
+				zap.Int("hosts", len(batch)),
+			)
+			batchCtx = withBatch(ctx, i+1)
+		}
+
+		scope := blockScopeFrom(ctx)
+		scope.start(batch)
+		outputs, errs := e.runBatch(batchCtx, run, step, batch, baseVars, item, timeout)
+		toBlock := scope != nil && !step.ContinueOnError
+		var failed []string
+		for j, target := range batch {
+			if errs[j] != nil {
+				failed = append(failed, target.Name)
+				if toBlock {
+					// The block step rescues each host with its own error.
+					scope.fail(step.Name, []string{target.Name}, errs[j])
+				}
+				continue
+			}
+			applied.hosts = append(applied.hosts, appliedHost{host: target, output: outputs[j]})
+			if exported := extractExportedVars(outputs[j]); len(exported) > 0 {
+				merged = mergeVars(merged, exported)
+			}
+		}
+		errs = compactErrors(errs)
+		if len(errs) == 0 {
+			continue
+		}
+		failedHosts += len(errs)
+		if toBlock {
+			// The block step rescues the failed hosts; the others go on.
+			blockFailed = true
+			logging.L().Debug("executor host failures left to the block",
+				zap.String("step", step.Name),
+				zap.Strings("failed", failed),
+			)
+			continue
+		}
+		if rollout.budget != nil {
+			if err := rollout.budget.fail(failed, errs[0]); err != nil {
+				return nil, err
+			}
+			logging.L().Debug("executor host failures tolerated by workflow budget",
+				zap.String("step", step.Name),
+				zap.Strings("failed", failed),
+			)
+			continue
+		}
+		if rollout.maxFailPercentage <= 0 {
+			return nil, errs[0]
+		}
+		if failedHosts*100 > rollout.maxFailPercentage*len(targets) {
+			return nil, fmt.Errorf("%d of %d hosts failed, exceeding max_fail_percentage %d%%: %w", failedHosts, len(targets), rollout.maxFailPercentage, errs[0])
+		}
+		logging.L().Debug("executor batch failures tolerated",
+			zap.String("step", step.Name),
+			zap.Int("failed", failedHosts),
+			zap.Int("targets", len(targets)),
+		)
+	}
+
+	if blockFailed {
+		return merged, errHostsFailed
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, nil
+}
+
+// runBatch runs the step on every host of the batch concurrently and returns
+// the outputs and errors indexed like targets.
+func (e *Executor) runBatch(ctx context.Context, run *runContext, step workflow.Step, targets []workflow.HostSpec, baseVars map[string]any, item any, timeout time.Duration) ([]map[string]any, []error) {
 	var wg sync.WaitGroup
-	errCh := make(chan error, len(targets))
-	outCh := make(chan map[string]any, len(targets))
+	outputs := make([]map[string]any, len(targets))
+	errs := make([]error, len(targets))
 
-	for _, target := range targets {
-		target := target
+	for i, target := range targets {
+		i, target := i, target
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
-			vars := mergeVars(target.Vars, baseVars)
-			if item != nil {
-				vars = mergeVars(vars, map[string]any{"item": item})
-			}
-
-			result, err := runWithRetry(ctx, e.Runner, step, target, vars, timeout)
+			vars := run.hostVars(target, baseVars, item)
+			result, err := runWithRetry(ctx, e.Runner, renderStep(step, vars), target, vars, timeout)
 			if err != nil {
-				errCh <- err
+				errs[i] = err
 				return
 			}
-			if exported := extractExportedVars(result.Output); len(exported) > 0 {
-				outCh <- exported
-			}
+			e.recordFacts(run, step, target, result.Output)
+			outputs[i] = result.Output
 		}()
 	}
-
 	wg.Wait()
-	close(errCh)
-	close(outCh)
+	return outputs, errs
+}
 
-	for err := range errCh {
+func compactErrors(errs []error) []error {
+	var out []error
+	for _, err := range errs {
 		if err != nil {
-			return nil, err
+			out = append(out, err)
 		}
 	}
-
-	merged := map[string]any{}
-	for vars := range outCh {
-		merged = mergeVars(merged, vars)
-	}
-	if len(merged) == 0 {
-		return nil, nil
-	}
-	return merged, nil
+	return out
 }
 
-func (e *Executor) runHandlers(ctx context.Context, handlers map[string]workflow.Handler, notify []string, targets []workflow.HostSpec, baseVars map[string]any, item any) error {
+func (e *Executor) runHandlers(ctx context.Context, run *runContext, notify []string, targets []workflow.HostSpec, baseVars map[string]any, item any) error {
 	seen := map[string]struct{}{}
 	for _, name := range notify {
 		if _, ok := seen[name]; ok {
@@ -214,7 +453,7 @@ func (e *Executor) runHandlers(ctx context.Context, handlers map[string]workflow
 		}
 		seen[name] = struct{}{}
 
-		handler, ok := handlers[name]
+		handler, ok := run.handlers[name]
 		if !ok {
 			return fmt.Errorf("handler %q not found", name)
 		}
@@ -240,7 +479,9 @@ func (e *Executor) runHandlers(ctx context.Context, handlers map[string]workflow
 			Timeout: handler.Timeout,
 		}
 
-		if _, err := e.runOnTargets(ctx, step, targets, baseVars, item); err != nil {
+		// Inside a block the hosts a handler failed on are left to the
+		// block step.
+		if _, err := e.runOnTargets(ctx, run, step, targets, baseVars, item, rolloutPolicy{}); err != nil && !errors.Is(err, errHostsFailed) {
 			return err
 		}
 	}
@@ -282,6 +523,12 @@ func runWithRetry(ctx context.Context, runner HostRunner, step workflow.Step, ho
 	return lastResult, lastErr
 }
 
+// renderStep resolves ${...} placeholders in the step args for one host.
+func renderStep(step workflow.Step, vars map[string]any) workflow.Step {
+	step.Args = workflow.RenderArgs(step.Args, vars)
+	return step
+}
+
 func parseTimeout(raw string) (time.Duration, error) {
 	if strings.TrimSpace(raw) == "" {
 		return 0, nil
@@ -3,6 +3,7 @@ package executor
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
 
 	"bops/runner/workflow"
@@ -241,3 +242,54 @@ func TestMustVarsFromExpectVars(t *testing.T) {
 		t.Fatalf("expected no error, got %v", err)
 	}
 }
+
+type argsRunner struct {
+	mu   sync.Mutex
+	args []map[string]any
+}
+
+func (r *argsRunner) Run(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any) (RunResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.args = append(r.args, step.Args)
+	return RunResult{}, nil
+}
+
+func TestLoopExprRendersArgsPerItem(t *testing.T) {
+	runner := &argsRunner{}
+	exec := &Executor{Runner: runner}
+	wf := workflow.Workflow{
+		Name: "demo",
+		Vars: map[string]any{"PACKAGES": "nginx,curl", "workers": 4},
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{"local": {Address: "local"}},
+		},
+		Steps: []workflow.Step{
+			{
+				Name:     "install",
+				Action:   "cmd.run",
+				LoopExpr: `split(PACKAGES, ",")`,
+				Args: map[string]any{
+					"cmd":     "apt-get install -y ${item | upper} # ${HOME}",
+					"workers": "${workers}",
+				},
+			},
+		},
+	}
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(runner.args) != 2 {
+		t.Fatalf("expected one run per item, got %d", len(runner.args))
+	}
+	if runner.args[0]["cmd"] != "apt-get install -y NGINX # ${HOME}" || runner.args[1]["cmd"] != "apt-get install -y CURL # ${HOME}" {
+		t.Fatalf("expected rendered commands, got %v", runner.args)
+	}
+	if runner.args[0]["workers"] != 4 {
+		t.Fatalf("expected workers to stay an int, got %#v", runner.args[0]["workers"])
+	}
+	if wf.Steps[0].Args["cmd"] != "apt-get install -y ${item | upper} # ${HOME}" {
+		t.Fatalf("expected workflow args to stay unrendered, got %v", wf.Steps[0].Args["cmd"])
+	}
+}
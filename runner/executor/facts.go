@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"context"
+	"strings"
+
+	"bops/runner/workflow"
+)
+
+// FactsObserver is implemented by observers that record the facts gathered
+// for each host.
+type FactsObserver interface {
+	HostFacts(host string, facts map[string]any)
+}
+
+// hostVars builds the vars a step sees on target: host vars, the run vars,
+// the facts gathered for the host and the loop item.
+func (r *runContext) hostVars(target workflow.HostSpec, baseVars map[string]any, item any) map[string]any {
+	vars := mergeVars(target.Vars, baseVars)
+	if facts := r.hostFacts(target.Name); facts != nil {
+		vars = mergeVars(vars, map[string]any{"facts": facts})
+	}
+	if item != nil {
+		vars = mergeVars(vars, map[string]any{"item": item})
+	}
+	return vars
+}
+
+func (r *runContext) hostFacts(host string) map[string]any {
+	r.factsMu.Lock()
+	defer r.factsMu.Unlock()
+	return r.facts[host]
+}
+
+func (r *runContext) setFacts(host string, facts map[string]any) {
+	r.factsMu.Lock()
+	defer r.factsMu.Unlock()
+	if r.facts == nil {
+		r.facts = map[string]map[string]any{}
+	}
+	r.facts[host] = facts
+}
+
+// recordFacts keeps the facts a facts.gather step returned for target.
+func (e *Executor) recordFacts(run *runContext, step workflow.Step, target workflow.HostSpec, output map[string]any) {
+	if step.Action != "facts.gather" {
+		return
+	}
+	facts, ok := output["facts"].(map[string]any)
+	if !ok {
+		return
+	}
+	run.setFacts(target.Name, facts)
+	if observer, ok := e.Observer.(FactsObserver); ok {
+		observer.HostFacts(target.Name, facts)
+	}
+}
+
+// gatherFacts runs facts.gather on every host before the workflow steps when
+// the workflow sets gather_facts.
+func (e *Executor) gatherFacts(ctx context.Context, run *runContext) error {
+	if !run.wf.GatherFacts {
+		return nil
+	}
+	step := workflow.Step{Name: workflow.GatherFactsStep, Action: "facts.gather"}
+	_, err := e.runStep(ctx, run, step, mergeVars(run.wf.Vars, nil), map[string]any{})
+	return err
+}
+
+// whenTargets keeps the targets the step's when expression holds for. The
+// expression sees the same per-host vars as the step itself.
+func (e *Executor) whenTargets(run *runContext, when string, targets []workflow.HostSpec, runtimeVars map[string]any) ([]workflow.HostSpec, error) {
+	if strings.TrimSpace(when) == "" {
+		return targets, nil
+	}
+	selected := make([]workflow.HostSpec, 0, len(targets))
+	for _, target := range targets {
+		ok, err := evalWhen(when, run.hostVars(target, runtimeVars, nil))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, target)
+		}
+	}
+	return selected, nil
+}
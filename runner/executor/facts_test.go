@@ -0,0 +1,116 @@
+package executor
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"bops/runner/workflow"
+)
+
+type factsRunner struct {
+	mu    sync.Mutex
+	runs  []string
+	facts map[string]map[string]any
+	seen  map[string]any
+}
+
+func (r *factsRunner) Run(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any) (RunResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs = append(r.runs, step.Name+"@"+host.Name)
+	if step.Action == "facts.gather" {
+		return RunResult{Output: map[string]any{"facts": r.facts[host.Name]}}, nil
+	}
+	if r.seen == nil {
+		r.seen = map[string]any{}
+	}
+	r.seen[host.Name] = step.Args["cmd"]
+	return RunResult{}, nil
+}
+
+type factsObserver struct {
+	mu    sync.Mutex
+	facts map[string]map[string]any
+}
+
+func (o *factsObserver) StepStart(step workflow.Step, targets []workflow.HostSpec) {}
+
+func (o *factsObserver) StepFinish(step workflow.Step, status string) {}
+
+func (o *factsObserver) HostFacts(host string, facts map[string]any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.facts == nil {
+		o.facts = map[string]map[string]any{}
+	}
+	o.facts[host] = facts
+}
+
+func TestGatherFactsDrivesWhenPerHost(t *testing.T) {
+	runner := &factsRunner{facts: map[string]map[string]any{
+		"web1": {"os": map[string]any{"family": "debian"}, "pkg_manager": "apt"},
+		"web2": {"os": map[string]any{"family": "redhat"}, "pkg_manager": "dnf"},
+	}}
+	observer := &factsObserver{}
+	exec := &Executor{Runner: runner, Observer: observer}
+	wf := workflow.Workflow{
+		Name:        "demo",
+		GatherFacts: true,
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{
+				"web1": {Address: "web1"},
+				"web2": {Address: "web2"},
+			},
+		},
+		Steps: []workflow.Step{
+			{
+				Name:   "install",
+				Action: "cmd.run",
+				When:   `facts.os.family == "debian"`,
+				Args:   map[string]any{"cmd": "${facts.pkg_manager} install nginx"},
+			},
+		},
+	}
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(runner.runs) != 3 || runner.runs[2] != "install@web1" {
+		t.Fatalf("expected gather on both hosts then install on web1, got %v", runner.runs)
+	}
+	if runner.seen["web1"] != "apt install nginx" {
+		t.Fatalf("expected facts in rendered args, got %v", runner.seen)
+	}
+	if observer.facts["web2"]["pkg_manager"] != "dnf" {
+		t.Fatalf("expected facts to be reported, got %v", observer.facts)
+	}
+}
+
+func TestResumeRestoresFacts(t *testing.T) {
+	runner := &factsRunner{}
+	exec := &Executor{
+		Runner: runner,
+		Resume: &Resume{
+			Steps: map[string]ResumedStep{workflow.GatherFactsStep: {}},
+			Facts: map[string]map[string]any{"web1": {"arch": "arm64"}},
+		},
+	}
+	wf := workflow.Workflow{
+		Name:        "demo",
+		GatherFacts: true,
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{"web1": {Address: "web1"}},
+		},
+		Steps: []workflow.Step{
+			{Name: "build", Action: "cmd.run", Args: map[string]any{"cmd": "make ARCH=${facts.arch}"}},
+		},
+	}
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(runner.runs) != 1 || runner.seen["web1"] != "make ARCH=arm64" {
+		t.Fatalf("expected only build with restored facts, got %v %v", runner.runs, runner.seen)
+	}
+}
@@ -0,0 +1,65 @@
+package executor
+
+import (
+	"bops/runner/logging"
+	"bops/runner/workflow"
+	"go.uber.org/zap"
+)
+
+// Resume describes what an earlier attempt of the same run already
+// completed, so a new attempt continues from the first unfinished step.
+type Resume struct {
+	// Steps holds the steps that finished successfully, keyed by name.
+	Steps map[string]ResumedStep
+	// Hosts holds the output of each host a step already succeeded on,
+	// keyed by step and host name.
+	Hosts map[string]map[string]map[string]any
+	// Facts holds the facts gathered per host by the earlier attempt.
+	Facts map[string]map[string]any
+}
+
+// ResumedStep is a completed step with the vars it exported.
+type ResumedStep struct {
+	Vars        map[string]any
+	AllowedVars map[string]any
+}
+
+// VarsObserver is implemented by observers that persist the vars exported by
+// successful steps, so a later Resume can restore them.
+type VarsObserver interface {
+	StepVars(step workflow.Step, vars, allowed map[string]any)
+}
+
+func (r *Resume) step(name string) (ResumedStep, bool) {
+	if r == nil {
+		return ResumedStep{}, false
+	}
+	step, ok := r.Steps[name]
+	return step, ok
+}
+
+// remainingTargets drops the targets the step already succeeded on and
+// returns the vars those hosts exported. Loop steps always run on every
+// target again because host results do not record which items finished.
+func (r *Resume) remainingTargets(step workflow.Step, targets []workflow.HostSpec) ([]workflow.HostSpec, map[string]any) {
+	if r == nil || len(step.Loop) > 0 || step.LoopExpr != "" || len(r.Hosts[step.Name]) == 0 {
+		return targets, nil
+	}
+	done := r.Hosts[step.Name]
+	remaining := make([]workflow.HostSpec, 0, len(targets))
+	exported := map[string]any{}
+	for _, target := range targets {
+		output, ok := done[target.Name]
+		if !ok {
+			remaining = append(remaining, target)
+			continue
+		}
+		exported = mergeVars(exported, extractExportedVars(output))
+	}
+	logging.L().Debug("executor step resumed on remaining hosts",
+		zap.String("step", step.Name),
+		zap.Int("skipped", len(targets)-len(remaining)),
+		zap.Int("remaining", len(remaining)),
+	)
+	return remaining, exported
+}
@@ -0,0 +1,119 @@
+package executor
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"bops/runner/workflow"
+)
+
+type resumeRunner struct {
+	mu   sync.Mutex
+	runs []string
+	vars map[string]map[string]any
+}
+
+func (r *resumeRunner) Run(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any) (RunResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs = append(r.runs, step.Name+"@"+host.Name)
+	if r.vars == nil {
+		r.vars = map[string]map[string]any{}
+	}
+	r.vars[step.Name] = vars
+	return RunResult{}, nil
+}
+
+type varsObserver struct {
+	vars map[string]map[string]any
+}
+
+func (o *varsObserver) StepStart(step workflow.Step, targets []workflow.HostSpec) {}
+
+func (o *varsObserver) StepFinish(step workflow.Step, status string) {}
+
+func (o *varsObserver) StepVars(step workflow.Step, vars, allowed map[string]any) {
+	if o.vars == nil {
+		o.vars = map[string]map[string]any{}
+	}
+	o.vars[step.Name] = vars
+}
+
+func TestResumeSkipsCompletedStepsAndRestoresVars(t *testing.T) {
+	runner := &resumeRunner{}
+	exec := &Executor{
+		Runner: runner,
+		Resume: &Resume{
+			Steps: map[string]ResumedStep{
+				"build": {
+					Vars:        map[string]any{"TOKEN": "abc"},
+					AllowedVars: map[string]any{"TOKEN": "abc"},
+				},
+			},
+		},
+	}
+	wf := workflow.Workflow{
+		Name: "demo",
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{"local": {Address: "local"}},
+		},
+		Steps: []workflow.Step{
+			{Name: "build", Action: "cmd.run", ExpectVars: []string{"TOKEN"}},
+			{Name: "deploy", Action: "cmd.run", MustVars: []string{"TOKEN"}},
+		},
+	}
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(runner.runs) != 1 || runner.runs[0] != "deploy@local" {
+		t.Fatalf("expected only deploy to run, got %v", runner.runs)
+	}
+	if got := runner.vars["deploy"]["TOKEN"]; got != "abc" {
+		t.Fatalf("expected deploy to see restored TOKEN, got %v", got)
+	}
+}
+
+func TestResumeRunsFailedStepOnRemainingHosts(t *testing.T) {
+	runner := &resumeRunner{}
+	observer := &varsObserver{}
+	exec := &Executor{
+		Runner:   runner,
+		Observer: observer,
+		Resume: &Resume{
+			Hosts: map[string]map[string]map[string]any{
+				"deploy": {
+					"web1": {"vars": map[string]any{"VERSION": "v2"}},
+				},
+			},
+		},
+	}
+	wf := workflow.Workflow{
+		Name: "demo",
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{
+				"web1": {Address: "web1"},
+				"web2": {Address: "web2"},
+			},
+		},
+		Steps: []workflow.Step{
+			{Name: "deploy", Action: "cmd.run"},
+			{Name: "verify", Action: "cmd.run", Targets: []string{"web1"}},
+		},
+	}
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := []string{"deploy@web2", "verify@web1"}
+	if len(runner.runs) != len(expected) || runner.runs[0] != expected[0] || runner.runs[1] != expected[1] {
+		t.Fatalf("expected runs %v, got %v", expected, runner.runs)
+	}
+	if got := runner.vars["verify"]["VERSION"]; got != "v2" {
+		t.Fatalf("expected verify to see VERSION from resumed host, got %v", got)
+	}
+	if got := observer.vars["deploy"]["VERSION"]; got != "v2" {
+		t.Fatalf("expected deploy vars to be reported, got %v", observer.vars)
+	}
+}
@@ -0,0 +1,155 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"bops/runner/logging"
+	"bops/runner/workflow"
+	"go.uber.org/zap"
+)
+
+// PhaseRollback is the phase reported to a PhaseObserver while completed
+// steps are rolled back.
+const PhaseRollback = "rollback"
+
+// RollbackRunner is implemented by host runners that can undo a successful
+// run, given the output that run produced.
+type RollbackRunner interface {
+	Rollback(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any, output map[string]any) (RunResult, error)
+}
+
+// FinishRunner is implemented by host runners that keep state on the hosts
+// for rollback, such as file backups. Finish is called once the run can no
+// longer roll back: after it succeeded or was rolled back.
+type FinishRunner interface {
+	Finish(ctx context.Context)
+}
+
+// PhaseObserver is implemented by observers that also track run phases.
+// Step events between PhaseStart and PhaseFinish belong to that phase.
+type PhaseObserver interface {
+	PhaseStart(phase string)
+	PhaseFinish(phase, status string)
+}
+
+type appliedStep struct {
+	step     workflow.Step
+	baseVars map[string]any
+	item     any
+	hosts    []appliedHost
+}
+
+type appliedHost struct {
+	host   workflow.HostSpec
+	output map[string]any
+}
+
+func (r *runContext) recordApplied(applied appliedStep) {
+	if len(applied.hosts) == 0 {
+		return
+	}
+	r.appliedMu.Lock()
+	r.applied = append(r.applied, applied)
+	r.appliedMu.Unlock()
+}
+
+// rollback undoes every successful host run in reverse completion order. It
+// keeps going after a failed rollback and returns all failures joined.
+func (e *Executor) rollback(ctx context.Context, run *runContext) error {
+	runner, ok := e.Runner.(RollbackRunner)
+	if !ok {
+		return fmt.Errorf("runner does not support rollback")
+	}
+
+	run.appliedMu.Lock()
+	applied := run.applied
+	run.appliedMu.Unlock()
+
+	logging.L().Debug("executor rollback start",
+		zap.String("workflow", run.wf.Name),
+		zap.Int("steps", len(applied)),
+	)
+	phases, _ := e.Observer.(PhaseObserver)
+	if phases != nil {
+		phases.PhaseStart(PhaseRollback)
+	}
+
+	var errs []error
+	for i := len(applied) - 1; i >= 0; i-- {
+		entry := applied[i]
+		if err := e.rollbackStep(ctx, run, runner, entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	err := errors.Join(errs...)
+	if phases != nil {
+		if err != nil {
+			phases.PhaseFinish(PhaseRollback, "failed")
+		} else {
+			phases.PhaseFinish(PhaseRollback, "success")
+		}
+	}
+	logging.L().Debug("executor rollback done", zap.String("workflow", run.wf.Name), zap.Error(err))
+	return err
+}
+
+func (e *Executor) rollbackStep(ctx context.Context, run *runContext, runner RollbackRunner, entry appliedStep) error {
+	timeout, err := parseTimeout(entry.step.Timeout)
+	if err != nil {
+		return err
+	}
+	targets := make([]workflow.HostSpec, 0, len(entry.hosts))
+	for _, applied := range entry.hosts {
+		targets = append(targets, applied.host)
+	}
+	if e.Observer != nil {
+		e.Observer.StepStart(entry.step, targets)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(entry.hosts))
+	for i, applied := range entry.hosts {
+		i, applied := i, applied
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			vars := run.hostVars(applied.host, entry.baseVars, entry.item)
+			runCtx := ctx
+			cancel := func() {}
+			if timeout > 0 {
+				runCtx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			defer cancel()
+			if _, err := runner.Rollback(runCtx, renderStep(entry.step, vars), applied.host, vars, applied.output); err != nil {
+				logging.L().Debug("executor rollback host failed",
+					zap.String("step", entry.step.Name),
+					zap.String("host", applied.host.Name),
+					zap.Error(err),
+				)
+				errs[i] = fmt.Errorf("rollback %s on %s: %w", entry.step.Name, applied.host.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = errors.Join(errs...)
+	if e.Observer != nil {
+		if err != nil {
+			e.Observer.StepFinish(entry.step, "failed")
+		} else {
+			e.Observer.StepFinish(entry.step, "success")
+		}
+	}
+	return err
+}
+
+func (e *Executor) finish(ctx context.Context) {
+	if runner, ok := e.Runner.(FinishRunner); ok {
+		runner.Finish(ctx)
+	}
+}
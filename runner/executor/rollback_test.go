@@ -0,0 +1,114 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"bops/runner/workflow"
+)
+
+type rollbackRunner struct {
+	mu        sync.Mutex
+	failOn    string
+	rollbacks []string
+	outputs   map[string]map[string]any
+}
+
+func (r *rollbackRunner) Run(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any) (RunResult, error) {
+	if step.Name == r.failOn {
+		return RunResult{}, errors.New("boom")
+	}
+	return RunResult{Output: map[string]any{"applied": step.Name}}, nil
+}
+
+func (r *rollbackRunner) Rollback(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any, output map[string]any) (RunResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rollbacks = append(r.rollbacks, step.Name)
+	if r.outputs == nil {
+		r.outputs = map[string]map[string]any{}
+	}
+	r.outputs[step.Name] = output
+	return RunResult{}, nil
+}
+
+type phaseObserver struct {
+	events []string
+}
+
+func (o *phaseObserver) StepStart(step workflow.Step, targets []workflow.HostSpec) {
+	o.events = append(o.events, "start:"+step.Name)
+}
+
+func (o *phaseObserver) StepFinish(step workflow.Step, status string) {
+	o.events = append(o.events, "finish:"+step.Name+":"+status)
+}
+
+func (o *phaseObserver) PhaseStart(phase string) {
+	o.events = append(o.events, "phase:"+phase)
+}
+
+func (o *phaseObserver) PhaseFinish(phase, status string) {
+	o.events = append(o.events, "phase:"+phase+":"+status)
+}
+
+func rollbackWorkflow(onFailure string) workflow.Workflow {
+	return workflow.Workflow{
+		Name:      "demo",
+		OnFailure: onFailure,
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{
+				"local": {Address: "local"},
+			},
+		},
+		Steps: []workflow.Step{
+			{Name: "step-1", Action: "template.render"},
+			{Name: "step-2", Action: "service.ensure"},
+			{Name: "step-3", Action: "cmd.run"},
+		},
+	}
+}
+
+func TestRollbackOnFailure(t *testing.T) {
+	runner := &rollbackRunner{failOn: "step-3"}
+	observer := &phaseObserver{}
+	exec := &Executor{Runner: runner, Observer: observer}
+
+	err := exec.Run(context.Background(), rollbackWorkflow("rollback"))
+	if err == nil || err.Error() != "boom" {
+		t.Fatalf("expected original step error, got %v", err)
+	}
+	if strings.Join(runner.rollbacks, ",") != "step-2,step-1" {
+		t.Fatalf("expected reverse rollback order, got %v", runner.rollbacks)
+	}
+	if runner.outputs["step-1"]["applied"] != "step-1" {
+		t.Fatalf("expected apply output to be passed to rollback, got %v", runner.outputs["step-1"])
+	}
+	expected := []string{
+		"start:step-1", "finish:step-1:success",
+		"start:step-2", "finish:step-2:success",
+		"start:step-3", "finish:step-3:failed",
+		"phase:rollback",
+		"start:step-2", "finish:step-2:success",
+		"start:step-1", "finish:step-1:success",
+		"phase:rollback:success",
+	}
+	if strings.Join(observer.events, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected events:\n%v\nwant:\n%v", observer.events, expected)
+	}
+}
+
+func TestRollbackDisabledByDefault(t *testing.T) {
+	runner := &rollbackRunner{failOn: "step-3"}
+	exec := &Executor{Runner: runner}
+
+	if err := exec.Run(context.Background(), rollbackWorkflow("")); err == nil {
+		t.Fatalf("expected error")
+	}
+	if len(runner.rollbacks) != 0 {
+		t.Fatalf("expected no rollback, got %v", runner.rollbacks)
+	}
+}
@@ -0,0 +1,176 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"bops/runner/logging"
+	"bops/runner/workflow"
+	"go.uber.org/zap"
+)
+
+// rolloutPolicy controls how a step is rolled out across its targets. The
+// zero value runs every target in a single batch and fails on any error.
+type rolloutPolicy struct {
+	serial            any
+	maxFailPercentage int
+	pause             string
+	// budget, when set, tolerates failures against the hosts of a workflow
+	// rolled out with plan.serial instead of maxFailPercentage.
+	budget *failBudget
+}
+
+// stepRollout returns the rollout settings of the step. A step without its
+// own max_fail_percentage uses the plan's, or the failure budget when the
+// whole workflow is rolled out in batches.
+func stepRollout(step workflow.Step, plan workflow.Plan, budget *failBudget) rolloutPolicy {
+	policy := rolloutPolicy{
+		serial:            step.Serial,
+		maxFailPercentage: plan.MaxFailPercentage,
+		pause:             step.BatchPause,
+	}
+	if step.MaxFailPercentage != nil {
+		policy.maxFailPercentage = *step.MaxFailPercentage
+		return policy
+	}
+	policy.budget = budget
+	return policy
+}
+
+// failBudget tracks the hosts that failed while a workflow runs batch by
+// batch. Failed hosts leave the rollout; the run fails once they exceed
+// plan.max_fail_percentage of all hosts.
+type failBudget struct {
+	mu                sync.Mutex
+	total             int
+	maxFailPercentage int
+	failed            map[string]struct{}
+}
+
+func newFailBudget(total, maxFailPercentage int) *failBudget {
+	return &failBudget{total: total, maxFailPercentage: maxFailPercentage, failed: map[string]struct{}{}}
+}
+
+// fail records hosts as failed with err and returns an error when the
+// budget is exceeded.
+func (b *failBudget) fail(hosts []string, err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, name := range hosts {
+		b.failed[name] = struct{}{}
+	}
+	if b.maxFailPercentage <= 0 {
+		return err
+	}
+	if len(b.failed)*100 > b.maxFailPercentage*b.total {
+		return fmt.Errorf("%d of %d hosts failed, exceeding max_fail_percentage %d%%: %w", len(b.failed), b.total, b.maxFailPercentage, err)
+	}
+	return nil
+}
+
+func (b *failBudget) hasFailed(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.failed[name]
+	return ok
+}
+
+// runBatches runs the steps once, or with plan.serial once per batch of
+// hosts, so that every step finishes on a batch before the next batch
+// starts.
+func (e *Executor) runBatches(ctx context.Context, run *runContext, steps func(context.Context, *runContext) error) error {
+	plan := run.wf.Plan
+	if plan.Serial == nil {
+		return steps(ctx, run)
+	}
+	hosts := stableHosts(run.hosts)
+	batches, err := rolloutPolicy{serial: plan.Serial}.batches(hosts)
+	if err != nil {
+		return err
+	}
+	pause, err := parseTimeout(plan.BatchPause)
+	if err != nil {
+		return err
+	}
+	run.budget = newFailBudget(len(hosts), plan.MaxFailPercentage)
+	defer func() {
+		run.batch = nil
+	}()
+	for i, batch := range batches {
+		if i > 0 && pause > 0 {
+			logging.L().Debug("executor workflow batch pause", zap.Duration("pause", pause))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pause):
+			}
+		}
+		run.batch = map[string]struct{}{}
+		for _, host := range batch {
+			run.batch[host.Name] = struct{}{}
+		}
+		logging.L().Debug("executor workflow batch start",
+			zap.String("workflow", run.wf.Name),
+			zap.Int("batch", i+1),
+			zap.Int("batches", len(batches)),
+			zap.Int("hosts", len(batch)),
+		)
+		if err := steps(withBatch(ctx, i+1), run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchTargets keeps the targets in the current workflow batch that have
+// not failed yet.
+func (run *runContext) batchTargets(targets []workflow.HostSpec) []workflow.HostSpec {
+	if run.batch == nil {
+		return targets
+	}
+	out := make([]workflow.HostSpec, 0, len(targets))
+	for _, target := range targets {
+		if _, ok := run.batch[target.Name]; !ok || run.budget.hasFailed(target.Name) {
+			continue
+		}
+		out = append(out, target)
+	}
+	return out
+}
+
+func (p rolloutPolicy) batches(targets []workflow.HostSpec) ([][]workflow.HostSpec, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	size, err := workflow.BatchSize(p.serial, len(targets))
+	if err != nil {
+		return nil, err
+	}
+	var batches [][]workflow.HostSpec
+	for start := 0; start < len(targets); start += size {
+		end := start + size
+		if end > len(targets) {
+			end = len(targets)
+		}
+		batches = append(batches, targets[start:end])
+	}
+	return batches, nil
+}
+
+type batchKey struct{}
+
+func withBatch(ctx context.Context, batch int) context.Context {
+	return context.WithValue(ctx, batchKey{}, batch)
+}
+
+// BatchFromContext returns the 1-based rollout batch a host run belongs to,
+// or 0 when the step is not rolled out in batches.
+func BatchFromContext(ctx context.Context) int {
+	if ctx == nil {
+		return 0
+	}
+	batch, _ := ctx.Value(batchKey{}).(int)
+	return batch
+}
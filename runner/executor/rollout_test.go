@@ -0,0 +1,177 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"bops/runner/workflow"
+)
+
+type batchRunner struct {
+	mu      sync.Mutex
+	failOn  map[string]bool
+	batches map[string]int
+	calls   []string
+}
+
+func (r *batchRunner) Run(ctx context.Context, step workflow.Step, host workflow.HostSpec, vars map[string]any) (RunResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.batches == nil {
+		r.batches = map[string]int{}
+	}
+	r.batches[host.Name] = BatchFromContext(ctx)
+	r.calls = append(r.calls, step.Name+"@"+host.Name)
+	if r.failOn[host.Name] {
+		return RunResult{}, errors.New("boom " + host.Name)
+	}
+	return RunResult{}, nil
+}
+
+func fleetWorkflow(plan workflow.Plan, step workflow.Step) workflow.Workflow {
+	hosts := map[string]workflow.Host{}
+	for _, name := range []string{"web1", "web2", "web3", "web4", "web5"} {
+		hosts[name] = workflow.Host{Address: name}
+	}
+	return workflow.Workflow{
+		Name:      "rollout",
+		Plan:      plan,
+		Inventory: workflow.Inventory{Hosts: hosts},
+		Steps:     []workflow.Step{step},
+	}
+}
+
+func TestRolloutBatches(t *testing.T) {
+	runner := &batchRunner{}
+	exec := &Executor{Runner: runner}
+	wf := fleetWorkflow(workflow.Plan{Serial: 2}, workflow.Step{Name: "deploy", Action: "cmd.run"})
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := map[string]int{"web1": 1, "web2": 1, "web3": 2, "web4": 2, "web5": 3}
+	for host, batch := range expected {
+		if runner.batches[host] != batch {
+			t.Fatalf("expected %s in batch %d, got %v", host, batch, runner.batches)
+		}
+	}
+}
+
+func TestRolloutCanaryFailureStopsLaterBatches(t *testing.T) {
+	runner := &batchRunner{failOn: map[string]bool{"web1": true}}
+	exec := &Executor{Runner: runner}
+	wf := fleetWorkflow(workflow.Plan{}, workflow.Step{Name: "deploy", Action: "cmd.run", Serial: "20%"})
+
+	err := exec.Run(context.Background(), wf)
+	if err == nil || err.Error() != "boom web1" {
+		t.Fatalf("expected canary failure, got %v", err)
+	}
+	if len(runner.batches) != 1 {
+		t.Fatalf("expected only the canary batch to run, got %v", runner.batches)
+	}
+}
+
+func TestRolloutMaxFailPercentage(t *testing.T) {
+	runner := &batchRunner{failOn: map[string]bool{"web2": true}}
+	exec := &Executor{Runner: runner}
+	maxFail := 20
+	step := workflow.Step{Name: "deploy", Action: "cmd.run", Serial: 2, MaxFailPercentage: &maxFail}
+	wf := fleetWorkflow(workflow.Plan{}, step)
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected tolerated failure, got %v", err)
+	}
+	if len(runner.batches) != 5 {
+		t.Fatalf("expected every batch to run, got %v", runner.batches)
+	}
+
+	runner = &batchRunner{failOn: map[string]bool{"web1": true, "web2": true}}
+	exec = &Executor{Runner: runner}
+	err := exec.Run(context.Background(), fleetWorkflow(workflow.Plan{}, step))
+	if err == nil || !strings.Contains(err.Error(), "2 of 5 hosts failed") {
+		t.Fatalf("expected max_fail_percentage error, got %v", err)
+	}
+	if len(runner.batches) != 2 {
+		t.Fatalf("expected rollout to stop after first batch, got %v", runner.batches)
+	}
+}
+
+func TestRolloutPlanSerialBatchesWholeWorkflow(t *testing.T) {
+	runner := &batchRunner{}
+	exec := &Executor{Runner: runner}
+	wf := fleetWorkflow(workflow.Plan{Serial: 2}, workflow.Step{Name: "stop", Action: "cmd.run"})
+	wf.Steps = append(wf.Steps, workflow.Step{Name: "start", Action: "cmd.run"})
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	batchOf := map[string]int{"web1": 1, "web2": 1, "web3": 2, "web4": 2, "web5": 3}
+	if len(runner.calls) != 10 {
+		t.Fatalf("expected both steps on every host, got %v", runner.calls)
+	}
+	lastBatch, started := 0, false
+	for _, call := range runner.calls {
+		name, host, _ := strings.Cut(call, "@")
+		batch := batchOf[host]
+		if batch < lastBatch || (batch == lastBatch && started && name == "stop") {
+			t.Fatalf("expected each batch to finish both steps before the next, got %v", runner.calls)
+		}
+		if batch > lastBatch {
+			lastBatch, started = batch, false
+		}
+		started = started || name == "start"
+	}
+}
+
+func TestRolloutPlanSerialCanaryStopsWorkflow(t *testing.T) {
+	runner := &batchRunner{failOn: map[string]bool{"web1": true}}
+	exec := &Executor{Runner: runner}
+	wf := fleetWorkflow(workflow.Plan{Serial: 1}, workflow.Step{Name: "stop", Action: "cmd.run"})
+	wf.Steps = append(wf.Steps, workflow.Step{Name: "start", Action: "cmd.run"})
+
+	err := exec.Run(context.Background(), wf)
+	if err == nil || err.Error() != "boom web1" {
+		t.Fatalf("expected canary failure, got %v", err)
+	}
+	if len(runner.calls) != 1 || runner.calls[0] != "stop@web1" {
+		t.Fatalf("expected only the canary host to run, got %v", runner.calls)
+	}
+}
+
+func TestRolloutPlanMaxFailPercentageDropsFailedHosts(t *testing.T) {
+	runner := &batchRunner{failOn: map[string]bool{"web2": true}}
+	exec := &Executor{Runner: runner}
+	wf := fleetWorkflow(workflow.Plan{Serial: 2, MaxFailPercentage: 20}, workflow.Step{Name: "stop", Action: "cmd.run"})
+	wf.Steps = append(wf.Steps, workflow.Step{Name: "start", Action: "cmd.run"})
+
+	if err := exec.Run(context.Background(), wf); err != nil {
+		t.Fatalf("expected tolerated failure, got %v", err)
+	}
+	for _, call := range runner.calls {
+		if call == "start@web2" {
+			t.Fatalf("expected failed host to leave the rollout, got %v", runner.calls)
+		}
+	}
+	if len(runner.calls) != 9 {
+		t.Fatalf("expected every other host to run both steps, got %v", runner.calls)
+	}
+}
+
+func TestRolloutStepMaxFailPercentageOverridesPlan(t *testing.T) {
+	runner := &batchRunner{failOn: map[string]bool{"web2": true}}
+	exec := &Executor{Runner: runner}
+	maxFail := 0
+	step := workflow.Step{Name: "deploy", Action: "cmd.run", Serial: 1, MaxFailPercentage: &maxFail}
+	wf := fleetWorkflow(workflow.Plan{MaxFailPercentage: 50}, step)
+
+	err := exec.Run(context.Background(), wf)
+	if err == nil || err.Error() != "boom web2" {
+		t.Fatalf("expected step max_fail_percentage 0 to fail the run, got %v", err)
+	}
+	if len(runner.batches) != 2 {
+		t.Fatalf("expected rollout to stop after the failed batch, got %v", runner.batches)
+	}
+}
@@ -0,0 +1,305 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// Module implements archive.unpack: it extracts args.src, an archive already
+// on the target host (e.g. from file.download), into args.dest with tar or
+// unzip on that host.
+type Module struct{}
+
+func New() *Module {
+	return &Module{}
+}
+
+var cLocale = host.RunOptions{Env: []string{"LC_ALL=C"}}
+
+// maxReported caps the paths listed in diffs and outputs.
+const maxReported = 20
+
+type unpackPlan struct {
+	src     string
+	dest    string
+	format  string
+	strip   int
+	creates string
+	// missing are the archive entries, relative to dest after stripping,
+	// that do not exist yet.
+	missing []string
+	entries int
+	skipped bool
+}
+
+func (m *Module) plan(ctx context.Context, adapter host.Adapter, req modules.Request) (unpackPlan, error) {
+	src, _ := readString(req, "src")
+	dest, _ := readString(req, "dest")
+	if src == "" || dest == "" {
+		return unpackPlan{}, fmt.Errorf("archive.unpack requires args.src and args.dest")
+	}
+	p := unpackPlan{src: src, dest: dest}
+	p.format, _ = readString(req, "format")
+	if p.format == "" {
+		p.format = detectFormat(src)
+	}
+	switch p.format {
+	case "tar", "tar.gz", "tar.bz2", "tar.xz", "zip":
+	default:
+		return unpackPlan{}, fmt.Errorf("archive.unpack cannot detect the format of %s; set args.format", src)
+	}
+	if raw, ok := readString(req, "strip_components"); ok && raw != "" {
+		strip, err := strconv.Atoi(raw)
+		if err != nil || strip < 0 {
+			return unpackPlan{}, fmt.Errorf("archive.unpack invalid strip_components %q", raw)
+		}
+		p.strip = strip
+	}
+	if p.strip > 0 && p.format == "zip" {
+		return unpackPlan{}, fmt.Errorf("archive.unpack strip_components is not supported for zip")
+	}
+
+	p.creates, _ = readString(req, "creates")
+	if p.creates != "" {
+		if exists(ctx, adapter, p.creates) {
+			p.skipped = true
+			return p, nil
+		}
+	}
+
+	entries, err := m.list(ctx, adapter, p)
+	if err != nil {
+		return unpackPlan{}, err
+	}
+	p.entries = len(entries)
+	p.missing, err = missingPaths(ctx, adapter, p.dest, entries)
+	if err != nil {
+		return unpackPlan{}, err
+	}
+	return p, nil
+}
+
+func detectFormat(src string) string {
+	lower := strings.ToLower(src)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar.bz2"), strings.HasSuffix(lower, ".tbz2"):
+		return "tar.bz2"
+	case strings.HasSuffix(lower, ".tar.xz"), strings.HasSuffix(lower, ".txz"):
+		return "tar.xz"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	default:
+		return ""
+	}
+}
+
+func tarFlag(format string) string {
+	switch format {
+	case "tar.gz":
+		return "z"
+	case "tar.bz2":
+		return "j"
+	case "tar.xz":
+		return "J"
+	default:
+		return ""
+	}
+}
+
+// list returns the archive entries as they will land under dest.
+func (m *Module) list(ctx context.Context, adapter host.Adapter, p unpackPlan) ([]string, error) {
+	var res host.RunResult
+	var err error
+	if p.format == "zip" {
+		res, err = adapter.Run(ctx, "unzip", []string{"-Z1", p.src}, cLocale)
+	} else {
+		res, err = adapter.Run(ctx, "tar", []string{"-t" + tarFlag(p.format) + "f", p.src}, cLocale)
+	}
+	if err != nil {
+		return nil, runError("list", p.src, res, err)
+	}
+	var out []string
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		entry := strings.TrimPrefix(strings.TrimSpace(line), "./")
+		if entry == "" {
+			continue
+		}
+		if entry = stripComponents(entry, p.strip); entry != "" {
+			out = append(out, entry)
+		}
+	}
+	return out, nil
+}
+
+func stripComponents(entry string, strip int) string {
+	parts := strings.Split(strings.Trim(entry, "/"), "/")
+	if len(parts) <= strip {
+		return ""
+	}
+	return path.Join(parts[strip:]...)
+}
+
+func exists(ctx context.Context, adapter host.Adapter, target string) bool {
+	_, err := adapter.Run(ctx, "test", []string{"-e", target}, cLocale)
+	return err == nil
+}
+
+// missingPaths checks all entries in one remote call and returns those that
+// do not exist under dest.
+func missingPaths(ctx context.Context, adapter host.Adapter, dest string, entries []string) ([]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	script := `cd "$1" 2>/dev/null || { shift; printf '%s\n' "$@"; exit 0; }; shift; for p in "$@"; do [ -e "$p" ] || [ -L "$p" ] || printf '%s\n' "$p"; done`
+	args := append([]string{"-c", script, "sh", dest}, entries...)
+	res, err := adapter.Run(ctx, "sh", args, cLocale)
+	if err != nil {
+		return nil, runError("check", dest, res, err)
+	}
+	var missing []string
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		if line != "" {
+			missing = append(missing, line)
+		}
+	}
+	return missing, nil
+}
+
+func (m *Module) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(ctx, modules.HostAdapter(req), req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	diff := map[string]any{"src": p.src, "dest": p.dest}
+	if p.skipped {
+		diff["creates"] = p.creates
+		return modules.Result{Diff: diff}, nil
+	}
+	diff["entries"] = p.entries
+	diff["missing"] = len(p.missing)
+	if len(p.missing) > 0 {
+		diff["missing_paths"] = truncate(p.missing)
+	}
+	return modules.Result{Changed: len(p.missing) > 0, Diff: diff}, nil
+}
+
+func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	adapter := modules.HostAdapter(req)
+	p, err := m.plan(ctx, adapter, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output := map[string]any{"src": p.src, "dest": p.dest}
+	if p.skipped {
+		output["skipped"] = "creates " + p.creates + " exists"
+		return modules.Result{Output: output}, nil
+	}
+	output["entries"] = p.entries
+	if len(p.missing) == 0 {
+		return modules.Result{Output: output}, nil
+	}
+
+	if err := adapter.MkdirAll(p.dest, 0o755); err != nil {
+		return modules.Result{}, err
+	}
+	var res host.RunResult
+	if p.format == "zip" {
+		res, err = adapter.Run(ctx, "unzip", []string{"-o", "-q", p.src, "-d", p.dest}, cLocale)
+	} else {
+		args := []string{"-x" + tarFlag(p.format) + "f", p.src, "-C", p.dest}
+		if p.strip > 0 {
+			args = append(args, "--strip-components="+strconv.Itoa(p.strip))
+		}
+		res, err = adapter.Run(ctx, "tar", args, cLocale)
+	}
+	if err != nil {
+		return modules.Result{}, fmt.Errorf("archive.unpack failed: %w", runError("extract", p.src, res, err))
+	}
+	// created drives Rollback; entries that already existed were overwritten
+	// in place and are left alone.
+	output["created"] = p.missing
+	output["created_count"] = len(p.missing)
+	return modules.Result{Changed: true, Output: output}, nil
+}
+
+// Rollback removes the paths the apply created, deepest first; directories
+// that gained other content since are kept.
+func (m *Module) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	dest, _ := req.ApplyOutput["dest"].(string)
+	if dest == "" {
+		return modules.Result{}, fmt.Errorf("archive.unpack rollback requires the apply output")
+	}
+	created := toStrings(req.ApplyOutput["created"])
+	if len(created) == 0 {
+		return modules.Result{Output: map[string]any{"dest": dest}}, nil
+	}
+	reversed := make([]string, 0, len(created))
+	for i := len(created) - 1; i >= 0; i-- {
+		reversed = append(reversed, created[i])
+	}
+	script := `cd "$1" || exit 1; shift; for p in "$@"; do if [ -d "$p" ] && [ ! -L "$p" ]; then rmdir "$p" 2>/dev/null; else rm -f "$p"; fi; done; exit 0`
+	args := append([]string{"-c", script, "sh", dest}, reversed...)
+	adapter := modules.HostAdapter(req)
+	if res, err := adapter.Run(ctx, "sh", args, cLocale); err != nil {
+		return modules.Result{}, fmt.Errorf("archive.unpack rollback failed: %w", runError("remove", dest, res, err))
+	}
+	return modules.Result{
+		Changed: true,
+		Output: map[string]any{
+			"dest":    dest,
+			"removed": len(created),
+		},
+	}, nil
+}
+
+func toStrings(value any) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			out = append(out, fmt.Sprint(item))
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+func truncate(paths []string) []string {
+	if len(paths) <= maxReported {
+		return paths
+	}
+	return paths[:maxReported]
+}
+
+func runError(op, target string, res host.RunResult, err error) error {
+	if detail := strings.TrimSpace(res.Stderr); detail != "" {
+		return fmt.Errorf("%s %s: %s: %w", op, target, detail, err)
+	}
+	return fmt.Errorf("%s %s: %w", op, target, err)
+}
+
+func readString(req modules.Request, key string) (string, bool) {
+	val, ok := req.Step.Args[key]
+	if !ok || val == nil {
+		return "", false
+	}
+	switch v := val.(type) {
+	case string:
+		return strings.TrimSpace(v), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
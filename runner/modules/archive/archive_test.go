@@ -0,0 +1,142 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"bops/runner/modules"
+	"bops/runner/workflow"
+)
+
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	dirs := map[string]bool{}
+	for name, content := range files {
+		for dir := filepath.Dir(name); dir != "." && !dirs[dir]; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+			if err := tw.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+				t.Fatalf("write dir header: %v", err)
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("write content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+}
+
+func unpackRequest(args map[string]any) modules.Request {
+	return modules.Request{Step: workflow.Step{Name: "unpack", Action: "archive.unpack", Args: args}}
+}
+
+func TestUnpackTarGzStripComponents(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not available")
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app-1.2.3.tar.gz")
+	writeTarGz(t, src, map[string]string{"app-1.2.3/bin/app": "binary", "app-1.2.3/README": "readme"})
+	dest := filepath.Join(dir, "releases", "1.2.3")
+	req := unpackRequest(map[string]any{"src": src, "dest": dest, "strip_components": 1})
+
+	mod := New()
+	checked, err := mod.Check(context.Background(), req)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !checked.Changed || checked.Diff["missing"] != 3 {
+		t.Fatalf("expected 3 missing entries, got %v", checked.Diff)
+	}
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "bin", "app"))
+	if err != nil || string(data) != "binary" {
+		t.Fatalf("expected stripped bin/app, got %q %v", data, err)
+	}
+	if checked, err := mod.Check(context.Background(), req); err != nil || checked.Changed {
+		t.Fatalf("expected unpacked archive to match, got %+v %v", checked, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dest, "bin", "local.conf"), []byte("keep"), 0o644); err != nil {
+		t.Fatalf("write local file: %v", err)
+	}
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "bin", "app")); !os.IsNotExist(err) {
+		t.Fatalf("expected bin/app to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "bin", "local.conf")); err != nil {
+		t.Fatalf("expected unrelated file to be kept: %v", err)
+	}
+}
+
+func TestUnpackCreatesGuard(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "installed")
+	if err := os.WriteFile(marker, nil, 0o644); err != nil {
+		t.Fatalf("write marker: %v", err)
+	}
+	req := unpackRequest(map[string]any{"src": filepath.Join(dir, "missing.tar.gz"), "dest": dir, "creates": marker})
+	res, err := New().Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if res.Changed || res.Output["skipped"] == nil {
+		t.Fatalf("expected creates guard to skip, got %+v", res)
+	}
+}
+
+func TestUnpackZip(t *testing.T) {
+	if _, err := exec.LookPath("unzip"); err != nil {
+		t.Skip("unzip not available")
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "site.zip")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatalf("create zip: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("index.html")
+	if err != nil {
+		t.Fatalf("zip entry: %v", err)
+	}
+	_, _ = w.Write([]byte("<h1>hi</h1>"))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	_ = f.Close()
+
+	dest := filepath.Join(dir, "www")
+	if _, err := New().Apply(context.Background(), unpackRequest(map[string]any{"src": src, "dest": dest})); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "index.html")); err != nil || string(data) != "<h1>hi</h1>" {
+		t.Fatalf("expected index.html, got %q %v", data, err)
+	}
+}
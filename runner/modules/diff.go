@@ -0,0 +1,162 @@
+package modules
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// diffContext is the number of unchanged lines shown around each hunk.
+const diffContext = 3
+
+// maxDiffCells bounds the LCS table; larger changes are shown as a full
+// replacement of the differing region.
+const maxDiffCells = 4 << 20
+
+type lineOp struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// UnifiedDiff returns a unified diff of before and after labelled with name,
+// or "" when they are equal or either side is not text.
+func UnifiedDiff(name string, before, after []byte) string {
+	if bytes.Equal(before, after) || !isText(before) || !isText(after) {
+		return ""
+	}
+	ops := diffLines(splitLines(before), splitLines(after))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", name, name)
+	// aLine and bLine hold the number of lines of each side before ops[i].
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.kind != '+' {
+			aLine[i+1]++
+		}
+		if op.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := max(0, i-diffContext)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				end = min(len(ops), end+diffContext)
+				break
+			}
+			end = next
+		}
+		aCount, bCount := aLine[end]-aLine[start], bLine[end]-bLine[start]
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(aLine[start], aCount), hunkRange(bLine[start], bCount))
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.text)
+			b.WriteByte('\n')
+		}
+		i = end
+	}
+	return b.String()
+}
+
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+func splitLines(data []byte) []string {
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffLines trims the common prefix and suffix and aligns the rest with a
+// longest common subsequence.
+func diffLines(a, b []string) []lineOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]lineOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, lineOp{' ', line})
+	}
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(am)*len(bm) > maxDiffCells {
+		for _, line := range am {
+			ops = append(ops, lineOp{'-', line})
+		}
+		for _, line := range bm {
+			ops = append(ops, lineOp{'+', line})
+		}
+	} else {
+		ops = append(ops, lcsOps(am, bm)...)
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, lineOp{' ', line})
+	}
+	return ops
+}
+
+func lcsOps(a, b []string) []lineOp {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []lineOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, lineOp{' ', a[i]})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, lineOp{'+', b[j]})
+			j++
+		default:
+			ops = append(ops, lineOp{'-', a[i]})
+			i++
+		}
+	}
+	return ops
+}
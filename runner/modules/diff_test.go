@@ -0,0 +1,36 @@
+package modules
+
+import "testing"
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	before := []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	after := []byte("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n")
+	want := `--- app.conf
++++ app.conf
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`
+	if got := UnifiedDiff("app.conf", before, after); got != want {
+		t.Fatalf("unexpected diff:\n%s", got)
+	}
+}
+
+func TestUnifiedDiffNewFileAndBinary(t *testing.T) {
+	want := "--- f\n+++ f\n@@ -0,0 +1,2 @@\n+x\n+y\n"
+	if got := UnifiedDiff("f", nil, []byte("x\ny\n")); got != want {
+		t.Fatalf("unexpected diff:\n%s", got)
+	}
+	if got := UnifiedDiff("f", []byte("x"), []byte{0, 1, 2}); got != "" {
+		t.Fatalf("expected no diff for binary content, got %q", got)
+	}
+}
@@ -44,7 +44,7 @@ func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result
 }
 
 func (m *Module) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
-	return modules.Result{}, fmt.Errorf("env.set rollback not supported")
+	return modules.Result{}, fmt.Errorf("env.set %w", modules.ErrRollbackNotSupported)
 }
 
 func readEnvMap(req modules.Request) (map[string]string, error) {
@@ -0,0 +1,293 @@
+package facts
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// Module implements facts.gather. The facts are returned under
+// Output["facts"]; the executor keeps them for the rest of the run and exposes
+// them to later steps on the same host as the facts var.
+type Module struct{}
+
+func New() *Module {
+	return &Module{}
+}
+
+func (m *Module) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	return m.Apply(ctx, req)
+}
+
+func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	facts, err := Gather(ctx, modules.HostAdapter(req))
+	if err != nil {
+		return modules.Result{}, err
+	}
+	return modules.Result{
+		Output: map[string]any{
+			"facts": facts,
+		},
+	}, nil
+}
+
+func (m *Module) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	return modules.Result{}, nil
+}
+
+var pkgManagers = []struct {
+	name   string
+	binary string
+}{
+	{name: "apt", binary: "apt-get"},
+	{name: "dnf", binary: "dnf"},
+	{name: "yum", binary: "yum"},
+	{name: "apk", binary: "apk"},
+	{name: "pacman", binary: "pacman"},
+	{name: "zypper", binary: "zypper"},
+}
+
+var serviceManagers = []string{"systemctl", "service", "rc-service"}
+
+// Gather collects host facts through adapter. Only uname is required; the
+// other sources are best effort so minimal images still report what they can.
+func Gather(ctx context.Context, adapter host.Adapter) (map[string]any, error) {
+	res, err := adapter.Run(ctx, "uname", []string{"-s", "-r", "-m"}, host.RunOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("facts.gather failed: uname: %w", err)
+	}
+	kernel := strings.Fields(res.Stdout)
+	if len(kernel) < 3 {
+		return nil, fmt.Errorf("facts.gather failed: unexpected uname output %q", strings.TrimSpace(res.Stdout))
+	}
+
+	facts := map[string]any{
+		"kernel": map[string]any{
+			"name":    kernel[0],
+			"release": kernel[1],
+		},
+		"arch":            kernel[2],
+		"os":              osFacts(adapter),
+		"cpu":             cpuFacts(adapter),
+		"memory":          memoryFacts(adapter),
+		"disks":           diskFacts(ctx, adapter),
+		"interfaces":      interfaceFacts(ctx, adapter),
+		"init_system":     initSystem(adapter),
+		"pkg_manager":     "",
+		"service_manager": "",
+	}
+	if res, err := adapter.Run(ctx, "hostname", nil, host.RunOptions{}); err == nil {
+		facts["hostname"] = strings.TrimSpace(res.Stdout)
+	}
+	for _, mgr := range pkgManagers {
+		if _, err := adapter.LookPath(mgr.binary); err == nil {
+			facts["pkg_manager"] = mgr.name
+			break
+		}
+	}
+	for _, name := range serviceManagers {
+		if _, err := adapter.LookPath(name); err == nil {
+			facts["service_manager"] = name
+			break
+		}
+	}
+	return facts, nil
+}
+
+func osFacts(adapter host.Adapter) map[string]any {
+	out := map[string]any{"id": "", "name": "", "version": "", "pretty_name": "", "family": ""}
+	data, err := adapter.ReadFile("/etc/os-release")
+	if err != nil {
+		return out
+	}
+	release := parseOSRelease(string(data))
+	out["id"] = release["ID"]
+	out["name"] = release["NAME"]
+	out["version"] = release["VERSION_ID"]
+	out["pretty_name"] = release["PRETTY_NAME"]
+	out["family"] = osFamily(release["ID"], release["ID_LIKE"])
+	return out
+}
+
+func parseOSRelease(content string) map[string]string {
+	out := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		out[key] = value
+	}
+	return out
+}
+
+// osFamily maps a distro to the family its tooling follows, e.g. ubuntu to
+// debian and rocky to redhat.
+func osFamily(id, idLike string) string {
+	candidates := append([]string{id}, strings.Fields(idLike)...)
+	for _, candidate := range candidates {
+		switch candidate {
+		case "debian", "ubuntu":
+			return "debian"
+		case "rhel", "fedora", "centos", "rocky", "almalinux":
+			return "redhat"
+		case "alpine":
+			return "alpine"
+		case "arch":
+			return "arch"
+		case "suse", "opensuse", "sles":
+			return "suse"
+		}
+	}
+	return id
+}
+
+func cpuFacts(adapter host.Adapter) map[string]any {
+	out := map[string]any{"count": int64(0), "model": ""}
+	data, err := adapter.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return out
+	}
+	var count int64
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "processor":
+			count++
+		case "model name":
+			if out["model"] == "" {
+				out["model"] = strings.TrimSpace(value)
+			}
+		}
+	}
+	out["count"] = count
+	return out
+}
+
+func memoryFacts(adapter host.Adapter) map[string]any {
+	out := map[string]any{"total_mb": int64(0), "available_mb": int64(0), "swap_total_mb": int64(0)}
+	data, err := adapter.ReadFile("/proc/meminfo")
+	if err != nil {
+		return out
+	}
+	fields := map[string]string{
+		"MemTotal":     "total_mb",
+		"MemAvailable": "available_mb",
+		"SwapTotal":    "swap_total_mb",
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, wanted := fields[key]
+		if !wanted {
+			continue
+		}
+		parts := strings.Fields(value)
+		if len(parts) == 0 {
+			continue
+		}
+		if kb, err := strconv.ParseInt(parts[0], 10, 64); err == nil {
+			out[name] = kb / 1024
+		}
+	}
+	return out
+}
+
+func diskFacts(ctx context.Context, adapter host.Adapter) []any {
+	disks := []any{}
+	res, err := adapter.Run(ctx, "df", []string{"-P", "-k"}, host.RunOptions{})
+	if err != nil {
+		return disks
+	}
+	scanner := bufio.NewScanner(strings.NewReader(res.Stdout))
+	first := true
+	for scanner.Scan() {
+		if first {
+			first = false
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || !strings.HasPrefix(fields[0], "/") {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		used, _ := strconv.ParseInt(fields[2], 10, 64)
+		avail, _ := strconv.ParseInt(fields[3], 10, 64)
+		disks = append(disks, map[string]any{
+			"device":       fields[0],
+			"mount":        strings.Join(fields[5:], " "),
+			"size_mb":      size / 1024,
+			"used_mb":      used / 1024,
+			"available_mb": avail / 1024,
+		})
+	}
+	return disks
+}
+
+// interfaceFacts parses `ip -o addr show`, one address per line:
+// "2: eth0    inet 10.0.0.5/24 brd ...".
+func interfaceFacts(ctx context.Context, adapter host.Adapter) []any {
+	res, err := adapter.Run(ctx, "ip", []string{"-o", "addr", "show"}, host.RunOptions{})
+	if err != nil {
+		return []any{}
+	}
+	order := []string{}
+	addresses := map[string][]any{}
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		name := strings.TrimSuffix(fields[1], ":")
+		if _, seen := addresses[name]; !seen {
+			order = append(order, name)
+			addresses[name] = []any{}
+		}
+		if fields[2] == "inet" || fields[2] == "inet6" {
+			addresses[name] = append(addresses[name], fields[3])
+		}
+	}
+	out := make([]any, 0, len(order))
+	for _, name := range order {
+		out = append(out, map[string]any{
+			"name":      name,
+			"addresses": addresses[name],
+		})
+	}
+	return out
+}
+
+func initSystem(adapter host.Adapter) string {
+	data, err := adapter.ReadFile("/proc/1/comm")
+	if err != nil {
+		return "unknown"
+	}
+	switch comm := strings.TrimSpace(string(data)); comm {
+	case "systemd":
+		return "systemd"
+	case "init":
+		if _, err := adapter.LookPath("openrc"); err == nil {
+			return "openrc"
+		}
+		return "sysvinit"
+	case "":
+		return "unknown"
+	default:
+		return comm
+	}
+}
@@ -0,0 +1,106 @@
+package facts
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// fakeHost is a host.Adapter serving canned command output and files.
+type fakeHost struct {
+	files    map[string]string
+	commands map[string]string
+	binaries map[string]bool
+}
+
+func (f *fakeHost) Run(ctx context.Context, cmd string, args []string, opts host.RunOptions) (host.RunResult, error) {
+	out, ok := f.commands[strings.TrimSpace(cmd+" "+strings.Join(args, " "))]
+	if !ok {
+		return host.RunResult{ExitCode: 127}, exec.ErrNotFound
+	}
+	return host.RunResult{Stdout: out}, nil
+}
+
+func (f *fakeHost) ReadFile(path string) ([]byte, error) {
+	data, ok := f.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(data), nil
+}
+
+func (f *fakeHost) WriteFile(path string, data []byte, perm os.FileMode) error { return nil }
+
+func (f *fakeHost) MkdirAll(path string, perm os.FileMode) error { return nil }
+
+func (f *fakeHost) Remove(path string) error { return nil }
+
+func (f *fakeHost) LookPath(file string) (string, error) {
+	if f.binaries[file] {
+		return "/usr/bin/" + file, nil
+	}
+	return "", exec.ErrNotFound
+}
+
+func TestGatherParsesHostFacts(t *testing.T) {
+	adapter := &fakeHost{
+		files: map[string]string{
+			"/etc/os-release": "NAME=\"Ubuntu\"\nID=ubuntu\nID_LIKE=debian\nVERSION_ID=\"22.04\"\nPRETTY_NAME=\"Ubuntu 22.04.4 LTS\"\n",
+			"/proc/cpuinfo":   "processor\t: 0\nmodel name\t: Test CPU\n\nprocessor\t: 1\nmodel name\t: Test CPU\n",
+			"/proc/meminfo":   "MemTotal:        4045564 kB\nMemFree:          102400 kB\nMemAvailable:    2048000 kB\nSwapTotal:             0 kB\n",
+			"/proc/1/comm":    "systemd\n",
+		},
+		commands: map[string]string{
+			"uname -s -r -m":  "Linux 6.1.0-18-amd64 x86_64\n",
+			"hostname":        "web1\n",
+			"df -P -k":        "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sda1 20480000 10240000 10240000 50% /\ntmpfs 1024 0 1024 0% /run\n",
+			"ip -o addr show": "1: lo    inet 127.0.0.1/8 scope host lo\n2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0\n2: eth0    inet6 fe80::1/64 scope link\n",
+		},
+		binaries: map[string]bool{"apt-get": true, "systemctl": true},
+	}
+
+	res, err := New().Apply(context.Background(), modules.Request{Adapter: adapter})
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	facts := res.Output["facts"].(map[string]any)
+	if res.Changed {
+		t.Fatalf("expected facts.gather to report no change")
+	}
+
+	osFacts := facts["os"].(map[string]any)
+	if osFacts["id"] != "ubuntu" || osFacts["family"] != "debian" || osFacts["version"] != "22.04" {
+		t.Fatalf("unexpected os facts: %v", osFacts)
+	}
+	if facts["arch"] != "x86_64" || facts["kernel"].(map[string]any)["release"] != "6.1.0-18-amd64" {
+		t.Fatalf("unexpected kernel facts: %v %v", facts["arch"], facts["kernel"])
+	}
+	if cpu := facts["cpu"].(map[string]any); cpu["count"] != int64(2) || cpu["model"] != "Test CPU" {
+		t.Fatalf("unexpected cpu facts: %v", cpu)
+	}
+	if mem := facts["memory"].(map[string]any); mem["total_mb"] != int64(3950) || mem["available_mb"] != int64(2000) {
+		t.Fatalf("unexpected memory facts: %v", mem)
+	}
+	disks := facts["disks"].([]any)
+	if len(disks) != 1 || disks[0].(map[string]any)["mount"] != "/" || disks[0].(map[string]any)["size_mb"] != int64(20000) {
+		t.Fatalf("unexpected disk facts: %v", disks)
+	}
+	ifaces := facts["interfaces"].([]any)
+	if len(ifaces) != 2 || len(ifaces[1].(map[string]any)["addresses"].([]any)) != 2 {
+		t.Fatalf("unexpected interface facts: %v", ifaces)
+	}
+	if facts["hostname"] != "web1" || facts["init_system"] != "systemd" || facts["pkg_manager"] != "apt" || facts["service_manager"] != "systemctl" {
+		t.Fatalf("unexpected host facts: %v", facts)
+	}
+}
+
+func TestGatherRequiresUname(t *testing.T) {
+	if _, err := Gather(context.Background(), &fakeHost{}); err == nil {
+		t.Fatalf("expected gather to fail without uname")
+	}
+}
@@ -0,0 +1,114 @@
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"time"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// BackupRoot is the directory on each host that holds the rollback backups
+// of the file modules, one directory per run. The engine removes the
+// directory of a run with backup.prune once the run can no longer roll back.
+var BackupRoot = "/var/lib/bops/backups"
+
+// BackupDir returns the directory holding the rollback backups of runID.
+func BackupDir(runID string) string {
+	return path.Join(BackupRoot, runID)
+}
+
+// snapshotPath names the rollback backup of target. The path hash keeps
+// files with the same name apart, and the content checksum keeps two steps
+// of one run that change the same file from overwriting each other's backup.
+func snapshotPath(runID, target, sum string) string {
+	if runID == "" {
+		runID = time.Now().UTC().Format("20060102T150405")
+	}
+	key := sha256.Sum256([]byte(target))
+	name := fmt.Sprintf("%s-%s-%s", hex.EncodeToString(key[:6]), sum[:12], path.Base(target))
+	return path.Join(BackupDir(runID), name)
+}
+
+// Backup writes data, the current content of target, to the backup
+// directory of runID and returns the backup path and the content checksum.
+func Backup(adapter host.Adapter, runID, target string, data []byte) (string, string, error) {
+	sum := contentHash(data)
+	dest := snapshotPath(runID, target, sum)
+	if err := adapter.MkdirAll(path.Dir(dest), 0o700); err != nil {
+		return "", "", fmt.Errorf("back up %s: %w", target, err)
+	}
+	if err := adapter.WriteFile(dest, data, 0o600); err != nil {
+		return "", "", fmt.Errorf("back up %s: %w", target, err)
+	}
+	return dest, sum, nil
+}
+
+// ReadBackup reads the backup of target and checks that it still holds the
+// content with checksum sum.
+func ReadBackup(adapter host.Adapter, target, backup, sum string) ([]byte, error) {
+	if backup == "" {
+		return nil, fmt.Errorf("previous content of %s was not recorded", target)
+	}
+	data, err := adapter.ReadFile(backup)
+	if err != nil {
+		return nil, fmt.Errorf("read backup of %s: %w", target, err)
+	}
+	if sum != "" && contentHash(data) != sum {
+		return nil, fmt.Errorf("backup %s of %s has changed since it was taken", backup, target)
+	}
+	return data, nil
+}
+
+// Snapshot records what RollbackOutput needs to put target back, backing up
+// its content on the host. Modules that write files outside this package
+// use it so their Rollback works the same way.
+func Snapshot(ctx context.Context, adapter host.Adapter, runID, target string) (map[string]any, error) {
+	st, err := statPath(ctx, adapter, target)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot(adapter, runID, target, st, false)
+}
+
+// RollbackOutput restores the snapshot recorded under "previous" in the
+// apply output of action; key names the path in that output.
+func RollbackOutput(ctx context.Context, adapter host.Adapter, action, key string, output map[string]any) (modules.Result, error) {
+	return rollbackOutput(ctx, adapter, action, key, output)
+}
+
+// Prune implements backup.prune: it removes the rollback backups the run
+// kept on the host.
+type Prune struct{}
+
+func NewPrune() *Prune {
+	return &Prune{}
+}
+
+func (m *Prune) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	if req.RunID == "" {
+		return modules.Result{}, fmt.Errorf("backup.prune requires a run")
+	}
+	return modules.Result{Diff: map[string]any{"path": BackupDir(req.RunID)}}, nil
+}
+
+func (m *Prune) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	if req.RunID == "" {
+		return modules.Result{}, fmt.Errorf("backup.prune requires a run")
+	}
+	dir := BackupDir(req.RunID)
+	adapter := modules.HostAdapter(req)
+	res, err := adapter.Run(ctx, "rm", []string{"-rf", "--", dir}, cLocale)
+	if err != nil {
+		return modules.Result{}, runError("rm", dir, res, err)
+	}
+	return modules.Result{Output: map[string]any{"path": dir}}, nil
+}
+
+func (m *Prune) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	return modules.Result{}, fmt.Errorf("backup.prune %w", modules.ErrRollbackNotSupported)
+}
@@ -0,0 +1,131 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// Copy implements file.copy: it writes a controller-side file (args.src) or
+// inline args.content to args.dest on the target host.
+type Copy struct{}
+
+func NewCopy() *Copy {
+	return &Copy{}
+}
+
+type copyPlan struct {
+	dest    string
+	content []byte
+	attrs   attrs
+	current pathStat
+	// contentChanged is set when dest is missing or its content differs.
+	contentChanged bool
+	diff           map[string]any
+}
+
+func (m *Copy) plan(ctx context.Context, adapter host.Adapter, req modules.Request) (copyPlan, error) {
+	dest, ok := readString(req, "dest")
+	if !ok || dest == "" {
+		return copyPlan{}, fmt.Errorf("file.copy requires args.dest")
+	}
+	var content []byte
+	if src, ok := readString(req, "src"); ok && src != "" {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return copyPlan{}, fmt.Errorf("file.copy read src: %w", err)
+		}
+		content = data
+	} else if inline, ok := readString(req, "content"); ok {
+		content = []byte(inline)
+	} else {
+		return copyPlan{}, fmt.Errorf("file.copy requires args.src or args.content")
+	}
+	want, err := readAttrs(req, "file.copy")
+	if err != nil {
+		return copyPlan{}, err
+	}
+
+	cur, err := statPath(ctx, adapter, dest)
+	if err != nil {
+		return copyPlan{}, err
+	}
+	if cur.Exists && cur.Type != "file" {
+		return copyPlan{}, fmt.Errorf("file.copy dest %s exists and is a %s", dest, cur.Type)
+	}
+
+	p := copyPlan{dest: dest, content: content, attrs: want, current: cur, diff: map[string]any{}}
+	if !cur.Exists {
+		p.contentChanged = true
+		p.diff["content"] = contentChange(dest, nil, false, content)
+	} else {
+		existing, err := adapter.ReadFile(dest)
+		if err != nil {
+			return copyPlan{}, err
+		}
+		if !bytes.Equal(existing, content) {
+			p.contentChanged = true
+			p.diff["content"] = contentChange(dest, existing, true, content)
+		}
+	}
+	want.diff(cur, p.diff)
+	return p, nil
+}
+
+func (m *Copy) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(ctx, modules.HostAdapter(req), req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed := len(p.diff) > 0
+	p.diff["dest"] = p.dest
+	return modules.Result{Changed: changed, Diff: p.diff}, nil
+}
+
+func (m *Copy) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	adapter := modules.HostAdapter(req)
+	p, err := m.plan(ctx, adapter, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output := map[string]any{"dest": p.dest}
+	if len(p.diff) == 0 {
+		return modules.Result{Output: output}, nil
+	}
+
+	keep := p.contentChanged && p.current.Exists && readBool(req, "backup")
+	prev, err := snapshot(adapter, req.RunID, p.dest, p.current, keep)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output["previous"] = prev
+	if keep {
+		output["backup"] = prev["backup"]
+	}
+	if p.contentChanged {
+		mode := os.FileMode(0o644)
+		if p.current.Exists {
+			mode = p.current.Mode.Perm()
+		}
+		if err := adapter.MkdirAll(filepath.Dir(p.dest), 0o755); err != nil {
+			return modules.Result{}, err
+		}
+		if err := adapter.WriteFile(p.dest, p.content, mode); err != nil {
+			return modules.Result{}, err
+		}
+	}
+	if err := p.attrs.apply(ctx, adapter, p.dest, false); err != nil {
+		return modules.Result{}, err
+	}
+	output["diff"] = p.diff
+	return modules.Result{Changed: true, Output: output}, nil
+}
+
+func (m *Copy) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	return rollbackOutput(ctx, modules.HostAdapter(req), "file.copy", "dest", req.ApplyOutput)
+}
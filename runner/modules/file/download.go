@@ -0,0 +1,396 @@
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// Download implements file.download: it fetches args.url (or reads the
+// controller-side args.src) on the controller, verifies args.sha256 and
+// writes the artifact to args.dest on the target host. Verified artifacts are
+// cached on the controller by checksum so a rollout downloads them once.
+type Download struct {
+	cacheDir string
+	client   *http.Client
+
+	mu sync.Mutex
+	// pending are the downloads in progress by checksum; hosts of a
+	// rollout that need the same artifact wait for one download.
+	pending map[string]*pendingArtifact
+}
+
+// artifactFile is a verified artifact on the controller.
+type artifactFile struct {
+	path   string
+	sha256 string
+	size   int64
+	cached bool
+	// temp is set for artifacts without a checksum, which are not cached;
+	// the caller removes them.
+	temp bool
+}
+
+type pendingArtifact struct {
+	done chan struct{}
+	file artifactFile
+	err  error
+}
+
+func NewDownload() *Download {
+	return &Download{cacheDir: defaultCacheDir(), client: http.DefaultClient}
+}
+
+func defaultCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "bops", "downloads")
+	}
+	return filepath.Join(os.TempDir(), "bops-downloads")
+}
+
+type downloadPlan struct {
+	source   string
+	dest     string
+	checksum string
+	attrs    attrs
+	current  pathStat
+	// fetch is set when dest is missing, differs from checksum or force is
+	// set; without a checksum an existing dest is trusted.
+	fetch bool
+	diff  map[string]any
+}
+
+func (m *Download) plan(ctx context.Context, adapter host.Adapter, req modules.Request) (downloadPlan, error) {
+	url, _ := readString(req, "url")
+	src, _ := readString(req, "src")
+	if (url == "") == (src == "") {
+		return downloadPlan{}, fmt.Errorf("file.download requires exactly one of args.url or args.src")
+	}
+	dest, ok := readString(req, "dest")
+	if !ok || dest == "" {
+		return downloadPlan{}, fmt.Errorf("file.download requires args.dest")
+	}
+	checksum, _ := readString(req, "sha256")
+	checksum = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(checksum), "sha256:"))
+	if checksum != "" && !isSHA256(checksum) {
+		return downloadPlan{}, fmt.Errorf("file.download args.sha256 must be 64 hex characters")
+	}
+	want, err := readAttrs(req, "file.download")
+	if err != nil {
+		return downloadPlan{}, err
+	}
+
+	p := downloadPlan{source: url, dest: dest, checksum: checksum, attrs: want, diff: map[string]any{}}
+	if p.source == "" {
+		p.source = src
+	}
+	if p.current, err = statPath(ctx, adapter, dest); err != nil {
+		return downloadPlan{}, err
+	}
+	switch {
+	case !p.current.Exists:
+		p.fetch = true
+		p.diff["content"] = change("", checksum)
+	case p.current.Type != "file":
+		return downloadPlan{}, fmt.Errorf("file.download dest %s exists and is a %s", dest, p.current.Type)
+	case checksum != "":
+		data, err := adapter.ReadFile(dest)
+		if err != nil {
+			return downloadPlan{}, err
+		}
+		if current := contentHash(data); current != checksum {
+			p.fetch = true
+			p.diff["content"] = change(current, checksum)
+		}
+	case readBool(req, "force"):
+		p.fetch = true
+		p.diff["content"] = change("", "")
+	}
+	want.diff(p.current, p.diff)
+	return p, nil
+}
+
+func isSHA256(value string) bool {
+	if len(value) != 64 {
+		return false
+	}
+	for _, r := range value {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Download) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(ctx, modules.HostAdapter(req), req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed := len(p.diff) > 0
+	p.diff["dest"] = p.dest
+	p.diff["source"] = p.source
+	return modules.Result{Changed: changed, Diff: p.diff}, nil
+}
+
+func (m *Download) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	adapter := modules.HostAdapter(req)
+	p, err := m.plan(ctx, adapter, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output := map[string]any{"dest": p.dest, "source": p.source}
+	if len(p.diff) == 0 {
+		output["sha256"] = p.checksum
+		return modules.Result{Output: output}, nil
+	}
+
+	output["previous"] = map[string]any{"exists": p.current.Exists}
+	if p.fetch {
+		art, err := m.artifact(ctx, p)
+		if err != nil {
+			return modules.Result{}, err
+		}
+		if art.temp {
+			defer os.Remove(art.path)
+		}
+		output["cached"] = art.cached
+		output["sha256"] = art.sha256
+		output["size"] = art.size
+		if p.current.Exists {
+			if !readBool(req, "backup") {
+				// Without a backup an overwritten artifact cannot be restored.
+				delete(output, "previous")
+			} else {
+				path, err := backup(adapter, p.dest, p.current)
+				if err != nil {
+					return modules.Result{}, fmt.Errorf("file.download backup failed: %w", err)
+				}
+				output["backup"] = path
+			}
+		}
+		mode, owner := os.FileMode(0o644), ""
+		if p.current.Exists {
+			mode, owner = p.current.Mode.Perm(), p.current.UID+":"+p.current.GID
+		}
+		if err := adapter.MkdirAll(filepath.Dir(p.dest), 0o755); err != nil {
+			return modules.Result{}, err
+		}
+		if err := upload(ctx, adapter, art.path, p.dest, mode, owner); err != nil {
+			return modules.Result{}, err
+		}
+	} else {
+		// Only attributes change; restore them on the file as it is.
+		output["previous"] = map[string]any{
+			"exists": true,
+			"type":   "attrs",
+			"mode":   formatMode(p.current),
+			"uid":    p.current.UID,
+			"gid":    p.current.GID,
+		}
+	}
+	if err := p.attrs.apply(ctx, adapter, p.dest, false); err != nil {
+		return modules.Result{}, err
+	}
+	output["diff"] = p.diff
+	return modules.Result{Changed: true, Output: output}, nil
+}
+
+// Rollback removes an artifact the apply created, restores an overwritten
+// one from its backup, or reverts attribute changes.
+func (m *Download) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	adapter := modules.HostAdapter(req)
+	dest, _ := req.ApplyOutput["dest"].(string)
+	prev, hasPrev := req.ApplyOutput["previous"].(map[string]any)
+	backupPath, _ := req.ApplyOutput["backup"].(string)
+	switch {
+	case dest == "":
+		return modules.Result{}, fmt.Errorf("file.download rollback requires the apply output")
+	case backupPath != "":
+		data, err := adapter.ReadFile(backupPath)
+		if err != nil {
+			return modules.Result{}, fmt.Errorf("file.download rollback failed: %w", err)
+		}
+		if err := adapter.WriteFile(dest, data, 0o644); err != nil {
+			return modules.Result{}, fmt.Errorf("file.download rollback failed: %w", err)
+		}
+		return modules.Result{Changed: true, Output: map[string]any{"dest": dest, "restored": true}}, nil
+	case !hasPrev:
+		if _, changed := req.ApplyOutput["diff"]; changed {
+			return modules.Result{}, fmt.Errorf("file.download overwrote %s without backup: %w", dest, modules.ErrRollbackNotSupported)
+		}
+		return modules.Result{Output: map[string]any{"dest": dest}}, nil
+	}
+	if kind, _ := prev["type"].(string); kind == "attrs" {
+		modeText, _ := prev["mode"].(string)
+		mode, _ := parseMode(modeText)
+		uid, _ := prev["uid"].(string)
+		gid, _ := prev["gid"].(string)
+		if err := chmod(ctx, adapter, dest, mode); err != nil {
+			return modules.Result{}, fmt.Errorf("file.download rollback failed: %w", err)
+		}
+		if err := chown(ctx, adapter, dest, uid, gid, false); err != nil {
+			return modules.Result{}, fmt.Errorf("file.download rollback failed: %w", err)
+		}
+		return modules.Result{Changed: true, Output: map[string]any{"dest": dest, "restored": true}}, nil
+	}
+	return rollbackOutput(ctx, adapter, "file.download", "dest", req.ApplyOutput)
+}
+
+// artifact returns the verified artifact as a file on the controller, from
+// the cache when the checksum is known and already cached. Concurrent
+// requests for the same checksum share one download.
+func (m *Download) artifact(ctx context.Context, p downloadPlan) (artifactFile, error) {
+	if p.checksum == "" || m.cacheDir == "" {
+		return m.download(ctx, p, "")
+	}
+	cachePath := filepath.Join(m.cacheDir, p.checksum)
+	for {
+		if size, err := verifyFile(cachePath, p.checksum); err == nil {
+			return artifactFile{path: cachePath, sha256: p.checksum, size: size, cached: true}, nil
+		}
+		m.mu.Lock()
+		if pending, ok := m.pending[p.checksum]; ok {
+			m.mu.Unlock()
+			select {
+			case <-pending.done:
+			case <-ctx.Done():
+				return artifactFile{}, ctx.Err()
+			}
+			if pending.err != nil && errors.Is(pending.err, context.Canceled) {
+				// The host that downloaded it was canceled, not this one.
+				continue
+			}
+			file := pending.file
+			file.cached = true
+			return file, pending.err
+		}
+		pending := &pendingArtifact{done: make(chan struct{})}
+		if m.pending == nil {
+			m.pending = map[string]*pendingArtifact{}
+		}
+		m.pending[p.checksum] = pending
+		m.mu.Unlock()
+
+		pending.file, pending.err = m.download(ctx, p, cachePath)
+		m.mu.Lock()
+		delete(m.pending, p.checksum)
+		m.mu.Unlock()
+		close(pending.done)
+		return pending.file, pending.err
+	}
+}
+
+// download streams the artifact to a temp file while hashing it, checks the
+// checksum and moves the file to cachePath when set.
+func (m *Download) download(ctx context.Context, p downloadPlan, cachePath string) (artifactFile, error) {
+	dir := m.cacheDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return artifactFile{}, fmt.Errorf("file.download cache: %w", err)
+	}
+	body, err := m.open(ctx, p.source)
+	if err != nil {
+		return artifactFile{}, fmt.Errorf("file.download %s: %w", p.source, err)
+	}
+	defer body.Close()
+	tmp, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return artifactFile{}, fmt.Errorf("file.download cache: %w", err)
+	}
+	hash := sha256.New()
+	size, copyErr := io.Copy(io.MultiWriter(tmp, hash), body)
+	closeErr := tmp.Close()
+	if err := errors.Join(copyErr, closeErr); err != nil {
+		_ = os.Remove(tmp.Name())
+		return artifactFile{}, fmt.Errorf("file.download %s: %w", p.source, err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if p.checksum != "" && sum != p.checksum {
+		_ = os.Remove(tmp.Name())
+		return artifactFile{}, fmt.Errorf("file.download %s: sha256 mismatch: expected %s, got %s", p.source, p.checksum, sum)
+	}
+	if cachePath == "" {
+		return artifactFile{path: tmp.Name(), sha256: sum, size: size, temp: true}, nil
+	}
+	// The rename keeps other hosts from ever reading a partial artifact.
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		_ = os.Remove(tmp.Name())
+		return artifactFile{}, fmt.Errorf("file.download cache: %w", err)
+	}
+	return artifactFile{path: cachePath, sha256: sum, size: size}, nil
+}
+
+// open returns the artifact content from args.url or args.src.
+func (m *Download) open(ctx context.Context, source string) (io.ReadCloser, error) {
+	if !strings.Contains(source, "://") {
+		return os.Open(source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// verifyFile returns the size of the file at path if it has checksum.
+func verifyFile(path, checksum string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return 0, fmt.Errorf("%s does not match sha256 %s", path, checksum)
+	}
+	return size, nil
+}
+
+// upload streams the controller file src to dest on the host through a temp
+// file next to dest, which is renamed over dest once complete, so an
+// interrupted transfer never leaves a truncated artifact. owner is the
+// uid:gid to keep when dest is replaced.
+func upload(ctx context.Context, adapter host.Adapter, src, dest string, mode os.FileMode, owner string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmp := filepath.Join(filepath.Dir(dest), fmt.Sprintf(".%s.%d.tmp", filepath.Base(dest), time.Now().UnixNano()))
+	script := `cat > "$1" && chmod "$3" "$1" && { [ -z "$4" ] || chown "$4" "$1"; } && mv -f "$1" "$2" || { rm -f "$1"; exit 1; }`
+	res, err := adapter.Run(ctx, "/bin/sh", []string{"-c", script, "sh", tmp, dest, fmt.Sprintf("%o", mode.Perm()), owner}, host.RunOptions{
+		Env:   cLocale.Env,
+		Stdin: f,
+	})
+	if err != nil {
+		return runError("write", dest, res, err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// Fetch implements file.fetch: it copies args.src from the target host to
+// args.dest on the controller. A dest ending in "/" becomes
+// <dest>/<host>/<basename of src> so one step can collect from every host.
+type Fetch struct{}
+
+func NewFetch() *Fetch {
+	return &Fetch{}
+}
+
+type fetchPlan struct {
+	src     string
+	dest    string
+	content []byte
+	missing bool
+	current pathStat
+	diff    map[string]any
+}
+
+func (m *Fetch) plan(ctx context.Context, adapter host.Adapter, req modules.Request) (fetchPlan, error) {
+	src, ok := readString(req, "src")
+	if !ok || src == "" {
+		return fetchPlan{}, fmt.Errorf("file.fetch requires args.src")
+	}
+	dest, ok := readString(req, "dest")
+	if !ok || dest == "" {
+		return fetchPlan{}, fmt.Errorf("file.fetch requires args.dest")
+	}
+	if strings.HasSuffix(dest, "/") {
+		dest = filepath.Join(dest, req.Host.Name, filepath.Base(src))
+	}
+
+	p := fetchPlan{src: src, dest: dest, diff: map[string]any{}}
+	content, err := adapter.ReadFile(src)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return fetchPlan{}, err
+		}
+		if _, set := req.Step.Args["fail_on_missing"]; !set || readBool(req, "fail_on_missing") {
+			return fetchPlan{}, fmt.Errorf("file.fetch src %s does not exist", src)
+		}
+		p.missing = true
+		return p, nil
+	}
+	p.content = content
+
+	local := host.NewLocalAdapter()
+	if p.current, err = statPath(ctx, local, dest); err != nil {
+		return fetchPlan{}, err
+	}
+	if !p.current.Exists {
+		p.diff["content"] = contentChange(dest, nil, false, content)
+		return p, nil
+	}
+	if p.current.Type != "file" {
+		return fetchPlan{}, fmt.Errorf("file.fetch dest %s exists and is a %s", dest, p.current.Type)
+	}
+	existing, err := local.ReadFile(dest)
+	if err != nil {
+		return fetchPlan{}, err
+	}
+	if !bytes.Equal(existing, content) {
+		p.diff["content"] = contentChange(dest, existing, true, content)
+	}
+	return p, nil
+}
+
+func (m *Fetch) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(ctx, modules.HostAdapter(req), req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed := len(p.diff) > 0
+	p.diff["src"] = p.src
+	p.diff["dest"] = p.dest
+	return modules.Result{Changed: changed, Diff: p.diff}, nil
+}
+
+func (m *Fetch) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(ctx, modules.HostAdapter(req), req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output := map[string]any{"src": p.src, "dest": p.dest}
+	if p.missing {
+		output["missing"] = true
+		return modules.Result{Output: output}, nil
+	}
+	if len(p.diff) == 0 {
+		return modules.Result{Output: output}, nil
+	}
+
+	local := host.NewLocalAdapter()
+	prev, err := snapshot(local, req.RunID, p.dest, p.current, false)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output["previous"] = prev
+	mode := os.FileMode(0o644)
+	if p.current.Exists {
+		mode = p.current.Mode.Perm()
+	}
+	if err := local.MkdirAll(filepath.Dir(p.dest), 0o755); err != nil {
+		return modules.Result{}, err
+	}
+	if err := local.WriteFile(p.dest, p.content, mode); err != nil {
+		return modules.Result{}, err
+	}
+	output["diff"] = p.diff
+	return modules.Result{Changed: true, Output: output}, nil
+}
+
+// Rollback restores the controller-side dest; the target host is untouched
+// by fetch.
+func (m *Fetch) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	return rollbackOutput(ctx, host.NewLocalAdapter(), "file.fetch", "dest", req.ApplyOutput)
+}
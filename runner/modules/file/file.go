@@ -0,0 +1,435 @@
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// pathStat describes a path on the target host. It is read with stat(1)
+// through the adapter so the same code works locally and over SSH.
+type pathStat struct {
+	Exists bool
+	Type   string
+	Mode   os.FileMode
+	Owner  string
+	Group  string
+	UID    string
+	GID    string
+	Target string
+}
+
+var cLocale = host.RunOptions{Env: []string{"LC_ALL=C"}}
+
+func statPath(ctx context.Context, adapter host.Adapter, path string) (pathStat, error) {
+	res, err := adapter.Run(ctx, "stat", []string{"-c", "%F|%a|%U|%G|%u|%g", "--", path}, cLocale)
+	if err != nil {
+		if strings.Contains(res.Stderr, "No such file or directory") {
+			return pathStat{}, nil
+		}
+		return pathStat{}, runError("stat", path, res, err)
+	}
+	fields := strings.Split(strings.TrimSpace(res.Stdout), "|")
+	if len(fields) != 6 {
+		return pathStat{}, fmt.Errorf("stat %s: unexpected output %q", path, res.Stdout)
+	}
+	mode, err := strconv.ParseUint(fields[1], 8, 32)
+	if err != nil {
+		return pathStat{}, fmt.Errorf("stat %s: invalid mode %q", path, fields[1])
+	}
+	st := pathStat{
+		Exists: true,
+		Mode:   os.FileMode(mode),
+		Owner:  fields[2],
+		Group:  fields[3],
+		UID:    fields[4],
+		GID:    fields[5],
+	}
+	switch fields[0] {
+	case "regular file", "regular empty file":
+		st.Type = "file"
+	case "directory":
+		st.Type = "directory"
+	case "symbolic link":
+		st.Type = "link"
+		res, err := adapter.Run(ctx, "readlink", []string{"--", path}, cLocale)
+		if err != nil {
+			return pathStat{}, runError("readlink", path, res, err)
+		}
+		st.Target = strings.TrimSpace(res.Stdout)
+	default:
+		st.Type = "other"
+	}
+	return st, nil
+}
+
+func runError(op, path string, res host.RunResult, err error) error {
+	if detail := strings.TrimSpace(res.Stderr); detail != "" {
+		return fmt.Errorf("%s %s: %s: %w", op, path, detail, err)
+	}
+	return fmt.Errorf("%s %s: %w", op, path, err)
+}
+
+// attrs are the optional mode/owner/group args shared by the file modules.
+type attrs struct {
+	mode    os.FileMode
+	hasMode bool
+	owner   string
+	group   string
+}
+
+func readAttrs(req modules.Request, action string) (attrs, error) {
+	var out attrs
+	if raw, ok := req.Step.Args["mode"]; ok && raw != nil {
+		mode, err := parseMode(raw)
+		if err != nil {
+			return attrs{}, fmt.Errorf("%s invalid mode: %w", action, err)
+		}
+		out.mode, out.hasMode = mode, true
+	}
+	out.owner, _ = readString(req, "owner")
+	out.group, _ = readString(req, "group")
+	return out, nil
+}
+
+// parseMode accepts octal strings ("0644", "644") and integers, which YAML
+// already decoded from octal (mode: 0644), like template.render does.
+func parseMode(raw any) (os.FileMode, error) {
+	switch v := raw.(type) {
+	case int:
+		return os.FileMode(v), nil
+	case int64:
+		return os.FileMode(v), nil
+	case uint64:
+		return os.FileMode(v), nil
+	case float64:
+		return os.FileMode(int64(v)), nil
+	}
+	text := strings.TrimPrefix(strings.TrimSpace(fmt.Sprint(raw)), "0o")
+	parsed, err := strconv.ParseUint(text, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an octal mode", text)
+	}
+	return os.FileMode(parsed), nil
+}
+
+// diff records the attribute changes needed to go from cur to a. A path that
+// does not exist yet only reports attributes that were asked for.
+func (a attrs) diff(cur pathStat, diff map[string]any) {
+	if a.hasMode && (!cur.Exists || cur.Mode.Perm() != a.mode.Perm()) {
+		diff["mode"] = change(formatMode(cur), fmt.Sprintf("%04o", a.mode.Perm()))
+	}
+	if a.owner != "" && (!cur.Exists || (cur.Owner != a.owner && cur.UID != a.owner)) {
+		diff["owner"] = change(cur.Owner, a.owner)
+	}
+	if a.group != "" && (!cur.Exists || (cur.Group != a.group && cur.GID != a.group)) {
+		diff["group"] = change(cur.Group, a.group)
+	}
+}
+
+// apply sets the requested attributes on path. Links get their ownership
+// changed with -h and never have their mode changed.
+func (a attrs) apply(ctx context.Context, adapter host.Adapter, path string, link bool) error {
+	if a.hasMode && !link {
+		if err := chmod(ctx, adapter, path, a.mode); err != nil {
+			return err
+		}
+	}
+	return chown(ctx, adapter, path, a.owner, a.group, link)
+}
+
+func chmod(ctx context.Context, adapter host.Adapter, path string, mode os.FileMode) error {
+	res, err := adapter.Run(ctx, "chmod", []string{fmt.Sprintf("%o", mode.Perm()), "--", path}, cLocale)
+	if err != nil {
+		return runError("chmod", path, res, err)
+	}
+	return nil
+}
+
+func chown(ctx context.Context, adapter host.Adapter, path, owner, group string, link bool) error {
+	if owner == "" && group == "" {
+		return nil
+	}
+	spec := owner
+	if group != "" {
+		spec += ":" + group
+	}
+	args := []string{spec, "--", path}
+	if link {
+		args = append([]string{"-h"}, args...)
+	}
+	res, err := adapter.Run(ctx, "chown", args, cLocale)
+	if err != nil {
+		return runError("chown", path, res, err)
+	}
+	return nil
+}
+
+func change(before, after string) map[string]any {
+	return map[string]any{"before": before, "after": after}
+}
+
+// contentChange reports a content change by hash, with a unified diff when
+// both sides are text.
+func contentChange(path string, before []byte, exists bool, after []byte) map[string]any {
+	out := change(contentHashIf(exists, before), contentHash(after))
+	if text := modules.UnifiedDiff(path, before, after); text != "" {
+		out["diff"] = text
+	}
+	return out
+}
+
+func contentHashIf(exists bool, data []byte) string {
+	if !exists {
+		return ""
+	}
+	return contentHash(data)
+}
+
+func formatMode(st pathStat) string {
+	if !st.Exists {
+		return ""
+	}
+	return fmt.Sprintf("%04o", st.Mode.Perm())
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// snapshot captures what Rollback needs to put path back: its type and
+// attributes. The content of a regular file is copied to the backup
+// directory of the run on the same host, and only the backup path and
+// checksum travel in the apply output, so file content never ends up in the
+// run state.
+//
+// With keep the backup asked for with args.backup doubles as the snapshot:
+// it is written next to path and stays there after the run.
+func snapshot(adapter host.Adapter, runID, path string, st pathStat, keep bool) (map[string]any, error) {
+	prev := map[string]any{"exists": st.Exists}
+	if !st.Exists {
+		return prev, nil
+	}
+	prev["type"] = st.Type
+	prev["mode"] = formatMode(st)
+	prev["uid"] = st.UID
+	prev["gid"] = st.GID
+	switch st.Type {
+	case "file":
+		data, err := adapter.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			dest := backupPath(path)
+			if err := adapter.WriteFile(dest, data, st.Mode.Perm()); err != nil {
+				return nil, fmt.Errorf("back up %s: %w", path, err)
+			}
+			prev["backup"] = dest
+			prev["sha256"] = contentHash(data)
+			prev["kept"] = true
+			break
+		}
+		dest, sum, err := Backup(adapter, runID, path, data)
+		if err != nil {
+			return nil, err
+		}
+		prev["backup"] = dest
+		prev["sha256"] = sum
+	case "link":
+		prev["target"] = st.Target
+	}
+	return prev, nil
+}
+
+// snapshotContent reads the backup recorded by snapshot and checks that it
+// still holds the content it was taken from.
+func snapshotContent(adapter host.Adapter, path string, prev map[string]any) ([]byte, error) {
+	backupPath, _ := prev["backup"].(string)
+	sum, _ := prev["sha256"].(string)
+	return ReadBackup(adapter, path, backupPath, sum)
+}
+
+// restore puts path back to the state recorded by snapshot.
+func restore(ctx context.Context, adapter host.Adapter, path string, prev map[string]any) error {
+	cur, err := statPath(ctx, adapter, path)
+	if err != nil {
+		return err
+	}
+	existed, _ := prev["exists"].(bool)
+	if !existed {
+		if !cur.Exists {
+			return nil
+		}
+		if cur.Type == "directory" {
+			res, err := adapter.Run(ctx, "rmdir", []string{"--", path}, cLocale)
+			if err != nil {
+				return runError("rmdir", path, res, err)
+			}
+			return nil
+		}
+		return adapter.Remove(path)
+	}
+
+	restoredBackup := false
+	kind, _ := prev["type"].(string)
+	modeText, _ := prev["mode"].(string)
+	mode, _ := parseMode(modeText)
+	switch kind {
+	case "file":
+		// A later step of the run may already have put the content back
+		// and removed the backup they share.
+		if !sameContent(adapter, path, cur, prev) {
+			content, err := snapshotContent(adapter, path, prev)
+			if err != nil {
+				return err
+			}
+			if cur.Exists && cur.Type != "file" {
+				if err := adapter.Remove(path); err != nil {
+					return err
+				}
+			}
+			if err := adapter.WriteFile(path, content, mode); err != nil {
+				return err
+			}
+			restoredBackup = true
+		}
+	case "directory":
+		if cur.Exists && cur.Type != "directory" {
+			if err := adapter.Remove(path); err != nil {
+				return err
+			}
+		}
+		if err := adapter.MkdirAll(path, mode); err != nil {
+			return err
+		}
+	case "link":
+		target, _ := prev["target"].(string)
+		if cur.Exists && cur.Type != "link" {
+			if err := adapter.Remove(path); err != nil {
+				return err
+			}
+		}
+		if err := symlink(ctx, adapter, target, path); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot restore %s of type %q", path, kind)
+	}
+
+	after, err := statPath(ctx, adapter, path)
+	if err != nil {
+		return err
+	}
+	if kind != "link" && after.Mode.Perm() != mode.Perm() {
+		if err := chmod(ctx, adapter, path, mode); err != nil {
+			return err
+		}
+	}
+	uid, _ := prev["uid"].(string)
+	gid, _ := prev["gid"].(string)
+	if uid != "" && (after.UID != uid || after.GID != gid) {
+		if err := chown(ctx, adapter, path, uid, gid, kind == "link"); err != nil {
+			return err
+		}
+	}
+	if kept, _ := prev["kept"].(bool); restoredBackup && !kept {
+		backup, _ := prev["backup"].(string)
+		return adapter.Remove(backup)
+	}
+	return nil
+}
+
+// sameContent reports whether path is a regular file that already holds the
+// content recorded by snapshot.
+func sameContent(adapter host.Adapter, path string, cur pathStat, prev map[string]any) bool {
+	sum, _ := prev["sha256"].(string)
+	if sum == "" || !cur.Exists || cur.Type != "file" {
+		return false
+	}
+	data, err := adapter.ReadFile(path)
+	return err == nil && contentHash(data) == sum
+}
+
+func symlink(ctx context.Context, adapter host.Adapter, target, path string) error {
+	res, err := adapter.Run(ctx, "ln", []string{"-sfn", "--", target, path}, cLocale)
+	if err != nil {
+		return runError("ln", path, res, err)
+	}
+	return nil
+}
+
+// backupPath names the backup args.backup asks for.
+func backupPath(path string) string {
+	return fmt.Sprintf("%s.%s.bak", path, time.Now().UTC().Format("20060102T150405"))
+}
+
+// backup copies the current content of path next to it before it changes.
+func backup(adapter host.Adapter, path string, st pathStat) (string, error) {
+	data, err := adapter.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	dest := backupPath(path)
+	if err := adapter.WriteFile(dest, data, st.Mode.Perm()); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// rollbackOutput restores the snapshot recorded under "previous" in the
+// apply output; applies that changed nothing leave nothing to undo.
+func rollbackOutput(ctx context.Context, adapter host.Adapter, action, key string, output map[string]any) (modules.Result, error) {
+	path, _ := output[key].(string)
+	prev, ok := output["previous"].(map[string]any)
+	if path == "" {
+		return modules.Result{}, fmt.Errorf("%s rollback requires the apply output", action)
+	}
+	if !ok {
+		return modules.Result{Output: map[string]any{key: path}}, nil
+	}
+	if err := restore(ctx, adapter, path, prev); err != nil {
+		return modules.Result{}, fmt.Errorf("%s rollback failed: %w", action, err)
+	}
+	return modules.Result{
+		Changed: true,
+		Output: map[string]any{
+			key:        path,
+			"restored": true,
+		},
+	}, nil
+}
+
+func readString(req modules.Request, key string) (string, bool) {
+	val, ok := req.Step.Args[key]
+	if !ok || val == nil {
+		return "", false
+	}
+	switch v := val.(type) {
+	case string:
+		return v, true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+func readBool(req modules.Request, key string) bool {
+	switch v := req.Step.Args[key].(type) {
+	case bool:
+		return v
+	case string:
+		parsed, _ := strconv.ParseBool(strings.TrimSpace(v))
+		return parsed
+	default:
+		return false
+	}
+}
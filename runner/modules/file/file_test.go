@@ -0,0 +1,416 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bops/runner/modules"
+	"bops/runner/workflow"
+)
+
+// useBackupRoot keeps the rollback backups of the test in a temp dir.
+func useBackupRoot(t *testing.T) {
+	t.Helper()
+	prev := BackupRoot
+	BackupRoot = t.TempDir()
+	t.Cleanup(func() { BackupRoot = prev })
+}
+
+func fileRequest(action string, args map[string]any) modules.Request {
+	return modules.Request{
+		RunID: "run-1",
+		Step:  workflow.Step{Name: "file", Action: action, Args: args},
+		Host:  workflow.HostSpec{Name: "web1"},
+	}
+}
+
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if string(data) != want {
+		t.Fatalf("expected %s content %q, got %q", path, want, string(data))
+	}
+}
+
+func assertMode(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("stat %s: %v", path, err)
+	}
+	if info.Mode().Perm() != want {
+		t.Fatalf("expected %s mode %04o, got %04o", path, want, info.Mode().Perm())
+	}
+}
+
+func TestCopyCheckApplyRollback(t *testing.T) {
+	useBackupRoot(t)
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(dest, []byte("port=80\n"), 0o644); err != nil {
+		t.Fatalf("write dest: %v", err)
+	}
+
+	mod := NewCopy()
+	req := fileRequest("file.copy", map[string]any{
+		"content": "port=8080\n",
+		"dest":    dest,
+		"mode":    "0600",
+		"backup":  true,
+	})
+	checked, err := mod.Check(context.Background(), req)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !checked.Changed || checked.Diff["content"] == nil || checked.Diff["mode"] == nil {
+		t.Fatalf("expected content and mode diff, got %+v", checked)
+	}
+
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	assertContent(t, dest, "port=8080\n")
+	assertMode(t, dest, 0o600)
+	backupPath, _ := applied.Output["backup"].(string)
+	if !strings.HasSuffix(backupPath, ".bak") {
+		t.Fatalf("expected backup path, got %v", applied.Output)
+	}
+	assertContent(t, backupPath, "port=80\n")
+	prev, _ := applied.Output["previous"].(map[string]any)
+	if _, ok := prev["content"]; ok {
+		t.Fatalf("expected previous content to stay out of the output, got %v", prev)
+	}
+	if prev["backup"] != backupPath || prev["sha256"] == nil {
+		t.Fatalf("expected the requested backup to double as the rollback snapshot, got %v", prev)
+	}
+	assertMode(t, backupPath, 0o644)
+	if _, err := os.Stat(BackupDir("run-1")); !os.IsNotExist(err) {
+		t.Fatalf("expected no second backup in the run backup dir, got %v", err)
+	}
+
+	again, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("second apply: %v", err)
+	}
+	if again.Changed {
+		t.Fatalf("expected second apply to be unchanged")
+	}
+
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	assertContent(t, dest, "port=80\n")
+	assertMode(t, dest, 0o644)
+	assertContent(t, backupPath, "port=80\n")
+}
+
+func TestCopyRollbackRemovesCreatedFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "motd")
+	dest := filepath.Join(dir, "etc", "motd")
+	if err := os.WriteFile(src, []byte("hello\n"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+
+	mod := NewCopy()
+	req := fileRequest("file.copy", map[string]any{"src": src, "dest": dest})
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	assertContent(t, dest, "hello\n")
+
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("expected dest to be removed, got %v", err)
+	}
+}
+
+func TestLineReplaceInsertRemove(t *testing.T) {
+	useBackupRoot(t)
+	path := filepath.Join(t.TempDir(), "sshd_config")
+	original := "Port 22\n#PermitRootLogin yes\nUsePAM yes\n"
+	if err := os.WriteFile(path, []byte(original), 0o640); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	mod := NewLine()
+	replace := fileRequest("file.line", map[string]any{
+		"path":   path,
+		"regexp": "^#?PermitRootLogin",
+		"line":   "PermitRootLogin no",
+	})
+	applied, err := mod.Apply(context.Background(), replace)
+	if err != nil {
+		t.Fatalf("apply replace: %v", err)
+	}
+	assertContent(t, path, "Port 22\nPermitRootLogin no\nUsePAM yes\n")
+	assertMode(t, path, 0o640)
+	prev, _ := applied.Output["previous"].(map[string]any)
+	snapshotPath, _ := prev["backup"].(string)
+	if !strings.HasPrefix(snapshotPath, BackupDir("run-1")+"/") {
+		t.Fatalf("expected the rollback backup in the run backup dir, got %v", prev)
+	}
+	assertContent(t, snapshotPath, original)
+	assertMode(t, snapshotPath, 0o600)
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Fatalf("expected nothing next to %s, got %v", path, matches)
+	}
+
+	if again, err := mod.Apply(context.Background(), replace); err != nil || again.Changed {
+		t.Fatalf("expected replace to be idempotent, got %+v %v", again, err)
+	}
+
+	insert := fileRequest("file.line", map[string]any{
+		"path":         path,
+		"line":         "ListenAddress 0.0.0.0",
+		"insert_after": "^Port ",
+	})
+	if _, err := mod.Apply(context.Background(), insert); err != nil {
+		t.Fatalf("apply insert: %v", err)
+	}
+	assertContent(t, path, "Port 22\nListenAddress 0.0.0.0\nPermitRootLogin no\nUsePAM yes\n")
+
+	remove := fileRequest("file.line", map[string]any{"path": path, "regexp": "^UsePAM", "state": "absent"})
+	if _, err := mod.Apply(context.Background(), remove); err != nil {
+		t.Fatalf("apply remove: %v", err)
+	}
+	assertContent(t, path, "Port 22\nListenAddress 0.0.0.0\nPermitRootLogin no\n")
+
+	replace.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), replace); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	assertContent(t, path, original)
+	if _, err := os.Stat(snapshotPath); !os.IsNotExist(err) {
+		t.Fatalf("expected rollback to remove %s, got %v", snapshotPath, err)
+	}
+}
+
+func TestLineRequiresCreateForMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf.d", "extra.conf")
+	mod := NewLine()
+	req := fileRequest("file.line", map[string]any{"path": path, "line": "a=1"})
+	if _, err := mod.Apply(context.Background(), req); err == nil {
+		t.Fatalf("expected error for missing file without create")
+	}
+	req.Step.Args["create"] = true
+	if _, err := mod.Apply(context.Background(), req); err != nil {
+		t.Fatalf("apply with create: %v", err)
+	}
+	assertContent(t, path, "a=1\n")
+}
+
+func TestStateDirectoryLinkAbsent(t *testing.T) {
+	useBackupRoot(t)
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	current := filepath.Join(dir, "current")
+	mod := NewState()
+
+	mkdir := fileRequest("file.state", map[string]any{"path": data, "state": "directory", "mode": 0o750})
+	applied, err := mod.Apply(context.Background(), mkdir)
+	if err != nil {
+		t.Fatalf("apply directory: %v", err)
+	}
+	assertMode(t, data, 0o750)
+	if again, err := mod.Check(context.Background(), mkdir); err != nil || again.Changed {
+		t.Fatalf("expected directory check to be clean, got %+v %v", again, err)
+	}
+
+	link := fileRequest("file.state", map[string]any{"path": current, "state": "link", "src": data})
+	if _, err := mod.Apply(context.Background(), link); err != nil {
+		t.Fatalf("apply link: %v", err)
+	}
+	if target, err := os.Readlink(current); err != nil || target != data {
+		t.Fatalf("expected link to %s, got %q %v", data, target, err)
+	}
+
+	remove := fileRequest("file.state", map[string]any{"path": current, "state": "absent"})
+	removed, err := mod.Apply(context.Background(), remove)
+	if err != nil {
+		t.Fatalf("apply absent: %v", err)
+	}
+	if _, err := os.Lstat(current); !os.IsNotExist(err) {
+		t.Fatalf("expected link to be removed, got %v", err)
+	}
+	remove.ApplyOutput = removed.Output
+	if _, err := mod.Rollback(context.Background(), remove); err != nil {
+		t.Fatalf("rollback absent: %v", err)
+	}
+	if target, err := os.Readlink(current); err != nil || target != data {
+		t.Fatalf("expected link restored to %s, got %q %v", data, target, err)
+	}
+
+	mkdir.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), mkdir); err != nil {
+		t.Fatalf("rollback directory: %v", err)
+	}
+	if _, err := os.Stat(data); !os.IsNotExist(err) {
+		t.Fatalf("expected directory to be removed, got %v", err)
+	}
+}
+
+func TestFetchToPerHostDir(t *testing.T) {
+	useBackupRoot(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "remote.log")
+	if err := os.WriteFile(src, []byte("line\n"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	collected := filepath.Join(dir, "collected") + "/"
+
+	mod := NewFetch()
+	req := fileRequest("file.fetch", map[string]any{"src": src, "dest": collected})
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	dest := filepath.Join(dir, "collected", "web1", "remote.log")
+	if applied.Output["dest"] != dest {
+		t.Fatalf("expected dest %s, got %v", dest, applied.Output["dest"])
+	}
+	assertContent(t, dest, "line\n")
+
+	missing := fileRequest("file.fetch", map[string]any{"src": filepath.Join(dir, "nope"), "dest": collected, "fail_on_missing": false})
+	if res, err := mod.Apply(context.Background(), missing); err != nil || res.Output["missing"] != true {
+		t.Fatalf("expected missing src to be tolerated, got %+v %v", res, err)
+	}
+}
+
+func TestDownloadVerifiesAndCachesByChecksum(t *testing.T) {
+	useBackupRoot(t)
+	artifact := []byte("release-1.2.3")
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		_, _ = w.Write(artifact)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	mod := &Download{cacheDir: filepath.Join(dir, "cache"), client: srv.Client()}
+	dest := filepath.Join(dir, "web1", "app.tar.gz")
+	req := fileRequest("file.download", map[string]any{
+		"url":    srv.URL + "/app.tar.gz",
+		"dest":   dest,
+		"sha256": "sha256:" + contentHash(artifact),
+	})
+
+	checked, err := mod.Check(context.Background(), req)
+	if err != nil || !checked.Changed {
+		t.Fatalf("expected missing dest to be reported, got %+v %v", checked, err)
+	}
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	assertContent(t, dest, string(artifact))
+	if applied.Output["cached"] != false {
+		t.Fatalf("expected first download to miss the cache, got %v", applied.Output)
+	}
+	if again, err := mod.Apply(context.Background(), req); err != nil || again.Changed {
+		t.Fatalf("expected matching dest to be unchanged, got %+v %v", again, err)
+	}
+
+	other := fileRequest("file.download", map[string]any{
+		"url":    srv.URL + "/app.tar.gz",
+		"dest":   filepath.Join(dir, "web2", "app.tar.gz"),
+		"sha256": contentHash(artifact),
+	})
+	second, err := mod.Apply(context.Background(), other)
+	if err != nil {
+		t.Fatalf("apply second host: %v", err)
+	}
+	if second.Output["cached"] != true || hits != 1 {
+		t.Fatalf("expected cache hit without a second download, got %v after %d hits", second.Output, hits)
+	}
+
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("expected downloaded file to be removed, got %v", err)
+	}
+}
+
+func TestDownloadSharesConcurrentFetches(t *testing.T) {
+	artifact := []byte("release-2.0.0")
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write(artifact)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	mod := &Download{cacheDir: filepath.Join(dir, "cache"), client: srv.Client()}
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := fileRequest("file.download", map[string]any{
+				"url":    srv.URL + "/app.tar.gz",
+				"dest":   filepath.Join(dir, fmt.Sprintf("web%d", i), "app.tar.gz"),
+				"sha256": contentHash(artifact),
+			})
+			_, errs[i] = mod.Apply(context.Background(), req)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("apply web%d: %v", i, err)
+		}
+		hostDir := filepath.Join(dir, fmt.Sprintf("web%d", i))
+		assertContent(t, filepath.Join(hostDir, "app.tar.gz"), string(artifact))
+		if entries, _ := os.ReadDir(hostDir); len(entries) != 1 {
+			t.Fatalf("expected no temp files left in %s, got %v", hostDir, entries)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("expected one download for all hosts, got %d", hits.Load())
+	}
+}
+
+func TestDownloadRejectsChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "artifact.bin")
+	if err := os.WriteFile(src, []byte("tampered"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	mod := &Download{cacheDir: filepath.Join(dir, "cache"), client: http.DefaultClient}
+	dest := filepath.Join(dir, "out.bin")
+	req := fileRequest("file.download", map[string]any{
+		"src":    src,
+		"dest":   dest,
+		"sha256": contentHash([]byte("original")),
+	})
+	if _, err := mod.Apply(context.Background(), req); err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("expected dest not to be written, got %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// Line implements file.line: it makes sure a single line is present in (or
+// absent from) a file on the target host without touching the rest of it.
+type Line struct{}
+
+func NewLine() *Line {
+	return &Line{}
+}
+
+type linePlan struct {
+	path    string
+	current pathStat
+	before  []byte
+	after   []byte
+	diff    map[string]any
+}
+
+func (m *Line) plan(ctx context.Context, adapter host.Adapter, req modules.Request) (linePlan, error) {
+	path, ok := readString(req, "path")
+	if !ok || path == "" {
+		return linePlan{}, fmt.Errorf("file.line requires args.path")
+	}
+	state, _ := readString(req, "state")
+	if state == "" {
+		state = "present"
+	}
+	if state != "present" && state != "absent" {
+		return linePlan{}, fmt.Errorf("file.line state must be present or absent")
+	}
+	line, hasLine := readString(req, "line")
+	if state == "present" && !hasLine {
+		return linePlan{}, fmt.Errorf("file.line requires args.line")
+	}
+	match, err := readRegexp(req, "regexp")
+	if err != nil {
+		return linePlan{}, err
+	}
+	if state == "absent" && !hasLine && match == nil {
+		return linePlan{}, fmt.Errorf("file.line state absent requires args.line or args.regexp")
+	}
+	after, err := readRegexp(req, "insert_after")
+	if err != nil {
+		return linePlan{}, err
+	}
+	before, err := readRegexp(req, "insert_before")
+	if err != nil {
+		return linePlan{}, err
+	}
+
+	cur, err := statPath(ctx, adapter, path)
+	if err != nil {
+		return linePlan{}, err
+	}
+	p := linePlan{path: path, current: cur, diff: map[string]any{}}
+	if cur.Exists {
+		if cur.Type != "file" {
+			return linePlan{}, fmt.Errorf("file.line path %s is a %s", path, cur.Type)
+		}
+		if p.before, err = adapter.ReadFile(path); err != nil {
+			return linePlan{}, err
+		}
+	} else if state == "present" && !readBool(req, "create") {
+		return linePlan{}, fmt.Errorf("file.line %s does not exist; set args.create to create it", path)
+	} else if state == "absent" {
+		return p, nil
+	}
+
+	lines := splitLines(string(p.before))
+	if state == "present" {
+		lines = ensureLine(lines, line, match, after, before)
+	} else {
+		lines = removeLines(lines, line, hasLine, match)
+	}
+	p.after = []byte(joinLines(lines))
+	if !cur.Exists || string(p.after) != string(p.before) {
+		p.diff["content"] = contentChange(path, p.before, cur.Exists, p.after)
+	}
+	return p, nil
+}
+
+func readRegexp(req modules.Request, key string) (*regexp.Regexp, error) {
+	pattern, ok := readString(req, key)
+	if !ok || pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("file.line invalid %s: %w", key, err)
+	}
+	return re, nil
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// ensureLine replaces the last line matching match, or leaves an identical
+// line alone, or inserts line after the last insertAfter match / before the
+// first insertBefore match, falling back to the end of the file.
+func ensureLine(lines []string, line string, match, insertAfter, insertBefore *regexp.Regexp) []string {
+	if match != nil {
+		for i := len(lines) - 1; i >= 0; i-- {
+			if match.MatchString(lines[i]) {
+				lines[i] = line
+				return lines
+			}
+		}
+	}
+	for _, existing := range lines {
+		if existing == line {
+			return lines
+		}
+	}
+	at := len(lines)
+	switch {
+	case insertAfter != nil:
+		for i := len(lines) - 1; i >= 0; i-- {
+			if insertAfter.MatchString(lines[i]) {
+				at = i + 1
+				break
+			}
+		}
+	case insertBefore != nil:
+		for i, existing := range lines {
+			if insertBefore.MatchString(existing) {
+				at = i
+				break
+			}
+		}
+	}
+	out := make([]string, 0, len(lines)+1)
+	out = append(out, lines[:at]...)
+	out = append(out, line)
+	return append(out, lines[at:]...)
+}
+
+func removeLines(lines []string, line string, hasLine bool, match *regexp.Regexp) []string {
+	out := make([]string, 0, len(lines))
+	for _, existing := range lines {
+		if match != nil && match.MatchString(existing) {
+			continue
+		}
+		if match == nil && hasLine && existing == line {
+			continue
+		}
+		out = append(out, existing)
+	}
+	return out
+}
+
+func (m *Line) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(ctx, modules.HostAdapter(req), req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed := len(p.diff) > 0
+	p.diff["path"] = p.path
+	return modules.Result{Changed: changed, Diff: p.diff}, nil
+}
+
+func (m *Line) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	adapter := modules.HostAdapter(req)
+	p, err := m.plan(ctx, adapter, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output := map[string]any{"path": p.path}
+	if len(p.diff) == 0 {
+		return modules.Result{Output: output}, nil
+	}
+
+	keep := p.current.Exists && readBool(req, "backup")
+	prev, err := snapshot(adapter, req.RunID, p.path, p.current, keep)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output["previous"] = prev
+	if keep {
+		output["backup"] = prev["backup"]
+	}
+	mode := os.FileMode(0o644)
+	if p.current.Exists {
+		mode = p.current.Mode.Perm()
+	} else if err := adapter.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return modules.Result{}, err
+	}
+	if err := adapter.WriteFile(p.path, p.after, mode); err != nil {
+		return modules.Result{}, err
+	}
+	output["diff"] = p.diff
+	return modules.Result{Changed: true, Output: output}, nil
+}
+
+func (m *Line) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	return rollbackOutput(ctx, modules.HostAdapter(req), "file.line", "path", req.ApplyOutput)
+}
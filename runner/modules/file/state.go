@@ -0,0 +1,184 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// State implements file.state: it ensures args.path is a file, directory,
+// symlink or absent, with the requested mode and ownership.
+type State struct{}
+
+func NewState() *State {
+	return &State{}
+}
+
+type statePlan struct {
+	path    string
+	state   string
+	target  string
+	attrs   attrs
+	current pathStat
+	diff    map[string]any
+}
+
+var fileStates = map[string]bool{"file": true, "directory": true, "link": true, "absent": true, "touch": true}
+
+func (m *State) plan(ctx context.Context, adapter host.Adapter, req modules.Request) (statePlan, error) {
+	path, ok := readString(req, "path")
+	if !ok || path == "" {
+		return statePlan{}, fmt.Errorf("file.state requires args.path")
+	}
+	state, _ := readString(req, "state")
+	if state == "" {
+		state = "file"
+	}
+	if !fileStates[state] {
+		return statePlan{}, fmt.Errorf("file.state unsupported state %q", state)
+	}
+	want, err := readAttrs(req, "file.state")
+	if err != nil {
+		return statePlan{}, err
+	}
+	cur, err := statPath(ctx, adapter, path)
+	if err != nil {
+		return statePlan{}, err
+	}
+
+	p := statePlan{path: path, state: state, attrs: want, current: cur, diff: map[string]any{}}
+	switch state {
+	case "absent":
+		if cur.Exists {
+			p.diff["state"] = change(cur.Type, "absent")
+		}
+		return p, nil
+	case "file":
+		if !cur.Exists {
+			return statePlan{}, fmt.Errorf("file.state %s does not exist; use state touch to create it", path)
+		}
+		if cur.Type != "file" {
+			return statePlan{}, fmt.Errorf("file.state %s is a %s", path, cur.Type)
+		}
+	case "touch":
+		if cur.Exists && cur.Type != "file" {
+			return statePlan{}, fmt.Errorf("file.state %s is a %s", path, cur.Type)
+		}
+		if !cur.Exists {
+			p.diff["state"] = change("absent", "file")
+		}
+	case "directory":
+		if cur.Exists && cur.Type != "directory" {
+			return statePlan{}, fmt.Errorf("file.state %s is a %s", path, cur.Type)
+		}
+		if !cur.Exists {
+			p.diff["state"] = change("absent", "directory")
+		}
+	case "link":
+		p.target, _ = readString(req, "src")
+		if p.target == "" {
+			return statePlan{}, fmt.Errorf("file.state link requires args.src")
+		}
+		if cur.Exists && cur.Type != "link" {
+			return statePlan{}, fmt.Errorf("file.state %s is a %s, not a link", path, cur.Type)
+		}
+		if cur.Target != p.target {
+			p.diff["target"] = change(cur.Target, p.target)
+		}
+		// The mode of a symlink is meaningless; only ownership applies.
+		want.hasMode = false
+		p.attrs = want
+	}
+	want.diff(cur, p.diff)
+	return p, nil
+}
+
+func (m *State) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(ctx, modules.HostAdapter(req), req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed := len(p.diff) > 0
+	p.diff["path"] = p.path
+	return modules.Result{Changed: changed, Diff: p.diff}, nil
+}
+
+func (m *State) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	adapter := modules.HostAdapter(req)
+	p, err := m.plan(ctx, adapter, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output := map[string]any{"path": p.path, "state": p.state}
+	if len(p.diff) == 0 {
+		return modules.Result{Output: output}, nil
+	}
+
+	// Removing a directory loses its contents, which the snapshot cannot
+	// carry; rollback of that case is refused instead of half-restored.
+	if p.state == "absent" && p.current.Type == "directory" {
+		output["removed_directory"] = true
+	} else {
+		prev, err := snapshot(adapter, req.RunID, p.path, p.current, false)
+		if err != nil {
+			return modules.Result{}, err
+		}
+		output["previous"] = prev
+	}
+
+	switch p.state {
+	case "absent":
+		if p.current.Type == "directory" {
+			res, err := adapter.Run(ctx, "rm", []string{"-rf", "--", p.path}, cLocale)
+			if err != nil {
+				return modules.Result{}, runError("rm", p.path, res, err)
+			}
+		} else if err := adapter.Remove(p.path); err != nil {
+			return modules.Result{}, err
+		}
+	case "touch":
+		if !p.current.Exists {
+			mode := os.FileMode(0o644)
+			if p.attrs.hasMode {
+				mode = p.attrs.mode
+			}
+			if err := adapter.WriteFile(p.path, nil, mode); err != nil {
+				return modules.Result{}, err
+			}
+		}
+	case "directory":
+		if !p.current.Exists {
+			mode := os.FileMode(0o755)
+			if p.attrs.hasMode {
+				mode = p.attrs.mode
+			}
+			if err := adapter.MkdirAll(p.path, mode); err != nil {
+				return modules.Result{}, err
+			}
+		}
+	case "link":
+		if p.current.Target != p.target {
+			if err := symlink(ctx, adapter, p.target, p.path); err != nil {
+				return modules.Result{}, err
+			}
+		}
+	}
+	if p.state != "absent" {
+		if err := p.attrs.apply(ctx, adapter, p.path, p.state == "link"); err != nil {
+			return modules.Result{}, err
+		}
+	}
+	output["diff"] = p.diff
+	return modules.Result{Changed: true, Output: output}, nil
+}
+
+func (m *State) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	if removed, _ := req.ApplyOutput["removed_directory"].(bool); removed {
+		path, _ := req.ApplyOutput["path"].(string)
+		return modules.Result{}, fmt.Errorf("file.state removed directory %s: %w", path, modules.ErrRollbackNotSupported)
+	}
+	return rollbackOutput(ctx, modules.HostAdapter(req), "file.state", "path", req.ApplyOutput)
+}
@@ -2,27 +2,65 @@ package modules
 
 import (
 	"context"
+	"errors"
 	"io"
 
+	"bops/internal/host"
 	"bops/runner/workflow"
 )
 
 type Request struct {
+	// RunID identifies the run the step belongs to; empty outside a tracked
+	// run.
+	RunID  string
 	Step   workflow.Step
 	Host   workflow.HostSpec
 	Vars   map[string]any
 	Stdout io.Writer
 	Stderr io.Writer
+	// Adapter executes commands and file operations on Host. Nil means the
+	// local machine.
+	Adapter host.Adapter
+	// ApplyOutput is the Output of the Apply being undone; only set for
+	// Rollback.
+	ApplyOutput map[string]any
 }
 
 type Result struct {
 	Changed bool
-	Diff    map[string]any
-	Output  map[string]any
+	// Diff describes what Check found. A {"before": x, "after": y} value,
+	// optionally with a unified "diff" text, is a change from the current x
+	// to the desired y; other values identify the resource.
+	Diff   map[string]any
+	Output map[string]any
 }
 
+// ErrRollbackNotSupported is returned by Rollback for actions that cannot be
+// undone.
+var ErrRollbackNotSupported = errors.New("rollback not supported")
+
 type Module interface {
 	Check(ctx context.Context, req Request) (Result, error)
 	Apply(ctx context.Context, req Request) (Result, error)
 	Rollback(ctx context.Context, req Request) (Result, error)
 }
+
+// HostAdapter returns the adapter attached to the request, falling back to
+// the local machine.
+func HostAdapter(req Request) host.Adapter {
+	if req.Adapter != nil {
+		return req.Adapter
+	}
+	return host.NewLocalAdapter()
+}
+
+// HostFact returns a top-level string fact gathered by facts.gather for the
+// request host, or "" when facts were not gathered.
+func HostFact(req Request, key string) string {
+	facts, ok := req.Vars["facts"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	value, _ := facts[key].(string)
+	return value
+}
@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"bops/internal/host"
+)
+
+type manager struct {
+	name       string
+	binary     string
+	queryCmd   []string
+	installCmd []string
+	upgradeCmd []string
+	removeCmd  []string
+	updateCmd  []string
+	// pinSep joins a package name and version; empty when the manager cannot
+	// install a specific version.
+	pinSep string
+}
+
+var managers = []manager{
+	{
+		name: "apt", binary: "apt-get",
+		queryCmd:   []string{"dpkg-query", "-W", "-f=${Status}\t${Version}\n"},
+		installCmd: []string{"apt-get", "install", "-y", "--allow-downgrades"},
+		upgradeCmd: []string{"apt-get", "install", "-y", "--only-upgrade"},
+		removeCmd:  []string{"apt-get", "remove", "-y"},
+		updateCmd:  []string{"apt-get", "update"},
+		pinSep:     "=",
+	},
+	{
+		name: "dnf", binary: "dnf",
+		queryCmd:   []string{"rpm", "-q", "--qf", "%{VERSION}-%{RELEASE}\n"},
+		installCmd: []string{"dnf", "install", "-y"},
+		upgradeCmd: []string{"dnf", "upgrade", "-y"},
+		removeCmd:  []string{"dnf", "remove", "-y"},
+		updateCmd:  []string{"dnf", "makecache"},
+		pinSep:     "-",
+	},
+	{
+		name: "yum", binary: "yum",
+		queryCmd:   []string{"rpm", "-q", "--qf", "%{VERSION}-%{RELEASE}\n"},
+		installCmd: []string{"yum", "install", "-y"},
+		upgradeCmd: []string{"yum", "update", "-y"},
+		removeCmd:  []string{"yum", "remove", "-y"},
+		updateCmd:  []string{"yum", "makecache"},
+		pinSep:     "-",
+	},
+	{
+		name: "apk", binary: "apk",
+		queryCmd:   []string{"apk", "list", "--installed"},
+		installCmd: []string{"apk", "add", "--no-cache"},
+		upgradeCmd: []string{"apk", "add", "--no-cache", "--upgrade"},
+		removeCmd:  []string{"apk", "del"},
+		updateCmd:  []string{"apk", "update"},
+		pinSep:     "=",
+	},
+	{
+		name: "pacman", binary: "pacman",
+		queryCmd:   []string{"pacman", "-Q"},
+		installCmd: []string{"pacman", "-S", "--noconfirm"},
+		upgradeCmd: []string{"pacman", "-S", "--noconfirm"},
+		removeCmd:  []string{"pacman", "-R", "--noconfirm"},
+		updateCmd:  []string{"pacman", "-Sy"},
+	},
+}
+
+// detectManager uses the package manager reported by facts.gather when the
+// host facts are known, and probes the host otherwise.
+func detectManager(adapter host.Adapter, preferred string) (manager, error) {
+	for _, mgr := range managers {
+		if preferred != "" && mgr.name == preferred {
+			return mgr, nil
+		}
+	}
+	for _, mgr := range managers {
+		if _, err := adapter.LookPath(mgr.binary); err == nil {
+			return mgr, nil
+		}
+	}
+	return manager{}, fmt.Errorf("no supported package manager found")
+}
+
+// installedVersion returns the installed version of name, or "" when the
+// package is not installed.
+func (m manager) installedVersion(ctx context.Context, adapter host.Adapter, name string) (string, error) {
+	res, err := adapter.Run(ctx, m.queryCmd[0], append(m.queryCmd[1:], name), cLocale)
+	if err != nil {
+		if res.ExitCode != 0 {
+			return "", nil
+		}
+		return "", err
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(res.Stdout), "\n")
+	switch m.name {
+	case "apt":
+		// Removed packages keep a "deinstall ok config-files" entry.
+		status, version, _ := strings.Cut(line, "\t")
+		if !strings.HasSuffix(status, " installed") {
+			return "", nil
+		}
+		return version, nil
+	case "apk":
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], name+"-") {
+			return "", nil
+		}
+		return strings.TrimPrefix(fields[0], name+"-"), nil
+	case "pacman":
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return "", nil
+		}
+		return fields[1], nil
+	default:
+		return line, nil
+	}
+}
+
+// outdated reports whether the repositories offer a newer version of an
+// installed package.
+func (m manager) outdated(ctx context.Context, adapter host.Adapter, name string) (bool, error) {
+	switch m.name {
+	case "apt":
+		res, err := adapter.Run(ctx, "apt-cache", []string{"policy", name}, cLocale)
+		if err != nil {
+			return false, runError("apt-cache", res, err)
+		}
+		var installed, candidate string
+		for _, line := range strings.Split(res.Stdout, "\n") {
+			line = strings.TrimSpace(line)
+			if value, ok := strings.CutPrefix(line, "Installed:"); ok {
+				installed = strings.TrimSpace(value)
+			}
+			if value, ok := strings.CutPrefix(line, "Candidate:"); ok {
+				candidate = strings.TrimSpace(value)
+			}
+		}
+		return candidate != "" && candidate != "(none)" && candidate != installed, nil
+	case "dnf", "yum":
+		// check-update exits 100 when updates are available.
+		res, err := adapter.Run(ctx, m.binary, []string{"-q", "check-update", name}, cLocale)
+		if err != nil {
+			if res.ExitCode == 100 {
+				return true, nil
+			}
+			return false, runError(m.binary, res, err)
+		}
+		return false, nil
+	case "apk":
+		res, err := adapter.Run(ctx, "apk", []string{"list", "--upgradable", name}, cLocale)
+		if err != nil {
+			return false, runError("apk", res, err)
+		}
+		return strings.TrimSpace(res.Stdout) != "", nil
+	case "pacman":
+		res, err := adapter.Run(ctx, "pacman", []string{"-Qu", name}, cLocale)
+		if err != nil {
+			if res.ExitCode == 1 {
+				return false, nil
+			}
+			return false, runError("pacman", res, err)
+		}
+		return strings.TrimSpace(res.Stdout) != "", nil
+	default:
+		return false, fmt.Errorf("unsupported package manager %s", m.name)
+	}
+}
+
+// pin returns the install spec for a specific version of name.
+func (m manager) pin(name, version string) (string, error) {
+	if version == "" {
+		return name, nil
+	}
+	if m.pinSep == "" {
+		return "", fmt.Errorf("%s does not support installing a specific version", m.name)
+	}
+	return name + m.pinSep + version, nil
+}
+
+func (m manager) run(ctx context.Context, adapter host.Adapter, command []string, packages []string) (string, string, error) {
+	res, err := adapter.Run(ctx, command[0], append(command[1:], packages...), host.RunOptions{})
+	return res.Stdout, res.Stderr, err
+}
+
+func (m manager) String() string {
+	return m.name
+}
+
+var cLocale = host.RunOptions{Env: []string{"LC_ALL=C"}}
+
+func runError(cmd string, res host.RunResult, err error) error {
+	if detail := strings.TrimSpace(res.Stderr); detail != "" {
+		return fmt.Errorf("%s: %s: %w", cmd, detail, err)
+	}
+	return fmt.Errorf("%s: %w", cmd, err)
+}
+
+// versionMatches reports whether an installed version satisfies a pin. A pin
+// matches the full version, the version without epoch, or a prefix ending at
+// the release separator ("1.24.0" matches "1:1.24.0-1ubuntu1"); "*" globs are
+// allowed as well.
+func versionMatches(installed, want string) bool {
+	if installed == "" {
+		return false
+	}
+	if installed == want {
+		return true
+	}
+	if _, rest, ok := strings.Cut(installed, ":"); ok && !strings.Contains(want, ":") {
+		installed = rest
+	}
+	if prefix, ok := strings.CutSuffix(want, "*"); ok {
+		return strings.HasPrefix(installed, prefix)
+	}
+	return installed == want || strings.HasPrefix(installed, want+"-")
+}
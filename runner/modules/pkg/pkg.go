@@ -1,173 +1,354 @@
 package pkg
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
 
+	"bops/internal/host"
 	"bops/runner/modules"
 )
 
+// Module implements pkg.install and pkg.remove, selected by the step action.
 type Module struct{}
 
 func New() *Module {
 	return &Module{}
 }
 
-func (m *Module) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
-	packages, err := readPackages(req)
+type pkgSpec struct {
+	name    string
+	version string
+}
+
+type pkgPlan struct {
+	action string
+	state  string
+	mgr    manager
+	// installs are install specs (pinned when a version is requested),
+	// upgrades and removes are package names.
+	installs []string
+	upgrades []string
+	removes  []string
+	missing  []string
+	previous map[string]any
+	diff     map[string]any
+}
+
+func (m *Module) plan(ctx context.Context, adapter host.Adapter, mgr manager, req modules.Request) (pkgPlan, error) {
+	action := strings.TrimSpace(req.Step.Action)
+	if action == "" {
+		action = "pkg.install"
+	}
+	if action != "pkg.install" && action != "pkg.remove" {
+		return pkgPlan{}, fmt.Errorf("unsupported pkg action %q", action)
+	}
+	specs, err := readPackages(req, action)
 	if err != nil {
-		return modules.Result{}, err
+		return pkgPlan{}, err
 	}
-
-	mgr, err := detectManager()
+	state, err := readState(req, action)
 	if err != nil {
-		return modules.Result{Changed: true}, nil
+		return pkgPlan{}, err
 	}
 
-	missing := []string{}
-	for _, name := range packages {
-		ok, err := mgr.isInstalled(ctx, name)
+	p := pkgPlan{action: action, state: state, mgr: mgr, missing: []string{}, previous: map[string]any{}, diff: map[string]any{}}
+	for _, spec := range specs {
+		current, err := mgr.installedVersion(ctx, adapter, spec.name)
 		if err != nil {
-			return modules.Result{}, err
+			return pkgPlan{}, err
 		}
-		if !ok {
-			missing = append(missing, name)
+		switch {
+		case state == "absent":
+			if current == "" {
+				continue
+			}
+			p.removes = append(p.removes, spec.name)
+			p.diff[spec.name] = change(current, "absent")
+		case current == "":
+			install, err := mgr.pin(spec.name, spec.version)
+			if err != nil {
+				return pkgPlan{}, err
+			}
+			p.installs = append(p.installs, install)
+			p.missing = append(p.missing, spec.name)
+			p.diff[spec.name] = change("", desired(spec, state))
+		case spec.version != "":
+			if versionMatches(current, spec.version) {
+				continue
+			}
+			install, err := mgr.pin(spec.name, spec.version)
+			if err != nil {
+				return pkgPlan{}, err
+			}
+			p.installs = append(p.installs, install)
+			p.diff[spec.name] = change(current, spec.version)
+		case state == "latest":
+			outdated, err := mgr.outdated(ctx, adapter, spec.name)
+			if err != nil {
+				return pkgPlan{}, err
+			}
+			if !outdated {
+				continue
+			}
+			p.upgrades = append(p.upgrades, spec.name)
+			p.diff[spec.name] = change(current, "latest")
+		default:
+			continue
 		}
+		p.previous[spec.name] = current
+	}
+	return p, nil
+}
+
+func desired(spec pkgSpec, state string) string {
+	if spec.version != "" {
+		return spec.version
+	}
+	if state == "latest" {
+		return "latest"
 	}
+	return "present"
+}
 
+func (m *Module) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	adapter := modules.HostAdapter(req)
+	mgr, err := detectManager(adapter, modules.HostFact(req, "pkg_manager"))
+	if err != nil {
+		return modules.Result{Changed: true}, nil
+	}
+	p, err := m.plan(ctx, adapter, mgr, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
 	return modules.Result{
-		Changed: len(missing) > 0,
+		Changed: len(p.diff) > 0,
 		Diff: map[string]any{
-			"missing": missing,
+			"missing":  p.missing,
+			"packages": p.diff,
 		},
 	}, nil
 }
 
 func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
-	packages, err := readPackages(req)
+	adapter := modules.HostAdapter(req)
+	mgr, err := detectManager(adapter, modules.HostFact(req, "pkg_manager"))
 	if err != nil {
 		return modules.Result{}, err
 	}
+	action := strings.TrimSpace(req.Step.Action)
+	if action == "" {
+		action = "pkg.install"
+	}
 
-	mgr, err := detectManager()
+	var stdout, stderr strings.Builder
+	output := map[string]any{"manager": mgr.name}
+	if readBool(req, "update_cache") {
+		out, errOut, err := mgr.run(ctx, adapter, mgr.updateCmd, nil)
+		stdout.WriteString(out)
+		stderr.WriteString(errOut)
+		if err != nil {
+			return modules.Result{}, fmt.Errorf("%s cache update failed: %w", action, err)
+		}
+		output["cache_updated"] = true
+	}
+
+	p, err := m.plan(ctx, adapter, mgr, req)
 	if err != nil {
 		return modules.Result{}, err
 	}
-
-	stdout, stderr, err := mgr.install(ctx, packages)
-	result := modules.Result{
-		Changed: true,
-		Output: map[string]any{
-			"stdout": stdout,
-			"stderr": stderr,
-		},
+	if len(p.diff) == 0 {
+		output["stdout"] = stdout.String()
+		output["stderr"] = stderr.String()
+		return modules.Result{Output: output}, nil
 	}
-	if err != nil {
-		return result, fmt.Errorf("pkg.install failed: %w", err)
+
+	// previous records the versions before the change so Rollback can put
+	// them back; "" means the package was not installed.
+	output["previous"] = p.previous
+	output["diff"] = p.diff
+	result := modules.Result{Changed: true, Output: output}
+	for _, batch := range []struct {
+		command  []string
+		packages []string
+	}{
+		{mgr.installCmd, p.installs},
+		{mgr.upgradeCmd, p.upgrades},
+		{mgr.removeCmd, p.removes},
+	} {
+		if len(batch.packages) == 0 {
+			continue
+		}
+		out, errOut, err := mgr.run(ctx, adapter, batch.command, batch.packages)
+		stdout.WriteString(out)
+		stderr.WriteString(errOut)
+		output["stdout"] = stdout.String()
+		output["stderr"] = stderr.String()
+		if err != nil {
+			return result, fmt.Errorf("%s failed: %w", p.action, err)
+		}
 	}
 	return result, nil
 }
 
+// Rollback reinstalls the versions recorded in the apply output and removes
+// packages the apply installed.
 func (m *Module) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
-	return modules.Result{}, fmt.Errorf("pkg.install rollback not supported")
-}
-
-type manager struct {
-	name       string
-	checkCmd   []string
-	installCmd []string
-}
-
-func detectManager() (manager, error) {
-	if path, err := exec.LookPath("apt-get"); err == nil {
-		_ = path
-		return manager{
-			name:       "apt",
-			checkCmd:   []string{"dpkg", "-s"},
-			installCmd: []string{"apt-get", "install", "-y"},
-		}, nil
-	}
-	if path, err := exec.LookPath("dnf"); err == nil {
-		_ = path
-		return manager{
-			name:       "dnf",
-			checkCmd:   []string{"rpm", "-q"},
-			installCmd: []string{"dnf", "install", "-y"},
-		}, nil
-	}
-	if path, err := exec.LookPath("yum"); err == nil {
-		_ = path
-		return manager{
-			name:       "yum",
-			checkCmd:   []string{"rpm", "-q"},
-			installCmd: []string{"yum", "install", "-y"},
-		}, nil
-	}
-	if path, err := exec.LookPath("apk"); err == nil {
-		_ = path
-		return manager{
-			name:       "apk",
-			checkCmd:   []string{"apk", "info", "-e"},
-			installCmd: []string{"apk", "add", "--no-cache"},
-		}, nil
-	}
-	if path, err := exec.LookPath("pacman"); err == nil {
-		_ = path
-		return manager{
-			name:       "pacman",
-			checkCmd:   []string{"pacman", "-Qi"},
-			installCmd: []string{"pacman", "-S", "--noconfirm"},
-		}, nil
-	}
-	return manager{}, fmt.Errorf("no supported package manager found")
-}
+	action := strings.TrimSpace(req.Step.Action)
+	if req.ApplyOutput == nil {
+		return modules.Result{}, fmt.Errorf("%s rollback requires the apply output", action)
+	}
+	previous, ok := req.ApplyOutput["previous"].(map[string]any)
+	if !ok {
+		return modules.Result{Output: map[string]any{}}, nil
+	}
+	adapter := modules.HostAdapter(req)
+	mgr, err := detectManager(adapter, modules.HostFact(req, "pkg_manager"))
+	if err != nil {
+		return modules.Result{}, err
+	}
 
-func (m manager) isInstalled(ctx context.Context, name string) (bool, error) {
-	cmd := exec.CommandContext(ctx, m.checkCmd[0], append(m.checkCmd[1:], name)...)
-	if err := cmd.Run(); err != nil {
-		if _, ok := err.(*exec.ExitError); ok {
-			return false, nil
+	names := make([]string, 0, len(previous))
+	for name := range previous {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var installs, removes []string
+	restored := map[string]any{}
+	for _, name := range names {
+		version := fmt.Sprint(previous[name])
+		current, err := mgr.installedVersion(ctx, adapter, name)
+		if err != nil {
+			return modules.Result{}, err
 		}
-		return false, err
+		switch {
+		case version == "" && current != "":
+			removes = append(removes, name)
+		case version != "" && current != version:
+			install, err := mgr.pin(name, version)
+			if err != nil {
+				return modules.Result{}, fmt.Errorf("%s rollback of %s: %w", action, name, modules.ErrRollbackNotSupported)
+			}
+			installs = append(installs, install)
+		default:
+			continue
+		}
+		restored[name] = change(current, version)
 	}
-	return true, nil
-}
 
-func (m manager) install(ctx context.Context, packages []string) (string, string, error) {
-	cmd := exec.CommandContext(ctx, m.installCmd[0], append(m.installCmd[1:], packages...)...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	return stdout.String(), stderr.String(), err
+	var stdout, stderr strings.Builder
+	for _, batch := range []struct {
+		command  []string
+		packages []string
+	}{
+		{mgr.installCmd, installs},
+		{mgr.removeCmd, removes},
+	} {
+		if len(batch.packages) == 0 {
+			continue
+		}
+		out, errOut, err := mgr.run(ctx, adapter, batch.command, batch.packages)
+		stdout.WriteString(out)
+		stderr.WriteString(errOut)
+		if err != nil {
+			return modules.Result{}, fmt.Errorf("%s rollback failed: %w", action, err)
+		}
+	}
+	return modules.Result{
+		Changed: len(restored) > 0,
+		Output: map[string]any{
+			"restored": restored,
+			"stdout":   stdout.String(),
+			"stderr":   stderr.String(),
+		},
+	}, nil
 }
 
-func readPackages(req modules.Request) ([]string, error) {
+// readPackages reads args.name or args.names. Entries may pin a version as
+// "name=1.2.3"; args.version pins a single args.name.
+func readPackages(req modules.Request, action string) ([]pkgSpec, error) {
 	if req.Step.Args == nil {
-		return nil, fmt.Errorf("pkg.install requires args.name or args.names")
+		return nil, fmt.Errorf("%s requires args.name or args.names", action)
 	}
 
+	var names []string
 	if name, ok := req.Step.Args["name"]; ok {
-		return []string{fmt.Sprint(name)}, nil
-	}
-	if raw, ok := req.Step.Args["names"]; ok {
-		switch names := raw.(type) {
+		names = []string{fmt.Sprint(name)}
+	} else if raw, ok := req.Step.Args["names"]; ok {
+		switch list := raw.(type) {
 		case []any:
-			result := make([]string, 0, len(names))
-			for _, item := range names {
-				result = append(result, fmt.Sprint(item))
+			for _, item := range list {
+				names = append(names, fmt.Sprint(item))
 			}
-			return result, nil
 		case []string:
-			return names, nil
+			names = list
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s requires args.name or args.names", action)
+	}
+
+	version, _ := readString(req, "version")
+	specs := make([]pkgSpec, 0, len(names))
+	for _, entry := range names {
+		name, pinned, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		name, pinned = strings.TrimSpace(name), strings.TrimSpace(pinned)
+		if name == "" {
+			return nil, fmt.Errorf("%s invalid package %q", action, entry)
+		}
+		if pinned == "" && len(names) == 1 {
+			pinned = version
+		}
+		if action == "pkg.remove" {
+			pinned = ""
 		}
+		specs = append(specs, pkgSpec{name: name, version: pinned})
 	}
+	return specs, nil
+}
+
+func readState(req modules.Request, action string) (string, error) {
+	if action == "pkg.remove" {
+		return "absent", nil
+	}
+	state, _ := readString(req, "state")
+	switch state {
+	case "":
+		return "present", nil
+	case "present", "latest":
+		return state, nil
+	default:
+		return "", fmt.Errorf("%s state must be present or latest", action)
+	}
+}
 
-	return nil, fmt.Errorf("pkg.install requires args.name or args.names")
+func readString(req modules.Request, key string) (string, bool) {
+	val, ok := req.Step.Args[key]
+	if !ok || val == nil {
+		return "", false
+	}
+	switch v := val.(type) {
+	case string:
+		return strings.TrimSpace(v), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+func readBool(req modules.Request, key string) bool {
+	switch v := req.Step.Args[key].(type) {
+	case bool:
+		return v
+	case string:
+		parsed, _ := strconv.ParseBool(strings.TrimSpace(v))
+		return parsed
+	default:
+		return false
+	}
 }
 
-func (m manager) String() string {
-	return m.name
+func change(before, after any) map[string]any {
+	return map[string]any{"before": before, "after": after}
 }
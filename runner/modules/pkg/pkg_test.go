@@ -0,0 +1,250 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+	"bops/runner/workflow"
+)
+
+// fakeApt is a host.Adapter emulating dpkg/apt over an in-memory package
+// set and file system, recording every command.
+type fakeApt struct {
+	installed  map[string]string
+	candidates map[string]string
+	files      map[string]string
+	calls      []string
+}
+
+func newFakeApt() *fakeApt {
+	return &fakeApt{
+		installed:  map[string]string{"curl": "7.81.0-1", "nginx": "1:1.18.0-6ubuntu14"},
+		candidates: map[string]string{"curl": "7.81.0-1ubuntu1.15", "nginx": "1:1.18.0-6ubuntu14", "jq": "1.6-2.1"},
+		files:      map[string]string{},
+	}
+}
+
+func (f *fakeApt) Run(ctx context.Context, cmd string, args []string, opts host.RunOptions) (host.RunResult, error) {
+	f.calls = append(f.calls, cmd+" "+strings.Join(args, " "))
+	last := args[len(args)-1]
+	switch {
+	case cmd == "dpkg-query":
+		version, ok := f.installed[last]
+		if !ok {
+			return host.RunResult{ExitCode: 1}, errors.New("exit status 1")
+		}
+		return host.RunResult{Stdout: "install ok installed\t" + version + "\n"}, nil
+	case cmd == "apt-cache":
+		return host.RunResult{Stdout: fmt.Sprintf("%s:\n  Installed: %s\n  Candidate: %s\n", last, f.installed[last], f.candidates[last])}, nil
+	case cmd == "apt-get" && args[0] == "install":
+		for _, spec := range args[2:] {
+			if strings.HasPrefix(spec, "-") {
+				continue
+			}
+			name, version, ok := strings.Cut(spec, "=")
+			if !ok {
+				version = f.candidates[name]
+			}
+			f.installed[name] = version
+		}
+	case cmd == "apt-get" && args[0] == "remove":
+		for _, name := range args[2:] {
+			delete(f.installed, name)
+		}
+	}
+	return host.RunResult{}, nil
+}
+
+func (f *fakeApt) ReadFile(path string) ([]byte, error) {
+	content, ok := f.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(content), nil
+}
+
+func (f *fakeApt) WriteFile(path string, data []byte, perm os.FileMode) error {
+	f.files[path] = string(data)
+	return nil
+}
+
+func (f *fakeApt) MkdirAll(path string, perm os.FileMode) error { return nil }
+
+func (f *fakeApt) Remove(path string) error {
+	if _, ok := f.files[path]; !ok {
+		return os.ErrNotExist
+	}
+	delete(f.files, path)
+	return nil
+}
+
+func (f *fakeApt) LookPath(file string) (string, error) {
+	return "", exec.ErrNotFound
+}
+
+func pkgRequest(adapter host.Adapter, action string, args map[string]any) modules.Request {
+	return modules.Request{
+		Step:    workflow.Step{Name: "packages", Action: action, Args: args},
+		Host:    workflow.HostSpec{Name: "web1"},
+		Vars:    map[string]any{"facts": map[string]any{"pkg_manager": "apt"}},
+		Adapter: adapter,
+	}
+}
+
+func TestInstallDiffsVersionsAndRollsBack(t *testing.T) {
+	fake := newFakeApt()
+	mod := New()
+	req := pkgRequest(fake, "pkg.install", map[string]any{
+		"names":        []any{"curl=7.81.0-1ubuntu1.15", "nginx=1.18.0", "jq"},
+		"update_cache": true,
+	})
+
+	checked, err := mod.Check(context.Background(), req)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	packages, _ := checked.Diff["packages"].(map[string]any)
+	if !checked.Changed || len(packages) != 2 || packages["nginx"] != nil {
+		t.Fatalf("expected curl and jq to differ and nginx to match its pin, got %v", checked.Diff)
+	}
+	if got := fmt.Sprint(checked.Diff["missing"]); got != "[jq]" {
+		t.Fatalf("expected jq missing, got %s", got)
+	}
+
+	fake.calls = nil
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if fake.calls[0] != "apt-get update" {
+		t.Fatalf("expected cache refresh first, got %v", fake.calls)
+	}
+	if fake.installed["curl"] != "7.81.0-1ubuntu1.15" || fake.installed["jq"] != "1.6-2.1" {
+		t.Fatalf("unexpected packages after apply: %v", fake.installed)
+	}
+	if again, err := mod.Check(context.Background(), req); err != nil || again.Changed {
+		t.Fatalf("expected second check to be unchanged, got %+v %v", again, err)
+	}
+
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if fake.installed["curl"] != "7.81.0-1" {
+		t.Fatalf("expected curl downgraded to previous version, got %q", fake.installed["curl"])
+	}
+	if _, ok := fake.installed["jq"]; ok {
+		t.Fatalf("expected jq removed on rollback")
+	}
+}
+
+func TestInstallLatestUpgradesOutdatedOnly(t *testing.T) {
+	fake := newFakeApt()
+	req := pkgRequest(fake, "pkg.install", map[string]any{"names": []any{"curl", "nginx"}, "state": "latest"})
+
+	applied, err := New().Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	previous, _ := applied.Output["previous"].(map[string]any)
+	if len(previous) != 1 || previous["curl"] != "7.81.0-1" {
+		t.Fatalf("expected only curl upgraded, got %v", applied.Output)
+	}
+	if last := fake.calls[len(fake.calls)-1]; last != "apt-get install -y --only-upgrade curl" {
+		t.Fatalf("unexpected upgrade command %q", last)
+	}
+}
+
+func TestRemoveRollbackReinstallsPreviousVersion(t *testing.T) {
+	fake := newFakeApt()
+	mod := New()
+	req := pkgRequest(fake, "pkg.remove", map[string]any{"names": []any{"nginx", "jq"}})
+
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if _, ok := fake.installed["nginx"]; ok || !applied.Changed {
+		t.Fatalf("expected nginx removed, got %+v", applied)
+	}
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if fake.installed["nginx"] != "1:1.18.0-6ubuntu14" {
+		t.Fatalf("expected nginx reinstalled at its previous version, got %v", fake.installed)
+	}
+}
+
+func TestRepoWritesAptSourceAndKey(t *testing.T) {
+	key := "-----BEGIN PGP PUBLIC KEY BLOCK-----\nabc\n-----END PGP PUBLIC KEY BLOCK-----\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(key))
+	}))
+	defer srv.Close()
+
+	fake := newFakeApt()
+	mod := &Repo{client: srv.Client()}
+	req := pkgRequest(fake, "pkg.repo", map[string]any{
+		"name":    "nodesource",
+		"repo":    "deb [arch=amd64] https://deb.nodesource.com/node_20.x nodistro main",
+		"key_url": srv.URL + "/key.asc",
+	})
+
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	list := fake.files["/etc/apt/sources.list.d/nodesource.list"]
+	if list != "deb [signed-by=/etc/apt/keyrings/nodesource.asc arch=amd64] https://deb.nodesource.com/node_20.x nodistro main\n" {
+		t.Fatalf("unexpected source list %q", list)
+	}
+	if fake.files["/etc/apt/keyrings/nodesource.asc"] != key {
+		t.Fatalf("expected key written, got %v", fake.files)
+	}
+	if fake.calls[len(fake.calls)-1] != "apt-get update" {
+		t.Fatalf("expected cache refresh, got %v", fake.calls)
+	}
+	if again, err := mod.Check(context.Background(), req); err != nil || again.Changed {
+		t.Fatalf("expected repo to be unchanged, got %+v %v", again, err)
+	}
+
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if len(fake.files) != 0 {
+		t.Fatalf("expected repo files removed, got %v", fake.files)
+	}
+}
+
+func TestRepoWritesYumRepoFile(t *testing.T) {
+	fake := newFakeApt()
+	req := pkgRequest(fake, "pkg.repo", map[string]any{
+		"name":         "docker-ce",
+		"baseurl":      "https://download.docker.com/linux/centos/9/x86_64/stable",
+		"key_url":      "https://download.docker.com/linux/centos/gpg",
+		"update_cache": false,
+	})
+	req.Vars = map[string]any{"facts": map[string]any{"pkg_manager": "dnf"}}
+
+	if _, err := NewRepo().Apply(context.Background(), req); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	want := "[docker-ce]\nname=docker-ce\nbaseurl=https://download.docker.com/linux/centos/9/x86_64/stable\nenabled=1\ngpgcheck=1\ngpgkey=https://download.docker.com/linux/centos/gpg\n"
+	if got := fake.files["/etc/yum.repos.d/docker-ce.repo"]; got != want {
+		t.Fatalf("unexpected repo file:\n%s", got)
+	}
+	if len(fake.calls) != 0 {
+		t.Fatalf("expected no commands without update_cache, got %v", fake.calls)
+	}
+}
@@ -0,0 +1,341 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+// Repo implements pkg.repo: it writes an apt source list or a yum/dnf .repo
+// file for args.name, plus its GPG key, and refreshes the package cache.
+type Repo struct {
+	client *http.Client
+}
+
+func NewRepo() *Repo {
+	return &Repo{client: http.DefaultClient}
+}
+
+var repoName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+type repoPlan struct {
+	name  string
+	state string
+	mgr   manager
+	// files maps each managed path to its desired content; nil means the
+	// file should not exist.
+	files   map[string][]byte
+	current map[string][]byte
+	diff    map[string]any
+}
+
+func (m *Repo) plan(ctx context.Context, adapter host.Adapter, req modules.Request) (repoPlan, error) {
+	name, _ := readString(req, "name")
+	if !repoName.MatchString(name) {
+		return repoPlan{}, fmt.Errorf("pkg.repo requires args.name made of letters, digits, '.', '_' or '-'")
+	}
+	state, _ := readString(req, "state")
+	if state == "" {
+		state = "present"
+	}
+	if state != "present" && state != "absent" {
+		return repoPlan{}, fmt.Errorf("pkg.repo state must be present or absent")
+	}
+	mgr, err := detectManager(adapter, modules.HostFact(req, "pkg_manager"))
+	if err != nil {
+		return repoPlan{}, err
+	}
+
+	p := repoPlan{name: name, state: state, mgr: mgr, files: map[string][]byte{}}
+	switch mgr.name {
+	case "apt":
+		err = m.planApt(ctx, &p, req)
+	case "dnf", "yum":
+		err = m.planYum(ctx, &p, req)
+	default:
+		err = fmt.Errorf("pkg.repo does not support %s", mgr.name)
+	}
+	if err != nil {
+		return repoPlan{}, err
+	}
+
+	p.current = map[string][]byte{}
+	p.diff = map[string]any{}
+	for file, want := range p.files {
+		data, err := adapter.ReadFile(file)
+		switch {
+		case err == nil:
+			p.current[file] = data
+		case !errors.Is(err, os.ErrNotExist):
+			return repoPlan{}, err
+		}
+		got, exists := p.current[file]
+		switch {
+		case want == nil && exists:
+			p.diff[file] = change("present", "absent")
+		case want != nil && !exists:
+			p.diff[file] = change("absent", "present")
+		case want != nil && !bytes.Equal(got, want):
+			p.diff[file] = change(describe(got), describe(want))
+		}
+	}
+	return p, nil
+}
+
+// describe shows text files as is and binary keys by size only.
+func describe(data []byte) string {
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return fmt.Sprintf("<%d bytes>", len(data))
+	}
+	return string(data)
+}
+
+func (m *Repo) planApt(ctx context.Context, p *repoPlan, req modules.Request) error {
+	listPath := "/etc/apt/sources.list.d/" + p.name + ".list"
+	keyBase := "/etc/apt/keyrings/" + p.name
+	if p.state == "absent" {
+		p.files[listPath] = nil
+		p.files[keyBase+".asc"] = nil
+		p.files[keyBase+".gpg"] = nil
+		return nil
+	}
+	repo, _ := readString(req, "repo")
+	if repo == "" {
+		return fmt.Errorf("pkg.repo requires args.repo, e.g. \"deb https://example.com/apt stable main\"")
+	}
+	key, err := m.readKey(ctx, req)
+	if err != nil {
+		return err
+	}
+	var keyPath string
+	if key != nil {
+		// apt needs the .asc extension for armored keys; drop the key under
+		// the other extension when the format changes.
+		stale := keyBase + ".asc"
+		keyPath = keyBase + ".gpg"
+		if bytes.Contains(key, []byte("-----BEGIN PGP")) {
+			keyPath, stale = stale, keyPath
+		}
+		p.files[keyPath] = key
+		p.files[stale] = nil
+	}
+
+	var lines []string
+	for _, line := range strings.Split(repo, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, signedBy(line, keyPath))
+		}
+	}
+	p.files[listPath] = []byte(strings.Join(lines, "\n") + "\n")
+	return nil
+}
+
+// signedBy adds signed-by=keyPath to a one-line apt source unless the line
+// already names a keyring.
+func signedBy(line, keyPath string) string {
+	if keyPath == "" || strings.Contains(line, "signed-by=") {
+		return line
+	}
+	kind, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+	if options, ok := strings.CutPrefix(rest, "["); ok {
+		return kind + " [signed-by=" + keyPath + " " + options
+	}
+	return kind + " [signed-by=" + keyPath + "] " + rest
+}
+
+func (m *Repo) planYum(ctx context.Context, p *repoPlan, req modules.Request) error {
+	repoPath := "/etc/yum.repos.d/" + p.name + ".repo"
+	keyPath := "/etc/pki/rpm-gpg/RPM-GPG-KEY-" + p.name
+	if p.state == "absent" {
+		p.files[repoPath] = nil
+		p.files[keyPath] = nil
+		return nil
+	}
+	baseurl, _ := readString(req, "baseurl")
+	mirrorlist, _ := readString(req, "mirrorlist")
+	if baseurl == "" && mirrorlist == "" {
+		return fmt.Errorf("pkg.repo requires args.baseurl or args.mirrorlist")
+	}
+	description, _ := readString(req, "description")
+	if description == "" {
+		description = p.name
+	}
+
+	// yum fetches a key URL itself; inline keys are written next to the
+	// distribution keys.
+	gpgkey, _ := readString(req, "key_url")
+	if inline, _ := readString(req, "key"); inline != "" {
+		p.files[keyPath] = []byte(strings.TrimSpace(inline) + "\n")
+		gpgkey = "file://" + keyPath
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]\nname=%s\n", p.name, description)
+	if baseurl != "" {
+		fmt.Fprintf(&b, "baseurl=%s\n", baseurl)
+	}
+	if mirrorlist != "" {
+		fmt.Fprintf(&b, "mirrorlist=%s\n", mirrorlist)
+	}
+	fmt.Fprintf(&b, "enabled=%s\n", flag(readBoolDefault(req, "enabled", true)))
+	fmt.Fprintf(&b, "gpgcheck=%s\n", flag(readBoolDefault(req, "gpgcheck", gpgkey != "")))
+	if gpgkey != "" {
+		fmt.Fprintf(&b, "gpgkey=%s\n", gpgkey)
+	}
+	p.files[repoPath] = []byte(b.String())
+	return nil
+}
+
+func flag(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+// readKey returns args.key, or args.key_url fetched on the controller, or
+// nil when neither is set.
+func (m *Repo) readKey(ctx context.Context, req modules.Request) ([]byte, error) {
+	if key, _ := readString(req, "key"); key != "" {
+		return []byte(key + "\n"), nil
+	}
+	url, _ := readString(req, "key_url")
+	if url == "" {
+		return nil, nil
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("pkg.repo key %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("pkg.repo key %s: unexpected status %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (m *Repo) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(ctx, modules.HostAdapter(req), req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed := len(p.diff) > 0
+	p.diff["name"] = p.name
+	return modules.Result{Changed: changed, Diff: p.diff}, nil
+}
+
+func (m *Repo) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	adapter := modules.HostAdapter(req)
+	p, err := m.plan(ctx, adapter, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output := map[string]any{"name": p.name, "state": p.state}
+	if len(p.diff) == 0 {
+		return modules.Result{Output: output}, nil
+	}
+
+	previous := map[string]any{}
+	for _, file := range sortedKeys(p.diff) {
+		if data, ok := p.current[file]; ok {
+			previous[file] = base64.StdEncoding.EncodeToString(data)
+		} else {
+			previous[file] = nil
+		}
+		if err := writeOrRemove(adapter, file, p.files[file]); err != nil {
+			return modules.Result{}, fmt.Errorf("pkg.repo failed: %w", err)
+		}
+	}
+	output["previous"] = previous
+	output["diff"] = p.diff
+	if readBoolDefault(req, "update_cache", true) {
+		if _, stderr, err := p.mgr.run(ctx, adapter, p.mgr.updateCmd, nil); err != nil {
+			return modules.Result{Changed: true, Output: output}, fmt.Errorf("pkg.repo cache update failed: %s: %w", strings.TrimSpace(stderr), err)
+		}
+	}
+	return modules.Result{Changed: true, Output: output}, nil
+}
+
+// Rollback puts back the repository and key files recorded in the apply
+// output and removes the ones it created.
+func (m *Repo) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	name, _ := req.ApplyOutput["name"].(string)
+	if name == "" {
+		return modules.Result{}, fmt.Errorf("pkg.repo rollback requires the apply output")
+	}
+	previous, ok := req.ApplyOutput["previous"].(map[string]any)
+	if !ok {
+		return modules.Result{Output: map[string]any{"name": name}}, nil
+	}
+	adapter := modules.HostAdapter(req)
+	for _, file := range sortedKeys(previous) {
+		var data []byte
+		if encoded, ok := previous[file].(string); ok {
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return modules.Result{}, fmt.Errorf("pkg.repo rollback %s: %w", file, err)
+			}
+			data = decoded
+		}
+		if err := writeOrRemove(adapter, file, data); err != nil {
+			return modules.Result{}, fmt.Errorf("pkg.repo rollback failed: %w", err)
+		}
+	}
+	if readBoolDefault(req, "update_cache", true) {
+		mgr, err := detectManager(adapter, modules.HostFact(req, "pkg_manager"))
+		if err != nil {
+			return modules.Result{}, err
+		}
+		if _, stderr, err := mgr.run(ctx, adapter, mgr.updateCmd, nil); err != nil {
+			return modules.Result{}, fmt.Errorf("pkg.repo rollback cache update failed: %s: %w", strings.TrimSpace(stderr), err)
+		}
+	}
+	return modules.Result{Changed: true, Output: map[string]any{"name": name, "restored": true}}, nil
+}
+
+func writeOrRemove(adapter host.Adapter, file string, data []byte) error {
+	if data == nil {
+		if err := adapter.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := adapter.MkdirAll(path.Dir(file), 0o755); err != nil {
+		return err
+	}
+	return adapter.WriteFile(file, data, 0o644)
+}
+
+func sortedKeys(values map[string]any) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func readBoolDefault(req modules.Request, key string, fallback bool) bool {
+	if _, ok := req.Step.Args[key]; !ok {
+		return fallback
+	}
+	return readBool(req, key)
+}
@@ -2,6 +2,7 @@ package modules
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -40,3 +41,15 @@ func (r *Registry) Get(action string) (Module, bool) {
 	module, ok := r.modules[key]
 	return module, ok
 }
+
+// Actions returns the registered action names in order.
+func (r *Registry) Actions() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	actions := make([]string, 0, len(r.modules))
+	for action := range r.modules {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
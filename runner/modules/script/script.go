@@ -1,14 +1,11 @@
 package script
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"os"
-	"os/exec"
 	"strings"
 
+	"bops/internal/host"
 	"bops/runner/modules"
 	"bops/runner/scriptstore"
 )
@@ -46,38 +43,33 @@ func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result
 		return modules.Result{}, err
 	}
 
-	var execCmd *exec.Cmd
+	var command string
+	var commandArgs []string
 	switch m.language {
 	case "shell":
-		execCmd = exec.CommandContext(ctx, "/bin/sh", append([]string{"-s", "--"}, args...)...)
+		command = "/bin/sh"
+		commandArgs = append([]string{"-s", "--"}, args...)
 	case "python":
-		execCmd = exec.CommandContext(ctx, "python3", append([]string{"-"}, args...)...)
+		command = "python3"
+		commandArgs = append([]string{"-"}, args...)
 	default:
 		return modules.Result{}, fmt.Errorf("unsupported script language: %s", m.language)
 	}
 
+	opts := host.RunOptions{
+		Stdin:  strings.NewReader(script),
+		Stdout: req.Stdout,
+		Stderr: req.Stderr,
+	}
 	if dir, ok := readString(req, "dir"); ok {
-		execCmd.Dir = dir
+		opts.Dir = dir
 	}
 	if env, ok := readEnv(req); ok {
-		execCmd.Env = append(os.Environ(), env...)
-	}
-
-	execCmd.Stdin = strings.NewReader(script)
-	var stdout, stderr bytes.Buffer
-	stdoutWriter := io.Writer(&stdout)
-	stderrWriter := io.Writer(&stderr)
-	if req.Stdout != nil {
-		stdoutWriter = io.MultiWriter(stdoutWriter, req.Stdout)
-	}
-	if req.Stderr != nil {
-		stderrWriter = io.MultiWriter(stderrWriter, req.Stderr)
+		opts.Env = env
 	}
-	execCmd.Stdout = stdoutWriter
-	execCmd.Stderr = stderrWriter
 
-	err = execCmd.Run()
-	stdoutText, stderrText := modules.ApplyOutputLimits(req, stdout.String(), stderr.String())
+	res, err := modules.HostAdapter(req).Run(ctx, command, commandArgs, opts)
+	stdoutText, stderrText := modules.ApplyOutputLimits(req, res.Stdout, res.Stderr)
 	result := modules.Result{
 		Changed: true,
 		Output: map[string]any{
@@ -97,7 +89,7 @@ func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result
 }
 
 func (m *Module) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
-	return modules.Result{}, fmt.Errorf("script.%s rollback not supported", m.language)
+	return modules.Result{}, fmt.Errorf("script.%s %w", m.language, modules.ErrRollbackNotSupported)
 }
 
 func readScript(store *scriptstore.Store, req modules.Request, expectedLanguage string) (string, error) {
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"bops/internal/host"
+)
+
+type manager struct {
+	name    string
+	adapter host.Adapter
+}
+
+// detectManager uses the service manager reported by facts.gather when the
+// host facts are known, and probes the host otherwise.
+func detectManager(adapter host.Adapter, preferred string) (manager, error) {
+	switch preferred {
+	case "systemctl", "service", "rc-service":
+		return manager{name: preferred, adapter: adapter}, nil
+	}
+	if _, err := adapter.LookPath("systemctl"); err == nil {
+		return manager{name: "systemctl", adapter: adapter}, nil
+	}
+	if _, err := adapter.LookPath("service"); err == nil {
+		return manager{name: "service", adapter: adapter}, nil
+	}
+	if _, err := adapter.LookPath("rc-service"); err == nil {
+		return manager{name: "rc-service", adapter: adapter}, nil
+	}
+	return manager{}, fmt.Errorf("no supported service manager found")
+}
+
+func (m manager) isActive(ctx context.Context, name string) (bool, error) {
+	var cmd string
+	var args []string
+	switch m.name {
+	case "systemctl":
+		cmd, args = "systemctl", []string{"is-active", name}
+	case "service":
+		cmd, args = "service", []string{name, "status"}
+	case "rc-service":
+		cmd, args = "rc-service", []string{name, "status"}
+	default:
+		return false, fmt.Errorf("unsupported service manager")
+	}
+	res, err := m.adapter.Run(ctx, cmd, args, host.RunOptions{})
+	if err != nil {
+		if res.ExitCode != 0 {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// isEnabled reports whether the service starts at boot. Only systemd and
+// OpenRC can answer this.
+func (m manager) isEnabled(ctx context.Context, name string) (bool, error) {
+	switch m.name {
+	case "systemctl":
+		res, err := m.adapter.Run(ctx, "systemctl", []string{"is-enabled", name}, cLocale)
+		if err != nil {
+			if res.ExitCode != 0 {
+				return false, nil
+			}
+			return false, err
+		}
+		// static, alias and indirect units cannot be toggled; treat them as
+		// enabled so they never show a change.
+		return strings.TrimSpace(res.Stdout) != "disabled", nil
+	case "rc-service":
+		res, err := m.adapter.Run(ctx, "rc-update", []string{"show", "default"}, cLocale)
+		if err != nil {
+			return false, err
+		}
+		for _, line := range strings.Split(res.Stdout, "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("args.enabled is not supported by the %s service manager", m.name)
+	}
+}
+
+func (m manager) setEnabled(ctx context.Context, name string, enabled bool) (string, string, error) {
+	var cmd string
+	var args []string
+	switch {
+	case m.name == "systemctl" && enabled:
+		cmd, args = "systemctl", []string{"enable", name}
+	case m.name == "systemctl":
+		cmd, args = "systemctl", []string{"disable", name}
+	case m.name == "rc-service" && enabled:
+		cmd, args = "rc-update", []string{"add", name, "default"}
+	case m.name == "rc-service":
+		cmd, args = "rc-update", []string{"del", name, "default"}
+	default:
+		return "", "", fmt.Errorf("args.enabled is not supported by the %s service manager", m.name)
+	}
+	res, err := m.adapter.Run(ctx, cmd, args, host.RunOptions{})
+	return res.Stdout, res.Stderr, err
+}
+
+// needsDaemonReload reports whether systemd saw unit files change on disk
+// since it last loaded them.
+func (m manager) needsDaemonReload(ctx context.Context, name string) (bool, error) {
+	if m.name != "systemctl" {
+		return false, nil
+	}
+	res, err := m.adapter.Run(ctx, "systemctl", []string{"show", "--property=NeedDaemonReload", name}, cLocale)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(res.Stdout) == "NeedDaemonReload=yes", nil
+}
+
+func (m manager) daemonReload(ctx context.Context) (string, string, error) {
+	res, err := m.adapter.Run(ctx, "systemctl", []string{"daemon-reload"}, host.RunOptions{})
+	return res.Stdout, res.Stderr, err
+}
+
+func (m manager) start(ctx context.Context, name string) (string, string, error) {
+	return m.run(ctx, name, "start")
+}
+
+func (m manager) stop(ctx context.Context, name string) (string, string, error) {
+	return m.run(ctx, name, "stop")
+}
+
+func (m manager) restart(ctx context.Context, name string) (string, string, error) {
+	return m.run(ctx, name, "restart")
+}
+
+func (m manager) reload(ctx context.Context, name string) (string, string, error) {
+	return m.run(ctx, name, "reload")
+}
+
+func (m manager) run(ctx context.Context, name, action string) (string, string, error) {
+	var cmd string
+	var args []string
+	switch m.name {
+	case "systemctl":
+		cmd, args = "systemctl", []string{action, name}
+	case "service":
+		cmd, args = "service", []string{name, action}
+	case "rc-service":
+		cmd, args = "rc-service", []string{name, action}
+	default:
+		return "", "", fmt.Errorf("unsupported service manager")
+	}
+
+	res, err := m.adapter.Run(ctx, cmd, args, host.RunOptions{})
+	return res.Stdout, res.Stderr, err
+}
+
+var cLocale = host.RunOptions{Env: []string{"LC_ALL=C"}}
@@ -1,10 +1,9 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"os/exec"
+	"strconv"
 	"strings"
 
 	"bops/runner/modules"
@@ -16,147 +15,209 @@ func New() *Module {
 	return &Module{}
 }
 
-func (m *Module) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+type ensurePlan struct {
+	name   string
+	state  string
+	active bool
+	// enabled is nil when args.enabled is not set.
+	enabled      *bool
+	wasEnabled   bool
+	daemonReload bool
+	diff         map[string]any
+}
+
+func (m *Module) plan(ctx context.Context, mgr manager, req modules.Request) (ensurePlan, error) {
 	name, err := readServiceName(req)
 	if err != nil {
-		return modules.Result{}, err
+		return ensurePlan{}, err
 	}
-
 	action := strings.TrimSpace(req.Step.Action)
-	mgr, err := detectManager()
-	if err != nil {
-		return modules.Result{}, err
-	}
-
+	p := ensurePlan{name: name, diff: map[string]any{}}
 	switch action {
 	case "service.restart":
-		return modules.Result{Changed: true}, nil
+		p.state = "restarted"
 	case "service.ensure":
-		desired := readDesiredState(req)
-		active, err := mgr.isActive(ctx, name)
-		if err != nil {
-			return modules.Result{}, err
+		if p.state, err = readDesiredState(req); err != nil {
+			return ensurePlan{}, err
+		}
+		if p.enabled, err = readEnabled(req); err != nil {
+			return ensurePlan{}, err
 		}
-		shouldChange := (desired == "started" && !active) || (desired == "stopped" && active)
-		return modules.Result{Changed: shouldChange}, nil
 	default:
-		return modules.Result{}, fmt.Errorf("unsupported service action %q", action)
+		return ensurePlan{}, fmt.Errorf("unsupported service action %q", action)
+	}
+
+	if p.active, err = mgr.isActive(ctx, name); err != nil {
+		return ensurePlan{}, err
 	}
+	current := "stopped"
+	if p.active {
+		current = "started"
+	}
+	switch {
+	case p.state == "restarted":
+		p.diff["state"] = change(current, "restarted")
+	case p.state == "reloaded" && p.active:
+		p.diff["state"] = change(current, "reloaded")
+	case p.state == "reloaded":
+		// A stopped service is started instead of reloaded.
+		p.diff["state"] = change(current, "started")
+	case p.state != current:
+		p.diff["state"] = change(current, p.state)
+	}
+
+	if p.enabled != nil {
+		if p.wasEnabled, err = mgr.isEnabled(ctx, name); err != nil {
+			return ensurePlan{}, err
+		}
+		if p.wasEnabled != *p.enabled {
+			p.diff["enabled"] = change(p.wasEnabled, *p.enabled)
+		}
+	}
+
+	p.daemonReload = readBool(req, "daemon_reload")
+	if !p.daemonReload {
+		if p.daemonReload, err = mgr.needsDaemonReload(ctx, name); err != nil {
+			return ensurePlan{}, err
+		}
+	}
+	if p.daemonReload {
+		p.diff["daemon_reload"] = true
+	}
+	return p, nil
 }
 
-func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
-	name, err := readServiceName(req)
+func (m *Module) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	mgr, err := detectManager(modules.HostAdapter(req), modules.HostFact(req, "service_manager"))
 	if err != nil {
 		return modules.Result{}, err
 	}
+	p, err := m.plan(ctx, mgr, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed := len(p.diff) > 0
+	p.diff["name"] = p.name
+	return modules.Result{Changed: changed, Diff: p.diff}, nil
+}
 
-	action := strings.TrimSpace(req.Step.Action)
-	mgr, err := detectManager()
+func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	mgr, err := detectManager(modules.HostAdapter(req), modules.HostFact(req, "service_manager"))
+	if err != nil {
+		return modules.Result{}, err
+	}
+	p, err := m.plan(ctx, mgr, req)
 	if err != nil {
 		return modules.Result{}, err
 	}
 
-	var stdout string
-	var stderr string
-	switch action {
-	case "service.restart":
-		stdout, stderr, err = mgr.restart(ctx, name)
-	case "service.ensure":
-		desired := readDesiredState(req)
-		if desired == "stopped" {
-			stdout, stderr, err = mgr.stop(ctx, name)
-		} else {
-			stdout, stderr, err = mgr.start(ctx, name)
-		}
-	default:
-		return modules.Result{}, fmt.Errorf("unsupported service action %q", action)
+	output := map[string]any{"previous_state": "stopped"}
+	if p.active {
+		output["previous_state"] = "started"
+	}
+	if p.enabled != nil {
+		output["previous_enabled"] = p.wasEnabled
+	}
+	if len(p.diff) == 0 {
+		return modules.Result{Output: output}, nil
 	}
 
-	result := modules.Result{
-		Changed: true,
-		Output: map[string]any{
-			"stdout": stdout,
-			"stderr": stderr,
-		},
+	var stdout, stderr strings.Builder
+	step := func(out, errOut string, err error) error {
+		stdout.WriteString(out)
+		stderr.WriteString(errOut)
+		return err
+	}
+	if p.daemonReload {
+		err = step(mgr.daemonReload(ctx))
 	}
+	if _, ok := p.diff["enabled"]; ok && err == nil {
+		err = step(mgr.setEnabled(ctx, p.name, *p.enabled))
+	}
+	if state, ok := p.diff["state"].(map[string]any); ok && err == nil {
+		switch state["after"] {
+		case "started":
+			err = step(mgr.start(ctx, p.name))
+		case "stopped":
+			err = step(mgr.stop(ctx, p.name))
+		case "restarted":
+			err = step(mgr.restart(ctx, p.name))
+		case "reloaded":
+			err = step(mgr.reload(ctx, p.name))
+		}
+	}
+
+	output["stdout"] = stdout.String()
+	output["stderr"] = stderr.String()
+	output["diff"] = p.diff
+	result := modules.Result{Changed: true, Output: output}
 	if err != nil {
 		return result, fmt.Errorf("service action failed: %w", err)
 	}
 	return result, nil
 }
 
+// Rollback puts back the active and enabled state recorded by the apply.
+// Restarts and reloads cannot be undone.
 func (m *Module) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
-	return modules.Result{}, fmt.Errorf("service rollback not supported")
-}
-
-type manager struct {
-	name string
-}
-
-func detectManager() (manager, error) {
-	if _, err := exec.LookPath("systemctl"); err == nil {
-		return manager{name: "systemctl"}, nil
+	action := strings.TrimSpace(req.Step.Action)
+	if action != "service.ensure" {
+		return modules.Result{}, fmt.Errorf("%s %w", action, modules.ErrRollbackNotSupported)
 	}
-	if _, err := exec.LookPath("service"); err == nil {
-		return manager{name: "service"}, nil
+	name, err := readServiceName(req)
+	if err != nil {
+		return modules.Result{}, err
 	}
-	if _, err := exec.LookPath("rc-service"); err == nil {
-		return manager{name: "rc-service"}, nil
+	previousState, _ := req.ApplyOutput["previous_state"].(string)
+	if previousState == "" {
+		return modules.Result{}, fmt.Errorf("service.ensure rollback requires the apply output")
 	}
-	return manager{}, fmt.Errorf("no supported service manager found")
-}
 
-func (m manager) isActive(ctx context.Context, name string) (bool, error) {
-	var cmd *exec.Cmd
-	switch m.name {
-	case "systemctl":
-		cmd = exec.CommandContext(ctx, "systemctl", "is-active", name)
-	case "service":
-		cmd = exec.CommandContext(ctx, "service", name, "status")
-	case "rc-service":
-		cmd = exec.CommandContext(ctx, "rc-service", name, "status")
-	default:
-		return false, fmt.Errorf("unsupported service manager")
+	mgr, err := detectManager(modules.HostAdapter(req), modules.HostFact(req, "service_manager"))
+	if err != nil {
+		return modules.Result{}, err
 	}
-	if err := cmd.Run(); err != nil {
-		if _, ok := err.(*exec.ExitError); ok {
-			return false, nil
+
+	var stdout, stderr strings.Builder
+	step := func(out, errOut string, err error) error {
+		stdout.WriteString(out)
+		stderr.WriteString(errOut)
+		return err
+	}
+	if previousEnabled, ok := req.ApplyOutput["previous_enabled"].(bool); ok {
+		enabled, err := mgr.isEnabled(ctx, name)
+		if err != nil {
+			return modules.Result{}, err
+		}
+		if enabled != previousEnabled {
+			err = step(mgr.setEnabled(ctx, name, previousEnabled))
+		}
+		if err != nil {
+			return modules.Result{}, fmt.Errorf("service rollback failed: %w", err)
 		}
-		return false, err
 	}
-	return true, nil
-}
-
-func (m manager) start(ctx context.Context, name string) (string, string, error) {
-	return m.run(ctx, name, "start")
-}
-
-func (m manager) stop(ctx context.Context, name string) (string, string, error) {
-	return m.run(ctx, name, "stop")
-}
-
-func (m manager) restart(ctx context.Context, name string) (string, string, error) {
-	return m.run(ctx, name, "restart")
-}
-
-func (m manager) run(ctx context.Context, name, action string) (string, string, error) {
-	var cmd *exec.Cmd
-	switch m.name {
-	case "systemctl":
-		cmd = exec.CommandContext(ctx, "systemctl", action, name)
-	case "service":
-		cmd = exec.CommandContext(ctx, "service", name, action)
-	case "rc-service":
-		cmd = exec.CommandContext(ctx, "rc-service", name, action)
-	default:
-		return "", "", fmt.Errorf("unsupported service manager")
+	active, err := mgr.isActive(ctx, name)
+	if err != nil {
+		return modules.Result{}, err
 	}
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	return stdout.String(), stderr.String(), err
+	switch {
+	case previousState == "stopped" && active:
+		err = step(mgr.stop(ctx, name))
+	case previousState == "started" && !active:
+		err = step(mgr.start(ctx, name))
+	}
+	result := modules.Result{
+		Changed: true,
+		Output: map[string]any{
+			"stdout": stdout.String(),
+			"stderr": stderr.String(),
+			"state":  previousState,
+		},
+	}
+	if err != nil {
+		return result, fmt.Errorf("service rollback failed: %w", err)
+	}
+	return result, nil
 }
 
 func readServiceName(req modules.Request) (string, error) {
@@ -170,17 +231,51 @@ func readServiceName(req modules.Request) (string, error) {
 	return fmt.Sprint(name), nil
 }
 
-func readDesiredState(req modules.Request) string {
-	if req.Step.Args == nil {
-		return "started"
-	}
+func readDesiredState(req modules.Request) (string, error) {
 	state, ok := req.Step.Args["state"]
 	if !ok {
-		return "started"
+		return "started", nil
 	}
 	value := strings.ToLower(strings.TrimSpace(fmt.Sprint(state)))
-	if value == "stopped" {
-		return "stopped"
+	switch value {
+	case "", "started":
+		return "started", nil
+	case "stopped", "restarted", "reloaded":
+		return value, nil
+	default:
+		return "", fmt.Errorf("service.ensure state must be started, stopped, restarted or reloaded")
 	}
-	return "started"
+}
+
+func readEnabled(req modules.Request) (*bool, error) {
+	raw, ok := req.Step.Args["enabled"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	switch v := raw.(type) {
+	case bool:
+		return &v, nil
+	default:
+		parsed, err := strconv.ParseBool(strings.TrimSpace(fmt.Sprint(v)))
+		if err != nil {
+			return nil, fmt.Errorf("service args.enabled must be true or false")
+		}
+		return &parsed, nil
+	}
+}
+
+func readBool(req modules.Request, key string) bool {
+	switch v := req.Step.Args[key].(type) {
+	case bool:
+		return v
+	case string:
+		parsed, _ := strconv.ParseBool(strings.TrimSpace(v))
+		return parsed
+	default:
+		return false
+	}
+}
+
+func change(before, after any) map[string]any {
+	return map[string]any{"before": before, "after": after}
 }
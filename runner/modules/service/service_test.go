@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+	"bops/runner/workflow"
+)
+
+// fakeSystemd is a host.Adapter that tracks a single service's active and
+// enabled state plus the unit files written to it.
+type fakeSystemd struct {
+	active     bool
+	enabled    bool
+	needReload bool
+	files      map[string]string
+	calls      []string
+}
+
+func (f *fakeSystemd) Run(ctx context.Context, cmd string, args []string, opts host.RunOptions) (host.RunResult, error) {
+	f.calls = append(f.calls, cmd+" "+strings.Join(args, " "))
+	switch args[0] {
+	case "is-active":
+		if !f.active {
+			return host.RunResult{ExitCode: 3}, errors.New("exit status 3")
+		}
+	case "is-enabled":
+		if !f.enabled {
+			return host.RunResult{Stdout: "disabled\n", ExitCode: 1}, errors.New("exit status 1")
+		}
+		return host.RunResult{Stdout: "enabled\n"}, nil
+	case "show":
+		if f.needReload {
+			return host.RunResult{Stdout: "NeedDaemonReload=yes\n"}, nil
+		}
+		return host.RunResult{Stdout: "NeedDaemonReload=no\n"}, nil
+	case "daemon-reload":
+		f.needReload = false
+	case "enable":
+		f.enabled = true
+	case "disable":
+		f.enabled = false
+	case "start":
+		f.active = true
+	case "stop":
+		f.active = false
+	}
+	return host.RunResult{}, nil
+}
+
+func (f *fakeSystemd) ReadFile(path string) ([]byte, error) {
+	content, ok := f.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(content), nil
+}
+
+func (f *fakeSystemd) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if f.files == nil {
+		f.files = map[string]string{}
+	}
+	f.files[path] = string(data)
+	return nil
+}
+
+func (f *fakeSystemd) MkdirAll(path string, perm os.FileMode) error { return nil }
+
+func (f *fakeSystemd) Remove(path string) error {
+	delete(f.files, path)
+	return nil
+}
+
+func (f *fakeSystemd) LookPath(file string) (string, error) {
+	if file == "systemctl" {
+		return "/usr/bin/systemctl", nil
+	}
+	return "", exec.ErrNotFound
+}
+
+func serviceRequest(adapter host.Adapter, action string, args map[string]any) modules.Request {
+	return modules.Request{
+		Step:    workflow.Step{Name: "service", Action: action, Args: args},
+		Adapter: adapter,
+	}
+}
+
+func TestEnsureRollbackRestoresPreviousState(t *testing.T) {
+	adapter := &fakeSystemd{active: false}
+	req := modules.Request{
+		Step: workflow.Step{
+			Name:   "start nginx",
+			Action: "service.ensure",
+			Args:   map[string]any{"name": "nginx", "state": "started"},
+		},
+		Adapter: adapter,
+	}
+	mod := New()
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !adapter.active {
+		t.Fatalf("expected service to be started")
+	}
+	if applied.Output["previous_state"] != "stopped" {
+		t.Fatalf("expected previous_state stopped, got %v", applied.Output["previous_state"])
+	}
+
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if adapter.active {
+		t.Fatalf("expected service to be stopped again, calls: %v", adapter.calls)
+	}
+}
+
+func TestRestartRollbackNotSupported(t *testing.T) {
+	req := modules.Request{
+		Step: workflow.Step{
+			Name:   "restart nginx",
+			Action: "service.restart",
+			Args:   map[string]any{"name": "nginx"},
+		},
+		Adapter: &fakeSystemd{},
+	}
+	_, err := New().Rollback(context.Background(), req)
+	if !errors.Is(err, modules.ErrRollbackNotSupported) {
+		t.Fatalf("expected ErrRollbackNotSupported, got %v", err)
+	}
+}
+
+func TestEnsureDiffsActiveAndEnabledState(t *testing.T) {
+	adapter := &fakeSystemd{active: true}
+	mod := New()
+	req := serviceRequest(adapter, "service.ensure", map[string]any{"name": "nginx", "state": "started", "enabled": true})
+
+	checked, err := mod.Check(context.Background(), req)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !checked.Changed || checked.Diff["state"] != nil || checked.Diff["enabled"] == nil {
+		t.Fatalf("expected only enabled to differ, got %v", checked.Diff)
+	}
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !adapter.enabled {
+		t.Fatalf("expected service enabled, calls: %v", adapter.calls)
+	}
+	for _, call := range adapter.calls {
+		if call == "systemctl start nginx" {
+			t.Fatalf("expected running service not to be started again")
+		}
+	}
+	if again, err := mod.Check(context.Background(), req); err != nil || again.Changed {
+		t.Fatalf("expected no changes after apply, got %+v %v", again, err)
+	}
+
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if adapter.enabled || !adapter.active {
+		t.Fatalf("expected enabled state restored only, got enabled=%v active=%v", adapter.enabled, adapter.active)
+	}
+}
+
+func TestEnsureReloadsAndRunsPendingDaemonReload(t *testing.T) {
+	adapter := &fakeSystemd{active: true, enabled: true, needReload: true}
+	req := serviceRequest(adapter, "service.ensure", map[string]any{"name": "nginx", "state": "reloaded"})
+
+	checked, err := New().Check(context.Background(), req)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if checked.Diff["daemon_reload"] != true {
+		t.Fatalf("expected pending daemon-reload in diff, got %v", checked.Diff)
+	}
+	adapter.calls = nil
+	if _, err := New().Apply(context.Background(), req); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	want := []string{"systemctl daemon-reload", "systemctl reload nginx"}
+	if got := adapter.calls[len(adapter.calls)-2:]; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, adapter.calls)
+	}
+}
+
+func TestUnitInstallsReloadsAndRollsBack(t *testing.T) {
+	adapter := &fakeSystemd{}
+	mod := NewUnit()
+	req := serviceRequest(adapter, "service.unit", map[string]any{
+		"name":    "app",
+		"content": "[Service]\nExecStart=/opt/app/bin/app\n",
+		"enabled": true,
+	})
+
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if adapter.files["/etc/systemd/system/app.service"] != "[Service]\nExecStart=/opt/app/bin/app\n" {
+		t.Fatalf("expected unit file written, got %v", adapter.files)
+	}
+	if !adapter.enabled || applied.Output["daemon_reloaded"] != true {
+		t.Fatalf("expected daemon-reload and enable, calls: %v", adapter.calls)
+	}
+	if again, err := mod.Check(context.Background(), req); err != nil || again.Changed {
+		t.Fatalf("expected unit to be unchanged, got %+v %v", again, err)
+	}
+
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if _, ok := adapter.files["/etc/systemd/system/app.service"]; ok || adapter.enabled {
+		t.Fatalf("expected unit removed and disabled, calls: %v", adapter.calls)
+	}
+}
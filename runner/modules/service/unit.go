@@ -0,0 +1,252 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"bops/runner/modules"
+)
+
+// Unit implements service.unit: it renders args.src (or takes args.content)
+// into a systemd unit file, runs daemon-reload when the file changes and
+// optionally enables the unit.
+type Unit struct{}
+
+func NewUnit() *Unit {
+	return &Unit{}
+}
+
+const defaultUnitDir = "/etc/systemd/system"
+
+type unitPlan struct {
+	name    string
+	path    string
+	state   string
+	content []byte
+	current []byte
+	exists  bool
+	enabled *bool
+	// wasEnabled is only read when enabled is set and the unit exists.
+	wasEnabled bool
+	diff       map[string]any
+}
+
+func (m *Unit) plan(ctx context.Context, mgr manager, req modules.Request) (unitPlan, error) {
+	if mgr.name != "systemctl" {
+		return unitPlan{}, fmt.Errorf("service.unit requires systemd, found %s", mgr.name)
+	}
+	name, err := readServiceName(req)
+	if err != nil {
+		return unitPlan{}, err
+	}
+	if strings.ContainsAny(name, "/ \t\n") {
+		return unitPlan{}, fmt.Errorf("service.unit invalid unit name %q", name)
+	}
+	if !strings.Contains(name, ".") {
+		name += ".service"
+	}
+	dir := defaultUnitDir
+	if value, _ := req.Step.Args["dir"].(string); strings.TrimSpace(value) != "" {
+		dir = strings.TrimSpace(value)
+	}
+	p := unitPlan{name: name, path: path.Join(dir, name), state: "present", diff: map[string]any{}}
+	if value, _ := req.Step.Args["state"].(string); value != "" {
+		p.state = strings.TrimSpace(value)
+	}
+	if p.state != "present" && p.state != "absent" {
+		return unitPlan{}, fmt.Errorf("service.unit state must be present or absent")
+	}
+	if p.enabled, err = readEnabled(req); err != nil {
+		return unitPlan{}, err
+	}
+
+	data, err := mgr.adapter.ReadFile(p.path)
+	switch {
+	case err == nil:
+		p.current, p.exists = data, true
+	case !errors.Is(err, os.ErrNotExist):
+		return unitPlan{}, err
+	}
+
+	if p.state == "absent" {
+		if p.exists {
+			p.diff["state"] = change("present", "absent")
+		}
+		return p, nil
+	}
+	if p.content, err = renderUnit(req); err != nil {
+		return unitPlan{}, err
+	}
+	if !bytes.Equal(p.current, p.content) || !p.exists {
+		p.diff["content"] = change(string(p.current), string(p.content))
+	}
+	if p.enabled != nil {
+		if p.exists {
+			if p.wasEnabled, err = mgr.isEnabled(ctx, name); err != nil {
+				return unitPlan{}, err
+			}
+		}
+		if p.wasEnabled != *p.enabled {
+			p.diff["enabled"] = change(p.wasEnabled, *p.enabled)
+		}
+	}
+	return p, nil
+}
+
+// renderUnit renders args.src with the run vars plus args.vars, the same way
+// template.render does, or returns args.content as is.
+func renderUnit(req modules.Request) ([]byte, error) {
+	content, hasContent := req.Step.Args["content"].(string)
+	src, _ := req.Step.Args["src"].(string)
+	if hasContent == (src != "") {
+		return nil, fmt.Errorf("service.unit requires exactly one of args.src or args.content")
+	}
+	if hasContent {
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		return []byte(content), nil
+	}
+	raw, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := template.New(filepath.Base(src)).Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]any{}
+	for k, v := range req.Vars {
+		vars[k] = v
+	}
+	if overlay, ok := req.Step.Args["vars"].(map[string]any); ok {
+		for k, v := range overlay {
+			vars[k] = v
+		}
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, vars); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *Unit) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	mgr, err := detectManager(modules.HostAdapter(req), modules.HostFact(req, "service_manager"))
+	if err != nil {
+		return modules.Result{}, err
+	}
+	p, err := m.plan(ctx, mgr, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed := len(p.diff) > 0
+	p.diff["name"] = p.name
+	p.diff["path"] = p.path
+	return modules.Result{Changed: changed, Diff: p.diff}, nil
+}
+
+func (m *Unit) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	mgr, err := detectManager(modules.HostAdapter(req), modules.HostFact(req, "service_manager"))
+	if err != nil {
+		return modules.Result{}, err
+	}
+	p, err := m.plan(ctx, mgr, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output := map[string]any{"name": p.name, "path": p.path}
+	if len(p.diff) == 0 {
+		return modules.Result{Output: output}, nil
+	}
+
+	// The previous unit travels with the output so Rollback can restore it.
+	output["previous_exists"] = p.exists
+	if p.exists {
+		output["previous_content"] = string(p.current)
+	}
+	if p.enabled != nil {
+		output["previous_enabled"] = p.wasEnabled
+	}
+	output["diff"] = p.diff
+
+	var stdout, stderr strings.Builder
+	step := func(out, errOut string, err error) error {
+		stdout.WriteString(out)
+		stderr.WriteString(errOut)
+		return err
+	}
+	adapter := mgr.adapter
+	_, contentChanged := p.diff["content"]
+	switch {
+	case p.state == "absent":
+		err = adapter.Remove(p.path)
+	case contentChanged:
+		if err = adapter.MkdirAll(path.Dir(p.path), 0o755); err == nil {
+			err = adapter.WriteFile(p.path, p.content, 0o644)
+		}
+	}
+	if err == nil && (p.state == "absent" || contentChanged) {
+		err = step(mgr.daemonReload(ctx))
+		output["daemon_reloaded"] = err == nil
+	}
+	if _, ok := p.diff["enabled"]; ok && err == nil {
+		err = step(mgr.setEnabled(ctx, p.name, *p.enabled))
+	}
+	output["stdout"] = stdout.String()
+	output["stderr"] = stderr.String()
+	result := modules.Result{Changed: true, Output: output}
+	if err != nil {
+		return result, fmt.Errorf("service.unit failed: %w", err)
+	}
+	return result, nil
+}
+
+// Rollback restores or removes the unit file, reloads systemd and puts the
+// enabled state back.
+func (m *Unit) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	unitPath, _ := req.ApplyOutput["path"].(string)
+	name, _ := req.ApplyOutput["name"].(string)
+	existed, ok := req.ApplyOutput["previous_exists"].(bool)
+	if unitPath == "" || name == "" {
+		return modules.Result{}, fmt.Errorf("service.unit rollback requires the apply output")
+	}
+	if !ok {
+		return modules.Result{Output: map[string]any{"name": name, "path": unitPath}}, nil
+	}
+	mgr, err := detectManager(modules.HostAdapter(req), modules.HostFact(req, "service_manager"))
+	if err != nil {
+		return modules.Result{}, err
+	}
+
+	if _, ok := req.ApplyOutput["previous_enabled"].(bool); ok && !existed {
+		// Disable before the unit file disappears so systemd drops its links.
+		if _, _, err := mgr.setEnabled(ctx, name, false); err != nil {
+			return modules.Result{}, fmt.Errorf("service.unit rollback failed: %w", err)
+		}
+	}
+	adapter := mgr.adapter
+	if existed {
+		previous, _ := req.ApplyOutput["previous_content"].(string)
+		err = adapter.WriteFile(unitPath, []byte(previous), 0o644)
+	} else if err = adapter.Remove(unitPath); errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err == nil {
+		_, _, err = mgr.daemonReload(ctx)
+	}
+	if previousEnabled, ok := req.ApplyOutput["previous_enabled"].(bool); ok && existed && err == nil {
+		_, _, err = mgr.setEnabled(ctx, name, previousEnabled)
+	}
+	if err != nil {
+		return modules.Result{}, fmt.Errorf("service.unit rollback failed: %w", err)
+	}
+	return modules.Result{Changed: true, Output: map[string]any{"name": name, "path": unitPath, "restored": true}}, nil
+}
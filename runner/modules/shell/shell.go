@@ -1,14 +1,11 @@
 package shell
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"os"
-	"os/exec"
 	"strings"
 
+	"bops/internal/host"
 	"bops/runner/modules"
 )
 
@@ -37,29 +34,20 @@ func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result
 		return modules.Result{}, err
 	}
 
-	execCmd := exec.CommandContext(ctx, "/bin/sh", "-s", "--")
+	opts := host.RunOptions{
+		Stdin:  strings.NewReader(script),
+		Stdout: req.Stdout,
+		Stderr: req.Stderr,
+	}
 	if dir, ok := readString(req, "dir"); ok {
-		execCmd.Dir = dir
+		opts.Dir = dir
 	}
 	if env, ok := readEnv(req); ok {
-		execCmd.Env = append(os.Environ(), env...)
-	}
-
-	execCmd.Stdin = strings.NewReader(script)
-	var stdout, stderr bytes.Buffer
-	stdoutWriter := io.Writer(&stdout)
-	stderrWriter := io.Writer(&stderr)
-	if req.Stdout != nil {
-		stdoutWriter = io.MultiWriter(stdoutWriter, req.Stdout)
-	}
-	if req.Stderr != nil {
-		stderrWriter = io.MultiWriter(stderrWriter, req.Stderr)
+		opts.Env = env
 	}
-	execCmd.Stdout = stdoutWriter
-	execCmd.Stderr = stderrWriter
 
-	err = execCmd.Run()
-	stdoutText, stderrText := modules.ApplyOutputLimits(req, stdout.String(), stderr.String())
+	res, err := modules.HostAdapter(req).Run(ctx, "/bin/sh", []string{"-s", "--"}, opts)
+	stdoutText, stderrText := modules.ApplyOutputLimits(req, res.Stdout, res.Stderr)
 	result := modules.Result{
 		Changed: true,
 		Output: map[string]any{
@@ -79,7 +67,7 @@ func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result
 }
 
 func (m *Module) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
-	return modules.Result{}, fmt.Errorf("shell.run rollback not supported")
+	return modules.Result{}, fmt.Errorf("shell.run %w", modules.ErrRollbackNotSupported)
 }
 
 func readScript(req modules.Request) (string, error) {
@@ -3,6 +3,8 @@ package template
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,6 +12,7 @@ import (
 	"text/template"
 
 	"bops/runner/modules"
+	"bops/runner/modules/file"
 )
 
 type Module struct{}
@@ -24,23 +27,35 @@ func (m *Module) Check(ctx context.Context, req modules.Request) (modules.Result
 		return modules.Result{}, err
 	}
 
-	current, err := os.ReadFile(dest)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return modules.Result{Changed: true}, nil
-		}
+	current, err := modules.HostAdapter(req).ReadFile(dest)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
 		return modules.Result{}, err
 	}
 
-	changed := !bytes.Equal(current, rendered)
+	changed := !exists || !bytes.Equal(current, rendered)
+	diff := map[string]any{"dest": dest}
+	if changed {
+		content := map[string]any{"before": "", "after": contentHash(rendered)}
+		if exists {
+			content["before"] = contentHash(current)
+		}
+		if text := modules.UnifiedDiff(dest, current, rendered); text != "" {
+			content["diff"] = text
+		}
+		diff["content"] = content
+	}
 	return modules.Result{
 		Changed: changed,
-		Diff: map[string]any{
-			"dest": dest,
-		},
+		Diff:    diff,
 	}, nil
 }
 
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
 	rendered, dest, err := renderTemplate(req)
 	if err != nil {
@@ -52,24 +67,40 @@ func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result
 		mode = rawMode
 	}
 
-	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+	adapter := modules.HostAdapter(req)
+	current, err := adapter.ReadFile(dest)
+	if err != nil && !os.IsNotExist(err) {
 		return modules.Result{}, err
 	}
+	output := map[string]any{"dest": dest}
+	if err == nil && bytes.Equal(current, rendered) {
+		return modules.Result{Output: output}, nil
+	}
 
-	if err := os.WriteFile(dest, rendered, mode); err != nil {
+	// The previous state of dest is backed up on the same host the way the
+	// file modules do it; the output only records where, so Rollback works
+	// even when the apply ran on a remote agent.
+	prev, err := file.Snapshot(ctx, adapter, req.RunID, dest)
+	if err != nil {
 		return modules.Result{}, err
 	}
+	output["previous"] = prev
 
+	if err := adapter.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return modules.Result{}, err
+	}
+
+	if err := adapter.WriteFile(dest, rendered, mode); err != nil {
+		return modules.Result{}, err
+	}
 	return modules.Result{
 		Changed: true,
-		Output: map[string]any{
-			"dest": dest,
-		},
+		Output:  output,
 	}, nil
 }
 
 func (m *Module) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
-	return modules.Result{}, fmt.Errorf("template.render rollback not supported")
+	return file.RollbackOutput(ctx, modules.HostAdapter(req), "template.render", "dest", req.ApplyOutput)
 }
 
 func renderTemplate(req modules.Request) ([]byte, string, error) {
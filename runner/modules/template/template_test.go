@@ -0,0 +1,152 @@
+package template
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bops/runner/modules"
+	"bops/runner/modules/file"
+	"bops/runner/workflow"
+)
+
+func TestRollbackRestoresPreviousContent(t *testing.T) {
+	dir := t.TempDir()
+	file.BackupRoot = filepath.Join(dir, "backups")
+	src := filepath.Join(dir, "app.conf.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(src, []byte("port={{ .port }}\n"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	if err := os.WriteFile(dest, []byte("port=80\n"), 0o640); err != nil {
+		t.Fatalf("write dest: %v", err)
+	}
+	if err := os.Chmod(dest, 0o640); err != nil {
+		t.Fatalf("chmod dest: %v", err)
+	}
+
+	req := modules.Request{
+		RunID: "run-1",
+		Step: workflow.Step{
+			Name:   "render",
+			Action: "template.render",
+			Args:   map[string]any{"src": src, "dest": dest, "mode": "0600"},
+		},
+		Vars: map[string]any{"port": 8080},
+	}
+	mod := New()
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	assertContent(t, dest, "port=8080\n")
+	prev, _ := applied.Output["previous"].(map[string]any)
+	if _, ok := applied.Output["previous_content"]; ok || prev["mode"] != "0640" {
+		t.Fatalf("expected only backup details in the output, got %v", applied.Output)
+	}
+	backupPath, _ := prev["backup"].(string)
+	if filepath.Dir(backupPath) != file.BackupDir("run-1") {
+		t.Fatalf("expected the backup in the run backup dir, got %s", backupPath)
+	}
+	assertContent(t, backupPath, "port=80\n")
+
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	assertContent(t, dest, "port=80\n")
+	info, err := os.Stat(dest)
+	if err != nil || info.Mode().Perm() != 0o640 {
+		t.Fatalf("expected original mode 0640 back, got %v %v", info, err)
+	}
+	if _, err := os.Stat(backupPath); !os.IsNotExist(err) {
+		t.Fatalf("expected rollback to remove %s, got %v", backupPath, err)
+	}
+}
+
+func TestApplyUnchangedLeavesNoBackup(t *testing.T) {
+	dir := t.TempDir()
+	file.BackupRoot = filepath.Join(dir, "backups")
+	src := filepath.Join(dir, "app.conf.tmpl")
+	dest := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(src, []byte("port={{ .port }}\n"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	if err := os.WriteFile(dest, []byte("port=80\n"), 0o644); err != nil {
+		t.Fatalf("write dest: %v", err)
+	}
+
+	req := modules.Request{
+		RunID: "run-1",
+		Step: workflow.Step{
+			Name:   "render",
+			Action: "template.render",
+			Args:   map[string]any{"src": src, "dest": dest},
+		},
+		Vars: map[string]any{"port": 80},
+	}
+	mod := New()
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if applied.Changed || applied.Output["previous"] != nil {
+		t.Fatalf("expected an unchanged apply, got %+v", applied)
+	}
+	if _, err := os.Stat(file.BackupRoot); !os.IsNotExist(err) {
+		t.Fatalf("expected no backup, got %v", err)
+	}
+
+	req.ApplyOutput = applied.Output
+	if res, err := mod.Rollback(context.Background(), req); err != nil || res.Changed {
+		t.Fatalf("expected nothing to roll back, got %+v %v", res, err)
+	}
+	assertContent(t, dest, "port=80\n")
+}
+
+func TestRollbackRemovesCreatedFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "motd.tmpl")
+	dest := filepath.Join(dir, "etc", "motd")
+	if err := os.WriteFile(src, []byte("hello\n"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+
+	req := modules.Request{
+		Step: workflow.Step{
+			Name:   "render",
+			Action: "template.render",
+			Args:   map[string]any{"src": src, "dest": dest},
+		},
+	}
+	mod := New()
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("expected rendered file to be removed, got %v", err)
+	}
+
+	req.ApplyOutput = nil
+	if _, err := mod.Rollback(context.Background(), req); err == nil {
+		t.Fatalf("expected error without apply output")
+	}
+}
+
+func assertContent(t *testing.T, path, expected string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if string(data) != expected {
+		t.Fatalf("unexpected content %q, want %q", data, expected)
+	}
+}
@@ -0,0 +1,198 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+type passwdEntry struct {
+	Name    string
+	UID     string
+	GID     string
+	Comment string
+	Home    string
+	Shell   string
+}
+
+type groupEntry struct {
+	Name    string
+	GID     string
+	Members []string
+}
+
+// accounts is a parsed view of /etc/passwd and /etc/group on the target host.
+type accounts struct {
+	users  map[string]passwdEntry
+	groups []groupEntry
+}
+
+func readAccounts(adapter host.Adapter) (accounts, error) {
+	passwd, err := adapter.ReadFile("/etc/passwd")
+	if err != nil {
+		return accounts{}, fmt.Errorf("read /etc/passwd: %w", err)
+	}
+	group, err := adapter.ReadFile("/etc/group")
+	if err != nil {
+		return accounts{}, fmt.Errorf("read /etc/group: %w", err)
+	}
+	out := accounts{users: map[string]passwdEntry{}}
+	for _, line := range strings.Split(string(passwd), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) < 7 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		out.users[fields[0]] = passwdEntry{
+			Name:    fields[0],
+			UID:     fields[2],
+			GID:     fields[3],
+			Comment: fields[4],
+			Home:    fields[5],
+			Shell:   fields[6],
+		}
+	}
+	for _, line := range strings.Split(string(group), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		entry := groupEntry{Name: fields[0], GID: fields[2]}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member = strings.TrimSpace(member); member != "" {
+				entry.Members = append(entry.Members, member)
+			}
+		}
+		out.groups = append(out.groups, entry)
+	}
+	return out, nil
+}
+
+func (a accounts) group(name string) (groupEntry, bool) {
+	for _, entry := range a.groups {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return groupEntry{}, false
+}
+
+// groupName resolves a group name or gid to the group name, falling back to
+// the value itself.
+func (a accounts) groupName(nameOrGID string) string {
+	for _, entry := range a.groups {
+		if entry.Name == nameOrGID || entry.GID == nameOrGID {
+			return entry.Name
+		}
+	}
+	return nameOrGID
+}
+
+// memberOf lists the supplementary groups of user, sorted.
+func (a accounts) memberOf(user string) []string {
+	out := []string{}
+	for _, entry := range a.groups {
+		for _, member := range entry.Members {
+			if member == user {
+				out = append(out, entry.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+var cLocale = host.RunOptions{Env: []string{"LC_ALL=C"}}
+
+func run(ctx context.Context, adapter host.Adapter, cmd string, args ...string) error {
+	res, err := adapter.Run(ctx, cmd, args, cLocale)
+	if err != nil {
+		if detail := strings.TrimSpace(res.Stderr); detail != "" {
+			return fmt.Errorf("%s: %s: %w", cmd, detail, err)
+		}
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+	return nil
+}
+
+func change(before, after any) map[string]any {
+	return map[string]any{"before": before, "after": after}
+}
+
+func readString(req modules.Request, key string) (string, bool) {
+	val, ok := req.Step.Args[key]
+	if !ok || val == nil {
+		return "", false
+	}
+	switch v := val.(type) {
+	case string:
+		return strings.TrimSpace(v), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+func readBool(req modules.Request, key string, fallback bool) bool {
+	switch v := req.Step.Args[key].(type) {
+	case bool:
+		return v
+	case string:
+		if parsed, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// readList accepts a YAML list or a comma separated string.
+func readList(req modules.Request, key string) ([]string, bool) {
+	val, ok := req.Step.Args[key]
+	if !ok || val == nil {
+		return nil, false
+	}
+	var items []string
+	switch v := val.(type) {
+	case []any:
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+	case []string:
+		items = v
+	default:
+		items = strings.Split(fmt.Sprint(v), ",")
+	}
+	out := []string{}
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out, true
+}
+
+func readState(req modules.Request, action string) (string, error) {
+	state, _ := readString(req, "state")
+	if state == "" {
+		return "present", nil
+	}
+	if state != "present" && state != "absent" {
+		return "", fmt.Errorf("%s state must be present or absent", action)
+	}
+	return state, nil
+}
+
+func readName(req modules.Request, action string) (string, error) {
+	name, _ := readString(req, "name")
+	if name == "" {
+		return "", fmt.Errorf("%s requires args.name", action)
+	}
+	if strings.ContainsAny(name, ": \t\n,") {
+		return "", fmt.Errorf("%s invalid name %q", action, name)
+	}
+	return name, nil
+}
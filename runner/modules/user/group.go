@@ -0,0 +1,137 @@
+package user
+
+import (
+	"context"
+	"fmt"
+
+	"bops/runner/modules"
+)
+
+// Group implements group.ensure.
+type Group struct{}
+
+func NewGroup() *Group {
+	return &Group{}
+}
+
+type groupPlan struct {
+	name    string
+	state   string
+	gid     string
+	system  bool
+	current groupEntry
+	exists  bool
+	diff    map[string]any
+}
+
+func (m *Group) plan(req modules.Request) (groupPlan, error) {
+	name, err := readName(req, "group.ensure")
+	if err != nil {
+		return groupPlan{}, err
+	}
+	state, err := readState(req, "group.ensure")
+	if err != nil {
+		return groupPlan{}, err
+	}
+	accts, err := readAccounts(modules.HostAdapter(req))
+	if err != nil {
+		return groupPlan{}, err
+	}
+	p := groupPlan{name: name, state: state, system: readBool(req, "system", false), diff: map[string]any{}}
+	p.gid, _ = readString(req, "gid")
+	p.current, p.exists = accts.group(name)
+
+	switch {
+	case state == "absent" && p.exists:
+		p.diff["state"] = change("present", "absent")
+	case state == "present" && !p.exists:
+		p.diff["state"] = change("absent", "present")
+		if p.gid != "" {
+			p.diff["gid"] = change("", p.gid)
+		}
+	case state == "present" && p.gid != "" && p.gid != p.current.GID:
+		p.diff["gid"] = change(p.current.GID, p.gid)
+	}
+	return p, nil
+}
+
+func (m *Group) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed := len(p.diff) > 0
+	p.diff["name"] = p.name
+	return modules.Result{Changed: changed, Diff: p.diff}, nil
+}
+
+func (m *Group) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output := map[string]any{"name": p.name, "state": p.state}
+	if len(p.diff) == 0 {
+		return modules.Result{Output: output}, nil
+	}
+
+	adapter := modules.HostAdapter(req)
+	previous := map[string]any{"exists": p.exists}
+	if p.exists {
+		previous["gid"] = p.current.GID
+	}
+	switch {
+	case p.state == "absent":
+		err = run(ctx, adapter, "groupdel", p.name)
+	case !p.exists:
+		args := []string{}
+		if p.gid != "" {
+			args = append(args, "-g", p.gid)
+		}
+		if p.system {
+			args = append(args, "-r")
+		}
+		err = run(ctx, adapter, "groupadd", append(args, p.name)...)
+	default:
+		err = run(ctx, adapter, "groupmod", "-g", p.gid, p.name)
+	}
+	if err != nil {
+		return modules.Result{}, fmt.Errorf("group.ensure failed: %w", err)
+	}
+	output["previous"] = previous
+	output["diff"] = p.diff
+	return modules.Result{Changed: true, Output: output}, nil
+}
+
+// Rollback deletes a group the apply created, recreates a deleted one with
+// its old gid, or puts a changed gid back.
+func (m *Group) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	name, _ := req.ApplyOutput["name"].(string)
+	if name == "" {
+		return modules.Result{}, fmt.Errorf("group.ensure rollback requires the apply output")
+	}
+	previous, ok := req.ApplyOutput["previous"].(map[string]any)
+	if !ok {
+		return modules.Result{Output: map[string]any{"name": name}}, nil
+	}
+	adapter := modules.HostAdapter(req)
+	existed, _ := previous["exists"].(bool)
+	gid, _ := previous["gid"].(string)
+	accts, err := readAccounts(adapter)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	_, exists := accts.group(name)
+	switch {
+	case !existed && exists:
+		err = run(ctx, adapter, "groupdel", name)
+	case existed && !exists:
+		err = run(ctx, adapter, "groupadd", "-g", gid, name)
+	case existed:
+		err = run(ctx, adapter, "groupmod", "-g", gid, name)
+	}
+	if err != nil {
+		return modules.Result{}, fmt.Errorf("group.ensure rollback failed: %w", err)
+	}
+	return modules.Result{Changed: true, Output: map[string]any{"name": name, "restored": true}}, nil
+}
@@ -0,0 +1,439 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+	"bops/runner/modules/file"
+)
+
+// User implements user.ensure. Check and Apply compare the request with the
+// /etc/passwd and /etc/group entries and only change fields that differ.
+type User struct{}
+
+func NewUser() *User {
+	return &User{}
+}
+
+type userPlan struct {
+	name    string
+	state   string
+	current passwdEntry
+	exists  bool
+
+	uid     string
+	group   string
+	groups  []string
+	shell   string
+	home    string
+	comment string
+
+	curGroups []string
+	keysPath  string
+	keysPrev  []byte
+	keysFound bool
+	keysNext  []byte
+
+	diff map[string]any
+}
+
+func (m *User) plan(adapter host.Adapter, req modules.Request) (userPlan, error) {
+	name, err := readName(req, "user.ensure")
+	if err != nil {
+		return userPlan{}, err
+	}
+	state, err := readState(req, "user.ensure")
+	if err != nil {
+		return userPlan{}, err
+	}
+	accts, err := readAccounts(adapter)
+	if err != nil {
+		return userPlan{}, err
+	}
+
+	p := userPlan{name: name, state: state, diff: map[string]any{}}
+	p.current, p.exists = accts.users[name]
+	if state == "absent" {
+		if p.exists {
+			p.diff["state"] = change("present", "absent")
+		}
+		return p, nil
+	}
+	if !p.exists {
+		p.diff["state"] = change("absent", "present")
+	}
+
+	var curGroup string
+	if p.exists {
+		curGroup = accts.groupName(p.current.GID)
+		p.curGroups = accts.memberOf(name)
+	}
+	compare := func(key, want, cur string) string {
+		if want != "" && want != cur {
+			p.diff[key] = change(cur, want)
+		}
+		return want
+	}
+	uid, _ := readString(req, "uid")
+	p.uid = compare("uid", uid, p.current.UID)
+	if group, _ := readString(req, "group"); group != "" {
+		p.group = compare("group", accts.groupName(group), curGroup)
+	}
+	shell, _ := readString(req, "shell")
+	p.shell = compare("shell", shell, p.current.Shell)
+	home, _ := readString(req, "home")
+	p.home = compare("home", home, p.current.Home)
+	comment, _ := readString(req, "comment")
+	p.comment = compare("comment", comment, p.current.Comment)
+
+	if groups, ok := readList(req, "groups"); ok {
+		want := make([]string, 0, len(groups))
+		for _, group := range groups {
+			want = append(want, accts.groupName(group))
+		}
+		if readBool(req, "append", false) {
+			want = union(p.curGroups, want)
+		}
+		sort.Strings(want)
+		if strings.Join(want, ",") != strings.Join(p.curGroups, ",") {
+			p.diff["groups"] = change(p.curGroups, want)
+			p.groups = want
+		}
+	}
+
+	if err := p.planKeys(adapter, req); err != nil {
+		return userPlan{}, err
+	}
+	return p, nil
+}
+
+// planKeys works out the authorized_keys content. Listed keys are added;
+// with authorized_keys_exclusive every other key is removed.
+func (p *userPlan) planKeys(adapter host.Adapter, req modules.Request) error {
+	keys, ok := readKeys(req)
+	if !ok {
+		return nil
+	}
+	home := p.home
+	if home == "" {
+		home = p.current.Home
+	}
+	if home == "" {
+		home = path.Join("/home", p.name)
+	}
+	p.keysPath = path.Join(home, ".ssh", "authorized_keys")
+
+	var existing []string
+	if p.exists {
+		data, err := adapter.ReadFile(p.keysPath)
+		switch {
+		case err == nil:
+			p.keysPrev, p.keysFound = data, true
+			for _, line := range strings.Split(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					existing = append(existing, line)
+				}
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return err
+		}
+	}
+
+	exclusive := readBool(req, "authorized_keys_exclusive", false)
+	next := []string{}
+	if !exclusive {
+		next = append(next, existing...)
+	}
+	added := []string{}
+	for _, key := range keys {
+		if !contains(existing, key) {
+			added = append(added, key)
+		}
+		if !contains(next, key) {
+			next = append(next, key)
+		}
+	}
+	removed := []string{}
+	for _, line := range existing {
+		if !contains(next, line) {
+			removed = append(removed, line)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 && (p.keysFound || len(keys) == 0) {
+		return nil
+	}
+	p.keysNext = []byte{}
+	if len(next) > 0 {
+		p.keysNext = []byte(strings.Join(next, "\n") + "\n")
+	}
+	p.diff["authorized_keys"] = map[string]any{"add": added, "remove": removed}
+	return nil
+}
+
+func readKeys(req modules.Request) ([]string, bool) {
+	val, ok := req.Step.Args["authorized_keys"]
+	if !ok || val == nil {
+		return nil, false
+	}
+	var items []string
+	switch v := val.(type) {
+	case []any:
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+	case []string:
+		items = v
+	default:
+		items = strings.Split(fmt.Sprint(v), "\n")
+	}
+	out := []string{}
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out, true
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func union(a, b []string) []string {
+	out := append([]string{}, a...)
+	for _, item := range b {
+		if !contains(out, item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func (m *User) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	p, err := m.plan(modules.HostAdapter(req), req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed := len(p.diff) > 0
+	p.diff["name"] = p.name
+	return modules.Result{Changed: changed, Diff: p.diff}, nil
+}
+
+func (m *User) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	adapter := modules.HostAdapter(req)
+	p, err := m.plan(adapter, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	output := map[string]any{"name": p.name, "state": p.state}
+	if len(p.diff) == 0 {
+		return modules.Result{Output: output}, nil
+	}
+
+	previous := map[string]any{"exists": p.exists}
+	if p.exists {
+		previous["uid"] = p.current.UID
+		previous["gid"] = p.current.GID
+		previous["groups"] = strings.Join(p.curGroups, ",")
+		previous["shell"] = p.current.Shell
+		previous["home"] = p.current.Home
+		previous["comment"] = p.current.Comment
+	}
+	if p.keysNext != nil {
+		// The keys themselves stay on the host; run state only records
+		// where they were backed up.
+		previous["authorized_keys_path"] = p.keysPath
+		previous["authorized_keys_existed"] = p.keysFound
+		if p.keysFound {
+			backup, sum, err := file.Backup(adapter, req.RunID, p.keysPath, p.keysPrev)
+			if err != nil {
+				return modules.Result{}, fmt.Errorf("user.ensure authorized_keys failed: %w", err)
+			}
+			previous["authorized_keys_backup"] = backup
+			previous["authorized_keys_sha256"] = sum
+		}
+	}
+
+	switch {
+	case p.state == "absent":
+		args := []string{}
+		if readBool(req, "remove", false) {
+			args = append(args, "-r")
+		}
+		err = run(ctx, adapter, "userdel", append(args, p.name)...)
+	case !p.exists:
+		createHome := readBool(req, "create_home", true)
+		output["created_home"] = createHome
+		err = run(ctx, adapter, "useradd", p.addArgs(createHome, readBool(req, "system", false))...)
+	default:
+		if args := p.modArgs(readBool(req, "append", false)); len(args) > 1 {
+			err = run(ctx, adapter, "usermod", args...)
+		}
+	}
+	if err != nil {
+		return modules.Result{}, fmt.Errorf("user.ensure failed: %w", err)
+	}
+	if p.state == "present" && p.keysNext != nil {
+		if err := writeKeys(ctx, adapter, p.name, p.keysPath, p.keysNext); err != nil {
+			return modules.Result{}, fmt.Errorf("user.ensure authorized_keys failed: %w", err)
+		}
+	}
+	output["previous"] = previous
+	output["diff"] = p.diff
+	return modules.Result{Changed: true, Output: output}, nil
+}
+
+func (p userPlan) addArgs(createHome, system bool) []string {
+	args := []string{}
+	if p.uid != "" {
+		args = append(args, "-u", p.uid)
+	}
+	if p.group != "" {
+		args = append(args, "-g", p.group)
+	}
+	if len(p.groups) > 0 {
+		args = append(args, "-G", strings.Join(p.groups, ","))
+	}
+	if p.shell != "" {
+		args = append(args, "-s", p.shell)
+	}
+	if p.home != "" {
+		args = append(args, "-d", p.home)
+	}
+	if p.comment != "" {
+		args = append(args, "-c", p.comment)
+	}
+	if createHome {
+		args = append(args, "-m")
+	} else {
+		args = append(args, "-M")
+	}
+	if system {
+		args = append(args, "-r")
+	}
+	return append(args, p.name)
+}
+
+// modArgs returns usermod arguments for the fields in the diff only; the
+// result always ends with the user name.
+func (p userPlan) modArgs(appendGroups bool) []string {
+	args := []string{}
+	if _, ok := p.diff["uid"]; ok {
+		args = append(args, "-u", p.uid)
+	}
+	if _, ok := p.diff["group"]; ok {
+		args = append(args, "-g", p.group)
+	}
+	if _, ok := p.diff["groups"]; ok {
+		if appendGroups {
+			args = append(args, "-a")
+		}
+		args = append(args, "-G", strings.Join(p.groups, ","))
+	}
+	if _, ok := p.diff["shell"]; ok {
+		args = append(args, "-s", p.shell)
+	}
+	if _, ok := p.diff["home"]; ok {
+		args = append(args, "-d", p.home)
+	}
+	if _, ok := p.diff["comment"]; ok {
+		args = append(args, "-c", p.comment)
+	}
+	return append(args, p.name)
+}
+
+func writeKeys(ctx context.Context, adapter host.Adapter, name, keysPath string, content []byte) error {
+	dir := path.Dir(keysPath)
+	if err := adapter.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if err := adapter.WriteFile(keysPath, content, 0o600); err != nil {
+		return err
+	}
+	return run(ctx, adapter, "chown", name+":", dir, keysPath)
+}
+
+// Rollback removes a user the apply created, recreates a deleted one
+// (without the removed home directory contents) or reverts changed fields and
+// authorized_keys.
+func (m *User) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	name, _ := req.ApplyOutput["name"].(string)
+	if name == "" {
+		return modules.Result{}, fmt.Errorf("user.ensure rollback requires the apply output")
+	}
+	previous, ok := req.ApplyOutput["previous"].(map[string]any)
+	if !ok {
+		return modules.Result{Output: map[string]any{"name": name}}, nil
+	}
+	adapter := modules.HostAdapter(req)
+	accts, err := readAccounts(adapter)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	_, exists := accts.users[name]
+	existed, _ := previous["exists"].(bool)
+	field := func(key string) string {
+		value, _ := previous[key].(string)
+		return value
+	}
+
+	switch {
+	case !existed && exists:
+		args := []string{}
+		if created, _ := req.ApplyOutput["created_home"].(bool); created {
+			args = append(args, "-r")
+		}
+		err = run(ctx, adapter, "userdel", append(args, name)...)
+	case existed && !exists:
+		err = run(ctx, adapter, "useradd", "-u", field("uid"), "-g", field("gid"), "-G", field("groups"),
+			"-s", field("shell"), "-d", field("home"), "-c", field("comment"), "-M", name)
+	case existed:
+		err = run(ctx, adapter, "usermod", "-u", field("uid"), "-g", field("gid"), "-G", field("groups"),
+			"-s", field("shell"), "-d", field("home"), "-c", field("comment"), name)
+		if err == nil {
+			err = restoreKeys(ctx, adapter, name, previous)
+		}
+	}
+	if err != nil {
+		return modules.Result{}, fmt.Errorf("user.ensure rollback failed: %w", err)
+	}
+	return modules.Result{Changed: true, Output: map[string]any{"name": name, "restored": true}}, nil
+}
+
+func restoreKeys(ctx context.Context, adapter host.Adapter, name string, previous map[string]any) error {
+	keysPath, _ := previous["authorized_keys_path"].(string)
+	if keysPath == "" {
+		return nil
+	}
+	if existed, _ := previous["authorized_keys_existed"].(bool); !existed {
+		if err := adapter.Remove(keysPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	backup, _ := previous["authorized_keys_backup"].(string)
+	sum, _ := previous["authorized_keys_sha256"].(string)
+	content, err := file.ReadBackup(adapter, keysPath, backup, sum)
+	if err != nil {
+		return err
+	}
+	if err := writeKeys(ctx, adapter, name, keysPath, content); err != nil {
+		return err
+	}
+	if err := adapter.Remove(backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
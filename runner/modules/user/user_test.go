@@ -0,0 +1,236 @@
+package user
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+	"bops/runner/workflow"
+)
+
+// fakeAccounts is a host.Adapter serving /etc/passwd, /etc/group and
+// whatever files the module writes, recording every command.
+type fakeAccounts struct {
+	files map[string]string
+	calls []string
+}
+
+func newFakeAccounts() *fakeAccounts {
+	return &fakeAccounts{files: map[string]string{
+		"/etc/passwd": "root:x:0:0:root:/root:/bin/bash\ndeploy:x:1001:1001:Deploy:/home/deploy:/bin/bash\n",
+		"/etc/group":  "root:x:0:\nwheel:x:10:deploy\ndeploy:x:1001:\ndocker:x:999:\n",
+	}}
+}
+
+func (f *fakeAccounts) Run(ctx context.Context, cmd string, args []string, opts host.RunOptions) (host.RunResult, error) {
+	f.calls = append(f.calls, cmd+" "+strings.Join(args, " "))
+	return host.RunResult{}, nil
+}
+
+func (f *fakeAccounts) ReadFile(path string) ([]byte, error) {
+	content, ok := f.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(content), nil
+}
+
+func (f *fakeAccounts) WriteFile(path string, data []byte, perm os.FileMode) error {
+	f.files[path] = string(data)
+	return nil
+}
+
+func (f *fakeAccounts) MkdirAll(path string, perm os.FileMode) error { return nil }
+
+func (f *fakeAccounts) Remove(path string) error {
+	delete(f.files, path)
+	return nil
+}
+
+func (f *fakeAccounts) LookPath(file string) (string, error) {
+	return "", exec.ErrNotFound
+}
+
+func accountRequest(adapter host.Adapter, action string, args map[string]any) modules.Request {
+	return modules.Request{
+		Step:    workflow.Step{Name: "account", Action: action, Args: args},
+		Host:    workflow.HostSpec{Name: "web1"},
+		Adapter: adapter,
+	}
+}
+
+func TestUserEnsureOnlyChangesDifferingFields(t *testing.T) {
+	fake := newFakeAccounts()
+	mod := NewUser()
+
+	same := accountRequest(fake, "user.ensure", map[string]any{
+		"name":   "deploy",
+		"uid":    1001,
+		"group":  "deploy",
+		"shell":  "/bin/bash",
+		"groups": []any{"wheel"},
+	})
+	checked, err := mod.Check(context.Background(), same)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if checked.Changed {
+		t.Fatalf("expected no diff for matching user, got %v", checked.Diff)
+	}
+
+	req := accountRequest(fake, "user.ensure", map[string]any{
+		"name":   "deploy",
+		"uid":    1001,
+		"shell":  "/bin/zsh",
+		"groups": "docker,wheel",
+	})
+	checked, err = mod.Check(context.Background(), req)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if _, ok := checked.Diff["uid"]; ok {
+		t.Fatalf("expected uid to be unchanged, got %v", checked.Diff)
+	}
+	shell, _ := checked.Diff["shell"].(map[string]any)
+	if shell["before"] != "/bin/bash" || shell["after"] != "/bin/zsh" {
+		t.Fatalf("expected shell diff, got %v", checked.Diff)
+	}
+
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if want := []string{"usermod -G docker,wheel -s /bin/zsh deploy"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Fatalf("expected %v, got %v", want, fake.calls)
+	}
+
+	fake.calls = nil
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if want := []string{"usermod -u 1001 -g 1001 -G wheel -s /bin/bash -d /home/deploy -c Deploy deploy"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Fatalf("expected %v, got %v", want, fake.calls)
+	}
+}
+
+func TestUserEnsureCreatesWithAuthorizedKeys(t *testing.T) {
+	fake := newFakeAccounts()
+	mod := NewUser()
+	req := accountRequest(fake, "user.ensure", map[string]any{
+		"name":            "app",
+		"uid":             "2000",
+		"group":           "999",
+		"authorized_keys": []any{"ssh-ed25519 AAAA app@ci"},
+	})
+
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	want := []string{
+		"useradd -u 2000 -g docker -m app",
+		"chown app: /home/app/.ssh /home/app/.ssh/authorized_keys",
+	}
+	if !reflect.DeepEqual(fake.calls, want) {
+		t.Fatalf("expected %v, got %v", want, fake.calls)
+	}
+	if got := fake.files["/home/app/.ssh/authorized_keys"]; got != "ssh-ed25519 AAAA app@ci\n" {
+		t.Fatalf("unexpected authorized_keys %q", got)
+	}
+
+	fake.calls = nil
+	req.ApplyOutput = applied.Output
+	fake.files["/etc/passwd"] += "app:x:2000:999::/home/app:/bin/sh\n"
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if want := []string{"userdel -r app"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Fatalf("expected %v, got %v", want, fake.calls)
+	}
+}
+
+func TestUserEnsureExclusiveKeys(t *testing.T) {
+	fake := newFakeAccounts()
+	fake.files["/home/deploy/.ssh/authorized_keys"] = "ssh-rsa OLD old@laptop\nssh-ed25519 KEEP ci\n"
+	req := accountRequest(fake, "user.ensure", map[string]any{
+		"name":                      "deploy",
+		"authorized_keys":           "ssh-ed25519 KEEP ci",
+		"authorized_keys_exclusive": true,
+	})
+
+	checked, err := NewUser().Check(context.Background(), req)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	keys, _ := checked.Diff["authorized_keys"].(map[string]any)
+	if !reflect.DeepEqual(keys["remove"], []string{"ssh-rsa OLD old@laptop"}) {
+		t.Fatalf("expected old key removal, got %v", checked.Diff)
+	}
+	applied, err := NewUser().Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if got := fake.files["/home/deploy/.ssh/authorized_keys"]; got != "ssh-ed25519 KEEP ci\n" {
+		t.Fatalf("unexpected authorized_keys %q", got)
+	}
+	previous, _ := applied.Output["previous"].(map[string]any)
+	for key, value := range previous {
+		if text, ok := value.(string); ok && strings.Contains(text, "OLD") {
+			t.Fatalf("expected keys to stay out of the output, got %s=%q", key, text)
+		}
+	}
+	backup, _ := previous["authorized_keys_backup"].(string)
+	if got := fake.files[backup]; got != "ssh-rsa OLD old@laptop\nssh-ed25519 KEEP ci\n" {
+		t.Fatalf("expected previous keys backed up on the host, got %q at %q", got, backup)
+	}
+
+	req.ApplyOutput = applied.Output
+	if _, err := NewUser().Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if got := fake.files["/home/deploy/.ssh/authorized_keys"]; got != "ssh-rsa OLD old@laptop\nssh-ed25519 KEEP ci\n" {
+		t.Fatalf("expected keys restored, got %q", got)
+	}
+	if _, ok := fake.files[backup]; ok {
+		t.Fatalf("expected backup %s removed after restore", backup)
+	}
+}
+
+func TestGroupEnsure(t *testing.T) {
+	fake := newFakeAccounts()
+	mod := NewGroup()
+
+	if res, err := mod.Check(context.Background(), accountRequest(fake, "group.ensure", map[string]any{"name": "docker", "gid": 999})); err != nil || res.Changed {
+		t.Fatalf("expected existing group to be unchanged, got %+v %v", res, err)
+	}
+
+	req := accountRequest(fake, "group.ensure", map[string]any{"name": "docker", "gid": 998})
+	applied, err := mod.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	req.ApplyOutput = applied.Output
+	if _, err := mod.Rollback(context.Background(), req); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+
+	created := accountRequest(fake, "group.ensure", map[string]any{"name": "app", "system": true})
+	if _, err := mod.Apply(context.Background(), created); err != nil {
+		t.Fatalf("apply create: %v", err)
+	}
+	removed := accountRequest(fake, "group.ensure", map[string]any{"name": "wheel", "state": "absent"})
+	if _, err := mod.Apply(context.Background(), removed); err != nil {
+		t.Fatalf("apply absent: %v", err)
+	}
+
+	want := []string{"groupmod -g 998 docker", "groupmod -g 999 docker", "groupadd -r app", "groupdel wheel"}
+	if !reflect.DeepEqual(fake.calls, want) {
+		t.Fatalf("expected %v, got %v", want, fake.calls)
+	}
+}
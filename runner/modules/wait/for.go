@@ -0,0 +1,447 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bops/internal/host"
+	"bops/runner/modules"
+)
+
+const (
+	defaultForInterval = time.Second
+	defaultForTimeout  = 5 * time.Minute
+	// probeTimeout bounds a single attempt so one hung connection cannot use
+	// up the whole wait.
+	probeTimeout = 10 * time.Second
+)
+
+// condition is one wait.for check, probed on the target host until it holds.
+type condition interface {
+	String() string
+	probe(ctx context.Context, adapter host.Adapter) (bool, string)
+}
+
+// parseCondition picks the condition from whichever of port, url, path or
+// cmd is set; exactly one is allowed.
+func parseCondition(req modules.Request) (condition, error) {
+	var kinds []string
+	for _, key := range []string{"port", "url", "path", "cmd"} {
+		if _, ok := req.Step.Args[key]; ok {
+			kinds = append(kinds, key)
+		}
+	}
+	if len(kinds) != 1 {
+		return nil, fmt.Errorf("wait.for requires exactly one of args.port, args.url, args.path or args.cmd")
+	}
+	state, _ := readString(req, "state")
+	state = strings.TrimSpace(state)
+
+	switch kinds[0] {
+	case "port":
+		raw, _ := readString(req, "port")
+		port, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("wait.for invalid port %q", raw)
+		}
+		addr, _ := readString(req, "host")
+		if strings.TrimSpace(addr) == "" {
+			addr = "127.0.0.1"
+		}
+		if state == "" {
+			state = "open"
+		}
+		if state != "open" && state != "closed" {
+			return nil, fmt.Errorf("wait.for port state must be open or closed")
+		}
+		return &tcpCondition{addr: net.JoinHostPort(strings.TrimSpace(addr), strconv.Itoa(port)), open: state == "open"}, nil
+	case "url":
+		url, _ := readString(req, "url")
+		if strings.TrimSpace(url) == "" {
+			return nil, fmt.Errorf("wait.for args.url is empty")
+		}
+		statuses, err := readStatuses(req)
+		if err != nil {
+			return nil, err
+		}
+		cond := &httpCondition{url: strings.TrimSpace(url), statuses: statuses}
+		if pattern, ok := readString(req, "body_regex"); ok && pattern != "" {
+			if cond.body, err = regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("wait.for invalid body_regex: %w", err)
+			}
+		}
+		return cond, nil
+	case "path":
+		path, _ := readString(req, "path")
+		if strings.TrimSpace(path) == "" {
+			return nil, fmt.Errorf("wait.for args.path is empty")
+		}
+		if state == "" {
+			state = "present"
+		}
+		if state != "present" && state != "absent" {
+			return nil, fmt.Errorf("wait.for path state must be present or absent")
+		}
+		cond := &fileCondition{path: path, present: state == "present"}
+		if pattern, ok := readString(req, "regex"); ok && pattern != "" {
+			if !cond.present {
+				return nil, fmt.Errorf("wait.for args.regex requires state present")
+			}
+			var err error
+			if cond.regex, err = regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("wait.for invalid regex: %w", err)
+			}
+		}
+		return cond, nil
+	default:
+		cmd, _ := readString(req, "cmd")
+		if strings.TrimSpace(cmd) == "" {
+			return nil, fmt.Errorf("wait.for args.cmd is empty")
+		}
+		rc := 0
+		if raw, ok := readString(req, "rc"); ok {
+			parsed, err := strconv.Atoi(strings.TrimSpace(raw))
+			if err != nil {
+				return nil, fmt.Errorf("wait.for invalid rc %q", raw)
+			}
+			rc = parsed
+		}
+		return &commandCondition{cmd: cmd, rc: rc}, nil
+	}
+}
+
+func readStatuses(req modules.Request) ([]int, error) {
+	raw, ok := req.Step.Args["status"]
+	if !ok || raw == nil {
+		return []int{http.StatusOK}, nil
+	}
+	values, isList := raw.([]any)
+	if !isList {
+		values = []any{raw}
+	}
+	out := make([]int, 0, len(values))
+	for _, value := range values {
+		status, err := strconv.Atoi(strings.TrimSpace(fmt.Sprint(value)))
+		if err != nil {
+			return nil, fmt.Errorf("wait.for invalid status %v", value)
+		}
+		out = append(out, status)
+	}
+	return out, nil
+}
+
+func readDuration(req modules.Request, key string, fallback time.Duration) (time.Duration, error) {
+	raw, ok := readString(req, key)
+	if !ok || strings.TrimSpace(raw) == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("wait.for invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// waitFor probes the condition every interval after an initial delay until it
+// holds or timeout elapses.
+func (m *Module) waitFor(ctx context.Context, req modules.Request) (modules.Result, error) {
+	cond, err := parseCondition(req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	interval, err := readDuration(req, "interval", defaultForInterval)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	timeout, err := readDuration(req, "timeout", defaultForTimeout)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	delay, err := readDuration(req, "delay", 0)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	if interval <= 0 {
+		interval = defaultForInterval
+	}
+	adapter := modules.HostAdapter(req)
+
+	start := time.Now()
+	output := func(attempts int, last string) map[string]any {
+		elapsed := time.Since(start)
+		out := map[string]any{
+			"condition":  cond.String(),
+			"attempts":   attempts,
+			"elapsed":    elapsed.Round(time.Millisecond).String(),
+			"elapsed_ms": elapsed.Milliseconds(),
+		}
+		if last != "" {
+			out["last_error"] = last
+		}
+		return out
+	}
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return modules.Result{Output: output(0, "")}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	attempts := 0
+	for {
+		attempts++
+		probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		ok, last := cond.probe(probeCtx, adapter)
+		cancel()
+		if ok {
+			return modules.Result{Changed: false, Output: output(attempts, "")}, nil
+		}
+
+		wait := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return modules.Result{Output: output(attempts, last)}, ctx.Err()
+		case <-deadline.C:
+			wait.Stop()
+			return modules.Result{Output: output(attempts, last)},
+				fmt.Errorf("wait.for timed out after %s waiting for %s (%d attempts): %s", timeout, cond, attempts, last)
+		case <-wait.C:
+		}
+	}
+}
+
+func isLocal(adapter host.Adapter) bool {
+	_, ok := adapter.(*host.LocalAdapter)
+	return ok
+}
+
+type tcpCondition struct {
+	addr string
+	open bool
+}
+
+func (c *tcpCondition) String() string {
+	if c.open {
+		return "tcp " + c.addr + " open"
+	}
+	return "tcp " + c.addr + " closed"
+}
+
+// probe dials from the controller for local hosts and through bash's
+// /dev/tcp on remote ones, so the address is resolved where the step runs.
+func (c *tcpCondition) probe(ctx context.Context, adapter host.Adapter) (bool, string) {
+	var err error
+	if isLocal(adapter) {
+		var conn net.Conn
+		var dialer net.Dialer
+		if conn, err = dialer.DialContext(ctx, "tcp", c.addr); err == nil {
+			_ = conn.Close()
+		}
+	} else {
+		addr, port, _ := net.SplitHostPort(c.addr)
+		res, runErr := adapter.Run(ctx, "bash", []string{"-c", fmt.Sprintf("exec 3<>/dev/tcp/%s/%s", addr, port)}, host.RunOptions{})
+		// bash exits with 1 when the connection fails; anything else means
+		// the probe itself did not run and says nothing about the port.
+		code, ran := exitCode(res, runErr)
+		switch {
+		case !ran:
+			return false, fmt.Sprintf("probe %s: %v", c.addr, runErr)
+		case code == 1:
+			err = fmt.Errorf("connect failed: %s", strings.TrimSpace(res.Stderr))
+		case code != 0:
+			return false, fmt.Sprintf("probe %s: bash exited with %d: %s", c.addr, code, strings.TrimSpace(res.Stderr))
+		}
+	}
+	if c.open {
+		if err != nil {
+			return false, fmt.Sprintf("%s not open: %v", c.addr, err)
+		}
+		return true, ""
+	}
+	if err == nil {
+		return false, fmt.Sprintf("%s still open", c.addr)
+	}
+	return true, ""
+}
+
+type httpCondition struct {
+	url      string
+	statuses []int
+	body     *regexp.Regexp
+}
+
+func (c *httpCondition) String() string {
+	return "http " + c.url
+}
+
+func (c *httpCondition) probe(ctx context.Context, adapter host.Adapter) (bool, string) {
+	status, body, err := c.fetch(ctx, adapter)
+	if err != nil {
+		return false, err.Error()
+	}
+	matched := false
+	for _, want := range c.statuses {
+		if status == want {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false, fmt.Sprintf("status %d, want %v", status, c.statuses)
+	}
+	if c.body != nil && !c.body.MatchString(body) {
+		return false, fmt.Sprintf("body does not match %q", c.body.String())
+	}
+	return true, ""
+}
+
+// fetch uses net/http for local hosts and curl on remote ones.
+func (c *httpCondition) fetch(ctx context.Context, adapter host.Adapter) (int, string, error) {
+	if isLocal(adapter) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+		if err != nil {
+			return 0, "", err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return 0, "", err
+		}
+		return resp.StatusCode, string(body), nil
+	}
+	res, err := adapter.Run(ctx, "curl", []string{"-s", "-o", "-", "-w", "\n%{http_code}", c.url}, host.RunOptions{})
+	if err != nil {
+		return 0, "", fmt.Errorf("curl %s: %v", c.url, err)
+	}
+	idx := strings.LastIndex(res.Stdout, "\n")
+	if idx < 0 {
+		return 0, "", fmt.Errorf("unexpected curl output")
+	}
+	status, err := strconv.Atoi(strings.TrimSpace(res.Stdout[idx+1:]))
+	if err != nil {
+		return 0, "", fmt.Errorf("unexpected curl status %q", res.Stdout[idx+1:])
+	}
+	return status, res.Stdout[:idx], nil
+}
+
+type fileCondition struct {
+	path    string
+	present bool
+	regex   *regexp.Regexp
+}
+
+func (c *fileCondition) String() string {
+	switch {
+	case !c.present:
+		return "file " + c.path + " absent"
+	case c.regex != nil:
+		return fmt.Sprintf("file %s matches %q", c.path, c.regex.String())
+	default:
+		return "file " + c.path + " present"
+	}
+}
+
+// probe only stats the path, or runs test -e on remote hosts; the content
+// is read when a regex has to match it.
+func (c *fileCondition) probe(ctx context.Context, adapter host.Adapter) (bool, string) {
+	exists, err := c.exists(ctx, adapter)
+	if err != nil {
+		return false, err.Error()
+	}
+	if !c.present {
+		if exists {
+			return false, c.path + " still exists"
+		}
+		return true, ""
+	}
+	if !exists {
+		return false, c.path + " does not exist"
+	}
+	if c.regex == nil {
+		return true, ""
+	}
+	data, err := adapter.ReadFile(c.path)
+	if err != nil {
+		return false, err.Error()
+	}
+	if !c.regex.Match(data) {
+		return false, fmt.Sprintf("%s does not match %q", c.path, c.regex.String())
+	}
+	return true, ""
+}
+
+func (c *fileCondition) exists(ctx context.Context, adapter host.Adapter) (bool, error) {
+	if isLocal(adapter) {
+		_, err := os.Stat(c.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	res, err := adapter.Run(ctx, "test", []string{"-e", c.path}, host.RunOptions{})
+	code, ran := exitCode(res, err)
+	switch {
+	case !ran:
+		return false, fmt.Errorf("test -e %s: %v", c.path, err)
+	case code == 0:
+		return true, nil
+	case code == 1:
+		return false, nil
+	default:
+		return false, fmt.Errorf("test -e %s exited with %d: %s", c.path, code, strings.TrimSpace(res.Stderr))
+	}
+}
+
+type commandCondition struct {
+	cmd string
+	rc  int
+}
+
+func (c *commandCondition) String() string {
+	return fmt.Sprintf("command %q rc %d", c.cmd, c.rc)
+}
+
+func (c *commandCondition) probe(ctx context.Context, adapter host.Adapter) (bool, string) {
+	res, err := adapter.Run(ctx, "sh", []string{"-c", c.cmd}, host.RunOptions{})
+	code, ran := exitCode(res, err)
+	if !ran {
+		return false, err.Error()
+	}
+	if code != c.rc {
+		return false, fmt.Sprintf("exit code %d, want %d", code, c.rc)
+	}
+	return true, ""
+}
+
+// exitCode returns the exit status of a command run through the adapter,
+// and false when the command could not be run at all.
+func exitCode(res host.RunResult, err error) (int, bool) {
+	if err == nil || res.ExitCode != 0 {
+		return res.ExitCode, true
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
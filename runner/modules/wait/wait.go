@@ -4,23 +4,46 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"bops/internal/core"
+	"bops/internal/eventbus"
 	"bops/runner/modules"
 )
 
 type Module struct {
 	mode string
+	bus  *eventbus.Bus
+
+	mu      sync.Mutex
+	waiting map[waitKey]int
+}
+
+// waitKey names the signal a wait.event step is waiting for.
+type waitKey struct {
+	runID string
+	event string
 }
 
 func NewUntil() *Module {
 	return &Module{mode: "until"}
 }
 
+func NewFor() *Module {
+	return &Module{mode: "for"}
+}
+
 func NewEvent() *Module {
 	return &Module{mode: "event"}
 }
 
+// NewEventWithBus returns a wait.event module that listens for signal events
+// on bus and reports its waiting state there.
+func NewEventWithBus(bus *eventbus.Bus) *Module {
+	return &Module{mode: "event", bus: bus}
+}
+
 func (m *Module) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
 	diff := map[string]any{"mode": m.mode}
 	if m.mode == "until" {
@@ -32,6 +55,16 @@ func (m *Module) Check(ctx context.Context, req modules.Request) (modules.Result
 		if event, ok := readString(req, "event"); ok {
 			diff["event"] = event
 		}
+		if timeout, ok := readString(req, "timeout"); ok {
+			diff["timeout"] = timeout
+		}
+	}
+	if m.mode == "for" {
+		cond, err := parseCondition(req)
+		if err != nil {
+			return modules.Result{}, err
+		}
+		diff["condition"] = cond.String()
 	}
 	return modules.Result{Changed: false, Diff: diff}, nil
 }
@@ -55,15 +88,155 @@ func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result
 		case <-timer.C:
 			return modules.Result{Changed: false}, nil
 		}
+	case "for":
+		return m.waitFor(ctx, req)
 	case "event":
-		return modules.Result{}, fmt.Errorf("wait.event is not implemented yet")
+		return m.waitEvent(ctx, req)
 	default:
 		return modules.Result{}, fmt.Errorf("unsupported wait mode")
 	}
 }
 
 func (m *Module) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
-	return modules.Result{}, fmt.Errorf("wait.%s rollback not supported", m.mode)
+	return modules.Result{}, fmt.Errorf("wait.%s %w", m.mode, modules.ErrRollbackNotSupported)
+}
+
+// waitEvent blocks until a signal named args.event is published for the run,
+// with a payload matching args.match, or until args.timeout elapses. Signals
+// published before the step starts waiting are not seen; Waiting tells the
+// sender whether a step was listening.
+func (m *Module) waitEvent(ctx context.Context, req modules.Request) (modules.Result, error) {
+	name, ok := readString(req, "event")
+	if !ok || strings.TrimSpace(name) == "" {
+		return modules.Result{}, fmt.Errorf("wait.event requires args.event")
+	}
+	if m.bus == nil {
+		return modules.Result{}, fmt.Errorf("wait.event requires an event bus")
+	}
+	match, err := readMatch(req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	var timeoutC <-chan time.Time
+	if timeout, ok := readString(req, "timeout"); ok && strings.TrimSpace(timeout) != "" {
+		parsed, err := time.ParseDuration(timeout)
+		if err != nil {
+			return modules.Result{}, fmt.Errorf("wait.event invalid timeout: %w", err)
+		}
+		timer := time.NewTimer(parsed)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+
+	sub := m.bus.Subscribe(64)
+	defer sub.Cancel()
+	defer m.wait(req.RunID, name)()
+	m.publish(req, core.EventWaitStart, core.EventInfo, map[string]any{"event": name, "match": match})
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.publish(req, core.EventWaitEnd, core.EventWarn, map[string]any{"event": name, "status": "canceled"})
+			return modules.Result{}, ctx.Err()
+		case <-timeoutC:
+			m.publish(req, core.EventWaitEnd, core.EventWarn, map[string]any{"event": name, "status": "timeout"})
+			return modules.Result{}, fmt.Errorf("wait.event timed out waiting for %q", name)
+		case evt, ok := <-sub.C:
+			if !ok {
+				return modules.Result{}, fmt.Errorf("wait.event bus closed")
+			}
+			if !signalMatches(evt, req.RunID, name, match) {
+				continue
+			}
+			m.publish(req, core.EventWaitEnd, core.EventInfo, map[string]any{"event": name, "status": "received"})
+			return modules.Result{
+				Changed: false,
+				Output: map[string]any{
+					"event":   name,
+					"payload": evt.Data,
+				},
+			}, nil
+		}
+	}
+}
+
+// Waiting returns how many wait.event steps of runID are waiting for the
+// signal event right now.
+func (m *Module) Waiting(runID, event string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.waiting[waitKey{runID: runID, event: event}]
+}
+
+// wait records a step waiting for event until the returned func is called.
+func (m *Module) wait(runID, event string) func() {
+	key := waitKey{runID: runID, event: event}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.waiting == nil {
+		m.waiting = map[waitKey]int{}
+	}
+	m.waiting[key]++
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.waiting[key]--; m.waiting[key] <= 0 {
+			delete(m.waiting, key)
+		}
+	}
+}
+
+func (m *Module) publish(req modules.Request, eventType core.EventType, level core.EventLevel, data map[string]any) {
+	now := time.Now().UTC()
+	m.bus.Publish(core.Event{
+		ID:    fmt.Sprintf("evt-%d", now.UnixNano()),
+		Type:  eventType,
+		Level: level,
+		Time:  now,
+		RunID: req.RunID,
+		Step:  req.Step.Name,
+		Host:  req.Host.Name,
+		Data:  data,
+	})
+}
+
+// signalMatches reports whether evt is the named signal for runID and every
+// match key equals the payload value, compared as strings.
+func signalMatches(evt core.Event, runID, name string, match map[string]string) bool {
+	if evt.Type != core.EventSignal || evt.Message != name {
+		return false
+	}
+	if runID != "" && evt.RunID != runID {
+		return false
+	}
+	for key, want := range match {
+		got, ok := evt.Data[key]
+		if !ok || fmt.Sprint(got) != want {
+			return false
+		}
+	}
+	return true
+}
+
+func readMatch(req modules.Request) (map[string]string, error) {
+	raw, ok := req.Step.Args["match"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	out := map[string]string{}
+	switch v := raw.(type) {
+	case map[string]any:
+		for key, value := range v {
+			out[key] = fmt.Sprint(value)
+		}
+	case map[any]any:
+		for key, value := range v {
+			out[fmt.Sprint(key)] = fmt.Sprint(value)
+		}
+	default:
+		return nil, fmt.Errorf("wait.event args.match must be a map")
+	}
+	return out, nil
 }
 
 func readString(req modules.Request, key string) (string, bool) {
@@ -0,0 +1,268 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bops/internal/core"
+	"bops/internal/eventbus"
+	"bops/internal/host"
+	"bops/runner/modules"
+	"bops/runner/workflow"
+)
+
+func eventRequest(args map[string]any) modules.Request {
+	return modules.Request{
+		RunID: "run-1",
+		Step:  workflow.Step{Name: "wait-migration", Action: "wait.event", Args: args},
+		Host:  workflow.HostSpec{Name: "db"},
+	}
+}
+
+func TestWaitEventMatchesSignal(t *testing.T) {
+	bus := eventbus.New()
+	defer bus.Close()
+	watch := bus.Subscribe(16)
+	defer watch.Cancel()
+
+	module := NewEventWithBus(bus)
+	type outcome struct {
+		res modules.Result
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		res, err := module.Apply(context.Background(), eventRequest(map[string]any{
+			"event":   "migrated",
+			"match":   map[string]any{"version": 42},
+			"timeout": "5s",
+		}))
+		done <- outcome{res: res, err: err}
+	}()
+
+	for evt := range watch.C {
+		if evt.Type == core.EventWaitStart {
+			if evt.RunID != "run-1" || evt.Step != "wait-migration" || evt.Host != "db" {
+				t.Fatalf("unexpected wait_start event: %+v", evt)
+			}
+			break
+		}
+	}
+
+	// Wrong run, wrong name and non-matching payload are all ignored.
+	bus.Publish(core.Event{Type: core.EventSignal, RunID: "run-2", Message: "migrated", Data: map[string]any{"version": "42"}})
+	bus.Publish(core.Event{Type: core.EventSignal, RunID: "run-1", Message: "deployed", Data: map[string]any{"version": "42"}})
+	bus.Publish(core.Event{Type: core.EventSignal, RunID: "run-1", Message: "migrated", Data: map[string]any{"version": "41"}})
+	bus.Publish(core.Event{Type: core.EventSignal, RunID: "run-1", Message: "migrated", Data: map[string]any{"version": "42", "by": "ci"}})
+
+	select {
+	case got := <-done:
+		if got.err != nil {
+			t.Fatalf("apply: %v", got.err)
+		}
+		payload, _ := got.res.Output["payload"].(map[string]any)
+		if payload["by"] != "ci" {
+			t.Fatalf("expected matching payload, got %v", got.res.Output)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wait.event did not return")
+	}
+}
+
+func TestWaitEventTimeout(t *testing.T) {
+	bus := eventbus.New()
+	defer bus.Close()
+
+	_, err := NewEventWithBus(bus).Apply(context.Background(), eventRequest(map[string]any{
+		"event":   "migrated",
+		"timeout": "20ms",
+	}))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+func TestWaitEventRequiresBus(t *testing.T) {
+	_, err := NewEvent().Apply(context.Background(), eventRequest(map[string]any{"event": "migrated"}))
+	if err == nil || !strings.Contains(err.Error(), "event bus") {
+		t.Fatalf("expected missing bus error, got %v", err)
+	}
+}
+
+func forRequest(args map[string]any) modules.Request {
+	return modules.Request{
+		Step: workflow.Step{Name: "ready", Action: "wait.for", Args: args},
+		Host: workflow.HostSpec{Name: "local"},
+	}
+}
+
+func TestWaitForTCPPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	res, err := NewFor().Apply(context.Background(), forRequest(map[string]any{"port": port, "timeout": "5s"}))
+	if err != nil {
+		t.Fatalf("wait for open port: %v", err)
+	}
+	if res.Output["attempts"] != 1 {
+		t.Fatalf("expected 1 attempt, got %v", res.Output)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = listener.Close()
+	}()
+	res, err = NewFor().Apply(context.Background(), forRequest(map[string]any{
+		"port":     port,
+		"state":    "closed",
+		"interval": "20ms",
+		"timeout":  "5s",
+	}))
+	if err != nil {
+		t.Fatalf("wait for closed port: %v", err)
+	}
+	if attempts, _ := res.Output["attempts"].(int); attempts < 2 {
+		t.Fatalf("expected several attempts, got %v", res.Output)
+	}
+}
+
+func TestWaitForHTTPStatusAndBody(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	res, err := NewFor().Apply(context.Background(), forRequest(map[string]any{
+		"url":        srv.URL,
+		"status":     []any{200, 204},
+		"body_regex": `"status":\s*"ok"`,
+		"interval":   "10ms",
+		"timeout":    "5s",
+	}))
+	if err != nil {
+		t.Fatalf("wait for http: %v", err)
+	}
+	if res.Output["attempts"] != 3 {
+		t.Fatalf("expected 3 attempts, got %v", res.Output)
+	}
+}
+
+func TestWaitForFileRegex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ready")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = os.WriteFile(path, []byte("booting\n"), 0o644)
+		time.Sleep(50 * time.Millisecond)
+		_ = os.WriteFile(path, []byte("booting\nready\n"), 0o644)
+	}()
+
+	_, err := NewFor().Apply(context.Background(), forRequest(map[string]any{
+		"path":     path,
+		"regex":    "(?m)^ready$",
+		"interval": "10ms",
+		"timeout":  "5s",
+	}))
+	if err != nil {
+		t.Fatalf("wait for file: %v", err)
+	}
+}
+
+func TestWaitForCommandTimeout(t *testing.T) {
+	res, err := NewFor().Apply(context.Background(), forRequest(map[string]any{
+		"cmd":      "exit 3",
+		"interval": "10ms",
+		"timeout":  "100ms",
+		"delay":    "10ms",
+	}))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if attempts, _ := res.Output["attempts"].(int); attempts < 2 {
+		t.Fatalf("expected attempts in output, got %v", res.Output)
+	}
+	if !strings.Contains(fmt.Sprint(res.Output["last_error"]), "exit code 3") {
+		t.Fatalf("expected last error in output, got %v", res.Output)
+	}
+
+	if _, err := NewFor().Apply(context.Background(), forRequest(map[string]any{"cmd": "exit 3", "rc": 3})); err != nil {
+		t.Fatalf("expected rc 3 to satisfy the condition: %v", err)
+	}
+}
+
+func TestWaitForRequiresOneCondition(t *testing.T) {
+	_, err := NewFor().Check(context.Background(), forRequest(map[string]any{"port": 80, "path": "/tmp/x"}))
+	if err == nil {
+		t.Fatalf("expected error for two conditions")
+	}
+}
+
+// remoteAdapter runs commands locally but is not a *host.LocalAdapter, so
+// the probes take their remote code paths.
+type remoteAdapter struct {
+	*host.LocalAdapter
+	runErr error
+}
+
+func (a remoteAdapter) Run(ctx context.Context, cmd string, args []string, opts host.RunOptions) (host.RunResult, error) {
+	if a.runErr != nil {
+		return host.RunResult{}, a.runErr
+	}
+	return a.LocalAdapter.Run(ctx, cmd, args, opts)
+}
+
+func TestWaitForRemoteProbes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	_ = listener.Close()
+	dir := t.TempDir()
+
+	remote := func(args map[string]any, runErr error) modules.Request {
+		req := forRequest(args)
+		req.Adapter = remoteAdapter{LocalAdapter: host.NewLocalAdapter(), runErr: runErr}
+		return req
+	}
+	if _, err := NewFor().Apply(context.Background(), remote(map[string]any{"host": "127.0.0.1", "port": port, "state": "closed", "timeout": "5s"}, nil)); err != nil {
+		t.Fatalf("expected closed port to satisfy the condition: %v", err)
+	}
+	_, err = NewFor().Apply(context.Background(), remote(map[string]any{
+		"host":     "127.0.0.1",
+		"port":     port,
+		"state":    "closed",
+		"interval": "10ms",
+		"timeout":  "50ms",
+	}, errors.New("ssh: connection lost")))
+	if err == nil || !strings.Contains(err.Error(), "connection lost") {
+		t.Fatalf("expected a failed probe not to count as closed, got %v", err)
+	}
+
+	if _, err := NewFor().Apply(context.Background(), remote(map[string]any{"path": dir, "timeout": "5s"}, nil)); err != nil {
+		t.Fatalf("expected remote directory to be present: %v", err)
+	}
+	if _, err := NewFor().Apply(context.Background(), forRequest(map[string]any{"path": dir, "timeout": "5s"})); err != nil {
+		t.Fatalf("expected local directory to be present: %v", err)
+	}
+	if _, err := NewFor().Apply(context.Background(), remote(map[string]any{"path": filepath.Join(dir, "missing"), "state": "absent", "timeout": "5s"}, nil)); err != nil {
+		t.Fatalf("expected missing remote path to be absent: %v", err)
+	}
+}
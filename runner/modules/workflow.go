@@ -0,0 +1,36 @@
+package modules
+
+import (
+	"context"
+
+	"bops/runner/workflow"
+)
+
+// WorkflowRunner runs the workflows called by workflow.call steps. The
+// engine adds itself to the context it passes to modules.
+type WorkflowRunner interface {
+	// LoadWorkflow returns the workflow ref names, by stored name or file
+	// path, with its includes expanded.
+	LoadWorkflow(ref string) (workflow.Workflow, error)
+	// CheckWorkflow plans wf and returns the names of the steps that would
+	// change.
+	CheckWorkflow(ctx context.Context, wf workflow.Workflow) ([]string, error)
+	// RunWorkflow applies wf as a child run of parentRunID and returns the
+	// child run ID.
+	RunWorkflow(ctx context.Context, parentRunID string, wf workflow.Workflow) (string, error)
+}
+
+type workflowRunnerKey struct{}
+
+func WithWorkflowRunner(ctx context.Context, runner WorkflowRunner) context.Context {
+	if runner == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, workflowRunnerKey{}, runner)
+}
+
+// WorkflowRunnerFrom returns the runner added by WithWorkflowRunner, or nil.
+func WorkflowRunnerFrom(ctx context.Context) WorkflowRunner {
+	runner, _ := ctx.Value(workflowRunnerKey{}).(WorkflowRunner)
+	return runner
+}
@@ -0,0 +1,134 @@
+package workflowcall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"bops/runner/modules"
+	"bops/runner/workflow"
+)
+
+// Module runs another workflow as a single step. The called workflow runs
+// on the step's host: every target in it maps to that host.
+type Module struct{}
+
+func New() *Module {
+	return &Module{}
+}
+
+func (m *Module) Check(ctx context.Context, req modules.Request) (modules.Result, error) {
+	runner, wf, err := prepare(ctx, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	changed, err := runner.CheckWorkflow(ctx, wf)
+	if err != nil {
+		return modules.Result{}, fmt.Errorf("workflow.call %s: %w", wf.Name, err)
+	}
+	diff := map[string]any{"workflow": wf.Name}
+	if len(changed) > 0 {
+		diff["steps"] = changed
+	}
+	return modules.Result{
+		Changed: len(changed) > 0,
+		Diff:    diff,
+	}, nil
+}
+
+func (m *Module) Apply(ctx context.Context, req modules.Request) (modules.Result, error) {
+	runner, wf, err := prepare(ctx, req)
+	if err != nil {
+		return modules.Result{}, err
+	}
+	runID, err := runner.RunWorkflow(ctx, req.RunID, wf)
+	res := modules.Result{
+		Changed: true,
+		Output: map[string]any{
+			"workflow": wf.Name,
+			"run_id":   runID,
+		},
+	}
+	if err != nil {
+		return res, fmt.Errorf("workflow.call %s (run %s): %w", wf.Name, runID, err)
+	}
+	return res, nil
+}
+
+func (m *Module) Rollback(ctx context.Context, req modules.Request) (modules.Result, error) {
+	return modules.Result{}, fmt.Errorf("workflow.call %w", modules.ErrRollbackNotSupported)
+}
+
+// prepare loads the called workflow, resolves its params and narrows its
+// inventory to the request host.
+func prepare(ctx context.Context, req modules.Request) (modules.WorkflowRunner, workflow.Workflow, error) {
+	ref, _ := req.Step.Args["workflow"].(string)
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, workflow.Workflow{}, fmt.Errorf("workflow.call requires args.workflow")
+	}
+	params, err := readParams(req.Step.Args["params"])
+	if err != nil {
+		return nil, workflow.Workflow{}, err
+	}
+	runner := modules.WorkflowRunnerFrom(ctx)
+	if runner == nil {
+		return nil, workflow.Workflow{}, fmt.Errorf("workflow.call is not supported by this runner")
+	}
+	wf, err := runner.LoadWorkflow(ref)
+	if err != nil {
+		return nil, workflow.Workflow{}, fmt.Errorf("workflow.call %s: %w", ref, err)
+	}
+	if err := wf.ResolveParams(params); err != nil {
+		return nil, workflow.Workflow{}, fmt.Errorf("workflow.call %s: %w", ref, err)
+	}
+	wf.Inventory = hostInventory(wf, req.Host)
+	return runner, wf, nil
+}
+
+// hostInventory returns an inventory holding only host, with every group
+// and host name the workflow targets resolving to it.
+func hostInventory(wf workflow.Workflow, host workflow.HostSpec) workflow.Inventory {
+	names := map[string]struct{}{}
+	for name := range wf.Inventory.Groups {
+		names[name] = struct{}{}
+	}
+	for name := range wf.Inventory.Hosts {
+		names[name] = struct{}{}
+	}
+	for _, step := range workflow.FlattenSteps(wf.Steps) {
+		for _, target := range step.Targets {
+			names[target] = struct{}{}
+		}
+	}
+	delete(names, host.Name)
+
+	inv := workflow.Inventory{
+		Vars: wf.Inventory.Vars,
+		Hosts: map[string]workflow.Host{
+			host.Name: {Address: host.Address, Vars: host.Vars},
+		},
+		Groups: map[string]workflow.Group{},
+	}
+	for name := range names {
+		inv.Groups[name] = workflow.Group{Hosts: []string{host.Name}}
+	}
+	return inv
+}
+
+func readParams(raw any) (map[string]any, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return v, nil
+	case map[any]any:
+		params := make(map[string]any, len(v))
+		for key, value := range v {
+			params[fmt.Sprint(key)] = value
+		}
+		return params, nil
+	default:
+		return nil, fmt.Errorf("workflow.call requires args.params to be a map")
+	}
+}
@@ -14,6 +14,9 @@ type StepPlan struct {
 	Action  string           `json:"action"`
 	Targets []string         `json:"targets"`
 	Changes []ResourceChange `json:"changes"`
+	// Parent and Section place a step of a block step, as in the run state.
+	Parent  string `json:"parent,omitempty"`
+	Section string `json:"section,omitempty"`
 }
 
 type ResourceChange struct {
@@ -24,4 +27,16 @@ type ResourceChange struct {
 type DiffEntry struct {
 	Current any `json:"current"`
 	Desired any `json:"desired"`
+	// Text is a unified diff of file content, when the module produced one.
+	Text string `json:"text,omitempty"`
+}
+
+// HasChanges reports whether any step would change a host.
+func (p Plan) HasChanges() bool {
+	for _, step := range p.Steps {
+		if len(step.Changes) > 0 {
+			return true
+		}
+	}
+	return false
 }
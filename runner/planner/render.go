@@ -0,0 +1,145 @@
+package planner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"bops/runner/workflow"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// maxValueWidth truncates long current/desired values in rendered plans;
+// file content is shown through the unified diff instead.
+const maxValueWidth = 100
+
+// Render writes a human-readable view of plan to w: every step, the hosts it
+// would change and each field as current -> desired, with unified diffs for
+// file content. color adds ANSI colors.
+func Render(w io.Writer, plan Plan, color bool) error {
+	paint := func(code, text string) string {
+		if !color {
+			return text
+		}
+		return code + text + colorReset
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", paint(colorBold, "Workflow: "+plan.WorkflowName))
+	changes, changedSteps := 0, 0
+	for _, step := range plan.Steps {
+		title := fmt.Sprintf("%s (%s)", step.Name, step.Action)
+		if step.Section == workflow.SectionAlways {
+			title += " [always]"
+		}
+		if len(step.Changes) == 0 {
+			fmt.Fprintf(&b, "= %s: no changes\n", title)
+			continue
+		}
+		changedSteps++
+		changes += len(step.Changes)
+		fmt.Fprintf(&b, "%s\n", paint(colorYellow+colorBold, "~ "+title))
+		for _, change := range step.Changes {
+			resource := strings.TrimPrefix(change.ResourceID, step.Name+":")
+			fmt.Fprintf(&b, "    %s\n", paint(colorBold, resource))
+			for _, key := range sortedKeys(change.Diff) {
+				renderEntry(&b, key, change.Diff[key], paint)
+			}
+		}
+	}
+
+	b.WriteString("\n")
+	if changes == 0 {
+		b.WriteString(paint(colorGreen, "No changes. Hosts match the workflow.") + "\n")
+	} else {
+		fmt.Fprintf(&b, "%s\n", paint(colorBold, fmt.Sprintf("Plan: %d change(s) in %d of %d step(s).", changes, changedSteps, len(plan.Steps))))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func renderEntry(b *strings.Builder, key string, entry DiffEntry, paint func(string, string) string) {
+	switch {
+	case entry.Current == nil && entry.Text == "":
+		fmt.Fprintf(b, "        %s: %s\n", key, formatValue(entry.Desired))
+	default:
+		fmt.Fprintf(b, "      %s %s: %s -> %s\n", paint(colorYellow, "~"), key,
+			paint(colorRed, formatValue(entry.Current)), paint(colorGreen, formatValue(entry.Desired)))
+	}
+	if entry.Text == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(entry.Text, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			line = paint(colorBold, line)
+		case strings.HasPrefix(line, "@@"):
+			line = paint(colorCyan, line)
+		case strings.HasPrefix(line, "+"):
+			line = paint(colorGreen, line)
+		case strings.HasPrefix(line, "-"):
+			line = paint(colorRed, line)
+		}
+		fmt.Fprintf(b, "          %s\n", line)
+	}
+}
+
+func formatValue(value any) string {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return "(none)"
+	case string:
+		if isDigest(v) {
+			// Content hashes are only useful to tell versions apart.
+			return v[:12]
+		}
+		if v == "" || strings.ContainsAny(v, "\n\t") {
+			text = fmt.Sprintf("%q", v)
+		} else {
+			text = v
+		}
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			text = fmt.Sprint(v)
+		} else {
+			text = string(data)
+		}
+	}
+	if len(text) > maxValueWidth {
+		text = text[:maxValueWidth-3] + "..."
+	}
+	return text
+}
+
+func isDigest(value string) bool {
+	if len(value) != 64 {
+		return false
+	}
+	for _, r := range value {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(diff map[string]DiffEntry) []string {
+	keys := make([]string, 0, len(diff))
+	for key := range diff {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
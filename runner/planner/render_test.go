@@ -0,0 +1,53 @@
+package planner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRenderShowsCurrentDesiredAndText(t *testing.T) {
+	plan := Plan{
+		WorkflowName: "deploy",
+		Steps: []StepPlan{
+			{Name: "install", Action: "pkg.install"},
+			{
+				Name:   "render config",
+				Action: "template.render",
+				Changes: []ResourceChange{{
+					ResourceID: "render config:web1",
+					Diff: map[string]DiffEntry{
+						"dest":    {Desired: "/etc/app.conf"},
+						"mode":    {Current: "0644", Desired: "0600"},
+						"content": {Current: "aaa", Desired: "bbb", Text: "--- /etc/app.conf\n+++ /etc/app.conf\n@@ -1 +1 @@\n-port=80\n+port=8080\n"},
+					},
+				}},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := Render(&out, plan, false); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	text := out.String()
+	for _, want := range []string{
+		"= install (pkg.install): no changes",
+		"~ render config (template.render)",
+		"    web1\n",
+		"~ mode: 0644 -> 0600",
+		"dest: /etc/app.conf",
+		"          +port=8080",
+		"Plan: 1 change(s) in 1 of 2 step(s).",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in output:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "\x1b[") {
+		t.Fatalf("expected no colors")
+	}
+	if !plan.HasChanges() {
+		t.Fatalf("expected plan to have changes")
+	}
+}
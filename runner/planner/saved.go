@@ -0,0 +1,315 @@
+package planner
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"bops/runner/modules"
+	"bops/runner/workflow"
+)
+
+// SavedPlanVersion is the format version written by SavePlan.
+const SavedPlanVersion = 1
+
+// ErrPlanStale reports that the workflow or its inputs changed after the
+// saved plan was computed, so the plan no longer describes what apply would
+// do.
+var ErrPlanStale = errors.New("saved plan is stale")
+
+// SavedPlan is a plan written to disk together with the hashes of everything
+// it was computed from. The signature covers all other fields, so a plan
+// cannot be edited between approval and apply.
+type SavedPlan struct {
+	Version       int    `json:"version"`
+	WorkflowFile  string `json:"workflow_file,omitempty"`
+	WorkflowHash  string `json:"workflow_hash"`
+	InventoryHash string `json:"inventory_hash"`
+	VarsHash      string `json:"vars_hash"`
+	Plan          Plan   `json:"plan"`
+	Signature     string `json:"signature"`
+}
+
+// NewSavedPlan binds plan to the workflow it was computed from.
+func NewSavedPlan(plan Plan, wf workflow.Workflow, workflowFile string) (SavedPlan, error) {
+	hashes, err := hashInputs(wf)
+	if err != nil {
+		return SavedPlan{}, err
+	}
+	return SavedPlan{
+		Version:       SavedPlanVersion,
+		WorkflowFile:  workflowFile,
+		WorkflowHash:  hashes[0],
+		InventoryHash: hashes[1],
+		VarsHash:      hashes[2],
+		Plan:          plan,
+	}, nil
+}
+
+// Save signs the plan with key and writes it to path.
+func (s SavedPlan) Save(path string, key []byte) error {
+	sig, err := s.sign(key)
+	if err != nil {
+		return err
+	}
+	s.Signature = sig
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// LoadSavedPlan reads a plan written by Save and checks its signature.
+func LoadSavedPlan(path string, key []byte) (SavedPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SavedPlan{}, err
+	}
+	var s SavedPlan
+	if err := json.Unmarshal(data, &s); err != nil {
+		return SavedPlan{}, fmt.Errorf("parse saved plan: %w", err)
+	}
+	if s.Version != SavedPlanVersion {
+		return SavedPlan{}, fmt.Errorf("unsupported saved plan version %d", s.Version)
+	}
+	want, err := s.sign(key)
+	if err != nil {
+		return SavedPlan{}, err
+	}
+	if !hmac.Equal([]byte(want), []byte(s.Signature)) {
+		return SavedPlan{}, fmt.Errorf("saved plan signature mismatch")
+	}
+	return s, nil
+}
+
+func (s SavedPlan) sign(key []byte) (string, error) {
+	if len(key) == 0 {
+		return "", fmt.Errorf("plan signing key is empty")
+	}
+	s.Signature = ""
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Verify returns ErrPlanStale when wf, its inventory or its vars differ from
+// the ones the plan was computed from.
+func (s SavedPlan) Verify(wf workflow.Workflow) error {
+	hashes, err := hashInputs(wf)
+	if err != nil {
+		return err
+	}
+	var changed []string
+	if hashes[0] != s.WorkflowHash {
+		changed = append(changed, "workflow")
+	}
+	if hashes[1] != s.InventoryHash {
+		changed = append(changed, "inventory")
+	}
+	if hashes[2] != s.VarsHash {
+		changed = append(changed, "vars")
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("%w: %s changed since the plan was created", ErrPlanStale, strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// operationActions describe operations rather than state. Their Check
+// does not tell whether a run would change anything: it reports a change
+// every time, later steps wait on them, or they set facts and vars that later
+// steps read.
+var operationActions = map[string]struct{}{
+	"cmd.run":         {},
+	"shell.run":       {},
+	"env.set":         {},
+	"facts.gather":    {},
+	"service.restart": {},
+	"wait.event":      {},
+	"wait.for":        {},
+	"workflow.call":   {},
+}
+
+// IsOperation reports whether action describes an operation rather than
+// state, as do script.* steps. A saved plan runs such steps as they are, and
+// drift detection ignores them.
+func IsOperation(action string) bool {
+	if _, ok := operationActions[action]; ok {
+		return true
+	}
+	return strings.HasPrefix(action, "script.")
+}
+
+// Workflow narrows wf to what the plan would change: steps that manage
+// state only target the hosts they change and are dropped when they change
+// none. Operation steps, steps that export vars and steps the plan did not
+// check (their when was false at plan time) are kept as they are. A block
+// step is kept whole unless every step it runs would be dropped, since its
+// rescue and always steps guard the changed steps.
+func (s SavedPlan) Workflow(wf workflow.Workflow) workflow.Workflow {
+	planned := map[string]StepPlan{}
+	for _, step := range s.Plan.Steps {
+		planned[step.Name] = step
+	}
+
+	kept := map[string]bool{}
+	steps := make([]workflow.Step, 0, len(wf.Steps))
+	for _, step := range wf.Steps {
+		if unchanged(step, planned) {
+			continue
+		}
+		if plan, ok := planned[step.Name]; ok && !step.IsBlock() && !keepTargets(step) {
+			step.Targets = changedHosts(plan)
+		}
+		kept[step.Name] = true
+		steps = append(steps, step)
+	}
+	for i, step := range steps {
+		if len(step.DependsOn) == 0 {
+			continue
+		}
+		// Dependencies on unchanged steps are already satisfied.
+		deps := make([]string, 0, len(step.DependsOn))
+		for _, dep := range step.DependsOn {
+			if kept[dep] {
+				deps = append(deps, dep)
+			}
+		}
+		steps[i].DependsOn = deps
+	}
+	wf.Steps = steps
+	return wf
+}
+
+// unchanged reports whether the plan checked step, and every step of it
+// when it is a block step, and found nothing to change.
+func unchanged(step workflow.Step, planned map[string]StepPlan) bool {
+	if step.IsBlock() {
+		for _, section := range []string{workflow.SectionBlock, workflow.SectionAlways} {
+			for _, child := range step.SectionSteps(section) {
+				if !unchanged(child, planned) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if keepTargets(step) {
+		return false
+	}
+	plan, ok := planned[step.Name]
+	return ok && len(plan.Changes) == 0
+}
+
+// keepTargets reports whether step runs on all of its targets regardless of
+// the plan.
+func keepTargets(step workflow.Step) bool {
+	if IsOperation(step.Action) {
+		return true
+	}
+	return modules.ExportVarsEnabled(modules.Request{Step: step})
+}
+
+func changedHosts(plan StepPlan) []string {
+	seen := map[string]struct{}{}
+	targets := make([]string, 0, len(plan.Changes))
+	for _, change := range plan.Changes {
+		hostName := strings.TrimPrefix(change.ResourceID, plan.Name+":")
+		if _, ok := seen[hostName]; ok {
+			continue
+		}
+		seen[hostName] = struct{}{}
+		targets = append(targets, hostName)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// hashInputs returns the hashes of the workflow definition, its inventory
+// and its vars. Local files referenced by args.src are part of the workflow
+// hash since templates and copied files change what a step does.
+func hashInputs(wf workflow.Workflow) ([3]string, error) {
+	var hashes [3]string
+	inventory, vars := wf.Inventory, wf.Vars
+	wf.Inventory, wf.Vars = workflow.Inventory{}, nil
+
+	definition := struct {
+		Workflow workflow.Workflow `json:"workflow"`
+		Files    map[string]string `json:"files,omitempty"`
+	}{Workflow: wf, Files: sourceFiles(wf.Steps)}
+	for i, value := range []any{definition, inventory, vars} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return hashes, err
+		}
+		sum := sha256.Sum256(data)
+		hashes[i] = hex.EncodeToString(sum[:])
+	}
+	return hashes, nil
+}
+
+func sourceFiles(steps []workflow.Step) map[string]string {
+	files := map[string]string{}
+	for _, step := range steps {
+		for _, section := range [][]workflow.Step{step.Block, step.Rescue, step.Always} {
+			for name, sum := range sourceFiles(section) {
+				files[name] = sum
+			}
+		}
+		src, _ := step.Args["src"].(string)
+		if src == "" || strings.Contains(src, "{{") {
+			continue
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		files[src] = hex.EncodeToString(sum[:])
+	}
+	return files
+}
+
+// PlanKey returns the key used to sign saved plans: BOPS_PLAN_KEY when set,
+// otherwise the contents of <dataDir>/plan.key, which is created on first
+// use.
+func PlanKey(dataDir string) ([]byte, error) {
+	if key := os.Getenv("BOPS_PLAN_KEY"); key != "" {
+		return []byte(key), nil
+	}
+	path := filepath.Join(dataDir, "plan.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if key := strings.TrimSpace(string(data)); key != "" {
+			return []byte(key), nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	key := hex.EncodeToString(raw)
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(key+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return []byte(key), nil
+}
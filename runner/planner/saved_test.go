@@ -0,0 +1,222 @@
+package planner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bops/runner/workflow"
+)
+
+func savedTestWorkflow(src string) workflow.Workflow {
+	return workflow.Workflow{
+		Name:        "deploy",
+		GatherFacts: true,
+		Inventory: workflow.Inventory{
+			Hosts: map[string]workflow.Host{"web1": {Address: "10.0.0.1"}, "web2": {Address: "10.0.0.2"}},
+			Groups: map[string]workflow.Group{
+				"web": {Hosts: []string{"web1", "web2"}},
+			},
+		},
+		Vars: map[string]any{"port": 8080},
+		Steps: []workflow.Step{
+			{Name: "install", Targets: []string{"web"}, Action: "pkg.install", Args: map[string]any{"name": "nginx"}},
+			{Name: "render", Targets: []string{"web"}, Action: "template.render", Args: map[string]any{"src": src, "dest": "/etc/app.conf"}, DependsOn: []string{"install"}},
+			{Name: "restart", Targets: []string{"web"}, Action: "service.restart", Args: map[string]any{"name": "nginx"}, DependsOn: []string{"render"}},
+		},
+	}
+}
+
+func savedTestPlan() Plan {
+	return Plan{
+		WorkflowName: "deploy",
+		Steps: []StepPlan{
+			{Name: workflow.GatherFactsStep, Action: "facts.gather", Targets: []string{"web1", "web2"}},
+			{Name: "install", Action: "pkg.install", Targets: []string{"web1", "web2"}},
+			{Name: "render", Action: "template.render", Targets: []string{"web1", "web2"}, Changes: []ResourceChange{
+				{ResourceID: "render:web2", Diff: map[string]DiffEntry{"content": {Current: "a", Desired: "b"}}},
+			}},
+		},
+	}
+}
+
+func TestSavedPlanRoundTripAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app.conf.tmpl")
+	if err := os.WriteFile(src, []byte("port={{ .port }}\n"), 0o644); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	wf := savedTestWorkflow(src)
+	key := []byte("secret")
+
+	saved, err := NewSavedPlan(savedTestPlan(), wf, "deploy.yaml")
+	if err != nil {
+		t.Fatalf("new saved plan: %v", err)
+	}
+	path := filepath.Join(dir, "plan.json")
+	if err := saved.Save(path, key); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded, err := LoadSavedPlan(path, key)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.WorkflowFile != "deploy.yaml" || !loaded.Plan.HasChanges() {
+		t.Fatalf("unexpected saved plan: %+v", loaded)
+	}
+	if err := loaded.Verify(wf); err != nil {
+		t.Fatalf("verify unchanged workflow: %v", err)
+	}
+
+	if _, err := LoadSavedPlan(path, []byte("other")); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("expected signature error with another key, got %v", err)
+	}
+
+	changed := savedTestWorkflow(src)
+	changed.Vars["port"] = 9090
+	changed.Inventory.Hosts["web3"] = workflow.Host{Address: "10.0.0.3"}
+	err = loaded.Verify(changed)
+	if !errors.Is(err, ErrPlanStale) || !strings.Contains(err.Error(), "inventory, vars") {
+		t.Fatalf("expected stale inventory and vars, got %v", err)
+	}
+
+	if err := os.WriteFile(src, []byte("port={{ .port }}\nworkers=4\n"), 0o644); err != nil {
+		t.Fatalf("rewrite template: %v", err)
+	}
+	err = loaded.Verify(wf)
+	if !errors.Is(err, ErrPlanStale) || !strings.Contains(err.Error(), "workflow changed") {
+		t.Fatalf("expected stale workflow after template edit, got %v", err)
+	}
+}
+
+func TestSavedPlanRejectsEditedPlan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	saved, err := NewSavedPlan(savedTestPlan(), savedTestWorkflow(""), "deploy.yaml")
+	if err != nil {
+		t.Fatalf("new saved plan: %v", err)
+	}
+	if err := saved.Save(path, []byte("secret")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	data = []byte(strings.Replace(string(data), "render:web2", "render:web1", 1))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := LoadSavedPlan(path, []byte("secret")); err == nil {
+		t.Fatalf("expected edited plan to be rejected")
+	}
+}
+
+func TestSavedPlanWorkflowKeepsChangedSteps(t *testing.T) {
+	saved := SavedPlan{Plan: savedTestPlan()}
+	wf := savedTestWorkflow("")
+	wf.Steps = append([]workflow.Step{{Name: "facts", Action: "facts.gather"}}, wf.Steps...)
+
+	got := saved.Workflow(wf)
+	if len(got.Steps) != 3 {
+		t.Fatalf("expected facts, render and restart steps, got %+v", got.Steps)
+	}
+	if got.Steps[0].Name != "facts" {
+		t.Fatalf("expected facts.gather step to be kept, got %q", got.Steps[0].Name)
+	}
+	render := got.Steps[1]
+	if render.Name != "render" || !reflect.DeepEqual(render.Targets, []string{"web2"}) {
+		t.Fatalf("expected render on web2 only, got %+v", render)
+	}
+	if len(render.DependsOn) != 0 {
+		t.Fatalf("expected dependency on unchanged step to be dropped, got %v", render.DependsOn)
+	}
+	restart := got.Steps[2]
+	if restart.Name != "restart" || !reflect.DeepEqual(restart.Targets, []string{"web"}) || !reflect.DeepEqual(restart.DependsOn, []string{"render"}) {
+		t.Fatalf("expected restart to be kept as it is, got %+v", restart)
+	}
+	if !got.GatherFacts || got.Vars["port"] != 8080 {
+		t.Fatalf("expected workflow settings to be preserved, got %+v", got)
+	}
+	if len(wf.Steps) != 4 || len(wf.Steps[2].Targets) != 1 || wf.Steps[2].Targets[0] != "web" {
+		t.Fatalf("expected input workflow to be untouched, got %+v", wf.Steps)
+	}
+}
+
+func TestSavedPlanWorkflowKeepsWaitAndExportSteps(t *testing.T) {
+	saved := SavedPlan{Plan: Plan{Steps: []StepPlan{
+		{Name: "version", Action: "pkg.install", Targets: []string{"web1", "web2"}},
+		{Name: "token", Action: "cmd.run", Targets: []string{"web1"}, Changes: []ResourceChange{{ResourceID: "token:web1"}}},
+		{Name: "ready", Action: "wait.for", Targets: []string{"web1", "web2"}},
+		{Name: "config", Action: "file.write", Targets: []string{"web1", "web2"}, Changes: []ResourceChange{{ResourceID: "config:web1"}}},
+	}}}
+	wf := workflow.Workflow{Steps: []workflow.Step{
+		{Name: "version", Targets: []string{"web"}, Action: "pkg.install"},
+		{Name: "token", Targets: []string{"web"}, Action: "cmd.run", Args: map[string]any{"cmd": "echo BOPS_EXPORT:TOKEN=x", "export_vars": true}},
+		{Name: "ready", Targets: []string{"web"}, Action: "wait.for", Args: map[string]any{"port": 8080}},
+		{Name: "config", Targets: []string{"web"}, Action: "file.write", MustVars: []string{"TOKEN"}, DependsOn: []string{"token", "ready"}},
+	}}
+
+	got := saved.Workflow(wf)
+	names := make([]string, 0, len(got.Steps))
+	for _, step := range got.Steps {
+		names = append(names, step.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"token", "ready", "config"}) {
+		t.Fatalf("expected token, ready and config steps, got %v", names)
+	}
+	if !reflect.DeepEqual(got.Steps[0].Targets, []string{"web"}) || !reflect.DeepEqual(got.Steps[1].Targets, []string{"web"}) {
+		t.Fatalf("expected export and wait steps to keep their targets, got %+v", got.Steps[:2])
+	}
+	config := got.Steps[2]
+	if !reflect.DeepEqual(config.Targets, []string{"web1"}) || !reflect.DeepEqual(config.DependsOn, []string{"token", "ready"}) {
+		t.Fatalf("expected config on web1 after token and ready, got %+v", config)
+	}
+}
+
+func TestPlanKeyCreatesAndReusesKeyFile(t *testing.T) {
+	t.Setenv("BOPS_PLAN_KEY", "")
+	dir := filepath.Join(t.TempDir(), "data")
+	first, err := PlanKey(dir)
+	if err != nil {
+		t.Fatalf("plan key: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "plan.key"))
+	if err != nil {
+		t.Fatalf("stat key: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected key mode 0600, got %v", info.Mode().Perm())
+	}
+	second, err := PlanKey(dir)
+	if err != nil || string(first) != string(second) {
+		t.Fatalf("expected the same key on reuse, got %q %q %v", first, second, err)
+	}
+
+	t.Setenv("BOPS_PLAN_KEY", "from-env")
+	if key, _ := PlanKey(dir); string(key) != "from-env" {
+		t.Fatalf("expected env key, got %q", key)
+	}
+}
+
+func TestSavedPlanWorkflowKeepsChangedBlocks(t *testing.T) {
+	saved := SavedPlan{Plan: Plan{Steps: []StepPlan{
+		{Name: "rollout/deploy", Parent: "rollout", Section: workflow.SectionBlock, Changes: []ResourceChange{{ResourceID: "rollout/deploy:web1"}}},
+		{Name: "idle/config", Parent: "idle", Section: workflow.SectionBlock},
+	}}}
+	block := workflow.Step{
+		Name:   "rollout",
+		Block:  []workflow.Step{{Name: "deploy", Action: "file.write"}},
+		Always: []workflow.Step{{Name: "undrain", Action: "cmd.run"}},
+	}
+	idle := workflow.Step{
+		Name:  "idle",
+		Block: []workflow.Step{{Name: "config", Action: "file.write"}},
+	}
+	got := saved.Workflow(workflow.Workflow{Steps: []workflow.Step{block, idle}})
+	if len(got.Steps) != 1 || !reflect.DeepEqual(got.Steps[0], block) {
+		t.Fatalf("expected only the changed block, kept whole, got %+v", got.Steps)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,7 +28,8 @@ type AgentDispatcher struct {
 	HeartbeatPath string
 	// StatusPath overrides the default status endpoint path.
 	StatusPath string
-	// RetryMax defines how many retries after the initial attempt.
+	// RetryMax defines how many retries after the initial attempt. Tasks
+	// the agent rejects with a 4xx other than 429 are not retried.
 	RetryMax int
 	// RetryDelay defines the delay between retries.
 	RetryDelay time.Duration
@@ -36,6 +39,10 @@ type AgentDispatcher struct {
 	HeartbeatTimeout time.Duration
 	// AsyncTimeout controls how long to wait for async task completion.
 	AsyncTimeout time.Duration
+	// BusyTimeout controls how long to keep sending a task to an agent that
+	// answers 429 because it already runs its max_tasks. Defaults to 10
+	// minutes.
+	BusyTimeout time.Duration
 	// PollInterval controls how often to poll /status.
 	PollInterval time.Duration
 	// OnOutput receives streaming output chunks while polling async tasks.
@@ -114,7 +121,7 @@ func (d *AgentDispatcher) Dispatch(ctx context.Context, task Task) (Result, erro
 			}
 		}
 
-		result, err := d.dispatchOnce(ctx, baseURL, task)
+		result, err := d.dispatchWhenFree(ctx, baseURL, task)
 		if err == nil {
 			if strings.EqualFold(result.Status, "running") {
 				return d.pollStatus(ctx, baseURL, result.TaskID)
@@ -123,6 +130,10 @@ func (d *AgentDispatcher) Dispatch(ctx context.Context, task Task) (Result, erro
 		}
 		lastErr = err
 		lastResult = result
+		var rejected *agentRejectedError
+		if errors.As(err, &rejected) {
+			return lastResult, err
+		}
 		if attempt < attempts-1 {
 			logging.L().Warn("agent dispatch failed, retrying",
 				zap.String("task_id", task.ID),
@@ -211,6 +222,12 @@ func (d *AgentDispatcher) dispatchOnce(ctx context.Context, baseURL string, task
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return Result{}, &agentBusyError{
+			retryAfter: retryAfter(resp.Header.Get("Retry-After")),
+			body:       readLimitedBody(resp.Body),
+		}
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body := readLimitedBody(resp.Body)
 		logging.L().Warn("agent dispatch failed",
@@ -220,10 +237,14 @@ func (d *AgentDispatcher) dispatchOnce(ctx context.Context, baseURL string, task
 			zap.String("task_id", task.ID),
 			zap.String("run_id", task.RunID),
 		)
+		err := fmt.Errorf("agent dispatch failed: %s", resp.Status)
 		if body != "" {
-			return Result{}, fmt.Errorf("agent dispatch failed: %s (%s)", resp.Status, body)
+			err = fmt.Errorf("agent dispatch failed: %s (%s)", resp.Status, body)
+		}
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return Result{}, &agentRejectedError{err: err}
 		}
-		return Result{}, fmt.Errorf("agent dispatch failed: %s", resp.Status)
+		return Result{}, err
 	}
 
 	var decoded struct {
@@ -244,6 +265,81 @@ func (d *AgentDispatcher) dispatchOnce(ctx context.Context, baseURL string, task
 	return decoded.Result, nil
 }
 
+// agentRejectedError is a 4xx other than 429: the agent refused the task
+// itself (an unsupported action, a bad token), so sending it again cannot
+// succeed.
+type agentRejectedError struct {
+	err error
+}
+
+func (e *agentRejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *agentRejectedError) Unwrap() error {
+	return e.err
+}
+
+// agentBusyError is a 429 from an agent running its max_tasks.
+type agentBusyError struct {
+	retryAfter time.Duration
+	body       string
+}
+
+func (e *agentBusyError) Error() string {
+	if e.body != "" {
+		return fmt.Sprintf("agent dispatch failed: %d %s (%s)", http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests), e.body)
+	}
+	return fmt.Sprintf("agent dispatch failed: %d %s", http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+}
+
+// dispatchWhenFree sends the task, waiting while the agent is busy. The
+// wait follows Retry-After, or backs off from RetryDelay up to 30 seconds,
+// and gives up after BusyTimeout. A busy agent does not count against
+// RetryMax.
+func (d *AgentDispatcher) dispatchWhenFree(ctx context.Context, baseURL string, task Task) (Result, error) {
+	timeout := d.BusyTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	deadline := time.Now().Add(timeout)
+	delay := d.RetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for {
+		result, err := d.dispatchOnce(ctx, baseURL, task)
+		var busy *agentBusyError
+		if !errors.As(err, &busy) || !time.Now().Before(deadline) {
+			return result, err
+		}
+		wait := delay
+		if busy.retryAfter > 0 {
+			wait = busy.retryAfter
+		}
+		logging.L().Debug("agent busy, waiting to dispatch",
+			zap.String("task_id", task.ID),
+			zap.String("run_id", task.RunID),
+			zap.Duration("wait", wait),
+		)
+		if err := sleepWithContextFor(ctx, wait); err != nil {
+			return Result{}, err
+		}
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header given in seconds.
+func retryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
 func (d *AgentDispatcher) pollStatus(ctx context.Context, baseURL, taskID string) (Result, error) {
 	if strings.TrimSpace(taskID) == "" {
 		return Result{}, fmt.Errorf("task_id is required for status polling")
@@ -2,8 +2,10 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"bops/internal/host"
 	"bops/runner/logging"
 	"bops/runner/modules"
 	"bops/runner/workflow"
@@ -16,6 +18,10 @@ type Task struct {
 	Step  workflow.Step
 	Host  workflow.HostSpec
 	Vars  map[string]any
+	// Rollback runs Module.Rollback instead of Apply, passing ApplyOutput
+	// from the apply being undone.
+	Rollback    bool
+	ApplyOutput map[string]any
 }
 
 type Result struct {
@@ -23,6 +29,9 @@ type Result struct {
 	Status string         `json:"status"`
 	Output map[string]any `json:"output,omitempty"`
 	Error  string         `json:"error,omitempty"`
+	// Batch is the rollout batch the task ran in; set by the engine, not
+	// by dispatchers.
+	Batch int `json:"batch,omitempty"`
 }
 
 type Dispatcher interface {
@@ -31,10 +40,27 @@ type Dispatcher interface {
 
 type LocalDispatcher struct {
 	Registry *modules.Registry
+	// Hosts resolves the adapter used to reach each task host. Nil runs every
+	// task on the local machine.
+	Hosts *host.Pool
 }
 
+// NewLocalDispatcher returns a dispatcher with its own host pool; Close it
+// to release the pooled SSH connections.
 func NewLocalDispatcher(registry *modules.Registry) *LocalDispatcher {
-	return &LocalDispatcher{Registry: registry}
+	return NewLocalDispatcherWithHosts(registry, host.NewPool())
+}
+
+func NewLocalDispatcherWithHosts(registry *modules.Registry, hosts *host.Pool) *LocalDispatcher {
+	return &LocalDispatcher{Registry: registry, Hosts: hosts}
+}
+
+// Close releases the pooled host connections.
+func (d *LocalDispatcher) Close() error {
+	if d == nil || d.Hosts == nil {
+		return nil
+	}
+	return d.Hosts.Close()
 }
 
 func (d *LocalDispatcher) Dispatch(ctx context.Context, task Task) (Result, error) {
@@ -53,11 +79,36 @@ func (d *LocalDispatcher) Dispatch(ctx context.Context, task Task) (Result, erro
 		return Result{}, fmt.Errorf("module %q not registered", task.Step.Action)
 	}
 
-	res, err := module.Apply(ctx, modules.Request{
-		Step: task.Step,
-		Host: task.Host,
-		Vars: task.Vars,
-	})
+	req := modules.Request{
+		RunID: task.RunID,
+		Step:  task.Step,
+		Host:  task.Host,
+		Vars:  task.Vars,
+	}
+	if d.Hosts != nil {
+		adapter, err := d.Hosts.Adapter(ctx, task.Host)
+		if err != nil {
+			logging.L().Debug("dispatch host connect failed",
+				zap.String("task_id", task.ID),
+				zap.String("host", task.Host.Name),
+				zap.Error(err),
+			)
+			return Result{
+				TaskID: task.ID,
+				Status: "failed",
+				Error:  err.Error(),
+			}, err
+		}
+		req.Adapter = adapter
+	}
+
+	if task.Rollback {
+		req.ApplyOutput = task.ApplyOutput
+		res, err := module.Rollback(ctx, req)
+		return RollbackResult(task.ID, res, err)
+	}
+
+	res, err := module.Apply(ctx, req)
 	if err != nil {
 		logging.L().Debug("dispatch task failed",
 			zap.String("task_id", task.ID),
@@ -78,3 +129,32 @@ func (d *LocalDispatcher) Dispatch(ctx context.Context, task Task) (Result, erro
 		Output: res.Output,
 	}, nil
 }
+
+// RollbackResult converts the outcome of Module.Rollback into a task result.
+// Actions that cannot be undone are reported as skipped rather than failed.
+func RollbackResult(taskID string, res modules.Result, err error) (Result, error) {
+	if errors.Is(err, modules.ErrRollbackNotSupported) {
+		return Result{
+			TaskID: taskID,
+			Status: "skipped",
+			Error:  err.Error(),
+		}, nil
+	}
+	if err != nil {
+		logging.L().Debug("dispatch rollback failed",
+			zap.String("task_id", taskID),
+			zap.Error(err),
+		)
+		return Result{
+			TaskID: taskID,
+			Status: "failed",
+			Output: res.Output,
+			Error:  err.Error(),
+		}, err
+	}
+	return Result{
+		TaskID: taskID,
+		Status: "success",
+		Output: res.Output,
+	}, nil
+}
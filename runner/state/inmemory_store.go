@@ -104,9 +104,8 @@ func (s *InMemoryRunStore) ListRuns(ctx context.Context, filter ListFilter) ([]R
 	defer s.mu.RUnlock()
 
 	out := make([]RunState, 0, len(s.runs))
-	statusFilter := strings.TrimSpace(strings.ToLower(filter.Status))
 	for _, run := range s.runs {
-		if statusFilter != "" && strings.ToLower(strings.TrimSpace(run.Status)) != statusFilter {
+		if !filter.Matches(run) {
 			continue
 		}
 		out = append(out, CloneRunState(run))
@@ -144,3 +143,22 @@ func (s *InMemoryRunStore) MarkInterruptedRunning(ctx context.Context, reason st
 	}
 	return updated, nil
 }
+
+func (s *InMemoryRunStore) ResumeRun(ctx context.Context, runID string) (RunState, error) {
+	_ = ctx
+	if err := ValidateRunID(runID); err != nil {
+		return RunState{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[runID]
+	if !ok {
+		return RunState{}, ErrRunNotFound
+	}
+	if err := ValidateRunResume(run); err != nil {
+		return RunState{}, err
+	}
+	run.BeginAttempt(time.Now().UTC())
+	s.runs[runID] = CloneRunState(run)
+	return CloneRunState(run), nil
+}
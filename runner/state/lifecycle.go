@@ -14,6 +14,10 @@ const (
 	RunStatusInterrupted = "interrupted"
 )
 
+// RunPhaseRollback is the phase of a run while completed steps are rolled
+// back after a failure. An empty phase means the apply itself.
+const RunPhaseRollback = "rollback"
+
 var validRunStatus = map[string]struct{}{
 	RunStatusQueued:      {},
 	RunStatusRunning:     {},
@@ -88,3 +92,18 @@ func IsTerminalRunStatus(status string) bool {
 		return false
 	}
 }
+
+// ValidateRunResume reports whether run can continue with another attempt.
+// Only failed, canceled and interrupted runs can be resumed, and not after
+// their completed steps were rolled back.
+func ValidateRunResume(run RunState) error {
+	switch strings.TrimSpace(strings.ToLower(run.Status)) {
+	case RunStatusFailed, RunStatusCanceled, RunStatusInterrupted:
+	default:
+		return fmt.Errorf("run %s is %s and cannot be resumed", run.RunID, run.Status)
+	}
+	if run.Rollback != nil {
+		return fmt.Errorf("run %s was rolled back and cannot be resumed", run.RunID)
+	}
+	return nil
+}
@@ -18,6 +18,10 @@ type HostResult struct {
 	FinishedAt time.Time      `json:"finished_at,omitempty"`
 	Message    string         `json:"message,omitempty"`
 	Output     map[string]any `json:"output,omitempty"`
+	Batch      int            `json:"batch,omitempty"`
+	// OutputFiles maps output fields that were too large to keep inline to
+	// the log file holding their full value.
+	OutputFiles map[string]string `json:"output_files,omitempty"`
 }
 
 type StepState struct {
@@ -27,21 +31,49 @@ type StepState struct {
 	FinishedAt time.Time             `json:"finished_at,omitempty"`
 	Message    string                `json:"message,omitempty"`
 	Hosts      map[string]HostResult `json:"hosts,omitempty"`
+	// Vars and AllowedVars are the vars the step exported and the subset
+	// listed in expect_vars, kept so a resumed run can restore them.
+	Vars        map[string]any `json:"vars,omitempty"`
+	AllowedVars map[string]any `json:"allowed_vars,omitempty"`
+	// Parent and Section place a step of a block step: the name of the
+	// block step and block, rescue or always.
+	Parent  string `json:"parent,omitempty"`
+	Section string `json:"section,omitempty"`
+}
+
+// PhaseState records a pass over steps that runs after the main apply, such
+// as rolling back completed steps.
+type PhaseState struct {
+	Status     string      `json:"status"`
+	StartedAt  time.Time   `json:"started_at,omitempty"`
+	FinishedAt time.Time   `json:"finished_at,omitempty"`
+	Message    string      `json:"message,omitempty"`
+	Steps      []StepState `json:"steps,omitempty"`
 }
 
 type RunState struct {
 	RunID             string                   `json:"run_id"`
 	WorkflowName      string                   `json:"workflow_name"`
 	WorkflowVersion   string                   `json:"workflow_version,omitempty"`
+	ScheduleID        string                   `json:"schedule_id,omitempty"`
+	ParentRunID       string                   `json:"parent_run_id,omitempty"`
 	Status            string                   `json:"status"`
+	Attempt           int                      `json:"attempt,omitempty"`
 	Message           string                   `json:"message,omitempty"`
 	LastError         string                   `json:"last_error,omitempty"`
 	InterruptedReason string                   `json:"interrupted_reason,omitempty"`
 	LastNotifyError   string                   `json:"last_notify_error,omitempty"`
 	Version           int64                    `json:"version"`
+	QueuedAt          time.Time                `json:"queued_at,omitempty"`
 	StartedAt         time.Time                `json:"started_at,omitempty"`
 	FinishedAt        time.Time                `json:"finished_at,omitempty"`
 	UpdatedAt         time.Time                `json:"updated_at,omitempty"`
 	Steps             []StepState              `json:"steps,omitempty"`
 	Resources         map[string]ResourceState `json:"resources,omitempty"`
+	Phase             string                   `json:"phase,omitempty"`
+	Rollback          *PhaseState              `json:"rollback,omitempty"`
+	// Facts holds the facts gathered per host by facts.gather.
+	Facts map[string]map[string]any `json:"facts,omitempty"`
+	// Params holds the non-secret workflow params the run was started with.
+	Params map[string]any `json:"params,omitempty"`
 }
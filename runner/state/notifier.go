@@ -14,6 +14,7 @@ type RunStateCallback struct {
 	RunID        string    `json:"run_id"`
 	WorkflowName string    `json:"workflow_name,omitempty"`
 	Status       string    `json:"status"`
+	Phase        string    `json:"phase,omitempty"`
 	Step         string    `json:"step,omitempty"`
 	Host         string    `json:"host,omitempty"`
 	Timestamp    time.Time `json:"timestamp"`
@@ -16,11 +16,68 @@ func CloneRunState(input RunState) RunState {
 			out.Steps = append(out.Steps, cloneStep(step))
 		}
 	}
+	if input.Rollback != nil {
+		rollback := *input.Rollback
+		rollback.Steps = nil
+		for _, step := range input.Rollback.Steps {
+			rollback.Steps = append(rollback.Steps, cloneStep(step))
+		}
+		out.Rollback = &rollback
+	}
+	if len(input.Facts) > 0 {
+		out.Facts = make(map[string]map[string]any, len(input.Facts))
+		for host, facts := range input.Facts {
+			out.Facts[host] = facts
+		}
+	}
+	if len(input.Params) > 0 {
+		out.Params = make(map[string]any, len(input.Params))
+		for k, v := range input.Params {
+			out.Params[k] = v
+		}
+	}
 	return out
 }
 
+// BeginAttempt moves a resumable run back to running under a new attempt
+// number, keeping the steps recorded by earlier attempts.
+func (r *RunState) BeginAttempt(now time.Time) {
+	if r.Attempt < 1 {
+		r.Attempt = 1
+	}
+	r.Attempt++
+	r.Status = RunStatusRunning
+	r.Message = ""
+	r.LastError = ""
+	r.InterruptedReason = ""
+	r.Phase = ""
+	r.FinishedAt = time.Time{}
+	r.UpdatedAt = now
+	r.Version++
+}
+
+// BeginPhase switches the run to phase; step updates made afterwards are
+// recorded under that phase instead of the apply steps.
+func (r *RunState) BeginPhase(phase string, now time.Time) {
+	r.Phase = phase
+	if phase == RunPhaseRollback {
+		r.Rollback = &PhaseState{
+			Status:    RunStatusRunning,
+			StartedAt: now,
+		}
+	}
+}
+
+func (r *RunState) FinishPhase(phase, status, message string, now time.Time) {
+	if phase == RunPhaseRollback && r.Rollback != nil {
+		r.Rollback.Status = status
+		r.Rollback.Message = message
+		r.Rollback.FinishedAt = now
+	}
+}
+
 func (r *RunState) UpsertStepStart(stepName string, now time.Time) {
-	step := r.ensureStep(stepName)
+	step := r.EnsureStep(stepName)
 	if step.StartedAt.IsZero() {
 		step.StartedAt = now
 	}
@@ -28,7 +85,7 @@ func (r *RunState) UpsertStepStart(stepName string, now time.Time) {
 }
 
 func (r *RunState) UpsertStepFinish(stepName, status, message string, now time.Time) {
-	step := r.ensureStep(stepName)
+	step := r.EnsureStep(stepName)
 	if step.StartedAt.IsZero() {
 		step.StartedAt = now
 	}
@@ -38,24 +95,30 @@ func (r *RunState) UpsertStepFinish(stepName, status, message string, now time.T
 }
 
 func (r *RunState) UpsertHostResult(stepName string, host HostResult) {
-	step := r.ensureStep(stepName)
+	step := r.EnsureStep(stepName)
 	if step.Hosts == nil {
 		step.Hosts = map[string]HostResult{}
 	}
 	step.Hosts[host.Host] = cloneHost(host)
 }
 
-func (r *RunState) ensureStep(name string) *StepState {
-	for i := range r.Steps {
-		if r.Steps[i].Name == name {
-			return &r.Steps[i]
+// EnsureStep returns the named step of the current phase, adding it when
+// missing.
+func (r *RunState) EnsureStep(name string) *StepState {
+	steps := &r.Steps
+	if r.Phase == RunPhaseRollback && r.Rollback != nil {
+		steps = &r.Rollback.Steps
+	}
+	for i := range *steps {
+		if (*steps)[i].Name == name {
+			return &(*steps)[i]
 		}
 	}
-	r.Steps = append(r.Steps, StepState{
+	*steps = append(*steps, StepState{
 		Name:  name,
 		Hosts: map[string]HostResult{},
 	})
-	return &r.Steps[len(r.Steps)-1]
+	return &(*steps)[len(*steps)-1]
 }
 
 func cloneResource(input ResourceState) ResourceState {
@@ -68,6 +131,8 @@ func cloneResource(input ResourceState) ResourceState {
 
 func cloneStep(input StepState) StepState {
 	out := input
+	out.Vars = cloneMap(input.Vars)
+	out.AllowedVars = cloneMap(input.AllowedVars)
 	if len(input.Hosts) > 0 {
 		out.Hosts = make(map[string]HostResult, len(input.Hosts))
 		for host, res := range input.Hosts {
@@ -80,6 +145,12 @@ func cloneStep(input StepState) StepState {
 func cloneHost(input HostResult) HostResult {
 	out := input
 	out.Output = cloneMap(input.Output)
+	if len(input.OutputFiles) > 0 {
+		out.OutputFiles = make(map[string]string, len(input.OutputFiles))
+		for k, v := range input.OutputFiles {
+			out.OutputFiles[k] = v
+		}
+	}
 	return out
 }
 
@@ -3,6 +3,8 @@ package state
 import (
 	"context"
 	"errors"
+	"strings"
+	"time"
 )
 
 var (
@@ -11,8 +13,25 @@ var (
 )
 
 type ListFilter struct {
-	Status string
-	Limit  int
+	WorkflowName string
+	Status       string
+	// Since keeps runs started at or after the given time.
+	Since time.Time
+	Limit int
+}
+
+// Matches reports whether run passes the filter, ignoring Limit.
+func (f ListFilter) Matches(run RunState) bool {
+	if name := strings.TrimSpace(f.WorkflowName); name != "" && strings.TrimSpace(run.WorkflowName) != name {
+		return false
+	}
+	if status := strings.TrimSpace(strings.ToLower(f.Status)); status != "" && strings.ToLower(strings.TrimSpace(run.Status)) != status {
+		return false
+	}
+	if !f.Since.IsZero() && run.StartedAt.Before(f.Since) {
+		return false
+	}
+	return true
 }
 
 type RunStateStore interface {
@@ -21,4 +40,7 @@ type RunStateStore interface {
 	GetRun(ctx context.Context, runID string) (RunState, error)
 	ListRuns(ctx context.Context, filter ListFilter) ([]RunState, error)
 	MarkInterruptedRunning(ctx context.Context, reason string) (int, error)
+	// ResumeRun moves a failed, canceled or interrupted run back to running
+	// with the next attempt number and returns the updated run.
+	ResumeRun(ctx context.Context, runID string) (RunState, error)
 }
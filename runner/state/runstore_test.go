@@ -106,3 +106,53 @@ func TestStoreGetRunNotFound(t *testing.T) {
 		t.Fatalf("expected IsNotFound to return true")
 	}
 }
+
+func TestFileStoreResumeRunStartsNextAttempt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runner-state.json")
+	store := NewFileStore(path)
+	runID := NewRunID()
+	now := time.Now().UTC()
+
+	if err := store.CreateRun(context.Background(), RunState{
+		RunID:        runID,
+		WorkflowName: "wf",
+		Status:       RunStatusRunning,
+		Attempt:      1,
+		StartedAt:    now,
+		UpdatedAt:    now,
+		Steps: []StepState{
+			{Name: "build", Status: RunStatusSuccess, Vars: map[string]any{"TOKEN": "abc"}},
+		},
+	}); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if _, err := store.ResumeRun(context.Background(), runID); err == nil {
+		t.Fatalf("expected running run not to be resumable")
+	}
+	if _, err := store.MarkInterruptedRunning(context.Background(), "process restarted"); err != nil {
+		t.Fatalf("mark interrupted: %v", err)
+	}
+
+	resumed, err := NewFileStore(path).ResumeRun(context.Background(), runID)
+	if err != nil {
+		t.Fatalf("resume run: %v", err)
+	}
+	if resumed.Status != RunStatusRunning || resumed.Attempt != 2 {
+		t.Fatalf("expected running attempt 2, got %q attempt %d", resumed.Status, resumed.Attempt)
+	}
+	if !resumed.FinishedAt.IsZero() || resumed.InterruptedReason != "" {
+		t.Fatalf("expected finished_at and interrupted reason to be cleared")
+	}
+
+	got, err := store.GetRun(context.Background(), runID)
+	if err != nil {
+		t.Fatalf("get resumed run: %v", err)
+	}
+	if got.Attempt != 2 || len(got.Steps) != 1 || got.Steps[0].Vars["TOKEN"] != "abc" {
+		t.Fatalf("expected resumed run to keep steps and vars, got %+v", got)
+	}
+
+	if _, err := store.ResumeRun(context.Background(), "run-unknown-001"); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("expected ErrRunNotFound, got %v", err)
+	}
+}
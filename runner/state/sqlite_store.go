@@ -0,0 +1,438 @@
+package state
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"bops/runner/logging"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+// ErrVersionConflict is returned by SQLiteStore.UpdateRun when the run was
+// updated by someone else since the caller read it.
+var ErrVersionConflict = errors.New("run state version conflict")
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	run_id        TEXT PRIMARY KEY,
+	workflow_name TEXT NOT NULL DEFAULT '',
+	status        TEXT NOT NULL,
+	version       INTEGER NOT NULL,
+	started_at    INTEGER NOT NULL,
+	updated_at    INTEGER NOT NULL,
+	payload       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS runs_started_idx ON runs (started_at DESC);
+CREATE INDEX IF NOT EXISTS runs_status_started_idx ON runs (status, started_at DESC);
+CREATE INDEX IF NOT EXISTS runs_workflow_started_idx ON runs (workflow_name, started_at DESC);
+`
+
+// SQLiteStore keeps one row per run in a SQLite database. The full RunState
+// is stored as JSON next to the columns used for filtering and ordering.
+//
+// Updates use optimistic concurrency on RunState.Version: a run whose
+// version is older than the stored one is rejected with ErrVersionConflict,
+// while an equal version is accepted and bumped like the other stores.
+type SQLiteStore struct {
+	Path string
+	db   *sql.DB
+}
+
+var (
+	_ RunStateStore = (*SQLiteStore)(nil)
+	_ Store         = (*SQLiteStore)(nil)
+)
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open run state db: %w", err)
+	}
+	// SQLite allows a single writer; one connection avoids SQLITE_BUSY
+	// between goroutines of this process.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate run state db: %w", err)
+	}
+	logging.L().Debug("run state db opened", zap.String("path", path))
+	return &SQLiteStore{Path: path, db: db}, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) CreateRun(ctx context.Context, run RunState) error {
+	if err := ValidateRunID(run.RunID); err != nil {
+		return err
+	}
+	if strings.TrimSpace(run.Status) == "" {
+		run.Status = RunStatusQueued
+	}
+	if err := ValidateRunStatus(run.Status); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if run.StartedAt.IsZero() {
+		run.StartedAt = now
+	}
+	if run.UpdatedAt.IsZero() {
+		run.UpdatedAt = now
+	}
+	if run.Version < 1 {
+		run.Version = 1
+	}
+
+	payload, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO runs (run_id, workflow_name, status, version, started_at, updated_at, payload)
+		 VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (run_id) DO NOTHING`,
+		run.RunID, strings.TrimSpace(run.WorkflowName), normalizeStatus(run.Status), run.Version,
+		run.StartedAt.UnixNano(), run.UpdatedAt.UnixNano(), string(payload),
+	)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrRunExists
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UpdateRun(ctx context.Context, run RunState) error {
+	if err := ValidateRunID(run.RunID); err != nil {
+		return err
+	}
+	if err := ValidateRunStatus(run.Status); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	prev, err := s.get(ctx, tx, run.RunID)
+	if err != nil {
+		return err
+	}
+	if run.Version < prev.Version {
+		return fmt.Errorf("%w: run %s is at version %d, got %d", ErrVersionConflict, run.RunID, prev.Version, run.Version)
+	}
+	if err := ValidateRunTransition(prev.Status, run.Status); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if run.StartedAt.IsZero() {
+		run.StartedAt = prev.StartedAt
+	}
+	if IsTerminalRunStatus(run.Status) && run.FinishedAt.IsZero() {
+		run.FinishedAt = now
+	}
+	run.UpdatedAt = now
+	if run.Version == prev.Version {
+		run.Version = prev.Version + 1
+	}
+	if err := s.replace(ctx, tx, run, prev.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Load returns every stored run, oldest first, for callers that work on the
+// whole run history like the server's run manager.
+func (s *SQLiteStore) Load() (StateFile, error) {
+	rows, err := s.db.Query(`SELECT payload FROM runs ORDER BY started_at ASC`)
+	if err != nil {
+		return StateFile{}, err
+	}
+	defer rows.Close()
+
+	data := StateFile{Runs: []RunState{}}
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			return StateFile{}, err
+		}
+		var run RunState
+		if err := json.Unmarshal([]byte(payload), &run); err != nil {
+			return StateFile{}, fmt.Errorf("decode run state: %w", err)
+		}
+		data.Runs = append(data.Runs, run)
+	}
+	return data, rows.Err()
+}
+
+// Save replaces the stored runs with data.Runs in one transaction: runs are
+// inserted or overwritten as given and runs missing from data are deleted.
+// Unlike UpdateRun it does not check versions.
+func (s *SQLiteStore) Save(data StateFile) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	keep := make(map[string]struct{}, len(data.Runs))
+	for _, run := range data.Runs {
+		if err := ValidateRunID(run.RunID); err != nil {
+			return err
+		}
+		if run.Version < 1 {
+			run.Version = 1
+		}
+		payload, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO runs (run_id, workflow_name, status, version, started_at, updated_at, payload)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)
+			 ON CONFLICT (run_id) DO UPDATE SET workflow_name = excluded.workflow_name, status = excluded.status,
+			 version = excluded.version, started_at = excluded.started_at, updated_at = excluded.updated_at, payload = excluded.payload`,
+			run.RunID, strings.TrimSpace(run.WorkflowName), normalizeStatus(run.Status), run.Version,
+			run.StartedAt.UnixNano(), run.UpdatedAt.UnixNano(), string(payload),
+		); err != nil {
+			return err
+		}
+		keep[run.RunID] = struct{}{}
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT run_id FROM runs`)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var runID string
+		if err := rows.Scan(&runID); err != nil {
+			rows.Close()
+			return err
+		}
+		if _, ok := keep[runID]; !ok {
+			stale = append(stale, runID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, runID := range stale {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM runs WHERE run_id = ?`, runID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetRun(ctx context.Context, runID string) (RunState, error) {
+	if err := ValidateRunID(runID); err != nil {
+		return RunState{}, err
+	}
+	return s.get(ctx, s.db, runID)
+}
+
+func (s *SQLiteStore) ListRuns(ctx context.Context, filter ListFilter) ([]RunState, error) {
+	query := `SELECT payload FROM runs`
+	var where []string
+	var args []any
+	if name := strings.TrimSpace(filter.WorkflowName); name != "" {
+		where = append(where, "workflow_name = ?")
+		args = append(args, name)
+	}
+	if status := normalizeStatus(filter.Status); status != "" {
+		where = append(where, "status = ?")
+		args = append(args, status)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "started_at >= ?")
+		args = append(args, filter.Since.UnixNano())
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY started_at DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []RunState{}
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			return nil, err
+		}
+		var run RunState
+		if err := json.Unmarshal([]byte(payload), &run); err != nil {
+			return nil, fmt.Errorf("decode run state: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func (s *SQLiteStore) MarkInterruptedRunning(ctx context.Context, reason string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx, `SELECT payload FROM runs WHERE status = ?`, RunStatusRunning)
+	if err != nil {
+		return 0, err
+	}
+	var running []RunState
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		var run RunState
+		if err := json.Unmarshal([]byte(payload), &run); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("decode run state: %w", err)
+		}
+		running = append(running, run)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	for _, run := range running {
+		prevVersion := run.Version
+		run.Status = RunStatusInterrupted
+		run.InterruptedReason = strings.TrimSpace(reason)
+		if run.InterruptedReason != "" {
+			run.Message = run.InterruptedReason
+		}
+		run.FinishedAt = now
+		run.UpdatedAt = now
+		run.Version++
+		if err := s.replace(ctx, tx, run, prevVersion); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(running), nil
+}
+
+func (s *SQLiteStore) ResumeRun(ctx context.Context, runID string) (RunState, error) {
+	if err := ValidateRunID(runID); err != nil {
+		return RunState{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return RunState{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	run, err := s.get(ctx, tx, runID)
+	if err != nil {
+		return RunState{}, err
+	}
+	if err := ValidateRunResume(run); err != nil {
+		return RunState{}, err
+	}
+	prevVersion := run.Version
+	run.BeginAttempt(time.Now().UTC())
+	if err := s.replace(ctx, tx, run, prevVersion); err != nil {
+		return RunState{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RunState{}, err
+	}
+	return run, nil
+}
+
+type sqlExecQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *SQLiteStore) get(ctx context.Context, q sqlExecQuerier, runID string) (RunState, error) {
+	var payload string
+	err := q.QueryRowContext(ctx, `SELECT payload FROM runs WHERE run_id = ?`, runID).Scan(&payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RunState{}, ErrRunNotFound
+	}
+	if err != nil {
+		return RunState{}, err
+	}
+	var run RunState
+	if err := json.Unmarshal([]byte(payload), &run); err != nil {
+		return RunState{}, fmt.Errorf("decode run state: %w", err)
+	}
+	return run, nil
+}
+
+// replace writes run over the stored row, provided the row is still at
+// prevVersion.
+func (s *SQLiteStore) replace(ctx context.Context, q sqlExecQuerier, run RunState, prevVersion int64) error {
+	payload, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	res, err := q.ExecContext(ctx,
+		`UPDATE runs SET workflow_name = ?, status = ?, version = ?, started_at = ?, updated_at = ?, payload = ?
+		 WHERE run_id = ? AND version = ?`,
+		strings.TrimSpace(run.WorkflowName), normalizeStatus(run.Status), run.Version,
+		run.StartedAt.UnixNano(), run.UpdatedAt.UnixNano(), string(payload),
+		run.RunID, prevVersion,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: run %s changed while updating", ErrVersionConflict, run.RunID)
+	}
+	return nil
+}
+
+func normalizeStatus(status string) string {
+	return strings.TrimSpace(strings.ToLower(status))
+}
@@ -0,0 +1,185 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSQLiteStore(t *testing.T, path string) *SQLiteStore {
+	t.Helper()
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("open sqlite store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestSQLiteStoreLifecycleAndVersionConflict(t *testing.T) {
+	store := newTestSQLiteStore(t, filepath.Join(t.TempDir(), "runs.db"))
+	ctx := context.Background()
+	runID := NewRunID()
+
+	if err := store.CreateRun(ctx, RunState{RunID: runID, WorkflowName: "wf", Status: RunStatusQueued}); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if err := store.CreateRun(ctx, RunState{RunID: runID, WorkflowName: "wf"}); !errors.Is(err, ErrRunExists) {
+		t.Fatalf("expected ErrRunExists, got %v", err)
+	}
+
+	stale, err := store.GetRun(ctx, runID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	run := stale
+	run.Status = RunStatusRunning
+	run.Steps = []StepState{{Name: "build", Status: RunStatusSuccess, Vars: map[string]any{"TOKEN": "abc"}}}
+	if err := store.UpdateRun(ctx, run); err != nil {
+		t.Fatalf("set running: %v", err)
+	}
+
+	got, err := store.GetRun(ctx, runID)
+	if err != nil {
+		t.Fatalf("get running run: %v", err)
+	}
+	if got.Status != RunStatusRunning || got.Version != stale.Version+1 {
+		t.Fatalf("expected running at version %d, got %q at %d", stale.Version+1, got.Status, got.Version)
+	}
+	if got.Steps[0].Vars["TOKEN"] != "abc" {
+		t.Fatalf("expected step vars to round-trip, got %+v", got.Steps)
+	}
+
+	stale.Status = RunStatusFailed
+	if err := store.UpdateRun(ctx, stale); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict for stale update, got %v", err)
+	}
+
+	got.Status = RunStatusSuccess
+	if err := store.UpdateRun(ctx, got); err != nil {
+		t.Fatalf("set success: %v", err)
+	}
+	got, _ = store.GetRun(ctx, runID)
+	if got.FinishedAt.IsZero() {
+		t.Fatalf("expected finished_at to be set")
+	}
+	got.Status = RunStatusRunning
+	if err := store.UpdateRun(ctx, got); err == nil {
+		t.Fatalf("expected transition success->running to fail")
+	}
+
+	if _, err := store.GetRun(ctx, "run-unknown-001"); !IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestSQLiteStoreListRunsFilters(t *testing.T) {
+	store := newTestSQLiteStore(t, filepath.Join(t.TempDir(), "runs.db"))
+	ctx := context.Background()
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	fixtures := []RunState{
+		{RunID: "run-list-0001", WorkflowName: "deploy", Status: RunStatusSuccess, StartedAt: base},
+		{RunID: "run-list-0002", WorkflowName: "deploy", Status: RunStatusFailed, StartedAt: base.Add(time.Hour)},
+		{RunID: "run-list-0003", WorkflowName: "backup", Status: RunStatusFailed, StartedAt: base.Add(2 * time.Hour)},
+		{RunID: "run-list-0004", WorkflowName: "deploy", Status: RunStatusSuccess, StartedAt: base.Add(3 * time.Hour)},
+	}
+	for _, run := range fixtures {
+		if err := store.CreateRun(ctx, run); err != nil {
+			t.Fatalf("create %s: %v", run.RunID, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter ListFilter
+		want   []string
+	}{
+		{name: "all newest first", filter: ListFilter{}, want: []string{"run-list-0004", "run-list-0003", "run-list-0002", "run-list-0001"}},
+		{name: "by workflow", filter: ListFilter{WorkflowName: "deploy", Limit: 2}, want: []string{"run-list-0004", "run-list-0002"}},
+		{name: "by status", filter: ListFilter{Status: "FAILED"}, want: []string{"run-list-0003", "run-list-0002"}},
+		{name: "since", filter: ListFilter{WorkflowName: "deploy", Since: base.Add(time.Hour)}, want: []string{"run-list-0004", "run-list-0002"}},
+	}
+	for _, tc := range tests {
+		runs, err := store.ListRuns(ctx, tc.filter)
+		if err != nil {
+			t.Fatalf("%s: list runs: %v", tc.name, err)
+		}
+		var got []string
+		for _, run := range runs {
+			got = append(got, run.RunID)
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("%s: expected %v, got %v", tc.name, tc.want, got)
+			}
+		}
+	}
+}
+
+func TestSQLiteStoreInterruptAndResumeAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs.db")
+	store := newTestSQLiteStore(t, path)
+	ctx := context.Background()
+	runID := NewRunID()
+
+	if err := store.CreateRun(ctx, RunState{RunID: runID, WorkflowName: "wf", Status: RunStatusRunning, Attempt: 1}); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	_ = store.Close()
+
+	reopened := newTestSQLiteStore(t, path)
+	updated, err := reopened.MarkInterruptedRunning(ctx, "process restarted")
+	if err != nil {
+		t.Fatalf("mark interrupted: %v", err)
+	}
+	if updated != 1 {
+		t.Fatalf("expected 1 run updated, got %d", updated)
+	}
+	runs, err := reopened.ListRuns(ctx, ListFilter{Status: RunStatusInterrupted})
+	if err != nil || len(runs) != 1 || runs[0].InterruptedReason != "process restarted" {
+		t.Fatalf("expected interrupted run listed, got %+v (%v)", runs, err)
+	}
+
+	resumed, err := reopened.ResumeRun(ctx, runID)
+	if err != nil {
+		t.Fatalf("resume run: %v", err)
+	}
+	if resumed.Status != RunStatusRunning || resumed.Attempt != 2 {
+		t.Fatalf("expected running attempt 2, got %q attempt %d", resumed.Status, resumed.Attempt)
+	}
+	if _, err := reopened.ResumeRun(ctx, runID); err == nil {
+		t.Fatalf("expected running run not to be resumable")
+	}
+}
+
+func TestSQLiteStoreLoadSaveReplacesRuns(t *testing.T) {
+	store := newTestSQLiteStore(t, filepath.Join(t.TempDir(), "runs.db"))
+	now := time.Now().UTC()
+	first := RunState{RunID: "run-00000001", WorkflowName: "wf", Status: RunStatusSuccess, StartedAt: now.Add(-time.Minute)}
+	second := RunState{RunID: "run-00000002", WorkflowName: "wf", Status: RunStatusRunning, StartedAt: now}
+	if err := store.Save(StateFile{Runs: []RunState{first, second}}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	second.Status = RunStatusFailed
+	if err := store.Save(StateFile{Runs: []RunState{second}}); err != nil {
+		t.Fatalf("save again: %v", err)
+	}
+	data, err := store.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(data.Runs) != 1 || data.Runs[0].RunID != "run-00000002" || data.Runs[0].Status != RunStatusFailed {
+		t.Fatalf("expected only the updated second run, got %+v", data.Runs)
+	}
+	runs, err := store.ListRuns(context.Background(), ListFilter{Status: RunStatusFailed})
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("expected saved run to be listed by status, got %+v (%v)", runs, err)
+	}
+}
@@ -221,9 +221,8 @@ func (s *FileStore) ListRuns(ctx context.Context, filter ListFilter) ([]RunState
 		return nil, err
 	}
 	runs := make([]RunState, 0, len(data.Runs))
-	statusFilter := strings.TrimSpace(strings.ToLower(filter.Status))
 	for _, run := range data.Runs {
-		if statusFilter != "" && strings.ToLower(strings.TrimSpace(run.Status)) != statusFilter {
+		if !filter.Matches(run) {
 			continue
 		}
 		runs = append(runs, CloneRunState(run))
@@ -272,6 +271,34 @@ func (s *FileStore) MarkInterruptedRunning(ctx context.Context, reason string) (
 	return updated, nil
 }
 
+func (s *FileStore) ResumeRun(ctx context.Context, runID string) (RunState, error) {
+	_ = ctx
+	if err := ValidateRunID(runID); err != nil {
+		return RunState{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadNoLock()
+	if err != nil {
+		return RunState{}, err
+	}
+	run, idx := findRun(data.Runs, runID)
+	if idx < 0 {
+		return RunState{}, ErrRunNotFound
+	}
+	if err := ValidateRunResume(run); err != nil {
+		return RunState{}, err
+	}
+	run.BeginAttempt(time.Now().UTC())
+	data.Runs[idx] = CloneRunState(run)
+	if err := s.saveNoLock(data); err != nil {
+		return RunState{}, err
+	}
+	return CloneRunState(run), nil
+}
+
 func findRun(runs []RunState, runID string) (RunState, int) {
 	for i := range runs {
 		if runs[i].RunID == runID {
@@ -0,0 +1,60 @@
+package workflow
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BatchSize resolves a serial setting (a host count or a percentage such as
+// "25%") to the number of hosts per batch for total targets. A nil serial
+// means a single batch with every target.
+func BatchSize(serial any, total int) (int, error) {
+	if serial == nil {
+		return total, nil
+	}
+
+	size := 0
+	switch v := serial.(type) {
+	case int:
+		size = v
+	case int64:
+		size = int(v)
+	case uint64:
+		size = int(v)
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("serial must be a whole number, got %v", v)
+		}
+		size = int(v)
+	case string:
+		raw := strings.TrimSpace(v)
+		if strings.HasSuffix(raw, "%") {
+			percent, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(raw, "%")))
+			if err != nil || percent <= 0 || percent > 100 {
+				return 0, fmt.Errorf("serial percentage must be between 1%% and 100%%, got %q", v)
+			}
+			size = int(math.Ceil(float64(total) * float64(percent) / 100))
+			if size < 1 {
+				size = 1
+			}
+			break
+		}
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, fmt.Errorf("serial must be a host count or percentage, got %q", v)
+		}
+		size = parsed
+	default:
+		return 0, fmt.Errorf("serial must be a host count or percentage, got %T", serial)
+	}
+
+	if size <= 0 {
+		return 0, fmt.Errorf("serial must be positive, got %v", serial)
+	}
+	if size > total {
+		size = total
+	}
+	return size, nil
+}
@@ -0,0 +1,33 @@
+package workflow
+
+import "testing"
+
+func TestBatchSize(t *testing.T) {
+	cases := []struct {
+		serial any
+		total  int
+		want   int
+	}{
+		{serial: nil, total: 7, want: 7},
+		{serial: 2, total: 7, want: 2},
+		{serial: float64(3), total: 7, want: 3},
+		{serial: "10", total: 7, want: 7},
+		{serial: "25%", total: 7, want: 2},
+		{serial: "1%", total: 7, want: 1},
+	}
+	for _, tc := range cases {
+		got, err := BatchSize(tc.serial, tc.total)
+		if err != nil {
+			t.Fatalf("serial %v: %v", tc.serial, err)
+		}
+		if got != tc.want {
+			t.Fatalf("serial %v: expected %d, got %d", tc.serial, tc.want, got)
+		}
+	}
+
+	for _, serial := range []any{0, "0%", "150%", "half", 1.5, []any{1}} {
+		if _, err := BatchSize(serial, 4); err == nil {
+			t.Fatalf("expected error for serial %v", serial)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package workflow
+
+const (
+	SectionBlock  = "block"
+	SectionRescue = "rescue"
+	SectionAlways = "always"
+)
+
+// IsBlock reports whether the step groups block, rescue and always steps
+// instead of running an action.
+func (s Step) IsBlock() bool {
+	return len(s.Block) > 0 || len(s.Rescue) > 0 || len(s.Always) > 0
+}
+
+// SectionSteps returns the steps of one section of a block step as they
+// run: named "<block>/<step>", on the block targets unless they set their
+// own, under the block's when and with the block vars below their own.
+func (s Step) SectionSteps(section string) []Step {
+	var steps []Step
+	switch section {
+	case SectionBlock:
+		steps = s.Block
+	case SectionRescue:
+		steps = s.Rescue
+	case SectionAlways:
+		steps = s.Always
+	}
+	out := make([]Step, 0, len(steps))
+	for _, child := range steps {
+		child.Name = s.Name + "/" + child.Name
+		if len(child.Targets) == 0 {
+			child.Targets = s.Targets
+		}
+		child.When = joinWhen(s.When, child.When)
+		if len(s.Vars) > 0 {
+			child.Vars = mergeVars(s.Vars, child.Vars)
+		}
+		child.Parent = s.Name
+		child.Section = section
+		out = append(out, child)
+	}
+	return out
+}
+
+// FlattenSteps returns steps with the steps of every section of each block
+// step following it, named and scoped as they run.
+func FlattenSteps(steps []Step) []Step {
+	out := make([]Step, 0, len(steps))
+	for _, step := range steps {
+		out = append(out, step)
+		if !step.IsBlock() {
+			continue
+		}
+		for _, section := range []string{SectionBlock, SectionRescue, SectionAlways} {
+			out = append(out, FlattenSteps(step.SectionSteps(section))...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,63 @@
+package workflow
+
+// DependencyCycle returns the step names forming the first depends_on cycle
+// found, walking steps in declaration order, e.g. [a b a]. Unknown
+// dependencies are ignored. It returns nil when the graph is acyclic.
+func DependencyCycle(steps []Step) []string {
+	index := make(map[string]int, len(steps))
+	for i, step := range steps {
+		if _, exists := index[step.Name]; !exists {
+			index[step.Name] = i
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(steps))
+	var path []int
+	var cycle []string
+
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		marks[i] = visiting
+		path = append(path, i)
+		for _, dep := range steps[i].DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				continue
+			}
+			switch marks[j] {
+			case visiting:
+				start := 0
+				for k, p := range path {
+					if p == j {
+						start = k
+						break
+					}
+				}
+				for _, p := range path[start:] {
+					cycle = append(cycle, steps[p].Name)
+				}
+				cycle = append(cycle, steps[j].Name)
+				return true
+			case unvisited:
+				if visit(j) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		marks[i] = visited
+		return false
+	}
+
+	for i := range steps {
+		if marks[i] == unvisited && visit(i) {
+			return cycle
+		}
+	}
+	return nil
+}
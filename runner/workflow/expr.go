@@ -0,0 +1,620 @@
+package workflow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Expressions are used by when, loop_expr and ${...} placeholders in args.
+//
+// Supported syntax:
+//
+//	literals     "str" 'str' 42 1.5 true false null [a, b]
+//	variables    NAME, a.b.c, list[0], map["key"], ${NAME} (same as NAME)
+//	operators    ! not - * / % + == != < <= > >= in "not in" && and || or
+//	calls        lower(name), default(x, "y")
+//	filters      name | lower, x | default("y") (same as the call form)
+//
+// Undefined variables evaluate to null, except that a bare word compared
+// with == or != stands for itself (ENV == prod, region == us-east-1,
+// version == 1.2.3). Comparisons coerce numeric strings, so exported vars
+// like "3" compare as numbers, and null equals "".
+
+// ExprError reports a syntax or evaluation error at a byte offset of the
+// expression source.
+type ExprError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("%s at position %d in %q", e.Msg, e.Pos+1, e.Expr)
+}
+
+// Expr is a parsed expression; it is safe for concurrent use.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+type compiledExpr struct {
+	expr *Expr
+	err  error
+}
+
+var exprCache sync.Map
+
+// ParseExpr parses src, reusing earlier results for the same source.
+func ParseExpr(src string) (*Expr, error) {
+	if cached, ok := exprCache.Load(src); ok {
+		c := cached.(compiledExpr)
+		return c.expr, c.err
+	}
+	expr, err := parseExpr(src)
+	exprCache.Store(src, compiledExpr{expr: expr, err: err})
+	return expr, err
+}
+
+// EvalExpr parses and evaluates src against vars.
+func EvalExpr(src string, vars map[string]any) (any, error) {
+	expr, err := ParseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	return expr.Eval(vars)
+}
+
+func (x *Expr) Eval(vars map[string]any) (any, error) {
+	return x.root.eval(&evalScope{src: x.src, vars: vars})
+}
+
+func (x *Expr) String() string {
+	return x.src
+}
+
+func parseExpr(src string) (*Expr, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, p.errorf(p.peek(), "empty expression")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	// value holds the decoded literal for numbers and strings.
+	value any
+	pos   int
+}
+
+func (t exprToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.value.(string))
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+var exprOps = []string{"${", "||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", "{", "}", ",", ".", "|"}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	i := 0
+	for i < len(src) {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '"' || ch == '\'':
+			value, end, err := lexString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, exprToken{kind: tokString, text: src[i:end], value: value, pos: i})
+			i = end
+		case isDigit(ch):
+			start := i
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			isFloat := false
+			if i+1 < len(src) && src[i] == '.' && isDigit(src[i+1]) {
+				isFloat = true
+				i++
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			text := src[start:i]
+			var value any
+			if isFloat {
+				f, err := strconv.ParseFloat(text, 64)
+				if err != nil {
+					return nil, &ExprError{Expr: src, Pos: start, Msg: "invalid number " + text}
+				}
+				value = f
+			} else {
+				n, err := strconv.ParseInt(text, 10, 64)
+				if err != nil {
+					return nil, &ExprError{Expr: src, Pos: start, Msg: "invalid number " + text}
+				}
+				value = n
+			}
+			tokens = append(tokens, exprToken{kind: tokNumber, text: text, value: value, pos: start})
+		case isIdentStart(ch):
+			start := i
+			for i < len(src) && isIdentPart(src[i]) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			matched := ""
+			for _, op := range exprOps {
+				if strings.HasPrefix(src[i:], op) {
+					matched = op
+					break
+				}
+			}
+			if matched == "" {
+				return nil, &ExprError{Expr: src, Pos: i, Msg: fmt.Sprintf("unexpected character %q", ch)}
+			}
+			tokens = append(tokens, exprToken{kind: tokOp, text: matched, pos: i})
+			i += len(matched)
+		}
+	}
+	return append(tokens, exprToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+func lexString(src string, start int) (string, int, error) {
+	quote := src[start]
+	var b strings.Builder
+	for i := start + 1; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case ch == quote:
+			return b.String(), i + 1, nil
+		case ch == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(src[i])
+			}
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return "", 0, &ExprError{Expr: src, Pos: start, Msg: "unterminated string"}
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isIdentPart(ch byte) bool {
+	return isIdentStart(ch) || isDigit(ch)
+}
+
+// parser
+
+type exprParser struct {
+	src    string
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) isOp(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokOp && tok.text == text
+}
+
+func (p *exprParser) isKeyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && tok.text == word
+}
+
+func (p *exprParser) expectOp(text string) (exprToken, error) {
+	tok := p.next()
+	if tok.kind != tokOp || tok.text != text {
+		return tok, p.errorf(tok, "expected %q, got %s", text, tok)
+	}
+	return tok, nil
+}
+
+func (p *exprParser) errorf(tok exprToken, format string, args ...any) error {
+	return &ExprError{Expr: p.src, Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") || p.isKeyword("or") {
+		tok := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{pos: tok.pos, op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") || p.isKeyword("and") {
+		tok := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{pos: tok.pos, op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot handles the "not" keyword, which binds looser than comparisons
+// ("not a == b" is "not (a == b)"), unlike "!".
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.isKeyword("not") {
+		tok := p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{pos: tok.pos, op: "!", operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	var left exprNode
+	var err error
+	if end, ok := p.bareWord(); ok && p.isEquality(p.tokens[end]) {
+		left, err = p.parseWord(end)
+	} else {
+		left, err = p.parseAdd()
+	}
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		op := ""
+		switch {
+		case tok.kind == tokOp && (tok.text == "==" || tok.text == "!=" || tok.text == "<" || tok.text == "<=" || tok.text == ">" || tok.text == ">="):
+			op = tok.text
+		case p.isKeyword("in"):
+			op = "in"
+		case p.isKeyword("not") && p.tokens[p.pos+1].kind == tokIdent && p.tokens[p.pos+1].text == "in":
+			p.next()
+			op = "not in"
+		default:
+			return left, nil
+		}
+		p.next()
+		var right exprNode
+		if end, ok := p.bareWord(); ok && p.isEquality(tok) {
+			right, err = p.parseWord(end)
+		} else {
+			right, err = p.parseAdd()
+		}
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{pos: tok.pos, op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) isEquality(tok exprToken) bool {
+	return tok.kind == tokOp && (tok.text == "==" || tok.text == "!=")
+}
+
+// bareWord reports whether the tokens at the current position spell a word
+// written without spaces that uses "-" or a dotted number, such as
+// us-east-1, web-01 or 1.2.3, and returns the index of the token after it.
+func (p *exprParser) bareWord() (int, bool) {
+	end := p.pos
+	dashed, dotted := false, false
+	for ; end < len(p.tokens); end++ {
+		tok := p.tokens[end]
+		if end > p.pos {
+			prev := p.tokens[end-1]
+			if tok.pos != prev.pos+len(prev.text) {
+				break
+			}
+		}
+		switch {
+		case tok.kind == tokIdent || tok.kind == tokNumber:
+			if end > p.pos && p.tokens[end-1].kind != tokOp {
+				return 0, false
+			}
+			if tok.kind == tokNumber && (strings.Contains(tok.text, ".") || p.tokens[end+1].text == ".") {
+				dotted = true
+			}
+			continue
+		case tok.kind == tokOp && (tok.text == "-" || tok.text == "."):
+			if end == p.pos || p.tokens[end-1].kind == tokOp {
+				return 0, false
+			}
+			dashed = dashed || tok.text == "-"
+			continue
+		}
+		break
+	}
+	if end == p.pos || p.tokens[end-1].kind == tokOp {
+		return 0, false
+	}
+	if next := p.tokens[end]; next.kind == tokOp && (next.text == "(" || next.text == "[") {
+		return 0, false
+	}
+	return end, dashed || dotted
+}
+
+// parseWord parses the bare word ending before tokens[end]. The word is
+// still evaluated when every variable it names is defined (count-1), and
+// otherwise stands for its text.
+func (p *exprParser) parseWord(end int) (exprNode, error) {
+	start := p.pos
+	word := &wordNode{text: p.src[p.tokens[start].pos : p.tokens[end-1].pos+len(p.tokens[end-1].text)]}
+	for i := start; i < end; i++ {
+		if p.tokens[i].kind == tokIdent && (i == start || p.tokens[i-1].text != ".") {
+			word.names = append(word.names, p.tokens[i].text)
+		}
+	}
+	if node, err := p.parseAdd(); err == nil && p.pos == end && len(word.names) > 0 {
+		word.node = node
+	}
+	p.pos = end
+	return word, nil
+}
+
+func (p *exprParser) parseAdd() (exprNode, error) {
+	left, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+") || p.isOp("-") {
+		tok := p.next()
+		right, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{pos: tok.pos, op: tok.text, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseMul() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*") || p.isOp("/") || p.isOp("%") {
+		tok := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{pos: tok.pos, op: tok.text, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOp("!") || p.isOp("-") {
+		tok := p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{pos: tok.pos, op: tok.text, operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			tok := p.next()
+			switch tok.kind {
+			case tokIdent:
+				node = &indexNode{pos: tok.pos, target: node, index: &literalNode{value: tok.text}}
+			case tokNumber:
+				node = &indexNode{pos: tok.pos, target: node, index: &literalNode{value: tok.value}}
+			default:
+				return nil, p.errorf(tok, "expected field name after \".\", got %s", tok)
+			}
+		case p.isOp("["):
+			tok := p.next()
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			node = &indexNode{pos: tok.pos, target: node, index: index}
+		case p.isOp("|"):
+			p.next()
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, p.errorf(name, "expected filter name after \"|\", got %s", name)
+			}
+			args := []exprNode{node}
+			if p.isOp("(") {
+				rest, err := p.parseArgs()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, rest...)
+			}
+			call, err := p.newCall(name, args)
+			if err != nil {
+				return nil, err
+			}
+			node = call
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber, tokString:
+		return &literalNode{value: tok.value}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null", "nil":
+			return &literalNode{value: nil}, nil
+		}
+		if p.isOp("(") {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return p.newCall(tok, args)
+		}
+		return &varNode{pos: tok.pos, name: tok.text}, nil
+	case tokOp:
+		switch tok.text {
+		case "(":
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		case "${":
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if v, ok := node.(*varNode); ok {
+				v.braced = true
+			}
+			if _, err := p.expectOp("}"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		case "[":
+			var items []exprNode
+			for !p.isOp("]") {
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			if _, err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			return &listNode{items: items}, nil
+		}
+	}
+	return nil, p.errorf(tok, "unexpected %s", tok)
+}
+
+func (p *exprParser) parseArgs() ([]exprNode, error) {
+	if _, err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var args []exprNode
+	for !p.isOp(")") {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+func (p *exprParser) newCall(name exprToken, args []exprNode) (exprNode, error) {
+	fn, ok := exprFuncs[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %q", name.text)
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, p.errorf(name, "%s expects %s, got %d", name.text, fn.arity(), len(args))
+	}
+	return &callNode{pos: name.pos, name: name.text, fn: fn, args: args}, nil
+}
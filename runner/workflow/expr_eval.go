@@ -0,0 +1,445 @@
+package workflow
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type evalScope struct {
+	src  string
+	vars map[string]any
+}
+
+func (s *evalScope) errorf(pos int, format string, args ...any) error {
+	return &ExprError{Expr: s.src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+type exprNode interface {
+	eval(scope *evalScope) (any, error)
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n *literalNode) eval(scope *evalScope) (any, error) {
+	return n.value, nil
+}
+
+type varNode struct {
+	pos  int
+	name string
+	// braced is set for ${NAME}, which never stands for a literal word.
+	braced bool
+}
+
+func (n *varNode) eval(scope *evalScope) (any, error) {
+	return scope.vars[n.name], nil
+}
+
+type listNode struct {
+	items []exprNode
+}
+
+func (n *listNode) eval(scope *evalScope) (any, error) {
+	out := make([]any, 0, len(n.items))
+	for _, item := range n.items {
+		value, err := item.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+	}
+	return out, nil
+}
+
+type indexNode struct {
+	pos    int
+	target exprNode
+	index  exprNode
+}
+
+func (n *indexNode) eval(scope *evalScope) (any, error) {
+	target, err := n.target.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := indexValue(target, index)
+	return value, nil
+}
+
+// indexValue looks up a map key or list position; missing entries yield
+// (nil, false) so that default() can supply a fallback.
+func indexValue(target, index any) (any, bool) {
+	switch t := target.(type) {
+	case nil:
+		return nil, false
+	case map[string]any:
+		v, ok := t[fmt.Sprint(index)]
+		return v, ok
+	case map[any]any:
+		if v, ok := t[index]; ok {
+			return v, true
+		}
+		v, ok := t[fmt.Sprint(index)]
+		return v, ok
+	}
+	rv := reflect.ValueOf(target)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		v := rv.MapIndex(reflect.ValueOf(fmt.Sprint(index)).Convert(rv.Type().Key()))
+		if !v.IsValid() {
+			return nil, false
+		}
+		return v.Interface(), true
+	case reflect.Slice, reflect.Array:
+		n, ok := toNumber(index)
+		if !ok || n != math.Trunc(n) {
+			return nil, false
+		}
+		i := int(n)
+		if i < 0 {
+			i += rv.Len()
+		}
+		if i < 0 || i >= rv.Len() {
+			return nil, false
+		}
+		return rv.Index(i).Interface(), true
+	}
+	return nil, false
+}
+
+type unaryNode struct {
+	pos     int
+	op      string
+	operand exprNode
+}
+
+func (n *unaryNode) eval(scope *evalScope) (any, error) {
+	value, err := n.operand.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !truthy(value), nil
+	}
+	if i, ok := toInt(value); ok {
+		return -i, nil
+	}
+	f, ok := toNumber(value)
+	if !ok {
+		return nil, scope.errorf(n.pos, "cannot negate %s", typeName(value))
+	}
+	return -f, nil
+}
+
+type logicalNode struct {
+	pos         int
+	op          string
+	left, right exprNode
+}
+
+func (n *logicalNode) eval(scope *evalScope) (any, error) {
+	left, err := n.left.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "||" && truthy(left) {
+		return true, nil
+	}
+	if n.op == "&&" && !truthy(left) {
+		return false, nil
+	}
+	right, err := n.right.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	return truthy(right), nil
+}
+
+type binaryNode struct {
+	pos         int
+	op          string
+	left, right exprNode
+}
+
+func (n *binaryNode) eval(scope *evalScope) (any, error) {
+	eval := exprNode.eval
+	if n.op == "==" || n.op == "!=" {
+		eval = comparand
+	}
+	left, err := eval(n.left, scope)
+	if err != nil {
+		return nil, err
+	}
+	right, err := eval(n.right, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return compareEqual(left, right), nil
+	case "!=":
+		return !compareEqual(left, right), nil
+	case "<", "<=", ">", ">=":
+		cmp, ok := compareOrder(left, right)
+		if !ok {
+			return nil, scope.errorf(n.pos, "cannot compare %s %s %s", typeName(left), n.op, typeName(right))
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	case "in", "not in":
+		found, ok := contains(right, left)
+		if !ok {
+			return nil, scope.errorf(n.pos, "%q expects a list, map or string, got %s", n.op, typeName(right))
+		}
+		return found == (n.op == "in"), nil
+	case "+":
+		// Strings concatenate unless both sides are numeric, since exported
+		// vars are always strings.
+		_, lnum := toNumber(left)
+		_, rnum := toNumber(right)
+		_, ls := left.(string)
+		_, rs := right.(string)
+		if (ls || rs) && !(lnum && rnum) {
+			return toString(left) + toString(right), nil
+		}
+	}
+	return arithmetic(scope, n.pos, n.op, left, right)
+}
+
+// wordNode is a bare word operand of == or != such as us-east-1 or 1.2.3.
+type wordNode struct {
+	text  string
+	names []string
+	node  exprNode
+}
+
+func (n *wordNode) eval(scope *evalScope) (any, error) {
+	if n.node == nil {
+		return n.text, nil
+	}
+	for _, name := range n.names {
+		if _, defined := scope.vars[name]; !defined {
+			return n.text, nil
+		}
+	}
+	return n.node.eval(scope)
+}
+
+// comparand evaluates an operand of == or !=. A bare word that is not a
+// defined variable stands for itself, so `ENV == prod` compares ENV with
+// "prod" as when expressions always have; ${NAME} stays null when undefined.
+func comparand(node exprNode, scope *evalScope) (any, error) {
+	if v, ok := node.(*varNode); ok && !v.braced {
+		if value, defined := scope.vars[v.name]; defined {
+			return value, nil
+		}
+		return v.name, nil
+	}
+	return node.eval(scope)
+}
+
+func arithmetic(scope *evalScope, pos int, op string, left, right any) (any, error) {
+	li, lint := intOperand(left)
+	ri, rint := intOperand(right)
+	if lint && rint {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "/", "%":
+			if ri == 0 {
+				return nil, scope.errorf(pos, "division by zero")
+			}
+			if op == "%" {
+				return li % ri, nil
+			}
+			if li%ri == 0 {
+				return li / ri, nil
+			}
+		}
+	}
+	lf, lok := toNumber(left)
+	rf, rok := toNumber(right)
+	if !lok || !rok {
+		return nil, scope.errorf(pos, "cannot apply %q to %s and %s", op, typeName(left), typeName(right))
+	}
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, scope.errorf(pos, "division by zero")
+		}
+		return lf / rf, nil
+	default:
+		return nil, scope.errorf(pos, "%q expects integers", op)
+	}
+}
+
+type callNode struct {
+	pos  int
+	name string
+	fn   exprFunc
+	args []exprNode
+}
+
+func (n *callNode) eval(scope *evalScope) (any, error) {
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	value, err := n.fn.call(args)
+	if err != nil {
+		return nil, scope.errorf(n.pos, "%s: %v", n.name, err)
+	}
+	return value, nil
+}
+
+// compareOrder returns -1, 0 or 1; numbers and numeric strings compare
+// numerically, other strings lexically.
+func compareOrder(left, right any) (int, bool) {
+	if ln, ok := toNumber(left); ok {
+		if rn, ok := toNumber(right); ok {
+			switch {
+			case ln < rn:
+				return -1, true
+			case ln > rn:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	}
+	ls, lok := left.(string)
+	rs, rok := right.(string)
+	if lok && rok {
+		return strings.Compare(ls, rs), true
+	}
+	return 0, false
+}
+
+func contains(container, value any) (bool, bool) {
+	switch c := container.(type) {
+	case nil:
+		return false, true
+	case string:
+		return strings.Contains(c, toString(value)), true
+	}
+	rv := reflect.ValueOf(container)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if compareEqual(rv.Index(i).Interface(), value) {
+				return true, true
+			}
+		}
+		return false, true
+	case reflect.Map:
+		_, found := indexValue(container, value)
+		return found, true
+	}
+	return false, false
+}
+
+func toInt(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// intOperand accepts integers and integer strings such as exported vars.
+func intOperand(value any) (int64, bool) {
+	if s, ok := value.(string); ok {
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		return i, err == nil
+	}
+	return toInt(value)
+}
+
+func toString(value any) string {
+	if value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+func typeName(value any) string {
+	if value == nil {
+		return "null"
+	}
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	}
+	if _, ok := toInt(value); ok {
+		return "int"
+	}
+	if _, ok := toNumber(value); ok {
+		return "number"
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Map:
+		return "map"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,213 @@
+package workflow
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type exprFunc struct {
+	minArgs int
+	// maxArgs is -1 for variadic functions.
+	maxArgs int
+	call    func(args []any) (any, error)
+}
+
+func (f exprFunc) arity() string {
+	switch {
+	case f.minArgs == f.maxArgs && f.minArgs == 1:
+		return "1 argument"
+	case f.minArgs == f.maxArgs:
+		return fmt.Sprintf("%d arguments", f.minArgs)
+	case f.maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", f.minArgs)
+	default:
+		return fmt.Sprintf("%d to %d arguments", f.minArgs, f.maxArgs)
+	}
+}
+
+var exprFuncs = map[string]exprFunc{
+	"default": {minArgs: 2, maxArgs: 2, call: func(args []any) (any, error) {
+		if args[0] == nil || args[0] == "" {
+			return args[1], nil
+		}
+		return args[0], nil
+	}},
+	"lower": stringFunc(strings.ToLower),
+	"upper": stringFunc(strings.ToUpper),
+	"trim":  stringFunc(strings.TrimSpace),
+	"join": {minArgs: 1, maxArgs: 2, call: func(args []any) (any, error) {
+		sep := ","
+		if len(args) > 1 {
+			sep = toString(args[1])
+		}
+		items, err := toList(args[0])
+		if err != nil {
+			return nil, err
+		}
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = toString(item)
+		}
+		return strings.Join(parts, sep), nil
+	}},
+	"split": {minArgs: 1, maxArgs: 2, call: func(args []any) (any, error) {
+		text := toString(args[0])
+		var parts []string
+		if len(args) > 1 {
+			parts = strings.Split(text, toString(args[1]))
+		} else {
+			parts = strings.Fields(text)
+		}
+		out := make([]any, len(parts))
+		for i, part := range parts {
+			out[i] = part
+		}
+		return out, nil
+	}},
+	"replace": {minArgs: 3, maxArgs: 3, call: func(args []any) (any, error) {
+		return strings.ReplaceAll(toString(args[0]), toString(args[1]), toString(args[2])), nil
+	}},
+	"contains": {minArgs: 2, maxArgs: 2, call: func(args []any) (any, error) {
+		found, ok := contains(args[0], args[1])
+		if !ok {
+			return nil, fmt.Errorf("expects a list, map or string, got %s", typeName(args[0]))
+		}
+		return found, nil
+	}},
+	"starts_with": {minArgs: 2, maxArgs: 2, call: func(args []any) (any, error) {
+		return strings.HasPrefix(toString(args[0]), toString(args[1])), nil
+	}},
+	"ends_with": {minArgs: 2, maxArgs: 2, call: func(args []any) (any, error) {
+		return strings.HasSuffix(toString(args[0]), toString(args[1])), nil
+	}},
+	"regex_match": {minArgs: 2, maxArgs: 2, call: func(args []any) (any, error) {
+		re, err := compileRegex(toString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(toString(args[0])), nil
+	}},
+	"len":    {minArgs: 1, maxArgs: 1, call: lengthOf},
+	"length": {minArgs: 1, maxArgs: 1, call: lengthOf},
+	"int": {minArgs: 1, maxArgs: 1, call: func(args []any) (any, error) {
+		if i, ok := toInt(args[0]); ok {
+			return i, nil
+		}
+		f, ok := toNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %s to int", typeName(args[0]))
+		}
+		return int64(f), nil
+	}},
+	"float": {minArgs: 1, maxArgs: 1, call: func(args []any) (any, error) {
+		f, ok := toNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %s to float", typeName(args[0]))
+		}
+		return f, nil
+	}},
+	"string": {minArgs: 1, maxArgs: 1, call: func(args []any) (any, error) {
+		return toString(args[0]), nil
+	}},
+	"bool": {minArgs: 1, maxArgs: 1, call: func(args []any) (any, error) {
+		return truthy(args[0]), nil
+	}},
+	"range": {minArgs: 1, maxArgs: 2, call: func(args []any) (any, error) {
+		var start, end int64
+		var ok bool
+		if len(args) == 1 {
+			end, ok = toInt(args[0])
+		} else {
+			start, ok = toInt(args[0])
+			if ok {
+				end, ok = toInt(args[1])
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("expects integer bounds")
+		}
+		out := []any{}
+		for i := start; i < end; i++ {
+			out = append(out, i)
+		}
+		return out, nil
+	}},
+	"keys": {minArgs: 1, maxArgs: 1, call: func(args []any) (any, error) {
+		rv := reflect.ValueOf(args[0])
+		if args[0] == nil {
+			return []any{}, nil
+		}
+		if rv.Kind() != reflect.Map {
+			return nil, fmt.Errorf("expects a map, got %s", typeName(args[0]))
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, key := range rv.MapKeys() {
+			keys = append(keys, fmt.Sprint(key.Interface()))
+		}
+		sort.Strings(keys)
+		out := make([]any, len(keys))
+		for i, key := range keys {
+			out[i] = key
+		}
+		return out, nil
+	}},
+}
+
+func stringFunc(fn func(string) string) exprFunc {
+	return exprFunc{minArgs: 1, maxArgs: 1, call: func(args []any) (any, error) {
+		return fn(toString(args[0])), nil
+	}}
+}
+
+func lengthOf(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return int64(0), nil
+	case string:
+		return int64(len(v)), nil
+	}
+	rv := reflect.ValueOf(args[0])
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return int64(rv.Len()), nil
+	}
+	return nil, fmt.Errorf("expects a list, map or string, got %s", typeName(args[0]))
+}
+
+// toList converts any slice to []any; null is an empty list.
+func toList(value any) ([]any, error) {
+	switch v := value.(type) {
+	case nil:
+		return []any{}, nil
+	case []any:
+		return v, nil
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expects a list, got %s", typeName(value))
+	}
+	out := make([]any, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+	return out, nil
+}
+
+var regexCache sync.Map
+
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := regexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", strconv.Quote(pattern), err)
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,118 @@
+package workflow
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEvalExprOperatorsAndFilters(t *testing.T) {
+	vars := map[string]any{
+		"env":     "Prod",
+		"count":   3,
+		"RETRIES": "2",
+		"hosts":   []any{"web1", "web2"},
+		"facts":   map[string]any{"os": map[string]any{"family": "debian"}},
+	}
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{expr: `(count > 2 || RETRIES > 5) && !(env == "dev")`, want: true},
+		{expr: `not env | lower == "prod"`, want: false},
+		{expr: `"web2" in hosts && "db1" not in hosts`, want: true},
+		{expr: `facts.os.family == "debian"`, want: true},
+		{expr: `hosts[-1]`, want: "web2"},
+		{expr: `count * 2 + 1`, want: int64(7)},
+		{expr: `RETRIES + 1`, want: int64(3)},
+		{expr: `env + "-" + RETRIES`, want: "Prod-2"},
+		{expr: `missing | default("fallback")`, want: "fallback"},
+		{expr: `join(hosts, ";")`, want: "web1;web2"},
+		{expr: `regex_match(env, "^(?i)prod$")`, want: true},
+		{expr: `len(split("a b c"))`, want: int64(3)},
+		{expr: `${count} >= 3`, want: true},
+	}
+	for _, tc := range tests {
+		got, err := EvalExpr(tc.expr, vars)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc.expr, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: expected %#v, got %#v", tc.expr, tc.want, got)
+		}
+	}
+}
+
+func TestParseExprErrorPositions(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+		msg  string
+	}{
+		{expr: `a == `, pos: 5, msg: "unexpected end of expression"},
+		{expr: `(a == 1`, pos: 7, msg: `expected ")"`},
+		{expr: `a | shout`, pos: 4, msg: `unknown function "shout"`},
+		{expr: `lower(a, b)`, pos: 0, msg: "lower expects 1 argument"},
+		{expr: `a == "open`, pos: 5, msg: "unterminated string"},
+	}
+	for _, tc := range tests {
+		_, err := ParseExpr(tc.expr)
+		var exprErr *ExprError
+		if !errors.As(err, &exprErr) {
+			t.Fatalf("%s: expected ExprError, got %v", tc.expr, err)
+		}
+		if exprErr.Pos != tc.pos || !strings.Contains(exprErr.Msg, tc.msg) {
+			t.Fatalf("%s: expected %q at %d, got %q at %d", tc.expr, tc.msg, tc.pos, exprErr.Msg, exprErr.Pos)
+		}
+	}
+}
+
+func TestRenderValuePreservesTypes(t *testing.T) {
+	vars := map[string]any{
+		"count": 3,
+		"name":  "Web",
+		"ports": []any{80, 443},
+	}
+	args := map[string]any{
+		"replicas": "${count}",
+		"label":    "${name | lower}-${count + 1}",
+		"ports":    "${ports}",
+		"shell":    "echo ${HOME} ${#ARR[@]}",
+		"nested":   []any{"${missing | default(8080)}"},
+	}
+
+	got := RenderArgs(args, vars)
+	if got["replicas"] != 3 {
+		t.Fatalf("expected replicas to stay an int, got %#v", got["replicas"])
+	}
+	if got["label"] != "web-4" {
+		t.Fatalf("expected rendered label, got %#v", got["label"])
+	}
+	if ports, ok := got["ports"].([]any); !ok || len(ports) != 2 {
+		t.Fatalf("expected ports list, got %#v", got["ports"])
+	}
+	if got["shell"] != "echo ${HOME} ${#ARR[@]}" {
+		t.Fatalf("expected unresolved placeholders untouched, got %#v", got["shell"])
+	}
+	if nested := got["nested"].([]any); nested[0] != int64(8080) {
+		t.Fatalf("expected default value, got %#v", nested[0])
+	}
+}
+
+func TestLoopItemsFromExpr(t *testing.T) {
+	vars := map[string]any{"csv": "a,b,c"}
+	items, err := LoopItems(Step{LoopExpr: `split(csv, ",")`}, vars)
+	if err != nil {
+		t.Fatalf("loop items: %v", err)
+	}
+	if len(items) != 3 || items[2] != "c" {
+		t.Fatalf("expected split items, got %v", items)
+	}
+	if _, err := LoopItems(Step{LoopExpr: `csv`}, vars); err == nil {
+		t.Fatalf("expected non-list loop_expr to fail")
+	}
+	items, err = LoopItems(Step{Loop: []any{"${csv}", 1}}, vars)
+	if err != nil || items[0] != "a,b,c" || items[1] != 1 {
+		t.Fatalf("expected rendered loop items, got %v (%v)", items, err)
+	}
+}
@@ -0,0 +1,291 @@
+package workflow
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Includer expands include steps into the steps of the workflows they name.
+type Includer struct {
+	// Dir resolves relative file includes of the root workflow. Includes in
+	// an included file resolve relative to that file.
+	Dir string
+	// Load returns a stored workflow by name without expanding its includes.
+	// When nil only file includes are allowed.
+	Load func(name string) (Workflow, error)
+	// NoFiles rejects file includes, for workflows that do not come from
+	// disk and must not read the files of the host expanding them.
+	NoFiles bool
+}
+
+// IncludeRef returns the workflow the step includes, from include or its
+// import_steps alias.
+func (s Step) IncludeRef() string {
+	if s.Include != "" {
+		return s.Include
+	}
+	return s.ImportSteps
+}
+
+// IsFileRef reports whether ref names a workflow file rather than a stored
+// workflow.
+func IsFileRef(ref string) bool {
+	if strings.ContainsAny(ref, `/\`) {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(ref))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Expand returns wf with every include step replaced by the steps of the
+// included workflow. ref names wf itself, as a file path or stored name, so
+// that a workflow including itself is reported as a cycle.
+//
+// Included steps are named "<include step>/<step>", keep the order of the
+// included workflow, run on the targets of the include step unless they set
+// their own, and carry the vars and resolved params of the included
+// workflow. Steps that depend on the include step depend on all of them.
+func (in Includer) Expand(wf Workflow, ref string) (Workflow, error) {
+	var stack []string
+	if ref != "" {
+		stack = append(stack, in.key(ref, in.Dir))
+	}
+	return in.expand(wf, in.Dir, stack)
+}
+
+func (in Includer) expand(wf Workflow, dir string, stack []string) (Workflow, error) {
+	if !hasIncludes(wf.Steps) {
+		return wf, nil
+	}
+	out := wf
+	out.Handlers = append([]Handler(nil), wf.Handlers...)
+	steps, handlers, err := in.expandSteps(wf.Steps, dir, stack, false)
+	if err != nil {
+		return Workflow{}, err
+	}
+	out.Steps = steps
+	out.Handlers = append(out.Handlers, handlers...)
+	return out, nil
+}
+
+// expandSteps replaces the include steps in steps, and in the sections of
+// block steps. Steps inside a block run in order and take no depends_on.
+func (in Includer) expandSteps(steps []Step, dir string, stack []string, nested bool) ([]Step, []Handler, error) {
+	var out []Step
+	var handlers []Handler
+	replaced := map[string][]string{}
+	for i, step := range steps {
+		if hasIncludes(step.Block) || hasIncludes(step.Rescue) || hasIncludes(step.Always) {
+			for _, section := range []*[]Step{&step.Block, &step.Rescue, &step.Always} {
+				expanded, more, err := in.expandSteps(*section, dir, stack, true)
+				if err != nil {
+					return nil, nil, err
+				}
+				*section = expanded
+				handlers = append(handlers, more...)
+			}
+		}
+		ref := step.IncludeRef()
+		if ref == "" {
+			out = append(out, step)
+			continue
+		}
+		label := fmt.Sprintf("steps[%d]", i)
+		issues := includeStepIssues(label, step)
+		if step.Name == "" {
+			issues = append(issues, fmt.Sprintf("%s name is required", label))
+		}
+		if len(issues) > 0 {
+			return nil, nil, &ValidationError{Issues: issues}
+		}
+		if in.NoFiles && IsFileRef(ref) {
+			return nil, nil, &ValidationError{Issues: []string{fmt.Sprintf("step %q includes file %q: only stored workflows can be included", step.Name, ref)}}
+		}
+		key := in.key(ref, dir)
+		for j, seen := range stack {
+			if seen == key {
+				cycle := append(append([]string(nil), stack[j:]...), key)
+				return nil, nil, &ValidationError{Issues: []string{fmt.Sprintf("include cycle: %s", strings.Join(cycle, " -> "))}}
+			}
+		}
+		sub, subDir, err := in.load(ref, dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("step %q include %s: %w", step.Name, ref, err)
+		}
+		sub, err = in.expand(sub, subDir, append(stack[:len(stack):len(stack)], key))
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := sub.ResolveParams(step.Params); err != nil {
+			return nil, nil, fmt.Errorf("step %q include %s: %w", step.Name, ref, err)
+		}
+		included, more := includedSteps(step, sub)
+		for j := range included {
+			if nested {
+				included[j].DependsOn = nil
+			}
+			replaced[step.Name] = append(replaced[step.Name], included[j].Name)
+		}
+		out = append(out, included...)
+		handlers = append(handlers, more...)
+	}
+
+	for i := range out {
+		var deps []string
+		rewritten := false
+		for _, dep := range out[i].DependsOn {
+			if names, ok := replaced[dep]; ok {
+				deps = append(deps, names...)
+				rewritten = true
+				continue
+			}
+			deps = append(deps, dep)
+		}
+		if rewritten {
+			out[i].DependsOn = deps
+		}
+	}
+	return out, handlers, nil
+}
+
+func hasIncludes(steps []Step) bool {
+	for _, step := range steps {
+		if step.IncludeRef() != "" {
+			return true
+		}
+		if hasIncludes(step.Block) || hasIncludes(step.Rescue) || hasIncludes(step.Always) {
+			return true
+		}
+	}
+	return false
+}
+
+func (in Includer) key(ref, dir string) string {
+	if !IsFileRef(ref) {
+		return ref
+	}
+	path := ref
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return path
+}
+
+func (in Includer) load(ref, dir string) (Workflow, string, error) {
+	if IsFileRef(ref) {
+		path := in.key(ref, dir)
+		wf, err := LoadFile(path)
+		if err != nil {
+			return Workflow{}, "", err
+		}
+		return wf, filepath.Dir(path), nil
+	}
+	if in.Load == nil {
+		return Workflow{}, "", fmt.Errorf("stored workflows cannot be included here")
+	}
+	wf, err := in.Load(ref)
+	if err != nil {
+		return Workflow{}, "", err
+	}
+	return wf, in.Dir, nil
+}
+
+// includedSteps returns the steps and handlers of sub renamed under the
+// include step.
+func includedSteps(include Step, sub Workflow) ([]Step, []Handler) {
+	prefix := include.Name + "/"
+	local := map[string]struct{}{}
+	for _, h := range sub.Handlers {
+		local[h.Name] = struct{}{}
+	}
+	handlers := make([]Handler, 0, len(sub.Handlers))
+	for _, h := range sub.Handlers {
+		h.Name = prefix + h.Name
+		handlers = append(handlers, h)
+	}
+
+	sequential := sub.Plan.Strategy != "dag"
+	steps := make([]Step, 0, len(sub.Steps))
+	for i, s := range sub.Steps {
+		s.Name = prefix + s.Name
+		s.Vars = mergeVars(sub.Vars, s.Vars)
+		if len(s.Targets) == 0 {
+			s.Targets = append([]string(nil), include.Targets...)
+		}
+		s.When = joinWhen(include.When, s.When)
+
+		var deps []string
+		for _, dep := range s.DependsOn {
+			deps = append(deps, prefix+dep)
+		}
+		switch {
+		case len(deps) > 0:
+		case sequential && i > 0:
+			// Keep the order of a sequential workflow when the parent
+			// runs as a dag.
+			deps = []string{steps[i-1].Name}
+		default:
+			deps = append([]string(nil), include.DependsOn...)
+		}
+		s.DependsOn = deps
+
+		renameNotify(&s, prefix, local)
+		steps = append(steps, s)
+	}
+	return steps, handlers
+}
+
+// renameNotify points the notify of step, and of the steps of a block step,
+// at the renamed handlers of the included workflow.
+func renameNotify(step *Step, prefix string, local map[string]struct{}) {
+	if len(step.Notify) > 0 {
+		notify := make([]string, 0, len(step.Notify))
+		for _, name := range step.Notify {
+			if _, ok := local[name]; ok {
+				name = prefix + name
+			}
+			notify = append(notify, name)
+		}
+		step.Notify = notify
+	}
+	for _, section := range []*[]Step{&step.Block, &step.Rescue, &step.Always} {
+		if len(*section) == 0 {
+			continue
+		}
+		steps := append([]Step(nil), *section...)
+		for i := range steps {
+			renameNotify(&steps[i], prefix, local)
+		}
+		*section = steps
+	}
+}
+
+func joinWhen(outer, inner string) string {
+	outer = strings.TrimSpace(outer)
+	inner = strings.TrimSpace(inner)
+	switch {
+	case outer == "":
+		return inner
+	case inner == "":
+		return outer
+	}
+	return fmt.Sprintf("(%s) and (%s)", outer, inner)
+}
+
+func includeStepIssues(label string, s Step) []string {
+	var issues []string
+	if s.Include != "" && s.ImportSteps != "" {
+		issues = append(issues, fmt.Sprintf("%s include and import_steps are mutually exclusive", label))
+	}
+	if s.Action != "" {
+		issues = append(issues, fmt.Sprintf("%s include and action are mutually exclusive", label))
+	}
+	if len(s.Loop) > 0 || strings.TrimSpace(s.LoopExpr) != "" {
+		issues = append(issues, fmt.Sprintf("%s loop is not supported with include", label))
+	}
+	return issues
+}
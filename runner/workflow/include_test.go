@@ -0,0 +1,179 @@
+package workflow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const nginxYAML = `
+version: v0.1
+name: nginx
+vars:
+  port: 80
+params:
+  - name: version
+    required: true
+steps:
+  - name: install
+    action: pkg.install
+    args:
+      name: nginx=${version}
+  - name: configure
+    action: template.render
+    notify: [reload]
+handlers:
+  - name: reload
+    action: service.restart
+`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestIncluderExpand(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "lib/nginx.yaml", nginxYAML)
+	root, err := Load([]byte(`
+version: v0.1
+name: site
+plan:
+  strategy: dag
+steps:
+  - name: prepare
+    action: cmd.run
+  - name: web
+    include: lib/nginx.yaml
+    targets: [web]
+    when: enabled
+    depends_on: [prepare]
+    params:
+      version: "1.24"
+  - name: firewall
+    import_steps: fw
+  - name: check
+    action: cmd.run
+    depends_on: [web]
+`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	stored := map[string]Workflow{
+		"fw": {Version: "v0.1", Name: "fw", Steps: []Step{{Name: "open", Action: "cmd.run", Targets: []string{"all"}}}},
+	}
+	in := Includer{Dir: dir, Load: func(name string) (Workflow, error) {
+		wf, ok := stored[name]
+		if !ok {
+			return Workflow{}, errors.New("not found")
+		}
+		return wf, nil
+	}}
+	wf, err := in.Expand(root, "")
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if err := wf.Validate(); err != nil {
+		t.Fatalf("expanded workflow invalid: %v", err)
+	}
+
+	var names []string
+	for _, s := range wf.Steps {
+		names = append(names, s.Name)
+	}
+	if want := []string{"prepare", "web/install", "web/configure", "firewall/open", "check"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected steps %v", names)
+	}
+	install, configure := wf.Steps[1], wf.Steps[2]
+	if !reflect.DeepEqual(install.Targets, []string{"web"}) || install.When != "enabled" {
+		t.Fatalf("expected the include targets and when, got %+v", install)
+	}
+	if !reflect.DeepEqual(install.DependsOn, []string{"prepare"}) || !reflect.DeepEqual(configure.DependsOn, []string{"web/install"}) {
+		t.Fatalf("expected the included order to be kept, got %v %v", install.DependsOn, configure.DependsOn)
+	}
+	if install.Vars["version"] != "1.24" || install.Vars["port"] != 80 {
+		t.Fatalf("expected the included vars and params, got %v", install.Vars)
+	}
+	if !reflect.DeepEqual(configure.Notify, []string{"web/reload"}) || len(wf.Handlers) != 1 || wf.Handlers[0].Name != "web/reload" {
+		t.Fatalf("expected the handler to be renamed, got %v %+v", configure.Notify, wf.Handlers)
+	}
+	if !reflect.DeepEqual(wf.Steps[3].Targets, []string{"all"}) {
+		t.Fatalf("expected the included step to keep its targets, got %v", wf.Steps[3].Targets)
+	}
+	if !reflect.DeepEqual(wf.Steps[4].DependsOn, []string{"web/install", "web/configure"}) {
+		t.Fatalf("expected depends_on the include to cover its steps, got %v", wf.Steps[4].DependsOn)
+	}
+
+	root.Steps[1].Params = nil
+	if _, err := in.Expand(root, ""); err == nil || !strings.Contains(err.Error(), `param "version" is required`) {
+		t.Fatalf("expected the include params to be checked, got %v", err)
+	}
+}
+
+func TestIncluderDetectsCycles(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.yaml", `
+version: v0.1
+name: a
+steps:
+  - name: b
+    include: b.yaml
+`)
+	writeFile(t, dir, "b.yaml", `
+version: v0.1
+name: b
+steps:
+  - name: a
+    include: ./a.yaml
+`)
+	wf, err := LoadFile(a)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	_, err = Includer{Dir: dir}.Expand(wf, a)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	want := "include cycle: " + a + " -> " + filepath.Join(dir, "b.yaml") + " -> " + a
+	assertIssue(t, verr.Issues, want)
+}
+
+func TestWorkflowValidate_Include(t *testing.T) {
+	wf := Workflow{
+		Version: "v0.1",
+		Name:    "site",
+		Steps: []Step{
+			{Name: "web", Include: "nginx"},
+			{Name: "both", Include: "a", ImportSteps: "b", Action: "cmd.run", Loop: []any{1}},
+			{Name: "self", ImportSteps: "site"},
+			{Name: "params", Action: "cmd.run", Params: map[string]any{"x": 1}},
+			{Name: "call", Action: "workflow.call", Args: map[string]any{"workflow": "site"}},
+		},
+	}
+	verr, ok := wf.Validate().(*ValidationError)
+	if !ok {
+		t.Fatalf("expected ValidationError")
+	}
+	assertIssue(t, verr.Issues, "steps[1] include and import_steps are mutually exclusive")
+	assertIssue(t, verr.Issues, "steps[1] include and action are mutually exclusive")
+	assertIssue(t, verr.Issues, "steps[1] loop is not supported with include")
+	assertIssue(t, verr.Issues, `steps[2] includes its own workflow "site"`)
+	assertIssue(t, verr.Issues, "steps[3] params only apply to include")
+	assertIssue(t, verr.Issues, `steps[4] workflow.call calls its own workflow "site"`)
+	for _, issue := range verr.Issues {
+		if strings.HasPrefix(issue, "steps[0]") {
+			t.Fatalf("unexpected issue for a valid include: %s", issue)
+		}
+	}
+}
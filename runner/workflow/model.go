@@ -7,13 +7,20 @@ type Workflow struct {
 	EnvPackages   []string       `json:"env_packages" yaml:"env_packages"`
 	ValidationEnv string         `json:"validation_env" yaml:"validation_env"`
 	Inventory     Inventory      `json:"inventory" yaml:"inventory"`
+	Params        []Param        `json:"params,omitempty" yaml:"params,omitempty"`
 	Vars          map[string]any `json:"vars" yaml:"vars"`
 	Plan          Plan           `json:"plan" yaml:"plan"`
+	OnFailure     string         `json:"on_failure,omitempty" yaml:"on_failure,omitempty"`
+	GatherFacts   bool           `json:"gather_facts,omitempty" yaml:"gather_facts,omitempty"`
 	Steps         []Step         `json:"steps" yaml:"steps"`
 	Handlers      []Handler      `json:"handlers" yaml:"handlers"`
 	Tests         []Test         `json:"tests" yaml:"tests"`
 }
 
+// GatherFactsStep names the facts.gather step run before the workflow steps
+// when GatherFacts is set.
+const GatherFactsStep = "gather_facts"
+
 type Inventory struct {
 	Groups map[string]Group `json:"groups" yaml:"groups"`
 	Hosts  map[string]Host  `json:"hosts" yaml:"hosts"`
@@ -31,23 +38,50 @@ type Host struct {
 }
 
 type Plan struct {
-	Mode     string `json:"mode" yaml:"mode"`
-	Strategy string `json:"strategy" yaml:"strategy"`
+	Mode              string `json:"mode" yaml:"mode"`
+	Strategy          string `json:"strategy" yaml:"strategy"`
+	MaxParallel       int    `json:"max_parallel,omitempty" yaml:"max_parallel,omitempty"`
+	Serial            any    `json:"serial,omitempty" yaml:"serial,omitempty"`
+	MaxFailPercentage int    `json:"max_fail_percentage,omitempty" yaml:"max_fail_percentage,omitempty"`
+	BatchPause        string `json:"batch_pause,omitempty" yaml:"batch_pause,omitempty"`
 }
 
 type Step struct {
-	Name            string         `json:"name" yaml:"name"`
-	Targets         []string       `json:"targets" yaml:"targets"`
-	Action          string         `json:"action" yaml:"action"`
-	Args            map[string]any `json:"args" yaml:"args"`
-	MustVars        []string       `json:"must_vars" yaml:"must_vars"`
-	When            string         `json:"when" yaml:"when"`
-	Loop            []any          `json:"loop" yaml:"loop"`
-	Retries         int            `json:"retries" yaml:"retries"`
-	Timeout         string         `json:"timeout" yaml:"timeout"`
-	ContinueOnError bool           `json:"continue_on_error" yaml:"continue_on_error"`
-	ExpectVars      []string       `json:"expect_vars" yaml:"expect_vars"`
-	Notify          []string       `json:"notify" yaml:"notify"`
+	Name              string         `json:"name" yaml:"name"`
+	Targets           []string       `json:"targets" yaml:"targets"`
+	Action            string         `json:"action" yaml:"action"`
+	Args              map[string]any `json:"args" yaml:"args"`
+	MustVars          []string       `json:"must_vars" yaml:"must_vars"`
+	When              string         `json:"when" yaml:"when"`
+	Loop              []any          `json:"loop" yaml:"loop"`
+	LoopExpr          string         `json:"loop_expr,omitempty" yaml:"loop_expr,omitempty"`
+	Retries           int            `json:"retries" yaml:"retries"`
+	Timeout           string         `json:"timeout" yaml:"timeout"`
+	ContinueOnError   bool           `json:"continue_on_error" yaml:"continue_on_error"`
+	ExpectVars        []string       `json:"expect_vars" yaml:"expect_vars"`
+	Notify            []string       `json:"notify" yaml:"notify"`
+	DependsOn         []string       `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+	Serial            any            `json:"serial,omitempty" yaml:"serial,omitempty"`
+	MaxFailPercentage *int           `json:"max_fail_percentage,omitempty" yaml:"max_fail_percentage,omitempty"`
+	BatchPause        string         `json:"batch_pause,omitempty" yaml:"batch_pause,omitempty"`
+	// Include replaces the step with the steps of another workflow, named
+	// by stored workflow name or file path; ImportSteps is an alias.
+	Include     string `json:"include,omitempty" yaml:"include,omitempty"`
+	ImportSteps string `json:"import_steps,omitempty" yaml:"import_steps,omitempty"`
+	// Params are passed to the params of the included workflow.
+	Params map[string]any `json:"params,omitempty" yaml:"params,omitempty"`
+	// Vars overlay the workflow vars for this step only. Included steps
+	// carry the vars and params of the workflow they came from.
+	Vars map[string]any `json:"vars,omitempty" yaml:"vars,omitempty"`
+	// Block groups steps that run in order as one step. Rescue runs when a
+	// block step fails; Always runs after them whatever happened.
+	Block  []Step `json:"block,omitempty" yaml:"block,omitempty"`
+	Rescue []Step `json:"rescue,omitempty" yaml:"rescue,omitempty"`
+	Always []Step `json:"always,omitempty" yaml:"always,omitempty"`
+	// Parent and Section are set on the steps of a block step when it runs:
+	// the name of the block step and the section the step belongs to.
+	Parent  string `json:"-" yaml:"-"`
+	Section string `json:"-" yaml:"-"`
 }
 
 type Handler struct {
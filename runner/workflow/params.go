@@ -0,0 +1,333 @@
+package workflow
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamBool   = "bool"
+	ParamEnum   = "enum"
+	ParamList   = "list"
+	// ParamSecret is a string that is never recorded with a run or
+	// returned by the server.
+	ParamSecret = "secret"
+)
+
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Param declares a typed input of the workflow. Resolved values are set in
+// Vars under the param name, so steps use them as ${name}.
+type Param struct {
+	Name        string `json:"name" yaml:"name"`
+	Type        string `json:"type,omitempty" yaml:"type,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Default     any    `json:"default,omitempty" yaml:"default,omitempty"`
+	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	// Values lists the allowed values of an enum param.
+	Values []string `json:"values,omitempty" yaml:"values,omitempty"`
+	// Pattern is a regular expression that string, secret and list item
+	// values must match.
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	// Min and Max bound an int value or the number of list items.
+	Min *int `json:"min,omitempty" yaml:"min,omitempty"`
+	Max *int `json:"max,omitempty" yaml:"max,omitempty"`
+}
+
+// Kind returns the param type, defaulting to string.
+func (p Param) Kind() string {
+	if t := strings.ToLower(strings.TrimSpace(p.Type)); t != "" {
+		return t
+	}
+	return ParamString
+}
+
+// Coerce converts value to the param type and checks its constraints.
+// Strings are parsed for int, bool and list params; lists split on commas.
+func (p Param) Coerce(value any) (any, error) {
+	var out any
+	switch p.Kind() {
+	case ParamString, ParamSecret, ParamEnum:
+		s, err := scalarString(value)
+		if err != nil {
+			return nil, err
+		}
+		if p.Kind() == ParamEnum && !containsString(p.Values, s) {
+			return nil, fmt.Errorf("must be one of %s, got %q", strings.Join(p.Values, ", "), s)
+		}
+		if err := p.matchPattern(s); err != nil {
+			return nil, err
+		}
+		out = s
+	case ParamInt:
+		n, err := paramInt(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkRange(n, "value"); err != nil {
+			return nil, err
+		}
+		out = n
+	case ParamBool:
+		switch v := value.(type) {
+		case bool:
+			out = v
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("must be a bool, got %q", v)
+			}
+			out = b
+		default:
+			return nil, fmt.Errorf("must be a bool, got %T", value)
+		}
+	case ParamList:
+		items, err := paramList(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if err := p.matchPattern(item.(string)); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.checkRange(len(items), "item count"); err != nil {
+			return nil, err
+		}
+		out = items
+	default:
+		return nil, fmt.Errorf("unknown type %q", p.Type)
+	}
+	return out, nil
+}
+
+func (p Param) matchPattern(s string) error {
+	if p.Pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(p.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	if !re.MatchString(s) {
+		return fmt.Errorf("%q does not match pattern %q", s, p.Pattern)
+	}
+	return nil
+}
+
+func (p Param) checkRange(n int, what string) error {
+	if p.Min != nil && n < *p.Min {
+		return fmt.Errorf("%s must be at least %d, got %d", what, *p.Min, n)
+	}
+	if p.Max != nil && n > *p.Max {
+		return fmt.Errorf("%s must be at most %d, got %d", what, *p.Max, n)
+	}
+	return nil
+}
+
+func validateParams(params []Param) []string {
+	var issues []string
+	seen := map[string]struct{}{}
+	for i, p := range params {
+		label := fmt.Sprintf("params[%d]", i)
+		if p.Name == "" {
+			issues = append(issues, fmt.Sprintf("%s name is required", label))
+		} else {
+			label = fmt.Sprintf("param %q", p.Name)
+			if !paramNamePattern.MatchString(p.Name) {
+				issues = append(issues, fmt.Sprintf("%s name must be letters, digits and underscores", label))
+			}
+			if _, exists := seen[p.Name]; exists {
+				issues = append(issues, fmt.Sprintf("%s is duplicated", label))
+			}
+			seen[p.Name] = struct{}{}
+		}
+		kind := p.Kind()
+		switch kind {
+		case ParamString, ParamInt, ParamBool, ParamEnum, ParamList, ParamSecret:
+		default:
+			issues = append(issues, fmt.Sprintf("%s type must be string, int, bool, enum, list or secret, got %q", label, p.Type))
+			continue
+		}
+		if kind == ParamEnum && len(p.Values) == 0 {
+			issues = append(issues, fmt.Sprintf("%s values are required for enum", label))
+		}
+		if kind != ParamEnum && len(p.Values) > 0 {
+			issues = append(issues, fmt.Sprintf("%s values only apply to enum", label))
+		}
+		if p.Pattern != "" {
+			if kind != ParamString && kind != ParamSecret && kind != ParamList {
+				issues = append(issues, fmt.Sprintf("%s pattern only applies to string, secret and list", label))
+			} else if _, err := regexp.Compile(p.Pattern); err != nil {
+				issues = append(issues, fmt.Sprintf("%s pattern: %v", label, err))
+				continue
+			}
+		}
+		if p.Min != nil || p.Max != nil {
+			if kind != ParamInt && kind != ParamList {
+				issues = append(issues, fmt.Sprintf("%s min and max only apply to int and list", label))
+			} else if p.Min != nil && p.Max != nil && *p.Min > *p.Max {
+				issues = append(issues, fmt.Sprintf("%s min must not exceed max", label))
+			}
+		}
+		if p.Default != nil {
+			if _, err := p.Coerce(p.Default); err != nil {
+				issues = append(issues, fmt.Sprintf("%s default %v", label, err))
+			}
+		}
+	}
+	return issues
+}
+
+// ResolveParams checks values against the declared params and sets the
+// results in Vars. A param takes its value from values, then from an
+// existing var of the same name, then from its default.
+func (w *Workflow) ResolveParams(values map[string]any) error {
+	var issues []string
+	declared := map[string]struct{}{}
+	for _, p := range w.Params {
+		declared[p.Name] = struct{}{}
+	}
+	for name := range values {
+		if _, ok := declared[name]; !ok {
+			issues = append(issues, fmt.Sprintf("unknown param %q", name))
+		}
+	}
+
+	resolved := map[string]any{}
+	for _, p := range w.Params {
+		value, ok := values[p.Name]
+		if !ok {
+			value, ok = w.Vars[p.Name]
+		}
+		if !ok && p.Default != nil {
+			value, ok = p.Default, true
+		}
+		if !ok {
+			if p.Required {
+				issues = append(issues, fmt.Sprintf("param %q is required", p.Name))
+			}
+			continue
+		}
+		coerced, err := p.Coerce(value)
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("param %q %v", p.Name, err))
+			continue
+		}
+		resolved[p.Name] = coerced
+	}
+	if len(issues) > 0 {
+		sort.Strings(issues)
+		return &ValidationError{Issues: issues}
+	}
+
+	if len(resolved) > 0 {
+		vars := make(map[string]any, len(w.Vars)+len(resolved))
+		for k, v := range w.Vars {
+			vars[k] = v
+		}
+		for k, v := range resolved {
+			vars[k] = v
+		}
+		w.Vars = vars
+	}
+	return nil
+}
+
+// ParamValues returns the current values of the non-secret params, for
+// recording with a run.
+func (w Workflow) ParamValues() map[string]any {
+	var out map[string]any
+	for _, p := range w.Params {
+		if p.Kind() == ParamSecret {
+			continue
+		}
+		value, ok := w.Vars[p.Name]
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = map[string]any{}
+		}
+		out[p.Name] = value
+	}
+	return out
+}
+
+func scalarString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, float64, uint64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("must be a string, got %T", value)
+	}
+}
+
+func paramInt(value any) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("must be an integer, got %v", v)
+		}
+		return int(v), nil
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("must be an integer, got %q", v)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("must be an integer, got %T", value)
+	}
+}
+
+func paramList(value any) ([]any, error) {
+	var raw []any
+	switch v := value.(type) {
+	case []any:
+		raw = v
+	case []string:
+		for _, s := range v {
+			raw = append(raw, s)
+		}
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return []any{}, nil
+		}
+		for _, s := range strings.Split(v, ",") {
+			raw = append(raw, strings.TrimSpace(s))
+		}
+	default:
+		return nil, fmt.Errorf("must be a list, got %T", value)
+	}
+	items := make([]any, 0, len(raw))
+	for _, item := range raw {
+		s, err := scalarString(item)
+		if err != nil {
+			return nil, fmt.Errorf("list items %v", err)
+		}
+		items = append(items, s)
+	}
+	return items, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
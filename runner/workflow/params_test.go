@@ -0,0 +1,120 @@
+package workflow
+
+import (
+	"reflect"
+	"testing"
+)
+
+const paramsYAML = `
+version: v0.1
+name: deploy
+vars:
+  region: eu
+params:
+  - name: version
+    required: true
+    pattern: '^\d+\.\d+$'
+  - name: replicas
+    type: int
+    default: 2
+    min: 1
+    max: 5
+  - name: channel
+    type: enum
+    values: [stable, beta]
+    default: stable
+  - name: hosts
+    type: list
+  - name: dry_run
+    type: bool
+  - name: token
+    type: secret
+steps:
+  - name: hi
+    action: cmd.run
+    args:
+      cmd: echo ${version}
+`
+
+func TestWorkflowValidate_Params(t *testing.T) {
+	wf, err := Load([]byte(paramsYAML))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := wf.Validate(); err != nil {
+		t.Fatalf("expected valid params, got %v", err)
+	}
+
+	two := 2
+	one := 1
+	wf.Params = []Param{
+		{Name: "bad-name"},
+		{Name: "a", Type: "float"},
+		{Name: "b", Type: "enum"},
+		{Name: "c", Pattern: "("},
+		{Name: "d", Type: "int", Min: &two, Max: &one},
+		{Name: "e", Type: "int", Default: "many"},
+		{Name: "f", Type: "bool", Values: []string{"x"}},
+		{Name: "a"},
+	}
+	verr, ok := wf.Validate().(*ValidationError)
+	if !ok {
+		t.Fatalf("expected ValidationError")
+	}
+	assertIssue(t, verr.Issues, `param "bad-name" name must be letters, digits and underscores`)
+	assertIssue(t, verr.Issues, `param "a" type must be string, int, bool, enum, list or secret, got "float"`)
+	assertIssue(t, verr.Issues, `param "a" is duplicated`)
+	assertIssue(t, verr.Issues, `param "b" values are required for enum`)
+	assertIssue(t, verr.Issues, "param \"c\" pattern: error parsing regexp: missing closing ): `(`")
+	assertIssue(t, verr.Issues, `param "d" min must not exceed max`)
+	assertIssue(t, verr.Issues, `param "e" default must be an integer, got "many"`)
+	assertIssue(t, verr.Issues, `param "f" values only apply to enum`)
+}
+
+func TestResolveParams(t *testing.T) {
+	wf, err := Load([]byte(paramsYAML))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	err = wf.ResolveParams(map[string]any{"version": "1.2", "hosts": "web1, web2", "dry_run": "true", "token": "s3cret"})
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	want := map[string]any{
+		"region":   "eu",
+		"version":  "1.2",
+		"replicas": 2,
+		"channel":  "stable",
+		"hosts":    []any{"web1", "web2"},
+		"dry_run":  true,
+		"token":    "s3cret",
+	}
+	if !reflect.DeepEqual(wf.Vars, want) {
+		t.Fatalf("unexpected vars: %#v", wf.Vars)
+	}
+	if _, ok := wf.ParamValues()["token"]; ok {
+		t.Fatalf("secret param must not be recorded: %v", wf.ParamValues())
+	}
+
+	wf, _ = Load([]byte(paramsYAML))
+	err = wf.ResolveParams(map[string]any{"replicas": float64(9), "channel": "nightly", "colour": "red"})
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	assertIssue(t, verr.Issues, `param "version" is required`)
+	assertIssue(t, verr.Issues, `param "replicas" value must be at most 5, got 9`)
+	assertIssue(t, verr.Issues, `param "channel" must be one of stable, beta, got "nightly"`)
+	assertIssue(t, verr.Issues, `unknown param "colour"`)
+
+	// A var of the same name satisfies a param, as schedule vars do.
+	wf, _ = Load([]byte(paramsYAML))
+	wf.Vars["version"] = "x"
+	if err := wf.ResolveParams(nil); err == nil {
+		t.Fatalf("expected the var to be checked against the pattern")
+	}
+	wf.Vars["version"] = "2.0"
+	if err := wf.ResolveParams(nil); err != nil || wf.Vars["version"] != "2.0" {
+		t.Fatalf("expected the var to satisfy the param, got %v", err)
+	}
+}
@@ -2,25 +2,46 @@ package workflow
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
 )
 
-var varPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
-
+// RenderString replaces every ${expr} placeholder in input with the value of
+// the expression. Placeholders that do not parse or resolve to nothing, such
+// as shell variables like ${HOME}, are left untouched.
 func RenderString(input string, vars map[string]any) string {
-	return varPattern.ReplaceAllStringFunc(input, func(match string) string {
-		key := strings.TrimSuffix(strings.TrimPrefix(match, "${"), "}")
-		if value, ok := lookupVar(vars, key); ok {
-			return fmt.Sprint(value)
+	if !strings.Contains(input, "${") {
+		return input
+	}
+	var b strings.Builder
+	rest := input
+	for {
+		start, end := nextPlaceholder(rest)
+		if start < 0 {
+			b.WriteString(rest)
+			return b.String()
 		}
-		return match
-	})
+		b.WriteString(rest[:start])
+		if value, ok := renderPlaceholder(rest[start:end], vars); ok {
+			b.WriteString(fmt.Sprint(value))
+		} else {
+			b.WriteString(rest[start:end])
+		}
+		rest = rest[end:]
+	}
 }
 
+// RenderValue renders placeholders in strings nested in maps and lists. A
+// string made of a single placeholder keeps the type of its value, so
+// "${count}" renders to the int count rather than its string form.
 func RenderValue(value any, vars map[string]any) any {
 	switch v := value.(type) {
 	case string:
+		if start, end := nextPlaceholder(v); start == 0 && end == len(v) {
+			if resolved, ok := renderPlaceholder(v, vars); ok {
+				return resolved
+			}
+			return v
+		}
 		return RenderString(v, vars)
 	case map[string]any:
 		return renderMap(v, vars)
@@ -41,6 +62,76 @@ func RenderValue(value any, vars map[string]any) any {
 	}
 }
 
+// renderPlaceholder evaluates a ${...} placeholder. Keys that are not valid
+// expressions, like ${my-var}, fall back to a plain dotted lookup.
+func renderPlaceholder(placeholder string, vars map[string]any) (any, bool) {
+	if value, err := EvalExpr(placeholder, vars); err == nil && value != nil {
+		return value, true
+	}
+	key := strings.TrimSpace(placeholder[2 : len(placeholder)-1])
+	return lookupVar(vars, key)
+}
+
+// nextPlaceholder returns the bounds of the first ${...} in input, skipping
+// braces inside quoted strings, or -1 when there is none.
+func nextPlaceholder(input string) (int, int) {
+	start := strings.Index(input, "${")
+	if start < 0 {
+		return -1, -1
+	}
+	depth := 0
+	var quote byte
+	for i := start + 2; i < len(input); i++ {
+		ch := input[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '{':
+			depth++
+		case ch == '}':
+			if depth == 0 {
+				return start, i + 1
+			}
+			depth--
+		}
+	}
+	return -1, -1
+}
+
+// RenderArgs renders the args of a step or handler for one host.
+func RenderArgs(args map[string]any, vars map[string]any) map[string]any {
+	if len(args) == 0 {
+		return args
+	}
+	return renderMap(args, vars)
+}
+
+// LoopItems returns the items a step iterates over: loop with placeholders
+// rendered, or the list produced by loop_expr. Steps without a loop return nil.
+func LoopItems(step Step, vars map[string]any) ([]any, error) {
+	if strings.TrimSpace(step.LoopExpr) == "" {
+		if len(step.Loop) == 0 {
+			return nil, nil
+		}
+		return RenderValue(step.Loop, vars).([]any), nil
+	}
+	value, err := EvalExpr(step.LoopExpr, vars)
+	if err != nil {
+		return nil, fmt.Errorf("loop_expr: %w", err)
+	}
+	items, err := toList(value)
+	if err != nil {
+		return nil, fmt.Errorf("loop_expr %q %w", step.LoopExpr, err)
+	}
+	return items, nil
+}
+
 func renderMap(input map[string]any, vars map[string]any) map[string]any {
 	out := make(map[string]any, len(input))
 	for k, v := range input {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 )
 
 type ValidationError struct {
@@ -33,9 +34,17 @@ func (w *Workflow) Validate() error {
 	if w.Plan.Mode != "" && w.Plan.Mode != "manual-approve" && w.Plan.Mode != "auto" {
 		issues = append(issues, fmt.Sprintf("plan.mode must be manual-approve or auto, got %q", w.Plan.Mode))
 	}
-	if w.Plan.Strategy != "" && w.Plan.Strategy != "sequential" {
-		issues = append(issues, fmt.Sprintf("plan.strategy must be sequential, got %q", w.Plan.Strategy))
+	if w.Plan.Strategy != "" && w.Plan.Strategy != "sequential" && w.Plan.Strategy != "dag" {
+		issues = append(issues, fmt.Sprintf("plan.strategy must be sequential or dag, got %q", w.Plan.Strategy))
 	}
+	if w.Plan.MaxParallel < 0 {
+		issues = append(issues, fmt.Sprintf("plan.max_parallel must not be negative, got %d", w.Plan.MaxParallel))
+	}
+	if w.OnFailure != "" && w.OnFailure != "stop" && w.OnFailure != "rollback" {
+		issues = append(issues, fmt.Sprintf("on_failure must be stop or rollback, got %q", w.OnFailure))
+	}
+	issues = append(issues, validateParams(w.Params)...)
+	issues = append(issues, validateRollout("plan", w.Plan.Serial, w.Plan.MaxFailPercentage, w.Plan.BatchPause)...)
 
 	handlerNames := map[string]struct{}{}
 	for _, h := range w.Handlers {
@@ -50,9 +59,20 @@ func (w *Workflow) Validate() error {
 		if h.Action == "" {
 			issues = append(issues, fmt.Sprintf("handler %q action is required", h.Name))
 		}
+		issues = append(issues, validateWhen(fmt.Sprintf("handler %q", h.Name), h.When)...)
+	}
+
+	stepIndex := map[string]int{}
+	for i, s := range w.Steps {
+		if _, exists := stepIndex[s.Name]; !exists && s.Name != "" {
+			stepIndex[s.Name] = i
+		}
 	}
 
 	stepNames := map[string]struct{}{}
+	if w.GatherFacts {
+		stepNames[GatherFactsStep] = struct{}{}
+	}
 	for i, s := range w.Steps {
 		stepLabel := fmt.Sprintf("steps[%d]", i)
 		if s.Name == "" {
@@ -63,15 +83,21 @@ func (w *Workflow) Validate() error {
 			}
 			stepNames[s.Name] = struct{}{}
 		}
-		if s.Action == "" {
-			issues = append(issues, fmt.Sprintf("%s action is required", stepLabel))
-		}
-		for _, notify := range s.Notify {
-			if _, ok := handlerNames[notify]; !ok {
-				issues = append(issues, fmt.Sprintf("%s notify handler %q not found", stepLabel, notify))
+		issues = append(issues, w.stepIssues(stepLabel, s, handlerNames)...)
+		for _, dep := range s.DependsOn {
+			depIndex, ok := stepIndex[dep]
+			if !ok {
+				issues = append(issues, fmt.Sprintf("%s depends_on step %q not found", stepLabel, dep))
+				continue
+			}
+			if w.Plan.Strategy != "dag" && depIndex >= i {
+				issues = append(issues, fmt.Sprintf("%s depends_on step %q must be declared earlier for sequential strategy", stepLabel, dep))
 			}
 		}
 	}
+	if cycle := DependencyCycle(w.Steps); len(cycle) > 0 {
+		issues = append(issues, fmt.Sprintf("steps depends_on cycle: %s", strings.Join(cycle, " -> ")))
+	}
 
 	if len(issues) > 0 {
 		sort.Strings(issues)
@@ -80,3 +106,114 @@ func (w *Workflow) Validate() error {
 
 	return nil
 }
+
+// stepIssues checks the fields of one step, and the steps of a block step.
+func (w *Workflow) stepIssues(label string, s Step, handlerNames map[string]struct{}) []string {
+	var issues []string
+	if s.IsBlock() {
+		issues = append(issues, w.blockIssues(label, s, handlerNames)...)
+	} else if ref := s.IncludeRef(); ref != "" {
+		issues = append(issues, includeStepIssues(label, s)...)
+		if ref == w.Name {
+			issues = append(issues, fmt.Sprintf("%s includes its own workflow %q", label, ref))
+		}
+	} else if s.Action == "" {
+		issues = append(issues, fmt.Sprintf("%s action is required", label))
+	} else if len(s.Params) > 0 {
+		issues = append(issues, fmt.Sprintf("%s params only apply to include", label))
+	}
+	if s.Action == "workflow.call" && s.Args["workflow"] == w.Name {
+		issues = append(issues, fmt.Sprintf("%s workflow.call calls its own workflow %q", label, w.Name))
+	}
+	for _, notify := range s.Notify {
+		if _, ok := handlerNames[notify]; !ok {
+			issues = append(issues, fmt.Sprintf("%s notify handler %q not found", label, notify))
+		}
+	}
+	maxFail := 0
+	if s.MaxFailPercentage != nil {
+		maxFail = *s.MaxFailPercentage
+	}
+	issues = append(issues, validateRollout(label, s.Serial, maxFail, s.BatchPause)...)
+	issues = append(issues, validateWhen(label, s.When)...)
+	if strings.TrimSpace(s.LoopExpr) != "" {
+		if len(s.Loop) > 0 {
+			issues = append(issues, fmt.Sprintf("%s loop and loop_expr are mutually exclusive", label))
+		}
+		if _, err := ParseExpr(s.LoopExpr); err != nil {
+			issues = append(issues, fmt.Sprintf("%s.loop_expr: %v", label, err))
+		}
+	}
+	return issues
+}
+
+// blockIssues checks a block step: it runs no action of its own, and its
+// steps need names unique within the block and cannot use depends_on.
+func (w *Workflow) blockIssues(label string, s Step, handlerNames map[string]struct{}) []string {
+	var issues []string
+	if len(s.Block) == 0 {
+		issues = append(issues, fmt.Sprintf("%s rescue and always require block", label))
+	}
+	if s.Action != "" {
+		issues = append(issues, fmt.Sprintf("%s block and action are mutually exclusive", label))
+	}
+	if s.IncludeRef() != "" {
+		issues = append(issues, fmt.Sprintf("%s block and include are mutually exclusive", label))
+	}
+	if len(s.Loop) > 0 || strings.TrimSpace(s.LoopExpr) != "" {
+		issues = append(issues, fmt.Sprintf("%s loop is not supported with block", label))
+	}
+	if len(s.Notify) > 0 || s.Retries != 0 || s.Timeout != "" {
+		issues = append(issues, fmt.Sprintf("%s notify, retries and timeout are not supported with block; set them on the block steps", label))
+	}
+	names := map[string]struct{}{}
+	for _, section := range []struct {
+		name  string
+		steps []Step
+	}{{SectionBlock, s.Block}, {SectionRescue, s.Rescue}, {SectionAlways, s.Always}} {
+		for i, child := range section.steps {
+			childLabel := fmt.Sprintf("%s.%s[%d]", label, section.name, i)
+			if child.Name == "" {
+				issues = append(issues, fmt.Sprintf("%s name is required", childLabel))
+			} else {
+				if _, exists := names[child.Name]; exists {
+					issues = append(issues, fmt.Sprintf("%s step name %q is duplicated", label, child.Name))
+				}
+				names[child.Name] = struct{}{}
+			}
+			if len(child.DependsOn) > 0 {
+				issues = append(issues, fmt.Sprintf("%s depends_on is not supported inside a block", childLabel))
+			}
+			issues = append(issues, w.stepIssues(childLabel, child, handlerNames)...)
+		}
+	}
+	return issues
+}
+
+func validateWhen(label, when string) []string {
+	trimmed := strings.TrimSpace(when)
+	switch strings.ToLower(trimmed) {
+	case "", "true", "false", "yes", "no":
+		return nil
+	}
+	if _, err := ParseExpr(trimmed); err != nil {
+		return []string{fmt.Sprintf("%s.when: %v", label, err)}
+	}
+	return nil
+}
+
+func validateRollout(label string, serial any, maxFailPercentage int, batchPause string) []string {
+	var issues []string
+	if _, err := BatchSize(serial, 1); err != nil {
+		issues = append(issues, fmt.Sprintf("%s.%s", label, err))
+	}
+	if maxFailPercentage < 0 || maxFailPercentage > 100 {
+		issues = append(issues, fmt.Sprintf("%s.max_fail_percentage must be between 0 and 100, got %d", label, maxFailPercentage))
+	}
+	if strings.TrimSpace(batchPause) != "" {
+		if pause, err := time.ParseDuration(batchPause); err != nil || pause < 0 {
+			issues = append(issues, fmt.Sprintf("%s.batch_pause must be a duration, got %q", label, batchPause))
+		}
+	}
+	return issues
+}
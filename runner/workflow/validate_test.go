@@ -1,6 +1,9 @@
 package workflow
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestWorkflowValidate_MissingBasics(t *testing.T) {
 	wf := Workflow{}
@@ -25,6 +28,7 @@ func TestWorkflowValidate_PlanAndDuplicates(t *testing.T) {
 			Mode:     "fast",
 			Strategy: "parallel",
 		},
+		OnFailure: "retry",
 		Handlers: []Handler{
 			{Name: "restart", Action: "cmd.run"},
 			{Name: "restart", Action: "cmd.run"},
@@ -50,12 +54,57 @@ func TestWorkflowValidate_PlanAndDuplicates(t *testing.T) {
 		t.Fatalf("expected ValidationError, got %T", err)
 	}
 	assertIssue(t, verr.Issues, `plan.mode must be manual-approve or auto, got "fast"`)
-	assertIssue(t, verr.Issues, `plan.strategy must be sequential, got "parallel"`)
+	assertIssue(t, verr.Issues, `plan.strategy must be sequential or dag, got "parallel"`)
+	assertIssue(t, verr.Issues, `on_failure must be stop or rollback, got "retry"`)
 	assertIssue(t, verr.Issues, `handler name "restart" is duplicated`)
 	assertIssue(t, verr.Issues, `step name "deploy" is duplicated`)
 	assertIssue(t, verr.Issues, `steps[0] notify handler "missing" not found`)
 }
 
+func TestWorkflowValidate_DependsOn(t *testing.T) {
+	wf := Workflow{
+		Version: "v0.1",
+		Name:    "demo",
+		Plan:    Plan{Strategy: "dag", MaxParallel: -1},
+		Steps: []Step{
+			{Name: "fetch", Action: "cmd.run", DependsOn: []string{"migrate"}},
+			{Name: "build", Action: "cmd.run", DependsOn: []string{"fetch", "missing"}},
+			{Name: "migrate", Action: "cmd.run", DependsOn: []string{"build"}},
+			{Name: "notify", Action: "cmd.run", DependsOn: []string{"build"}},
+		},
+	}
+	err := wf.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected ValidationError, got %T", err)
+	}
+	assertIssue(t, verr.Issues, `plan.max_parallel must not be negative, got -1`)
+	assertIssue(t, verr.Issues, `steps[1] depends_on step "missing" not found`)
+	assertIssue(t, verr.Issues, `steps depends_on cycle: fetch -> migrate -> build -> fetch`)
+	if len(verr.Issues) != 3 {
+		t.Fatalf("unexpected issues: %v", verr.Issues)
+	}
+
+	wf.Plan = Plan{Strategy: "sequential"}
+	wf.Steps = []Step{
+		{Name: "build", Action: "cmd.run", DependsOn: []string{"test"}},
+		{Name: "test", Action: "cmd.run"},
+	}
+	err = wf.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	assertIssue(t, err.(*ValidationError).Issues, `steps[0] depends_on step "test" must be declared earlier for sequential strategy`)
+
+	wf.Plan = Plan{Strategy: "dag", MaxParallel: 2}
+	if err := wf.Validate(); err != nil {
+		t.Fatalf("expected valid dag workflow, got %v", err)
+	}
+}
+
 func assertIssue(t *testing.T, issues []string, expected string) {
 	t.Helper()
 	for _, issue := range issues {
@@ -65,3 +114,84 @@ func assertIssue(t *testing.T, issues []string, expected string) {
 	}
 	t.Fatalf("expected issue %q, got %v", expected, issues)
 }
+
+func TestWorkflowValidate_Expressions(t *testing.T) {
+	wf := Workflow{
+		Version: "v0.1",
+		Name:    "demo",
+		Handlers: []Handler{
+			{Name: "restart", Action: "cmd.run", When: "changed &&"},
+		},
+		Steps: []Step{
+			{Name: "deploy", Action: "cmd.run", When: `env == "prod" && (count > 1`},
+			{Name: "fanout", Action: "cmd.run", Loop: []any{"a"}, LoopExpr: "hosts"},
+			{Name: "items", Action: "cmd.run", LoopExpr: "split(csv"},
+			{Name: "ok", Action: "cmd.run", When: `"web" in group | lower`},
+		},
+	}
+	err := wf.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	assertIssue(t, verr.Issues, `handler "restart".when: unexpected end of expression at position 11 in "changed &&"`)
+	assertIssue(t, verr.Issues, `steps[0].when: expected ")", got end of expression at position 28 in "env == \"prod\" && (count > 1"`)
+	assertIssue(t, verr.Issues, "steps[1] loop and loop_expr are mutually exclusive")
+	assertIssue(t, verr.Issues, `steps[2].loop_expr: expected ")", got end of expression at position 10 in "split(csv"`)
+	if len(verr.Issues) != 4 {
+		t.Fatalf("expected 4 issues, got %v", verr.Issues)
+	}
+}
+
+func TestWorkflowValidate_Block(t *testing.T) {
+	wf, err := Load([]byte(`
+version: v0.1
+name: demo
+steps:
+  - name: rollout
+    targets: [web]
+    block:
+      - name: drain
+        action: cmd.run
+      - name: deploy
+        action: cmd.run
+        depends_on: [drain]
+    rescue:
+      - name: drain
+        action: cmd.run
+    always:
+      - name: undrain
+    notify: [restart]
+  - name: orphan
+    action: cmd.run
+    always:
+      - name: cleanup
+        action: cmd.run
+`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	verr, ok := wf.Validate().(*ValidationError)
+	if !ok {
+		t.Fatalf("expected ValidationError")
+	}
+	assertIssue(t, verr.Issues, "steps[0].block[1] depends_on is not supported inside a block")
+	assertIssue(t, verr.Issues, `steps[0] step name "drain" is duplicated`)
+	assertIssue(t, verr.Issues, "steps[0].always[0] action is required")
+	assertIssue(t, verr.Issues, "steps[0] notify, retries and timeout are not supported with block; set them on the block steps")
+	assertIssue(t, verr.Issues, "steps[1] rescue and always require block")
+	assertIssue(t, verr.Issues, "steps[1] block and action are mutually exclusive")
+
+	steps := FlattenSteps(wf.Steps[:1])
+	var names []string
+	for _, s := range steps {
+		names = append(names, s.Name+"@"+s.Section)
+	}
+	want := []string{"rollout@", "rollout/drain@block", "rollout/deploy@block", "rollout/drain@rescue", "rollout/undrain@always"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected flattened steps %v", names)
+	}
+	if steps[1].Targets[0] != "web" || steps[1].Parent != "rollout" {
+		t.Fatalf("expected block steps to inherit targets, got %+v", steps[1])
+	}
+}
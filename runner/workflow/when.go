@@ -2,10 +2,13 @@ package workflow
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 )
 
+// EvalWhen evaluates a when expression; see expr.go for the syntax. Besides
+// the expression result, the bare words yes and no are accepted.
 func EvalWhen(expr string, vars map[string]any) (bool, error) {
 	trimmed := strings.TrimSpace(expr)
 	if trimmed == "" {
@@ -19,175 +22,18 @@ func EvalWhen(expr string, vars map[string]any) (bool, error) {
 		return false, nil
 	}
 
-	orParts := splitLogical(trimmed, "||")
-	if len(orParts) > 1 {
-		for _, part := range orParts {
-			ok, err := EvalWhen(part, vars)
-			if err != nil {
-				return false, err
-			}
-			if ok {
-				return true, nil
-			}
-		}
-		return false, nil
-	}
-
-	andParts := splitLogical(trimmed, "&&")
-	if len(andParts) > 1 {
-		for _, part := range andParts {
-			ok, err := EvalWhen(part, vars)
-			if err != nil {
-				return false, err
-			}
-			if !ok {
-				return false, nil
-			}
-		}
-		return true, nil
-	}
-
-	op, idx := findOperator(trimmed)
-	if op == "" || idx <= 0 {
-		value := parseOperand(trimmed, vars)
-		return truthy(value), nil
-	}
-
-	leftRaw := strings.TrimSpace(trimmed[:idx])
-	rightRaw := strings.TrimSpace(trimmed[idx+len(op):])
-	left := parseOperand(leftRaw, vars)
-	right := parseOperand(rightRaw, vars)
-
-	switch op {
-	case "==":
-		return compareEqual(left, right), nil
-	case "!=":
-		return !compareEqual(left, right), nil
-	case ">", ">=", "<", "<=":
-		ln, lok := toNumber(left)
-		rn, rok := toNumber(right)
-		if !lok || !rok {
-			return false, fmt.Errorf("when expression expects numeric comparison for %q", op)
-		}
-		switch op {
-		case ">":
-			return ln > rn, nil
-		case ">=":
-			return ln >= rn, nil
-		case "<":
-			return ln < rn, nil
-		case "<=":
-			return ln <= rn, nil
-		}
-	}
-
-	return false, fmt.Errorf("unsupported when expression: %q", expr)
-}
-
-func splitLogical(expr, token string) []string {
-	if !strings.Contains(expr, token) {
-		return []string{expr}
-	}
-	var parts []string
-	start := 0
-	inSingle := false
-	inDouble := false
-	for i := 0; i < len(expr); i++ {
-		ch := expr[i]
-		switch ch {
-		case '\'':
-			if !inDouble {
-				inSingle = !inSingle
-			}
-		case '"':
-			if !inSingle {
-				inDouble = !inDouble
-			}
-		}
-		if inSingle || inDouble {
-			continue
-		}
-		if strings.HasPrefix(expr[i:], token) {
-			parts = append(parts, expr[start:i])
-			i += len(token) - 1
-			start = i + 1
-		}
-	}
-	if start <= len(expr) {
-		parts = append(parts, expr[start:])
-	}
-	return parts
-}
-
-func findOperator(expr string) (string, int) {
-	ops := []string{"==", "!=", ">=", "<=", ">", "<"}
-	inSingle := false
-	inDouble := false
-	for i := 0; i < len(expr); i++ {
-		ch := expr[i]
-		switch ch {
-		case '\'':
-			if !inDouble {
-				inSingle = !inSingle
-			}
-		case '"':
-			if !inSingle {
-				inDouble = !inDouble
-			}
-		}
-		if inSingle || inDouble {
-			continue
-		}
-		for _, op := range ops {
-			if strings.HasPrefix(expr[i:], op) {
-				return op, i
-			}
-		}
-	}
-	return "", -1
-}
-
-func parseOperand(raw string, vars map[string]any) any {
-	trimmed := strings.TrimSpace(raw)
-	if trimmed == "" {
-		return ""
-	}
-	if isQuoted(trimmed) {
-		return trimmed[1 : len(trimmed)-1]
-	}
-	if strings.HasPrefix(trimmed, "${") && strings.HasSuffix(trimmed, "}") {
-		key := strings.TrimSuffix(strings.TrimPrefix(trimmed, "${"), "}")
-		if value, ok := lookupVar(vars, strings.TrimSpace(key)); ok {
-			return value
-		}
-		return ""
-	}
-	if value, ok := lookupVar(vars, trimmed); ok {
-		return value
+	value, err := EvalExpr(trimmed, vars)
+	if err != nil {
+		return false, fmt.Errorf("when expression: %w", err)
 	}
-	if lowered := strings.ToLower(trimmed); lowered == "true" || lowered == "false" {
-		return lowered == "true"
-	}
-	if num, err := strconv.ParseFloat(trimmed, 64); err == nil {
-		return num
-	}
-	return trimmed
-}
-
-func isQuoted(value string) bool {
-	if len(value) < 2 {
-		return false
-	}
-	if value[0] == '"' && value[len(value)-1] == '"' {
-		return true
-	}
-	if value[0] == '\'' && value[len(value)-1] == '\'' {
-		return true
-	}
-	return false
+	return truthy(value), nil
 }
 
 func compareEqual(left, right any) bool {
+	if left == nil || right == nil {
+		// Undefined variables compare equal to the empty string.
+		return toString(left) == toString(right)
+	}
 	if ln, lok := toNumber(left); lok {
 		if rn, rok := toNumber(right); rok {
 			return ln == rn
@@ -211,6 +57,9 @@ func toNumber(value any) (float64, bool) {
 		return v, true
 	case float32:
 		return float64(v), true
+	case int32, int16, int8, uint, uint8, uint16, uint32, uint64:
+		i, _ := toInt(v)
+		return float64(i), true
 	case string:
 		trimmed := strings.TrimSpace(v)
 		if trimmed == "" {
@@ -248,6 +97,10 @@ func truthy(value any) bool {
 		}
 		return true
 	default:
+		switch rv := reflect.ValueOf(value); rv.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return rv.Len() > 0
+		}
 		return true
 	}
 }
@@ -44,3 +44,62 @@ func TestEvalWhenLogical(t *testing.T) {
 		t.Fatalf("expected OR expression to be true")
 	}
 }
+
+func TestEvalWhenExpressions(t *testing.T) {
+	vars := map[string]any{"ENV": "prod", "ROLES": []any{"web", "db"}, "COUNT": "4"}
+	ok, err := EvalWhen(`!(ENV == "dev") && "db" in ROLES && COUNT % 2 == 0`, vars)
+	if err != nil || !ok {
+		t.Fatalf("expected expression to be true, got %v (%v)", ok, err)
+	}
+	ok, err = EvalWhen(`ROLES | length > 2 or upper(ENV) == "PROD"`, vars)
+	if err != nil || !ok {
+		t.Fatalf("expected filter expression to be true, got %v (%v)", ok, err)
+	}
+	if _, err := EvalWhen(`ROLES > 1`, vars); err == nil {
+		t.Fatalf("expected comparing a list to fail")
+	}
+}
+
+func TestEvalWhenBareWords(t *testing.T) {
+	vars := map[string]any{"ENV": "prod"}
+	ok, err := EvalWhen("ENV == prod", vars)
+	if err != nil || !ok {
+		t.Fatalf("expected bare word to compare as a literal, got %v %v", ok, err)
+	}
+	ok, err = EvalWhen("ENV != staging && ENV == prod", vars)
+	if err != nil || !ok {
+		t.Fatalf("expected bare words in logical expression to compare as literals, got %v %v", ok, err)
+	}
+	ok, err = EvalWhen("${REGION} == eu", vars)
+	if err != nil || ok {
+		t.Fatalf("expected undefined ${REGION} to stay empty, got %v %v", ok, err)
+	}
+	ok, err = EvalWhen("REGION", vars)
+	if err != nil || ok {
+		t.Fatalf("expected undefined variable to be false outside comparisons, got %v %v", ok, err)
+	}
+}
+
+func TestEvalWhenHyphenatedAndDottedWords(t *testing.T) {
+	vars := map[string]any{"region": "us-east-1", "host": "web-01", "ver": "1.2.3", "COUNT": "4"}
+	ok, err := EvalWhen("region == us-east-1", vars)
+	if err != nil || !ok {
+		t.Fatalf("expected hyphenated word to compare as a literal, got %v %v", ok, err)
+	}
+	ok, err = EvalWhen("host == web-01 && host != web-02", vars)
+	if err != nil || !ok {
+		t.Fatalf("expected host names to compare as literals, got %v %v", ok, err)
+	}
+	ok, err = EvalWhen("ver == 1.2.3", vars)
+	if err != nil || !ok {
+		t.Fatalf("expected dotted version to compare as a literal, got %v %v", ok, err)
+	}
+	ok, err = EvalWhen("1.2.4 != ver", vars)
+	if err != nil || !ok {
+		t.Fatalf("expected dotted version on the left to compare as a literal, got %v %v", ok, err)
+	}
+	ok, err = EvalWhen("COUNT-1 == 3", vars)
+	if err != nil || !ok {
+		t.Fatalf("expected defined variables to still subtract, got %v %v", ok, err)
+	}
+}